@@ -535,6 +535,9 @@ export const SPECIAL_FILTER_ID = {
     PARENTAL: -3,
     SAFE_BROWSING: -4,
     SAFE_SEARCH: -5,
+    TRACKERS: -6,
+    DEFAULT_DENY: -7,
+    EXCEPTIONS: -8,
 };
 
 export const BLOCK_ACTIONS = {
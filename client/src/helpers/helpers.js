@@ -794,6 +794,8 @@ export const getSpecialFilterName = (filterId) => {
             return i18n.t('safe_browsing');
         case SPECIAL_FILTER_ID.SAFE_SEARCH:
             return i18n.t('safe_search');
+        case SPECIAL_FILTER_ID.TRACKERS:
+            return i18n.t('builtin_trackers_list');
         default:
             return i18n.t('unknown_filter', { filterId });
     }
@@ -91,6 +91,10 @@ type FilteringConfig struct {
 	// FastestTimeout replaces the default timeout for dialing IP addresses
 	// when FastestAddr is true.
 	FastestTimeout timeutil.Duration `yaml:"fastest_timeout"`
+	// RoutingUpstreams maps the tags of the routing filters to the upstreams
+	// used for the hosts matched by those, see
+	// filtering.DNSFilter.RouteDecision.
+	RoutingUpstreams map[string][]string `yaml:"routing_upstreams"`
 
 	// Access settings
 	// --
@@ -370,6 +374,41 @@ func (s *Server) prepareUpstreamSettings() error {
 
 	s.conf.UpstreamConfig = upstreamConfig
 
+	return s.prepareRoutingUpstreams()
+}
+
+// prepareRoutingUpstreams parses the upstream groups of the routing filters and
+// closes the previous ones.
+func (s *Server) prepareRoutingUpstreams() (err error) {
+	if len(s.conf.RoutingUpstreams) == 0 {
+		closeUpstreamConfigs(s.routingUpstreams)
+		s.routingUpstreams = nil
+
+		return nil
+	}
+
+	confs := make(map[string]*proxy.UpstreamConfig, len(s.conf.RoutingUpstreams))
+	for tag, upstreams := range s.conf.RoutingUpstreams {
+		upstreams = stringutil.FilterOut(upstreams, IsCommentOrEmpty)
+		if len(upstreams) == 0 {
+			return fmt.Errorf("dns: routing upstreams for tag %q: no upstreams", tag)
+		}
+
+		var conf *proxy.UpstreamConfig
+		conf, err = proxy.ParseUpstreamsConfig(upstreams, &upstream.Options{
+			Bootstrap: s.conf.BootstrapDNS,
+			Timeout:   s.conf.UpstreamTimeout,
+		})
+		if err != nil {
+			return fmt.Errorf("dns: routing upstreams for tag %q: %w", tag, err)
+		}
+
+		confs[tag] = conf
+	}
+
+	closeUpstreamConfigs(s.routingUpstreams)
+	s.routingUpstreams = confs
+
 	return nil
 }
 
@@ -2,6 +2,7 @@ package dnsforward
 
 import (
 	"encoding/binary"
+	"fmt"
 	"net"
 	"strings"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/AdguardTeam/golibs/stringutil"
@@ -38,6 +40,10 @@ type dnsContext struct {
 	// clientID is the clientID from DoH, DoQ, or DoT, if provided.
 	clientID string
 
+	// routeTag is the tag of the upstream group chosen for the request by
+	// the routing filters, if any.
+	routeTag string
+
 	// origQuestion is the question received from the client.  It is set
 	// when the request is modified by rewrites.
 	origQuestion dns.Question
@@ -523,6 +529,47 @@ func ipStringFromAddr(addr net.Addr) (ipStr string) {
 	return ""
 }
 
+// rewriteUpstreamConfig returns the upstream configuration for the upstream
+// address addr of a rewrite, see filtering.RewriteEntry.Upstream.  The
+// configurations are cached, so that the connections are reused.
+func (s *Server) rewriteUpstreamConfig(addr string) (conf *proxy.UpstreamConfig, err error) {
+	s.serverLock.RLock()
+	opts := &upstream.Options{
+		Bootstrap: s.conf.BootstrapDNS,
+		Timeout:   s.conf.UpstreamTimeout,
+	}
+	s.serverLock.RUnlock()
+
+	s.rewriteUpstreamsLock.Lock()
+	defer s.rewriteUpstreamsLock.Unlock()
+
+	if conf = s.rewriteUpstreams[addr]; conf != nil {
+		return conf, nil
+	}
+
+	conf, err = proxy.ParseUpstreamsConfig([]string{addr}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("parsing upstream: %w", err)
+	}
+
+	if s.rewriteUpstreams == nil {
+		s.rewriteUpstreams = map[string]*proxy.UpstreamConfig{}
+	}
+
+	s.rewriteUpstreams[addr] = conf
+
+	return conf, nil
+}
+
+// routingUpstreamConfig returns the upstream configuration of the routing
+// filters with tag, if any.
+func (s *Server) routingUpstreamConfig(tag string) (conf *proxy.UpstreamConfig) {
+	s.serverLock.RLock()
+	defer s.serverLock.RUnlock()
+
+	return s.routingUpstreams[tag]
+}
+
 // processUpstream passes request to upstream servers and handles the response.
 func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 	pctx := dctx.proxyCtx
@@ -543,6 +590,30 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 		}
 	}
 
+	if dctx.routeTag != "" {
+		if upsConf := s.routingUpstreamConfig(dctx.routeTag); upsConf != nil {
+			log.Debug("dns: using upstreams of routing tag %q", dctx.routeTag)
+			pctx.CustomUpstreamConfig = upsConf
+		} else {
+			log.Debug("dns: no upstreams for routing tag %q", dctx.routeTag)
+		}
+	}
+
+	if res := dctx.result; res != nil && res.Upstream != "" && dctx.origQuestion.Name != "" {
+		upsConf, err := s.rewriteUpstreamConfig(res.Upstream)
+		if err != nil {
+			// Don't resolve the rewritten name with the default upstreams,
+			// since those may answer it differently.
+			log.Error("dns: getting rewrite upstream %s: %s", res.Upstream, err)
+			pctx.Res = s.genServerFailure(pctx.Req)
+
+			return resultCodeSuccess
+		}
+
+		log.Debug("dns: using rewrite upstream %s for %s", res.Upstream, res.CanonName)
+		pctx.CustomUpstreamConfig = upsConf
+	}
+
 	req := pctx.Req
 	origReqAD := false
 	if s.conf.EnableDNSSEC {
@@ -580,12 +651,12 @@ func (s *Server) processUpstream(dctx *dnsContext) (rc resultCode) {
 func (s *Server) processFilteringAfterResponse(ctx *dnsContext) (rc resultCode) {
 	d := ctx.proxyCtx
 
-	switch res := ctx.result; res.Reason {
-	case filtering.NotFilteredAllowList:
+	switch res := ctx.result; {
+	case res.Reason == filtering.NotFilteredAllowList:
 		// Go on.
 	case
-		filtering.Rewritten,
-		filtering.RewrittenRule:
+		res.Reason.In(filtering.Rewritten, filtering.RewrittenRule),
+		res.IsFiltered && res.CanonName != "":
 
 		if len(ctx.origQuestion.Name) == 0 {
 			// origQuestion is set in case we get only CNAME without IP from
@@ -598,6 +669,10 @@ func (s *Server) processFilteringAfterResponse(ctx *dnsContext) (rc resultCode)
 			answer := append([]dns.RR{s.genAnswerCNAME(d.Req, res.CanonName)}, d.Res.Answer...)
 			d.Res.Answer = answer
 		}
+
+		// The upstream answer for the canonical name must not outlive the
+		// rewrites it's built from.
+		capAnswerTTL(d.Res, res.TTL)
 	default:
 		// Check the response only if the it's from an upstream.  Don't check
 		// the response if the protection is disabled since dnsrewrite rules
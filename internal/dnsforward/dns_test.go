@@ -380,6 +380,88 @@ func TestServer_ProcessLocalPTR_usingResolvers(t *testing.T) {
 	})
 }
 
+func TestServer_ProcessFilteringAfterResponse_rewriteTTL(t *testing.T) {
+	const (
+		blockedTTL  uint32 = 3600
+		upstreamTTL uint32 = 600
+	)
+
+	s := &Server{
+		conf: ServerConfig{
+			FilteringConfig: FilteringConfig{
+				BlockedResponseTTL: blockedTTL,
+			},
+		},
+	}
+
+	origQ := dns.Question{
+		Name:   "alias.example.",
+		Qtype:  dns.TypeA,
+		Qclass: dns.ClassINET,
+	}
+
+	testCases := []struct {
+		name          string
+		ttl           uint32
+		wantCNAMETTL  uint32
+		wantAnswerTTL uint32
+	}{{
+		name:          "unset",
+		ttl:           0,
+		wantCNAMETTL:  blockedTTL,
+		wantAnswerTTL: upstreamTTL,
+	}, {
+		name:          "lower",
+		ttl:           60,
+		wantCNAMETTL:  60,
+		wantAnswerTTL: 60,
+	}, {
+		name:          "higher",
+		ttl:           1000,
+		wantCNAMETTL:  1000,
+		wantAnswerTTL: upstreamTTL,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := createTestMessageWithType("target.example.", dns.TypeA)
+			resp := (&dns.Msg{}).SetReply(req)
+			resp.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   "target.example.",
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET,
+					Ttl:    upstreamTTL,
+				},
+				A: net.IP{1, 2, 3, 4},
+			}}
+
+			dctx := &dnsContext{
+				proxyCtx: &proxy.DNSContext{
+					Req: req,
+					Res: resp,
+				},
+				result: &filtering.Result{
+					Reason:    filtering.Rewritten,
+					CanonName: "target.example",
+					TTL:       tc.ttl,
+				},
+				origQuestion: origQ,
+			}
+
+			rc := s.processFilteringAfterResponse(dctx)
+			require.Equal(t, resultCodeSuccess, rc)
+
+			ans := dctx.proxyCtx.Res.Answer
+			require.Len(t, ans, 2)
+
+			assert.Equal(t, origQ, dctx.proxyCtx.Res.Question[0])
+			assert.Equal(t, tc.wantCNAMETTL, ans[0].Header().Ttl)
+			assert.Equal(t, tc.wantAnswerTTL, ans[1].Header().Ttl)
+		})
+	}
+}
+
 func TestIPStringFromAddr(t *testing.T) {
 	t.Run("not_nil", func(t *testing.T) {
 		addr := net.UDPAddr{
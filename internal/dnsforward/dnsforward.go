@@ -3,6 +3,7 @@ package dnsforward
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"runtime"
@@ -92,6 +93,15 @@ type Server struct {
 	// extracted during the BeforeRequestHandler stage.
 	clientIDCache cache.Cache
 
+	// rewriteUpstreams are the cached configurations of the upstreams of the
+	// rewrites, see rewriteUpstreamConfig.
+	rewriteUpstreams     map[string]*proxy.UpstreamConfig
+	rewriteUpstreamsLock sync.Mutex
+
+	// routingUpstreams are the upstream groups of the routing filters by
+	// their tags, see FilteringConfig.RoutingUpstreams.
+	routingUpstreams map[string]*proxy.UpstreamConfig
+
 	// DNS proxy instance for internal usage
 	// We don't Start() it and so no listen port is required.
 	internalProxy *proxy.Proxy
@@ -219,6 +229,10 @@ func (s *Server) Close() {
 	s.queryLog = nil
 	s.dnsProxy = nil
 
+	closeUpstreamConfigs(s.routingUpstreams)
+	s.routingUpstreams = nil
+	s.resetRewriteUpstreams()
+
 	if err := s.ipset.close(); err != nil {
 		log.Error("closing ipset: %s", err)
 	}
@@ -594,6 +608,10 @@ func (s *Server) Reconfigure(config *ServerConfig) error {
 		return fmt.Errorf("could not reconfigure the server: %w", err)
 	}
 
+	// The bootstrap and the timeout of the rewrite upstreams may have
+	// changed.
+	s.resetRewriteUpstreams()
+
 	err = s.startLocked()
 	if err != nil {
 		return fmt.Errorf("could not reconfigure the server: %w", err)
@@ -602,6 +620,43 @@ func (s *Server) Reconfigure(config *ServerConfig) error {
 	return nil
 }
 
+// resetRewriteUpstreams closes and removes the cached configurations of the
+// rewrite upstreams.
+func (s *Server) resetRewriteUpstreams() {
+	s.rewriteUpstreamsLock.Lock()
+	defer s.rewriteUpstreamsLock.Unlock()
+
+	closeUpstreamConfigs(s.rewriteUpstreams)
+	s.rewriteUpstreams = nil
+}
+
+// closeUpstreamConfigs closes the upstreams of confs.  Since upstream.Upstream
+// doesn't require it, only the upstreams implementing io.Closer are closed.
+func closeUpstreamConfigs(confs map[string]*proxy.UpstreamConfig) {
+	for key, conf := range confs {
+		if conf == nil {
+			continue
+		}
+
+		ups := conf.Upstreams
+		for _, domainUps := range conf.DomainReservedUpstreams {
+			ups = append(ups[:len(ups):len(ups)], domainUps...)
+		}
+
+		for _, u := range ups {
+			c, ok := u.(io.Closer)
+			if !ok {
+				continue
+			}
+
+			err := c.Close()
+			if err != nil {
+				log.Error("dns: closing upstream %s of %q: %s", u.Address(), key, err)
+			}
+		}
+	}
+}
+
 // ServeHTTP is a HTTP handler method we use to provide DNS-over-HTTPS.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if prx := s.proxy(); prx != nil {
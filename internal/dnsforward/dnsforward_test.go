@@ -579,6 +579,116 @@ func TestServerCustomClientUpstream(t *testing.T) {
 	assert.Equal(t, net.IP{192, 168, 0, 1}, reply.Answer[0].(*dns.A).A)
 }
 
+func TestServerRoutingUpstreams(t *testing.T) {
+	forwardConf := ServerConfig{
+		UDPListenAddrs: []*net.UDPAddr{{}},
+		TCPListenAddrs: []*net.TCPAddr{{}},
+		FilteringConfig: FilteringConfig{
+			ProtectionEnabled: true,
+		},
+	}
+	s := createTestServer(t, &filtering.Config{}, forwardConf, nil)
+	s.conf.UpstreamConfig.Upstreams = []upstream.Upstream{&aghtest.TestUpstream{
+		IPv4: map[string][]net.IP{
+			"host.corp.example.":  {{192, 0, 2, 1}},
+			"other.example.":      {{192, 0, 2, 2}},
+			"local.corp.example.": {{192, 0, 2, 3}},
+		},
+	}}
+	s.routingUpstreams = map[string]*proxy.UpstreamConfig{
+		"internal": {
+			Upstreams: []upstream.Upstream{&aghtest.TestUpstream{
+				IPv4: map[string][]net.IP{
+					"host.corp.example.": {{10, 0, 0, 1}},
+				},
+			}},
+		},
+	}
+
+	err := s.dnsFilter.SetRoutingFilters([]filtering.RoutingFilter{{
+		Tag: "internal",
+		Filter: filtering.Filter{
+			ID:   100,
+			Data: []byte("||corp.example^\n@@||local.corp.example^\n"),
+		},
+	}})
+	require.NoError(t, err)
+
+	startDeferStop(t, s)
+
+	addr := s.dnsProxy.Addr(proxy.ProtoUDP).String()
+
+	testCases := []struct {
+		name   string
+		host   string
+		wantIP net.IP
+	}{{
+		name:   "routed",
+		host:   "host.corp.example.",
+		wantIP: net.IP{10, 0, 0, 1},
+	}, {
+		name:   "not_matched",
+		host:   "other.example.",
+		wantIP: net.IP{192, 0, 2, 2},
+	}, {
+		name:   "exception",
+		host:   "local.corp.example.",
+		wantIP: net.IP{192, 0, 2, 3},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reply, eerr := dns.Exchange(createTestMessage(tc.host), addr)
+			require.NoError(t, eerr)
+
+			assert.Equal(t, dns.RcodeSuccess, reply.Rcode)
+			require.Len(t, reply.Answer, 1)
+
+			a, ok := reply.Answer[0].(*dns.A)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.wantIP, a.A.To4())
+		})
+	}
+}
+
+// closerUpstream is an upstream which records whether it's closed.
+type closerUpstream struct {
+	aghtest.TestUpstream
+
+	closed bool
+}
+
+// Close implements the io.Closer interface for *closerUpstream.
+func (u *closerUpstream) Close() (err error) {
+	u.closed = true
+
+	return nil
+}
+
+func TestCloseUpstreamConfigs(t *testing.T) {
+	ups := &closerUpstream{}
+	domainUps := &closerUpstream{}
+
+	s := &Server{
+		rewriteUpstreams: map[string]*proxy.UpstreamConfig{
+			"udp://192.0.2.1": {
+				Upstreams: []upstream.Upstream{ups, &aghtest.TestUpstream{}},
+				DomainReservedUpstreams: map[string][]upstream.Upstream{
+					"example.": {domainUps},
+				},
+			},
+			"udp://192.0.2.2": nil,
+		},
+	}
+
+	s.resetRewriteUpstreams()
+
+	assert.True(t, ups.closed)
+	assert.True(t, domainUps.closed)
+	assert.Nil(t, s.rewriteUpstreams)
+}
+
 // testCNAMEs is a map of names and CNAMEs necessary for the TestUpstream work.
 var testCNAMEs = map[string]string{
 	"badhost.":               "NULL.example.org.",
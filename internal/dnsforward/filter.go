@@ -3,10 +3,13 @@ package dnsforward
 import (
 	"encoding/binary"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/netutil"
 	"github.com/miekg/dns"
@@ -53,6 +56,17 @@ func (s *Server) beforeRequestHandler(
 func (s *Server) getClientRequestFilteringSettings(ctx *dnsContext) *filtering.Settings {
 	setts := s.dnsFilter.GetConfig()
 	setts.ProtectionEnabled = ctx.protectionEnabled
+	if req := ctx.proxyCtx.Req; req != nil {
+		setts.Opcode = req.Opcode
+		setts.ECS = ecsFromMsg(req)
+	}
+
+	if s.dnsFilter.UsesServerIP() {
+		// Determining the address may take a few syscalls, so only do it
+		// when there are rules scoped by it.
+		setts.ServerIP = serverIP(ctx.proxyCtx)
+	}
+
 	if s.conf.FilterHandler != nil {
 		ip, _ := netutil.IPAndPortFromAddr(ctx.proxyCtx.Addr)
 		s.conf.FilterHandler(ip, ctx.clientID, &setts)
@@ -61,6 +75,98 @@ func (s *Server) getClientRequestFilteringSettings(ctx *dnsContext) *filtering.S
 	return &setts
 }
 
+// serverIP returns the address of the server's interface which has received
+// the request from pctx.  The local address of the UDP listeners bound to all
+// interfaces is unspecified, so in that case it's the address of the interface
+// the server would use to reply to the client, see routeLocalIP.  ip is nil if
+// it can't be determined.
+func serverIP(pctx *proxy.DNSContext) (ip net.IP) {
+	var laddr net.Addr
+	if pctx.Conn != nil {
+		laddr = pctx.Conn.LocalAddr()
+	} else if r := pctx.HTTPRequest; r != nil {
+		laddr, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	}
+
+	if laddr != nil {
+		ip, _ = netutil.IPAndPortFromAddr(laddr)
+	}
+
+	if ip != nil && !ip.IsUnspecified() {
+		return ip
+	}
+
+	clientIP, port := netutil.IPAndPortFromAddr(pctx.Addr)
+	if clientIP == nil {
+		return nil
+	}
+
+	ip, err := routeLocalIP(clientIP, port)
+	if err != nil {
+		log.Debug("dnsforward: getting server ip for %s: %s", clientIP, err)
+
+		return nil
+	}
+
+	return ip
+}
+
+// routeLocalIP returns the local address the system chooses for sending to
+// clientIP, which is the address of the interface facing the client.  No
+// packets are sent, since connecting a UDP socket only selects the route.
+func routeLocalIP(clientIP net.IP, port int) (ip net.IP, err error) {
+	if port == 0 {
+		// Connecting to the zero port fails on some systems, and any port
+		// selects the same route.
+		port = 53
+	}
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: clientIP, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	ip, _ = netutil.IPAndPortFromAddr(conn.LocalAddr())
+
+	return ip, nil
+}
+
+// ecsFromMsg returns the subnet from the EDNS Client Subnet option of msg.  ecs
+// is nil if there is no such option or it's malformed.
+func ecsFromMsg(msg *dns.Msg) (ecs *net.IPNet) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+
+	for _, o := range opt.Option {
+		sn, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok {
+			continue
+		}
+
+		bits := net.IPv4len * 8
+		ip := sn.Address.To4()
+		if sn.Family == 2 {
+			bits, ip = net.IPv6len*8, sn.Address.To16()
+		}
+
+		if ip == nil || int(sn.SourceNetmask) > bits {
+			return nil
+		}
+
+		mask := net.CIDRMask(int(sn.SourceNetmask), bits)
+
+		return &net.IPNet{
+			IP:   ip.Mask(mask),
+			Mask: mask,
+		}
+	}
+
+	return nil
+}
+
 // filterDNSRequest applies the dnsFilter and sets d.Res if the request was
 // filtered.
 func (s *Server) filterDNSRequest(ctx *dnsContext) (*filtering.Result, error) {
@@ -72,12 +178,30 @@ func (s *Server) filterDNSRequest(ctx *dnsContext) (*filtering.Result, error) {
 	switch {
 	case err != nil:
 		return nil, fmt.Errorf("failed to check host %q: %w", host, err)
+	case res.Reason == filtering.FilteredInvalid:
+		// The filter doesn't process the request with such an opcode.
+		log.Tracef("host %q is refused, opcode %s", host, dns.OpcodeToString[req.Opcode])
+		d.Res = s.makeResponseREFUSED(req)
+	case res.IsFiltered && res.DNSRewriteResult != nil:
+		// The filter has decided on the exact response, for example for the
+		// blocked services.
+		log.Tracef("host %q is filtered, reason %q, rule: %q", host, res.Reason, res.Rules[0].Text)
+		if err = s.filterDNSRewrite(req, res, d); err != nil {
+			return nil, err
+		}
+	case res.IsFiltered && res.CanonName != "":
+		// Resolve the block page host instead of the blocked one.  The
+		// original question is readded in processFilteringAfterResponse.
+		log.Tracef("host %q is filtered, reason %q, rule: %q", host, res.Reason, res.Rules[0].Text)
+		ctx.origQuestion = q
+		req.Question[0].Name = dns.Fqdn(res.CanonName)
 	case res.IsFiltered:
 		log.Tracef("host %q is filtered, reason %q, rule: %q", host, res.Reason, res.Rules[0].Text)
 		d.Res = s.genDNSFilterMessage(d, &res)
 	case res.Reason.In(filtering.Rewritten, filtering.RewrittenRule) &&
 		res.CanonName != "" &&
-		len(res.IPList) == 0:
+		len(res.IPList) == 0 &&
+		res.DNSRewriteResult == nil:
 		// Resolve the new canonical name, not the original host name.  The
 		// original question is readded in processFilteringAfterResponse.
 		ctx.origQuestion = q
@@ -104,6 +228,16 @@ func (s *Server) filterDNSRequest(ctx *dnsContext) (*filtering.Result, error) {
 			}
 		}
 
+		if dnsrr := res.DNSRewriteResult; dnsrr != nil {
+			var rrs []dns.RR
+			rrs, err = dnsrr.Records(dns.Fqdn(name), q.Qtype, s.conf.BlockedResponseTTL)
+			if err != nil {
+				return nil, fmt.Errorf("rewriting %q: %w", host, err)
+			}
+
+			resp.Answer = append(resp.Answer, rrs...)
+		}
+
 		d.Res = resp
 	case res.Reason.In(filtering.RewrittenRule, filtering.RewrittenAutoHosts):
 		if err = s.filterDNSRewrite(req, res, d); err != nil {
@@ -111,9 +245,51 @@ func (s *Server) filterDNSRequest(ctx *dnsContext) (*filtering.Result, error) {
 		}
 	}
 
+	if d.Res != nil {
+		s.setResponseTTL(d.Res, &res)
+	} else if !res.IsFiltered && res.Upstream == "" {
+		// Route the host which is actually resolved, which may be the
+		// canonical name of a rewrite.
+		routed := strings.TrimSuffix(req.Question[0].Name, ".")
+		if tag, ok := s.dnsFilter.RouteDecision(routed, ctx.setts); ok {
+			ctx.routeTag = tag
+		}
+	}
+
 	return &res, err
 }
 
+// setResponseTTL sets the TTL of the records in resp, which is the response
+// generated for res, according to the filtering configuration.
+func (s *Server) setResponseTTL(resp *dns.Msg, res *filtering.Result) {
+	ttl := s.dnsFilter.ResponseTTL(res, s.conf.BlockedResponseTTL)
+	if ttl == s.conf.BlockedResponseTTL {
+		return
+	}
+
+	for _, rr := range resp.Answer {
+		rr.Header().Ttl = ttl
+	}
+
+	for _, rr := range resp.Ns {
+		rr.Header().Ttl = ttl
+	}
+}
+
+// capAnswerTTL lowers the TTLs of the answer records of resp to ttl, if those
+// are greater.  Zero ttl means no limit.
+func capAnswerTTL(resp *dns.Msg, ttl uint32) {
+	if ttl == 0 {
+		return
+	}
+
+	for _, rr := range resp.Answer {
+		if hdr := rr.Header(); hdr.Ttl > ttl {
+			hdr.Ttl = ttl
+		}
+	}
+}
+
 // checkHostRules checks the host against filters.  It is safe for concurrent
 // use.
 func (s *Server) checkHostRules(host string, qtype uint16, setts *filtering.Settings) (
@@ -169,6 +345,7 @@ func (s *Server) filterDNSResponse(ctx *dnsContext) (*filtering.Result, error) {
 			continue
 		} else if res.IsFiltered {
 			d.Res = s.genDNSFilterMessage(d, res)
+			s.setResponseTTL(d.Res, res)
 			log.Debug("DNSFwd: Matched %s by response: %s", d.Req.Question[0].Name, host)
 
 			return res, nil
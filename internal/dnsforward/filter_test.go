@@ -0,0 +1,289 @@
+package dnsforward
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEcsFromMsg(t *testing.T) {
+	newMsg := func(opts ...dns.EDNS0) (msg *dns.Msg) {
+		msg = (&dns.Msg{}).SetQuestion("example.com.", dns.TypeA)
+		if len(opts) == 0 {
+			return msg
+		}
+
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, opts...)
+
+		return msg
+	}
+
+	testCases := []struct {
+		msg  *dns.Msg
+		want *net.IPNet
+		name string
+	}{{
+		msg: newMsg(&dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: 24,
+			Address:       net.IP{192, 0, 2, 42},
+		}),
+		want: &net.IPNet{
+			IP:   net.IP{192, 0, 2, 0},
+			Mask: net.CIDRMask(24, 32),
+		},
+		name: "ipv4",
+	}, {
+		msg: newMsg(&dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        2,
+			SourceNetmask: 48,
+			Address:       net.ParseIP("2001:db8:1:2::1"),
+		}),
+		want: &net.IPNet{
+			IP:   net.ParseIP("2001:db8:1::"),
+			Mask: net.CIDRMask(48, 128),
+		},
+		name: "ipv6",
+	}, {
+		msg: newMsg(&dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: 33,
+			Address:       net.IP{192, 0, 2, 42},
+		}),
+		want: nil,
+		name: "bad_netmask",
+	}, {
+		msg:  newMsg(&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE}),
+		want: nil,
+		name: "other_option",
+	}, {
+		msg:  newMsg(),
+		want: nil,
+		name: "no_edns",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ecsFromMsg(tc.msg))
+		})
+	}
+}
+
+func TestServer_FilterDNSRequest_route(t *testing.T) {
+	f := filtering.New(&filtering.Config{}, []filtering.Filter{{
+		ID: 1, Data: []byte("||nxdomain.example.org^\n"),
+	}})
+	t.Cleanup(f.Close)
+
+	f.SetEnabled(true)
+
+	err := f.SetRoutingFilters([]filtering.RoutingFilter{{
+		Tag: "internal",
+		Filter: filtering.Filter{
+			ID:   100,
+			Data: []byte("||corp.example^\n||nxdomain.example.org^\n"),
+		},
+	}})
+	require.NoError(t, err)
+
+	s := &Server{
+		dnsFilter: f,
+	}
+
+	testCases := []struct {
+		name    string
+		host    string
+		wantTag string
+	}{{
+		name:    "routed",
+		host:    "host.corp.example.",
+		wantTag: "internal",
+	}, {
+		name:    "not_matched",
+		host:    "other.example.",
+		wantTag: "",
+	}, {
+		name:    "blocked",
+		host:    "nxdomain.example.org.",
+		wantTag: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dctx := &dnsContext{
+				proxyCtx:          &proxy.DNSContext{Req: createTestMessage(tc.host)},
+				protectionEnabled: true,
+			}
+			dctx.setts = s.getClientRequestFilteringSettings(dctx)
+
+			_, ferr := s.filterDNSRequest(dctx)
+			require.NoError(t, ferr)
+
+			assert.Equal(t, tc.wantTag, dctx.routeTag)
+		})
+	}
+}
+
+func TestServer_FilterDNSRequest_selfUpstream(t *testing.T) {
+	f := filtering.New(&filtering.Config{
+		Rewrites: []filtering.RewriteEntry{{
+			Domain:   "loop.example",
+			Answer:   "other.example",
+			Upstream: "udp://127.0.0.1:53",
+		}},
+	}, nil)
+	t.Cleanup(f.Close)
+
+	f.SetEnabled(true)
+
+	s := &Server{
+		dnsFilter: f,
+	}
+
+	dctx := &dnsContext{
+		proxyCtx:          &proxy.DNSContext{Req: createTestMessage("loop.example.")},
+		protectionEnabled: true,
+	}
+	dctx.setts = s.getClientRequestFilteringSettings(dctx)
+
+	res, err := s.filterDNSRequest(dctx)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+
+	assert.Equal(t, filtering.RewrittenRule, res.Reason)
+	assert.Empty(t, res.Upstream)
+	require.NotNil(t, dctx.proxyCtx.Res)
+
+	assert.Equal(t, dns.RcodeServerFailure, dctx.proxyCtx.Res.Rcode)
+}
+
+// testLocalConn is a net.Conn with the local address for tests.
+type testLocalConn struct {
+	// Conn is embedded here simply to make testLocalConn a net.Conn without
+	// actually implementing all methods.
+	net.Conn
+
+	laddr net.Addr
+}
+
+// LocalAddr implements the net.Conn interface for testLocalConn.
+func (c testLocalConn) LocalAddr() (laddr net.Addr) {
+	return c.laddr
+}
+
+func TestServerIP(t *testing.T) {
+	clientAddr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 12345}
+
+	httpReq := func(laddr net.Addr) (r *http.Request) {
+		r = httptest.NewRequest(http.MethodPost, "/dns-query", nil)
+		ctx := context.WithValue(r.Context(), http.LocalAddrContextKey, laddr)
+
+		return r.WithContext(ctx)
+	}
+
+	testCases := []struct {
+		pctx *proxy.DNSContext
+		want net.IP
+		name string
+	}{{
+		pctx: &proxy.DNSContext{
+			Conn: testLocalConn{laddr: &net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 53}},
+			Addr: clientAddr,
+		},
+		want: net.IP{192, 0, 2, 1},
+		name: "specified",
+	}, {
+		pctx: &proxy.DNSContext{
+			Conn: testLocalConn{laddr: &net.UDPAddr{IP: net.IPv4zero, Port: 53}},
+			Addr: clientAddr,
+		},
+		want: net.IP{127, 0, 0, 1},
+		name: "unspecified_v4",
+	}, {
+		pctx: &proxy.DNSContext{
+			Conn: testLocalConn{laddr: &net.UDPAddr{IP: net.IPv6unspecified, Port: 53}},
+			Addr: clientAddr,
+		},
+		want: net.IP{127, 0, 0, 1},
+		name: "unspecified_v6",
+	}, {
+		pctx: &proxy.DNSContext{
+			HTTPRequest: httpReq(&net.TCPAddr{IP: net.IP{192, 0, 2, 2}, Port: 443}),
+			Addr:        &net.TCPAddr{IP: clientAddr.IP, Port: clientAddr.Port},
+		},
+		want: net.IP{192, 0, 2, 2},
+		name: "https",
+	}, {
+		pctx: &proxy.DNSContext{},
+		want: nil,
+		name: "no_addr",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ip := serverIP(tc.pctx)
+			if tc.want == nil {
+				assert.Nil(t, ip)
+
+				return
+			}
+
+			assert.True(t, tc.want.Equal(ip), "got %s", ip)
+		})
+	}
+}
+
+func TestServer_GetClientRequestFilteringSettings_serverIP(t *testing.T) {
+	pctx := &proxy.DNSContext{
+		Req:  createTestMessage("example.org."),
+		Conn: testLocalConn{laddr: &net.UDPAddr{IP: net.IPv4zero, Port: 53}},
+		Addr: &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 12345},
+	}
+
+	testCases := []struct {
+		want net.IP
+		name string
+		data string
+	}{{
+		want: nil,
+		name: "not_used",
+		data: "||example.org^\n",
+	}, {
+		want: net.IP{127, 0, 0, 1},
+		name: "used",
+		data: "||example.org^$ctag=server_ip_127_0_0_1\n",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := filtering.New(&filtering.Config{}, []filtering.Filter{{
+				ID: 1, Data: []byte(tc.data),
+			}})
+			t.Cleanup(f.Close)
+
+			s := &Server{
+				dnsFilter: f,
+			}
+
+			setts := s.getClientRequestFilteringSettings(&dnsContext{proxyCtx: pctx})
+			if tc.want == nil {
+				assert.Nil(t, setts.ServerIP)
+			} else {
+				assert.True(t, tc.want.Equal(setts.ServerIP), "got %s", setts.ServerIP)
+			}
+		})
+	}
+}
@@ -149,38 +149,6 @@ func (s *Server) genAnswerCNAME(req *dns.Msg, cname string) (ans *dns.CNAME) {
 	}
 }
 
-func (s *Server) genAnswerMX(req *dns.Msg, mx *rules.DNSMX) (ans *dns.MX) {
-	return &dns.MX{
-		Hdr:        s.hdr(req, dns.TypeMX),
-		Preference: mx.Preference,
-		Mx:         dns.Fqdn(mx.Exchange),
-	}
-}
-
-func (s *Server) genAnswerPTR(req *dns.Msg, ptr string) (ans *dns.PTR) {
-	return &dns.PTR{
-		Hdr: s.hdr(req, dns.TypePTR),
-		Ptr: dns.Fqdn(ptr),
-	}
-}
-
-func (s *Server) genAnswerSRV(req *dns.Msg, srv *rules.DNSSRV) (ans *dns.SRV) {
-	return &dns.SRV{
-		Hdr:      s.hdr(req, dns.TypeSRV),
-		Priority: srv.Priority,
-		Weight:   srv.Weight,
-		Port:     srv.Port,
-		Target:   dns.Fqdn(srv.Target),
-	}
-}
-
-func (s *Server) genAnswerTXT(req *dns.Msg, strs []string) (ans *dns.TXT) {
-	return &dns.TXT{
-		Hdr: s.hdr(req, dns.TypeTXT),
-		Txt: strs,
-	}
-}
-
 // genResponseWithIPs generates a DNS response message with the provided IP
 // addresses and an appropriate resource record type.  If any of the IPs cannot
 // be converted to the correct protocol, genResponseWithIPs returns an empty
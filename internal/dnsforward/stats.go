@@ -111,9 +111,15 @@ func (s *Server) updateStats(
 		e.Result = stats.RParental
 	case filtering.FilteredSafeSearch:
 		e.Result = stats.RSafeSearch
-	case filtering.FilteredBlockList,
-		filtering.FilteredInvalid,
-		filtering.FilteredBlockedService:
+	case filtering.FilteredBlockList:
+		// The blocks by the non-enforcing lists aren't filtered.
+		if res.IsFiltered {
+			e.Result = stats.RFiltered
+		}
+	case filtering.FilteredInvalid,
+		filtering.FilteredBlockedService,
+		filtering.FilteredDefaultDeny,
+		filtering.FilteredSecurityFailure:
 		e.Result = stats.RFiltered
 	}
 
@@ -54,6 +54,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient string
 		wantCode       resultCode
 		reason         filtering.Reason
+		isFiltered     bool
 		wantStatResult stats.Result
 	}{{
 		name:           "success_udp",
@@ -64,6 +65,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.NotFilteredNotFound,
+		isFiltered:     false,
 		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_tls_client_id",
@@ -74,6 +76,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "cli42",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.NotFilteredNotFound,
+		isFiltered:     false,
 		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_tls",
@@ -84,6 +87,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.NotFilteredNotFound,
+		isFiltered:     false,
 		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_quic",
@@ -94,6 +98,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.NotFilteredNotFound,
+		isFiltered:     false,
 		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_https",
@@ -104,6 +109,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.NotFilteredNotFound,
+		isFiltered:     false,
 		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_dnscrypt",
@@ -114,6 +120,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.NotFilteredNotFound,
+		isFiltered:     false,
 		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_udp_filtered",
@@ -124,7 +131,19 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.FilteredBlockList,
+		isFiltered:     true,
 		wantStatResult: stats.RFiltered,
+	}, {
+		name:           "success_udp_not_enforced",
+		proto:          proxy.ProtoUDP,
+		addr:           &net.UDPAddr{IP: net.IP{1, 2, 3, 4}, Port: 1234},
+		clientID:       "",
+		wantLogProto:   "",
+		wantStatClient: "1.2.3.4",
+		wantCode:       resultCodeSuccess,
+		reason:         filtering.FilteredBlockList,
+		isFiltered:     false,
+		wantStatResult: stats.RNotFiltered,
 	}, {
 		name:           "success_udp_sb",
 		proto:          proxy.ProtoUDP,
@@ -134,6 +153,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.FilteredSafeBrowsing,
+		isFiltered:     true,
 		wantStatResult: stats.RSafeBrowsing,
 	}, {
 		name:           "success_udp_ss",
@@ -144,6 +164,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.FilteredSafeSearch,
+		isFiltered:     true,
 		wantStatResult: stats.RSafeSearch,
 	}, {
 		name:           "success_udp_pc",
@@ -154,6 +175,7 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 		wantStatClient: "1.2.3.4",
 		wantCode:       resultCodeSuccess,
 		reason:         filtering.FilteredParental,
+		isFiltered:     true,
 		wantStatResult: stats.RParental,
 	}}
 
@@ -185,7 +207,8 @@ func TestProcessQueryLogsAndStats(t *testing.T) {
 				proxyCtx:  pctx,
 				startTime: time.Now(),
 				result: &filtering.Result{
-					Reason: tc.reason,
+					Reason:     tc.reason,
+					IsFiltered: tc.isFiltered,
 				},
 				clientID: tc.clientID,
 			}
@@ -0,0 +1,117 @@
+package filtering
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// ClientInfo is the information about a client required to check if a rule
+// applies to it.
+type ClientInfo struct {
+	// ID is the identifier of the client returned by AffectedClients.
+	ID string
+	// Name is the name of the client, if any.
+	Name string
+	// IPs are the addresses of the client, if any.
+	IPs []net.IP
+	// Tags are the tags of the client, if any.
+	Tags []string
+}
+
+// probeHost is the hostname of the probe rule built by AffectedClients.
+const probeHost = "affected-clients.probe.invalid"
+
+// clientModifiers returns the $client and $ctag modifiers of the network rule
+// with text.
+func clientModifiers(text string) (mods []string) {
+	for _, m := range ruleModifiers(text) {
+		if strings.HasPrefix(m, "client=") || strings.HasPrefix(m, "ctag=") {
+			mods = append(mods, m)
+		}
+	}
+
+	return mods
+}
+
+// AffectedClients returns the identifiers of the clients to which rule would
+// apply according to its $client and $ctag modifiers.  The other parts of the
+// rule aren't considered, so all the clients are returned for a rule without
+// such modifiers.  ids is nil if rule isn't a valid rule.
+func (d *DNSFilter) AffectedClients(rule string, clients []ClientInfo) (ids []string) {
+	mods, err := ruleClientModifiers(rule)
+	if err != nil {
+		log.Debug("filtering: checking affected clients: %s", err)
+
+		return nil
+	}
+
+	if len(mods) == 0 {
+		for _, c := range clients {
+			ids = append(ids, c.ID)
+		}
+
+		return ids
+	}
+
+	// Build a rule with only the client constraints of the original one, so
+	// that the rest of the request doesn't matter.
+	probe, err := rules.NewNetworkRule("||"+probeHost+"^$"+strings.Join(mods, ","), CustomListID)
+	if err != nil {
+		log.Debug("filtering: checking affected clients: parsing client modifiers: %s", err)
+
+		return nil
+	}
+
+	for _, c := range clients {
+		if clientAffected(probe, c) {
+			ids = append(ids, c.ID)
+		}
+	}
+
+	return ids
+}
+
+// ruleClientModifiers parses rule and returns its $client and $ctag modifiers.
+func ruleClientModifiers(rule string) (mods []string, err error) {
+	r, err := rules.NewRule(strings.TrimSpace(rule), CustomListID)
+	if err != nil {
+		return nil, err
+	} else if r == nil {
+		return nil, errors.Error("not a rule")
+	}
+
+	if nr, ok := r.(*rules.NetworkRule); ok {
+		mods = clientModifiers(nr.RuleText)
+	}
+
+	return mods, nil
+}
+
+// clientAffected returns true if probe matches the request from the client
+// c by any of its addresses.
+func clientAffected(probe *rules.NetworkRule, c ClientInfo) (ok bool) {
+	req := rules.NewRequestForHostname(probeHost)
+	req.DNSType = dns.TypeA
+	req.ClientName = c.Name
+	req.SortedClientTags = append([]string(nil), c.Tags...)
+	sort.Strings(req.SortedClientTags)
+
+	if len(c.IPs) == 0 {
+		return probe.Match(req)
+	}
+
+	for _, ip := range c.IPs {
+		req.ClientIP = ip.String()
+		if probe.Match(req) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSFilter_AffectedClients(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	clients := []ClientInfo{{
+		ID:   "laptop",
+		Name: "laptop",
+		IPs:  []net.IP{{192, 168, 0, 10}},
+		Tags: []string{"user_admin", "device_laptop"},
+	}, {
+		ID:   "kid-phone",
+		Name: "kid-phone",
+		IPs:  []net.IP{{192, 168, 0, 20}, net.ParseIP("fd00::20")},
+		Tags: []string{"user_child", "device_phone"},
+	}, {
+		ID:   "tv",
+		IPs:  []net.IP{{10, 0, 0, 5}},
+		Tags: []string{"device_tv"},
+	}, {
+		ID:   "unknown",
+		Name: "unknown",
+	}}
+
+	testCases := []struct {
+		name string
+		rule string
+		want []string
+	}{{
+		name: "no_constraints",
+		rule: "||example.org^",
+		want: []string{"laptop", "kid-phone", "tv", "unknown"},
+	}, {
+		name: "hosts_syntax",
+		rule: "0.0.0.0 example.org",
+		want: []string{"laptop", "kid-phone", "tv", "unknown"},
+	}, {
+		name: "ctag",
+		rule: "||example.org^$ctag=user_child",
+		want: []string{"kid-phone"},
+	}, {
+		name: "ctag_several",
+		rule: "||example.org^$ctag=device_tv|device_laptop",
+		want: []string{"laptop", "tv"},
+	}, {
+		name: "ctag_restricted",
+		rule: "||example.org^$ctag=~user_child",
+		want: []string{"laptop", "tv", "unknown"},
+	}, {
+		name: "client_subnet",
+		rule: "||example.org^$client=192.168.0.0/24",
+		want: []string{"laptop", "kid-phone"},
+	}, {
+		name: "client_ipv6",
+		rule: "||example.org^$client=fd00::/64",
+		want: []string{"kid-phone"},
+	}, {
+		name: "client_name",
+		rule: "||example.org^$client=laptop",
+		want: []string{"laptop"},
+	}, {
+		name: "client_restricted",
+		rule: "@@||example.org^$client=~10.0.0.5",
+		want: []string{"laptop", "kid-phone", "unknown"},
+	}, {
+		name: "client_and_ctag",
+		rule: "||example.org^$important,client=192.168.0.0/24,ctag=device_phone",
+		want: []string{"kid-phone"},
+	}, {
+		name: "none",
+		rule: "||example.org^$client=172.16.0.1",
+		want: nil,
+	}, {
+		name: "invalid",
+		rule: "||example.org^$client",
+		want: nil,
+	}, {
+		name: "comment",
+		rule: "! comment",
+		want: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, d.AffectedClients(tc.rule, clients))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package filtering
+
+import (
+	"context"
+	"strings"
+)
+
+// AggregatedResult contains the results of all the filtering mechanisms which
+// have matched a request, see CheckHostAll.
+type AggregatedResult struct {
+	// Results are the matched results in the order the mechanisms are
+	// checked by CheckHost, so the first one is the one CheckHost returns.
+	Results []Result
+}
+
+// Reasons returns the reasons of all the matched results.
+func (r *AggregatedResult) Reasons() (reasons []Reason) {
+	for _, res := range r.Results {
+		reasons = append(reasons, res.Reason)
+	}
+
+	return reasons
+}
+
+// IsFiltered returns true if any of the matched results blocks the request.
+func (r *AggregatedResult) IsFiltered() (ok bool) {
+	for _, res := range r.Results {
+		if res.IsFiltered {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckHostAll is like CheckHost, but runs all the stages instead of stopping
+// at the first matched one and returns all the matched results.  The default
+// deny is only reported if no other stage matched.  Unlike CheckHost, it
+// doesn't report the decisions to the sink.
+func (d *DNSFilter) CheckHostAll(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res AggregatedResult, err error) {
+	if host == "" {
+		return AggregatedResult{}, nil
+	}
+
+	host = strings.ToLower(host)
+
+	collect := func(_ *hostChecker, r Result) (cont bool) {
+		if r.Reason.Matched() {
+			d.redactRules(&r)
+			res.Results = append(res.Results, r)
+		}
+
+		return true
+	}
+
+	_, err = d.walkCheckers(context.Background(), host, qtype, setts, false, collect)
+	if err != nil {
+		return AggregatedResult{}, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,145 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHostAll(t *testing.T) {
+	const data = "||both.example^\n||list.example^\n"
+
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "1.2.3.4",
+		}, {
+			Domain: "cname.example",
+			Answer: "list.example",
+		}},
+	}, []Filter{{ID: 1, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	svcRules := []*rules.NetworkRule{}
+	for _, text := range []string{"||both.example^", "||service.example^"} {
+		r, err := rules.NewNetworkRule(text, BlockedSvcsListID)
+		require.NoError(t, err)
+
+		svcRules = append(svcRules, r)
+	}
+
+	s := setts
+	s.ServicesRules = []ServiceEntry{{
+		Name:  "service",
+		Rules: svcRules,
+	}}
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantReasons []Reason
+		wantBlocked bool
+	}{{
+		name:        "both",
+		host:        "both.example",
+		wantReasons: []Reason{FilteredBlockList, FilteredBlockedService},
+		wantBlocked: true,
+	}, {
+		name:        "list",
+		host:        "list.example",
+		wantReasons: []Reason{FilteredBlockList},
+		wantBlocked: true,
+	}, {
+		name:        "service",
+		host:        "Service.Example",
+		wantReasons: []Reason{FilteredBlockedService},
+		wantBlocked: true,
+	}, {
+		name:        "rewritten",
+		host:        "rewritten.example",
+		wantReasons: []Reason{Rewritten},
+		wantBlocked: false,
+	}, {
+		name:        "rewritten_blocked",
+		host:        "cname.example",
+		wantReasons: []Reason{FilteredBlockList},
+		wantBlocked: true,
+	}, {
+		name:        "none",
+		host:        "example.org",
+		wantReasons: nil,
+		wantBlocked: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHostAll(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReasons, res.Reasons())
+			assert.Equal(t, tc.wantBlocked, res.IsFiltered())
+		})
+	}
+
+	t.Run("rules", func(t *testing.T) {
+		res, err := d.CheckHostAll("both.example", dns.TypeA, &s)
+		require.NoError(t, err)
+		require.Len(t, res.Results, 2)
+
+		require.Len(t, res.Results[0].Rules, 1)
+		assert.Equal(t, int64(1), res.Results[0].Rules[0].FilterListID)
+
+		require.Len(t, res.Results[1].Rules, 1)
+		assert.Equal(t, "||both.example^", res.Results[1].Rules[0].Text)
+
+		// CheckHost still returns the first one.
+		single, err := d.CheckHost("both.example", dns.TypeA, &s)
+		require.NoError(t, err)
+
+		assert.Equal(t, FilteredBlockList, single.Reason)
+	})
+}
+
+func TestDNSFilter_CheckHostAll_defaultDeny(t *testing.T) {
+	d := newForTest(t, &Config{DefaultDeny: true}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters([]Filter{{
+		ID:   1,
+		Data: []byte("||blocked.example^\n"),
+	}}, []Filter{{
+		ID:   2,
+		Data: []byte("@@||allowed.example^\n"),
+	}}, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantReasons []Reason
+	}{{
+		name:        "allowed",
+		host:        "allowed.example",
+		wantReasons: []Reason{NotFilteredAllowList},
+	}, {
+		name:        "blocked",
+		host:        "blocked.example",
+		wantReasons: []Reason{FilteredBlockList},
+	}, {
+		name:        "denied",
+		host:        "other.example",
+		wantReasons: []Reason{FilteredDefaultDeny},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, aErr := d.CheckHostAll(tc.host, dns.TypeA, &setts)
+			require.NoError(t, aErr)
+
+			assert.Equal(t, tc.wantReasons, res.Reasons())
+		})
+	}
+}
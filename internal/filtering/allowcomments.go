@@ -0,0 +1,145 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// allowCommentKey identifies an allow rule by its filter list and text.
+type allowCommentKey struct {
+	text   string
+	listID int64
+}
+
+// allowRuleComments returns the comments explaining the allow rules of the
+// filters, see scanAllowComments.  All the rules of the allow filters are the
+// allow rules, while only the exception rules are among the block ones.  The
+// lists which can't be read are skipped, since those are reported when
+// loading the rules.  comments is nil if there are none.
+func allowRuleComments(block, allow []Filter) (comments map[allowCommentKey]string) {
+	comments = map[allowCommentKey]string{}
+	for _, lists := range []struct {
+		filters  []Filter
+		allowAll bool
+	}{{
+		filters:  block,
+		allowAll: false,
+	}, {
+		filters:  allow,
+		allowAll: true,
+	}} {
+		for _, f := range lists.filters {
+			err := scanFilterAllowComments(f, lists.allowAll, comments)
+			if err != nil {
+				log.Debug("filtering: allow rule comments: filter list %d: %s", f.ID, err)
+			}
+		}
+	}
+
+	if len(comments) == 0 {
+		return nil
+	}
+
+	return comments
+}
+
+// scanFilterAllowComments adds the comments of the allow rules of f into
+// comments, see scanAllowComments.
+func scanFilterAllowComments(
+	f Filter,
+	allowAll bool,
+	comments map[allowCommentKey]string,
+) (err error) {
+	if len(f.Data) != 0 {
+		return scanAllowComments(bytes.NewReader(f.Data), f.ID, allowAll, comments)
+	} else if f.FilePath == "" {
+		return nil
+	}
+
+	file, err := os.Open(f.FilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("opening: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	return scanAllowComments(file, f.ID, allowAll, comments)
+}
+
+// scanAllowComments adds the comments of the allow rules of the list with
+// listID read from r into comments.  A comment
+// applies to the rules following it up to the next empty line or comment, so
+// that
+//
+//	! Required for the bank's app.
+//	@@||bank.example^
+//	@@||cdn.bank.example^
+//
+// explains both rules.  If allowAll is false, only the exception rules, like
+// "@@||example.org^", are considered the allow ones.
+func scanAllowComments(
+	r io.Reader,
+	listID int64,
+	allowAll bool,
+	comments map[allowCommentKey]string,
+) (err error) {
+	var comment string
+
+	s := bufio.NewScanner(r)
+	for first := true; s.Scan(); first = false {
+		line := strings.TrimSpace(s.Text())
+		if first {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+
+		switch {
+		case line == "":
+			comment = ""
+		case isCommentLine(line):
+			comment = strings.TrimSpace(line[1:])
+		case comment == "":
+			// Go on.
+		case allowAll || strings.HasPrefix(line, "@@"):
+			comments[allowCommentKey{text: line, listID: listID}] = comment
+		}
+	}
+
+	return s.Err()
+}
+
+// isCommentLine returns true if the trimmed line is an Adblock-style or
+// hosts-style comment, but not a directive, like "!#include", or a cosmetic
+// rule, like "##.banner".
+func isCommentLine(line string) (ok bool) {
+	switch line[0] {
+	case '!':
+		return !strings.HasPrefix(line, "!#")
+	case '#':
+		return line == "#" || line[1] == ' ' || line[1] == '\t'
+	default:
+		return false
+	}
+}
+
+// withAllowComments sets the comments of the allow rules of res, see
+// ResultRule.Comment.  d.engineLock is expected to be locked.
+func (d *DNSFilter) withAllowComments(res Result) (commented Result) {
+	if res.Reason != NotFilteredAllowList || len(d.allowComments) == 0 {
+		return res
+	}
+
+	for _, r := range res.Rules {
+		r.Comment = d.allowComments[allowCommentKey{text: r.Text, listID: r.FilterListID}]
+	}
+
+	return res
+}
@@ -0,0 +1,103 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_allowComment(t *testing.T) {
+	allowPath := filepath.Join(t.TempDir(), "allow.txt")
+	err := os.WriteFile(allowPath, []byte(
+		"! Title: Allowlist\n"+
+			"\n"+
+			"! Needed for the login page.\n"+
+			"||login.example^\n"+
+			"\n"+
+			"||uncommented.example^\n",
+	), 0o644)
+	require.NoError(t, err)
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err = d.SetFilters([]Filter{{
+		ID: 1,
+		Data: []byte("||example.com^\n" +
+			"! Breaks the bank's app.\n" +
+			"@@||bank.example.com^\n" +
+			"@@||cdn.bank.example.com^\n" +
+			"# Hosts-style comment.\n" +
+			"@@||hosts.example.com^\n" +
+			"##.banner\n" +
+			"@@||cosmetic.example.com^\n",
+		),
+	}}, []Filter{{
+		ID:       2,
+		FilePath: allowPath,
+	}}, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		host    string
+		wantID  int64
+		wantCmt string
+	}{{
+		name:    "exception",
+		host:    "bank.example.com",
+		wantID:  1,
+		wantCmt: "Breaks the bank's app.",
+	}, {
+		name:    "exception_same_comment",
+		host:    "cdn.bank.example.com",
+		wantID:  1,
+		wantCmt: "Breaks the bank's app.",
+	}, {
+		name:    "hosts_comment",
+		host:    "hosts.example.com",
+		wantID:  1,
+		wantCmt: "Hosts-style comment.",
+	}, {
+		name:    "after_cosmetic",
+		host:    "cosmetic.example.com",
+		wantID:  1,
+		wantCmt: "Hosts-style comment.",
+	}, {
+		name:    "allowlist",
+		host:    "login.example",
+		wantID:  2,
+		wantCmt: "Needed for the login page.",
+	}, {
+		name:    "allowlist_no_comment",
+		host:    "uncommented.example",
+		wantID:  2,
+		wantCmt: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, NotFilteredAllowList, res.Reason)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantID, res.Rules[0].FilterListID)
+			assert.Equal(t, tc.wantCmt, res.Rules[0].Comment)
+		})
+	}
+
+	t.Run("blocked", func(t *testing.T) {
+		res, cErr := d.CheckHost("example.com", dns.TypeA, &setts)
+		require.NoError(t, cErr)
+		require.Len(t, res.Rules, 1)
+
+		assert.True(t, res.IsFiltered)
+		assert.Empty(t, res.Rules[0].Comment)
+	})
+}
@@ -0,0 +1,232 @@
+package filtering
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghio"
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// Magic numbers of the supported archive formats.
+const (
+	zipMagic  = "PK\x03\x04"
+	gzipMagic = "\x1f\x8b"
+)
+
+// The limits of the uncompressed archives.
+const (
+	// maxArchiveEntrySize is the maximum size of a single archive entry.
+	maxArchiveEntrySize = 64 * 1024 * 1024
+
+	// maxArchiveSize is the maximum total size of the archive entries.
+	maxArchiveSize = 256 * 1024 * 1024
+)
+
+// Errors of loading the archives.
+const (
+	// errUnknownArchive is returned when the archive is neither a zip nor a
+	// tar.gz one.
+	errUnknownArchive errors.Error = "unknown archive format"
+
+	// errArchiveEntryTooLarge is returned when an archive entry exceeds
+	// maxArchiveEntrySize.
+	errArchiveEntryTooLarge errors.Error = "archive entry is too large"
+
+	// errArchiveTooLarge is returned when the archive entries exceed
+	// maxArchiveSize in total.
+	errArchiveTooLarge errors.Error = "archive is too large"
+
+	// errArchiveDupID is returned when two archive entries have the same
+	// filter ID, see archiveListID.
+	errArchiveDupID errors.Error = "duplicate archive list id"
+)
+
+// LoadListsFromArchive returns the filters with the rule lists from the zip or
+// tar.gz archive of size read from r.  The format is detected by the content.
+// Each regular file of the archive becomes a filter with an ID derived from its
+// path within the archive, so the IDs are the same each time the archive is
+// loaded.  The files which don't look like text are skipped.  The entries
+// larger than maxArchiveEntrySize, the archives larger than maxArchiveSize in
+// total, and the entries with the same ID are rejected.
+func (d *DNSFilter) LoadListsFromArchive(r io.ReaderAt, size int64) (filters []Filter, err error) {
+	magic := make([]byte, len(zipMagic))
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+
+	magic = magic[:n]
+	switch {
+	case bytes.HasPrefix(magic, []byte(zipMagic)):
+		filters, err = zipLists(r, size)
+	case bytes.HasPrefix(magic, []byte(gzipMagic)):
+		filters, err = tarGzipLists(io.NewSectionReader(r, 0, size))
+	default:
+		return nil, errUnknownArchive
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return filters, nil
+}
+
+// archiveLists collects the filters from the entries of an archive.
+type archiveLists struct {
+	// names are the names of the entries by the IDs of their filters.
+	names   map[int64]string
+	filters []Filter
+	// total is the total size of the entries read so far.
+	total int64
+}
+
+// add reads the entry with name from r and appends its filter, unless the
+// data doesn't look like text.
+func (l *archiveLists) add(name string, r io.Reader) (err error) {
+	limit := int64(maxArchiveEntrySize)
+	limitErr := errArchiveEntryTooLarge
+	if rest := maxArchiveSize - l.total; rest < limit {
+		limit, limitErr = rest, errArchiveTooLarge
+	}
+
+	r, err = aghio.LimitReader(r, limit)
+	if err != nil {
+		// Shouldn't happen, since limit is never negative.
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		lre := &aghio.LimitReachedError{}
+		if errors.As(err, &lre) {
+			return limitErr
+		}
+
+		return fmt.Errorf("reading: %w", err)
+	}
+
+	l.total += int64(len(data))
+	if !isTextList(data) {
+		return nil
+	}
+
+	id := archiveListID(name)
+	if prev, ok := l.names[id]; ok {
+		return fmt.Errorf("%w %d, also used by %q", errArchiveDupID, id, prev)
+	}
+
+	if l.names == nil {
+		l.names = map[int64]string{}
+	}
+
+	l.names[id] = name
+	l.filters = append(l.filters, Filter{
+		ID:   id,
+		Data: data,
+	})
+
+	return nil
+}
+
+// zipLists returns the filters from the zip archive of size read from r.
+func zipLists(r io.ReaderAt, size int64) (filters []Filter, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("opening zip: %w", err)
+	}
+
+	l := &archiveLists{}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		err = addZipFile(l, f)
+		if err != nil {
+			return nil, fmt.Errorf("zip entry %q: %w", f.Name, err)
+		}
+	}
+
+	return l.filters, nil
+}
+
+// addZipFile adds the uncompressed content of f to l.
+func addZipFile(l *archiveLists, f *zip.File) (err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("opening: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, rc.Close()) }()
+
+	return l.add(f.Name, rc)
+}
+
+// tarGzipLists returns the filters from the tar.gz archive read from r.
+func tarGzipLists(r io.Reader) (filters []Filter, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening gzip: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, gr.Close()) }()
+
+	l := &archiveLists{}
+	tr := tar.NewReader(gr)
+	for {
+		var hdr *tar.Header
+		hdr, err = tr.Next()
+		if errors.Is(err, io.EOF) {
+			return l.filters, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		err = l.add(hdr.Name, tr)
+		if err != nil {
+			return nil, fmt.Errorf("tar entry %q: %w", hdr.Name, err)
+		}
+	}
+}
+
+// archiveListID returns the positive filter ID derived from the name of the
+// archive entry.  The ID fits into int32, since urlfilter packs the list IDs
+// into the 32 bits of the rule indexes.
+func archiveListID(name string) (id int64) {
+	h := fnv.New32a()
+	// Writing to a hash never fails.
+	_, _ = h.Write([]byte(name))
+
+	id = int64(h.Sum32() & math.MaxInt32)
+	if id == 0 {
+		id = 1
+	}
+
+	return id
+}
+
+// isTextList returns true if data is a valid UTF-8 text without the control
+// characters other than CR, LF, and TAB.
+func isTextList(data []byte) (ok bool) {
+	if !utf8.Valid(data) {
+		return false
+	}
+
+	for _, c := range data {
+		if c < ' ' && c != '\n' && c != '\r' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
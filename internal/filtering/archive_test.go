@@ -0,0 +1,197 @@
+package filtering
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testArchiveFiles are the contents of the test archives.
+var testArchiveFiles = []struct {
+	name string
+	data string
+}{{
+	name: "lists/ads.txt",
+	data: "! Title: Ads\n||ads.example^\n",
+}, {
+	name: "lists/trackers.txt",
+	data: "||trackers.example^\n0.0.0.0 metrics.example\n",
+}, {
+	name: "logo.png",
+	data: "\x89PNG\r\n\x1a\n\x00\x00",
+}}
+
+// newTestZip returns the zip archive with testArchiveFiles.
+func newTestZip(t *testing.T) (data []byte) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, f := range testArchiveFiles {
+		w, err := zw.Create(f.name)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+
+	_, err := zw.Create("lists/empty-dir/")
+	require.NoError(t, err)
+
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+// newTestTarGzip returns the tar.gz archive with testArchiveFiles.
+func newTestTarGzip(t *testing.T) (data []byte) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	err := tw.WriteHeader(&tar.Header{
+		Name:     "lists/",
+		Typeflag: tar.TypeDir,
+		Mode:     0o755,
+	})
+	require.NoError(t, err)
+
+	for _, f := range testArchiveFiles {
+		err = tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(f.data)),
+		})
+		require.NoError(t, err)
+
+		_, err = tw.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+
+	return buf.Bytes()
+}
+
+func TestDNSFilter_LoadListsFromArchive(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name string
+		data []byte
+	}{{
+		name: "zip",
+		data: newTestZip(t),
+	}, {
+		name: "tar_gzip",
+		data: newTestTarGzip(t),
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filters, err := d.LoadListsFromArchive(
+				bytes.NewReader(tc.data),
+				int64(len(tc.data)),
+			)
+			require.NoError(t, err)
+			require.Len(t, filters, 2)
+
+			assert.Equal(t, testArchiveFiles[0].data, string(filters[0].Data))
+			assert.Equal(t, testArchiveFiles[1].data, string(filters[1].Data))
+
+			assert.Equal(t, archiveListID("lists/ads.txt"), filters[0].ID)
+			assert.Equal(t, archiveListID("lists/trackers.txt"), filters[1].ID)
+			assert.NotEqual(t, filters[0].ID, filters[1].ID)
+
+			fd := newForTest(t, nil, filters)
+			t.Cleanup(fd.Close)
+
+			for _, host := range []string{
+				"ads.example",
+				"trackers.example",
+				"metrics.example",
+			} {
+				res, cErr := fd.CheckHost(host, dns.TypeA, &setts)
+				require.NoError(t, cErr)
+
+				assert.True(t, res.IsFiltered, host)
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		data := []byte("||example.org^\n")
+		_, err := d.LoadListsFromArchive(bytes.NewReader(data), int64(len(data)))
+
+		assert.ErrorIs(t, err, errUnknownArchive)
+	})
+
+	newZip := func(t *testing.T, name string, datas ...[]byte) (data []byte) {
+		t.Helper()
+
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+
+		for _, d := range datas {
+			_, err = w.Write(d)
+			require.NoError(t, err)
+		}
+
+		// Add the entry with the same name to check the duplicates.
+		w, err = zw.Create(name)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(testArchiveFiles[0].data))
+		require.NoError(t, err)
+
+		require.NoError(t, zw.Close())
+
+		return buf.Bytes()
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		data := newZip(t, "lists/ads.txt", []byte(testArchiveFiles[0].data))
+		_, err := d.LoadListsFromArchive(bytes.NewReader(data), int64(len(data)))
+
+		assert.ErrorIs(t, err, errArchiveDupID)
+	})
+
+	t.Run("entry_too_large", func(t *testing.T) {
+		const chunkSize = 1024 * 1024
+
+		chunk := bytes.Repeat([]byte("\n"), chunkSize)
+		datas := make([][]byte, maxArchiveEntrySize/chunkSize+1)
+		for i := range datas {
+			datas[i] = chunk
+		}
+
+		data := newZip(t, "lists/large.txt", datas...)
+		_, err := d.LoadListsFromArchive(bytes.NewReader(data), int64(len(data)))
+
+		assert.ErrorIs(t, err, errArchiveEntryTooLarge)
+	})
+
+	t.Run("too_large", func(t *testing.T) {
+		l := &archiveLists{
+			total: maxArchiveSize - 1,
+		}
+
+		err := l.add("lists/small.txt", strings.NewReader("\n"))
+		assert.ErrorIs(t, err, errArchiveTooLarge)
+		assert.Empty(t, l.filters)
+	})
+}
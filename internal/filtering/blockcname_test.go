@@ -0,0 +1,84 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_blockCNAME(t *testing.T) {
+	const (
+		text = "||blocked.example^\n" +
+			"0.0.0.0 hosts.example\n" +
+			"@@||allowed.example^\n"
+		blockHost = "block-page.lan"
+	)
+
+	testCases := []struct {
+		name      string
+		conf      string
+		host      string
+		wantCanon string
+		qtype     uint16
+		wantBlock bool
+	}{{
+		name:      "a",
+		conf:      blockHost,
+		host:      "blocked.example",
+		wantCanon: blockHost,
+		qtype:     dns.TypeA,
+		wantBlock: true,
+	}, {
+		name:      "aaaa",
+		conf:      blockHost + ".",
+		host:      "blocked.example",
+		wantCanon: blockHost,
+		qtype:     dns.TypeAAAA,
+		wantBlock: true,
+	}, {
+		name:      "other_qtype",
+		conf:      blockHost,
+		host:      "blocked.example",
+		wantCanon: "",
+		qtype:     dns.TypeTXT,
+		wantBlock: true,
+	}, {
+		name:      "default",
+		conf:      "",
+		host:      "blocked.example",
+		wantCanon: "",
+		qtype:     dns.TypeA,
+		wantBlock: true,
+	}, {
+		name:      "host_rule",
+		conf:      blockHost,
+		host:      "hosts.example",
+		wantCanon: "",
+		qtype:     dns.TypeA,
+		wantBlock: true,
+	}, {
+		name:      "allowed",
+		conf:      blockHost,
+		host:      "allowed.example",
+		wantCanon: "",
+		qtype:     dns.TypeA,
+		wantBlock: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{BlockCNAME: tc.conf}, []Filter{{
+				ID: 1, Data: []byte(text),
+			}})
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantBlock, res.IsFiltered)
+			assert.Equal(t, tc.wantCanon, res.CanonName)
+		})
+	}
+}
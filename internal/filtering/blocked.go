@@ -2,14 +2,23 @@ package filtering
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
 )
 
 var serviceRules map[string][]*rules.NetworkRule // service name -> filtering rules
 
+// serviceRulesLock protects serviceRules and customServices, since the custom
+// services may be added and removed at runtime, see AddBlockedService.
+var serviceRulesLock sync.RWMutex
+
 type svc struct {
 	name  string
 	rules []string
@@ -233,8 +242,12 @@ var serviceRulesArray = []svc{
 	}},
 }
 
-// convert array to map
+// convert array to map.  The custom services are removed.
 func initBlockedServices() {
+	serviceRulesLock.Lock()
+	defer serviceRulesLock.Unlock()
+
+	customServices = nil
 	serviceRules = make(map[string][]*rules.NetworkRule)
 	for _, s := range serviceRulesArray {
 		netRules := []*rules.NetworkRule{}
@@ -250,33 +263,189 @@ func initBlockedServices() {
 	}
 }
 
-// BlockedSvcKnown - return TRUE if a blocked service name is known
+// RuleTexts returns the texts of the filtering rules of the service.
+func (e ServiceEntry) RuleTexts() (texts []string) {
+	texts = make([]string, 0, len(e.Rules))
+	for _, r := range e.Rules {
+		texts = append(texts, r.Text())
+	}
+
+	return texts
+}
+
+// Patterns returns the domain patterns the service covers, which are the texts
+// of its rules without the anchors and the separators, like "youtube.com" for
+// "||youtube.com^".  Each pattern matches the domain itself and its
+// subdomains, unless it's a part of a domain, like "youtube" for "||youtube".
+func (e ServiceEntry) Patterns() (pats []string) {
+	pats = make([]string, 0, len(e.Rules))
+	for _, r := range e.Rules {
+		pats = append(pats, servicePattern(r.Text()))
+	}
+
+	return pats
+}
+
+// servicePattern returns the domain pattern of the blocked service rule text,
+// see ServiceEntry.Patterns.
+func servicePattern(text string) (pat string) {
+	if i := strings.IndexByte(text, '$'); i >= 0 {
+		text = text[:i]
+	}
+
+	text = strings.TrimPrefix(text, "||")
+
+	return strings.TrimSuffix(text, "^")
+}
+
+// mostSpecificRule returns the rule of the service matching req with the
+// longest pattern, see servicePattern, so that "||cdn.example.com^" is
+// preferred over "||example".  The earlier rules win the ties.  r is nil if
+// none of the rules match.
+func (e ServiceEntry) mostSpecificRule(req *rules.Request) (r *rules.NetworkRule) {
+	bestLen := -1
+	for _, rule := range e.Rules {
+		if !rule.Match(req) {
+			continue
+		}
+
+		if l := len(servicePattern(rule.Text())); l > bestLen {
+			r, bestLen = rule, l
+		}
+	}
+
+	return r
+}
+
+// BlockedServiceEntry returns the entry of the known blocked service with name
+// along with all its rules.  ok is false if the service is unknown.  The rules
+// of the entry must not be modified.
+func BlockedServiceEntry(name string) (e ServiceEntry, ok bool) {
+	name = normalizeServiceName(name)
+	rules, ok := knownServiceRules(name)
+	if !ok {
+		return ServiceEntry{}, false
+	}
+
+	return ServiceEntry{
+		Name:  name,
+		Rules: rules,
+	}, true
+}
+
+// normalizeServiceName returns the blocked service name s as used by
+// BlockedSvcKnown, that is trimmed and lowercased, so that the names like
+// " YouTube" in the configuration are still recognized.
+func normalizeServiceName(s string) (name string) {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// BlockedSvcKnown returns true if the blocked service with name s is known.
+// s is normalized, see normalizeServiceName.
 func BlockedSvcKnown(s string) bool {
-	_, ok := serviceRules[s]
+	_, ok := knownServiceRules(s)
 	return ok
 }
 
+// knownServiceRules returns the rules of the built-in or custom service with
+// name.  name is normalized, see normalizeServiceName.
+func knownServiceRules(name string) (rules []*rules.NetworkRule, ok bool) {
+	serviceRulesLock.RLock()
+	defer serviceRulesLock.RUnlock()
+
+	rules, ok = serviceRules[normalizeServiceName(name)]
+
+	return rules, ok
+}
+
 // ApplyBlockedServices - set blocked services settings for this DNS request
 func (d *DNSFilter) ApplyBlockedServices(setts *Settings, list []string, global bool) {
-	setts.ServicesRules = []ServiceEntry{}
 	if global {
 		d.confLock.RLock()
 		defer d.confLock.RUnlock()
 		list = d.Config.BlockedServices
 	}
-	for _, name := range list {
-		rules, ok := serviceRules[name]
 
+	setts.ServicesRules = effectiveServices(list, ClientServices{})
+}
+
+// BlockedServiceRules returns the entries of the globally blocked services
+// currently in effect, which are the known services from
+// Config.BlockedServices in the same order.  The rules of the entries must not
+// be modified.
+func (d *DNSFilter) BlockedServiceRules() (svcs []ServiceEntry) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	return effectiveServices(d.Config.BlockedServices, ClientServices{})
+}
+
+// ClientServices are the blocked services directives of a single client.
+type ClientServices struct {
+	// Block are the services blocked for the client in addition to the
+	// global ones.
+	Block []string
+	// Allow are the services not blocked for the client, even if those are
+	// blocked globally or by Block.
+	Allow []string
+	// Replace makes Block replace the global services instead of adding to
+	// them.
+	Replace bool
+}
+
+// ApplyClientBlockedServices sets the blocked services of setts to the global
+// ones merged with the client's ones, see effectiveServices.
+func (d *DNSFilter) ApplyClientBlockedServices(setts *Settings, client ClientServices) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	setts.ServicesRules = effectiveServices(d.Config.BlockedServices, client)
+}
+
+// effectiveServices returns the entries for the services blocked for the
+// client.  The precedence is as follows:
+//
+//   - the services from global are blocked, unless client.Replace is true;
+//
+//   - the services from client.Block are blocked in addition;
+//
+//   - the services from client.Allow aren't blocked, even if those are also
+//     in global or client.Block.
+//
+// The entries follow the order of global and then client.Block without
+// duplicates.  The unknown services are skipped.
+func effectiveServices(global []string, client ClientServices) (svcs []ServiceEntry) {
+	svcs = []ServiceEntry{}
+
+	names := global
+	if client.Replace {
+		names = nil
+	}
+
+	names = append(names[:len(names):len(names)], client.Block...)
+
+	seen := stringutil.NewSet(client.Allow...)
+	for _, name := range names {
+		if seen.Has(name) {
+			continue
+		}
+
+		seen.Add(name)
+
+		rules, ok := knownServiceRules(name)
 		if !ok {
 			log.Error("unknown service name: %s", name)
+
 			continue
 		}
 
-		s := ServiceEntry{}
-		s.Name = name
-		s.Rules = rules
-		setts.ServicesRules = append(setts.ServicesRules, s)
+		svcs = append(svcs, ServiceEntry{
+			Name:  name,
+			Rules: rules,
+		})
 	}
+
+	return svcs
 }
 
 func (d *DNSFilter) handleBlockedServicesList(w http.ResponseWriter, r *http.Request) {
@@ -300,6 +469,10 @@ func (d *DNSFilter) handleBlockedServicesSet(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	for i, s := range list {
+		list[i] = normalizeServiceName(s)
+	}
+
 	d.confLock.Lock()
 	d.Config.BlockedServices = list
 	d.confLock.Unlock()
@@ -314,3 +487,56 @@ func (d *DNSFilter) registerBlockedServicesHandlers() {
 	d.Config.HTTPRegister(http.MethodGet, "/control/blocked_services/list", d.handleBlockedServicesList)
 	d.Config.HTTPRegister(http.MethodPost, "/control/blocked_services/set", d.handleBlockedServicesSet)
 }
+
+// The special values of Config.BlockedServiceResponse.
+const (
+	// BlockedSvcRespDefault means responding according to the server's
+	// blocking mode.
+	BlockedSvcRespDefault = ""
+	// BlockedSvcRespNXDomain means responding with NXDOMAIN.
+	BlockedSvcRespNXDomain = "nxdomain"
+	// BlockedSvcRespNoData means responding with NOERROR and no answers.
+	BlockedSvcRespNoData = "nodata"
+)
+
+// blockedServiceRewrite returns the DNS rewrite result for the requests
+// blocked by the blocked services according to the configured response.  res
+// is nil if the server's blocking mode should be used.
+func (d *DNSFilter) blockedServiceRewrite() (res *DNSRewriteResult) {
+	d.confLock.RLock()
+	resp := d.BlockedServiceResponse
+	d.confLock.RUnlock()
+
+	switch resp {
+	case BlockedSvcRespDefault:
+		return nil
+	case BlockedSvcRespNXDomain:
+		return &DNSRewriteResult{RCode: dns.RcodeNameError}
+	case BlockedSvcRespNoData:
+		return &DNSRewriteResult{
+			RCode:    dns.RcodeSuccess,
+			Response: DNSRewriteResultResponse{},
+		}
+	default:
+		// Go on.
+	}
+
+	ip := net.ParseIP(resp)
+	if ip == nil {
+		log.Debug("blocked services: bad response %q, using default", resp)
+
+		return nil
+	}
+
+	rrType := dns.TypeAAAA
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, rrType = ip4, dns.TypeA
+	}
+
+	return &DNSRewriteResult{
+		RCode: dns.RcodeSuccess,
+		Response: DNSRewriteResultResponse{
+			rrType: []rules.RRValue{ip},
+		},
+	}
+}
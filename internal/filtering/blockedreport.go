@@ -0,0 +1,112 @@
+package filtering
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// BlockedHostReport is the information about a blocked host from a sample, see
+// FilterBlocked.
+type BlockedHostReport struct {
+	// Host is the blocked hostname.
+	Host string
+	// Rules are the rules which have blocked the host.
+	Rules []*ResultRule
+	// Count is the number of times the host is met in the sample.
+	Count int
+	// Reason is the reason of the block.
+	Reason Reason
+}
+
+// BlockedHostReportCSVHeader is the header of the CSV table with the records
+// returned by BlockedHostReport.CSVRecord.
+var BlockedHostReportCSVHeader = []string{"host", "count", "reason", "rules", "filter_list_ids"}
+
+// CSVRecord returns the report as the CSV record with the fields described by
+// BlockedHostReportCSVHeader.  The rules and the filter list IDs are separated
+// with newlines.
+func (r *BlockedHostReport) CSVRecord() (rec []string) {
+	texts := make([]string, 0, len(r.Rules))
+	ids := make([]string, 0, len(r.Rules))
+	for _, rr := range r.Rules {
+		texts = append(texts, rr.Text)
+		ids = append(ids, strconv.FormatInt(rr.FilterListID, 10))
+	}
+
+	return []string{
+		r.Host,
+		strconv.Itoa(r.Count),
+		r.Reason.String(),
+		strings.Join(texts, "\n"),
+		strings.Join(ids, "\n"),
+	}
+}
+
+// FilterBlocked checks the A requests for hosts from the client with setts and
+// returns the reports about the blocked ones in the order of their first
+// appearance.  The hosts are checked through the same stages as with CheckHost,
+// but the network-based ones, like safe browsing, are skipped, and the decisions
+// aren't reported to the sink.
+func (d *DNSFilter) FilterBlocked(hosts []string, setts *Settings) (reports []BlockedHostReport, err error) {
+	// indexes are the indexes of the already checked hosts within reports
+	// or -1 if those aren't blocked.
+	indexes := map[string]int{}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host == "" {
+			continue
+		}
+
+		if i, ok := indexes[host]; ok {
+			if i >= 0 {
+				reports[i].Count++
+			}
+
+			continue
+		}
+
+		var res Result
+		res, err = d.checkBlocked(host, setts)
+		if err != nil {
+			return nil, fmt.Errorf("checking %q: %w", host, err)
+		}
+
+		if !res.IsFiltered {
+			indexes[host] = -1
+
+			continue
+		}
+
+		d.redactRules(&res)
+		indexes[host] = len(reports)
+		reports = append(reports, BlockedHostReport{
+			Host:   host,
+			Rules:  res.Rules,
+			Count:  1,
+			Reason: res.Reason,
+		})
+	}
+
+	return reports, nil
+}
+
+// checkBlocked returns the result of the A request for host the same way
+// CheckHost does, but skipping the remote stages.
+func (d *DNSFilter) checkBlocked(host string, setts *Settings) (res Result, err error) {
+	decide := func(_ *hostChecker, hcRes Result) (cont bool) {
+		res = hcRes
+
+		return !res.Reason.Matched()
+	}
+
+	_, err = d.walkCheckers(context.Background(), host, dns.TypeA, setts, true, decide)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return res, nil
+}
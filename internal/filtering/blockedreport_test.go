@@ -0,0 +1,101 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_FilterBlocked(t *testing.T) {
+	const data = "||ads.example^\n" +
+		"@@||good.ads.example^\n" +
+		"0.0.0.0 tracker.example\n" +
+		"||rewritten.example^\n"
+
+	d := newForTest(t, &Config{
+		SafeBrowsingEnabled: true,
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "1.2.3.4",
+		}, {
+			// The canonical names are checked as with CheckHost.
+			Domain: "cname.example",
+			Answer: "www.ads.example",
+		}},
+	}, []Filter{{ID: 1, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	// The safe browsing upstream would block sb.example, but it must not be
+	// used.
+	sbUps := &aghtest.TestBlockUpstream{Hostname: "sb.example", Block: true}
+	d.SetSafeBrowsingUpstream(sbUps)
+
+	svcRule, err := rules.NewNetworkRule("||service.example^", BlockedSvcsListID)
+	require.NoError(t, err)
+
+	s := setts
+	s.SafeBrowsingEnabled = true
+	s.ServicesRules = []ServiceEntry{{
+		Name:  "service",
+		Rules: []*rules.NetworkRule{svcRule},
+	}}
+
+	sample := []string{
+		"www.ads.example",
+		"good.ads.example",
+		"tracker.example",
+		"example.org",
+		"WWW.ADS.EXAMPLE.",
+		"rewritten.example",
+		"cname.example",
+		"service.example",
+		"",
+		"sb.example",
+		"www.ads.example",
+	}
+
+	reports, err := d.FilterBlocked(sample, &s)
+	require.NoError(t, err)
+	require.Len(t, reports, 4)
+
+	assert.Zero(t, sbUps.RequestsCount())
+
+	assert.Equal(t, "www.ads.example", reports[0].Host)
+	assert.Equal(t, 3, reports[0].Count)
+	assert.Equal(t, FilteredBlockList, reports[0].Reason)
+	require.Len(t, reports[0].Rules, 1)
+	assert.Equal(t, "||ads.example^", reports[0].Rules[0].Text)
+
+	assert.Equal(t, "tracker.example", reports[1].Host)
+	assert.Equal(t, 1, reports[1].Count)
+	assert.Equal(t, FilteredBlockList, reports[1].Reason)
+
+	assert.Equal(t, "cname.example", reports[2].Host)
+	assert.Equal(t, FilteredBlockList, reports[2].Reason)
+
+	assert.Equal(t, "service.example", reports[3].Host)
+	assert.Equal(t, FilteredBlockedService, reports[3].Reason)
+
+	assert.Equal(t, []string{
+		"www.ads.example",
+		"3",
+		"FilteredBlackList",
+		"||ads.example^",
+		"1",
+	}, reports[0].CSVRecord())
+	assert.Len(t, BlockedHostReportCSVHeader, len(reports[0].CSVRecord()))
+
+	t.Run("filtering_disabled", func(t *testing.T) {
+		ds := s
+		ds.FilteringEnabled = false
+
+		reports, err = d.FilterBlocked(sample, &ds)
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+
+		assert.Equal(t, "service.example", reports[0].Host)
+	})
+}
@@ -0,0 +1,358 @@
+package filtering
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveServices(t *testing.T) {
+	InitModule()
+
+	names := func(svcs []ServiceEntry) (res []string) {
+		for _, s := range svcs {
+			res = append(res, s.Name)
+		}
+
+		return res
+	}
+
+	testCases := []struct {
+		name   string
+		global []string
+		client ClientServices
+		want   []string
+	}{{
+		name:   "global",
+		global: []string{"youtube", "tiktok"},
+		client: ClientServices{},
+		want:   []string{"youtube", "tiktok"},
+	}, {
+		name:   "add",
+		global: []string{"youtube"},
+		client: ClientServices{Block: []string{"tiktok", "youtube"}},
+		want:   []string{"youtube", "tiktok"},
+	}, {
+		name:   "subtract",
+		global: []string{"youtube", "tiktok"},
+		client: ClientServices{Allow: []string{"youtube"}},
+		want:   []string{"tiktok"},
+	}, {
+		name:   "add_and_subtract",
+		global: []string{"youtube", "tiktok"},
+		client: ClientServices{
+			Block: []string{"twitter"},
+			Allow: []string{"tiktok"},
+		},
+		want: []string{"youtube", "twitter"},
+	}, {
+		name:   "conflict",
+		global: nil,
+		client: ClientServices{
+			Block: []string{"twitter", "tiktok"},
+			Allow: []string{"twitter"},
+		},
+		want: []string{"tiktok"},
+	}, {
+		name:   "replace",
+		global: []string{"youtube"},
+		client: ClientServices{
+			Block:   []string{"tiktok"},
+			Replace: true,
+		},
+		want: []string{"tiktok"},
+	}, {
+		name:   "unknown",
+		global: []string{"unknown_service", "youtube"},
+		client: ClientServices{},
+		want:   []string{"youtube"},
+	}, {
+		name:   "none",
+		global: []string{"youtube"},
+		client: ClientServices{Allow: []string{"youtube"}},
+		want:   nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svcs := effectiveServices(tc.global, tc.client)
+			require.NotNil(t, svcs)
+
+			assert.Equal(t, tc.want, names(svcs))
+			for _, s := range svcs {
+				assert.NotEmpty(t, s.Rules)
+			}
+		})
+	}
+}
+
+func TestDNSFilter_ApplyClientBlockedServices(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, &Config{BlockedServices: []string{"youtube"}}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name    string
+		client  ClientServices
+		host    string
+		blocked bool
+	}{{
+		name:    "global",
+		client:  ClientServices{},
+		host:    "youtube.com",
+		blocked: true,
+	}, {
+		name:    "allowed",
+		client:  ClientServices{Allow: []string{"youtube"}},
+		host:    "youtube.com",
+		blocked: false,
+	}, {
+		name:    "added",
+		client:  ClientServices{Block: []string{"tiktok"}},
+		host:    "tiktok.com",
+		blocked: true,
+	}, {
+		name:    "added_keeps_global",
+		client:  ClientServices{Block: []string{"tiktok"}},
+		host:    "youtube.com",
+		blocked: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := setts
+			d.ApplyClientBlockedServices(&s, tc.client)
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.blocked, res.IsFiltered)
+		})
+	}
+}
+
+func TestServiceEntry_RuleTexts(t *testing.T) {
+	InitModule()
+
+	svcs := effectiveServices([]string{"twitter", "youtube"}, ClientServices{})
+	require.Len(t, svcs, 2)
+
+	twitter, youtube := svcs[0], svcs[1]
+
+	assert.Equal(t, []string{
+		"||twitter.com^",
+		"||twttr.com^",
+		"||t.co^",
+		"||twimg.com^",
+	}, twitter.RuleTexts())
+	assert.Equal(t, []string{
+		"twitter.com",
+		"twttr.com",
+		"t.co",
+		"twimg.com",
+	}, twitter.Patterns())
+
+	pats := youtube.Patterns()
+	assert.Len(t, pats, len(youtube.Rules))
+	assert.Contains(t, pats, "youtube.com")
+	assert.Contains(t, pats, "youtube")
+
+	empty := ServiceEntry{Name: "empty"}
+	assert.Empty(t, empty.RuleTexts())
+	assert.Empty(t, empty.Patterns())
+}
+
+func TestNew_blockedServicesNormalization(t *testing.T) {
+	InitModule()
+
+	c := &Config{
+		BlockedServices: []string{" YouTube ", "TIKTOK", "\ttwitter\n", "unknown"},
+	}
+
+	problems := c.Validate()
+	require.Len(t, problems, 1)
+
+	assert.Equal(t, "blocked_services[3]", problems[0].Field)
+
+	d := newForTest(t, c, nil)
+	t.Cleanup(d.Close)
+
+	assert.Equal(t, []string{"youtube", "tiktok", "twitter"}, d.BlockedServices)
+
+	s := setts
+	d.ApplyBlockedServices(&s, nil, true)
+
+	res, err := d.CheckHost("www.youtube.com", dns.TypeA, &s)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, FilteredBlockedService, res.Reason)
+	assert.Equal(t, "youtube", res.ServiceName)
+}
+
+func TestBlockedSvcKnown(t *testing.T) {
+	InitModule()
+
+	assert.True(t, BlockedSvcKnown("youtube"))
+	assert.True(t, BlockedSvcKnown(" YouTube "))
+	assert.True(t, BlockedSvcKnown("\tTIKTOK\n"))
+	assert.False(t, BlockedSvcKnown("unknown"))
+}
+
+func TestDNSFilter_handleBlockedServicesSet(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.ConfigModified = func() {}
+
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/control/blocked_services/set",
+		strings.NewReader(`[" YouTube ","TIKTOK"]`),
+	)
+	w := httptest.NewRecorder()
+	d.handleBlockedServicesSet(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	assert.Equal(t, []string{"youtube", "tiktok"}, d.BlockedServices)
+}
+
+func TestDNSFilter_BlockedServiceRules(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, &Config{
+		BlockedServices: []string{"youtube", "unknown_service", "tiktok"},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	svcs := d.BlockedServiceRules()
+	require.Len(t, svcs, 2)
+
+	for i, name := range []string{"youtube", "tiktok"} {
+		assert.Equal(t, name, svcs[i].Name)
+		assert.Equal(t, serviceRules[name], svcs[i].Rules)
+		assert.NotEmpty(t, svcs[i].Rules)
+	}
+
+	t.Run("updated", func(t *testing.T) {
+		d.confLock.Lock()
+		d.BlockedServices = []string{"twitter"}
+		d.confLock.Unlock()
+
+		svcs = d.BlockedServiceRules()
+		require.Len(t, svcs, 1)
+
+		assert.Equal(t, "twitter", svcs[0].Name)
+	})
+
+	t.Run("none", func(t *testing.T) {
+		nd := newForTest(t, nil, nil)
+		t.Cleanup(nd.Close)
+
+		assert.Empty(t, nd.BlockedServiceRules())
+	})
+}
+
+func TestDNSFilter_CheckHost_blockedServiceRule(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	// The broad rule goes first so that the most specific one has to be
+	// picked and not just the first matching one.
+	var svcRules []*rules.NetworkRule
+	for _, text := range []string{
+		"||example",
+		"||example.com^",
+		"||cdn.example.com^",
+	} {
+		r, err := rules.NewNetworkRule(text, BlockedSvcsListID)
+		require.NoError(t, err)
+
+		svcRules = append(svcRules, r)
+	}
+
+	youtube, ok := BlockedServiceEntry(" YouTube ")
+	require.True(t, ok)
+
+	s := setts
+	s.ServicesRules = []ServiceEntry{{
+		Name:  "example",
+		Rules: svcRules,
+	}, youtube}
+
+	testCases := []struct {
+		name     string
+		host     string
+		wantSvc  string
+		wantRule string
+	}{{
+		name:     "subdomain",
+		host:     "img.cdn.example.com",
+		wantSvc:  "example",
+		wantRule: "||cdn.example.com^",
+	}, {
+		name:     "domain",
+		host:     "www.example.com",
+		wantSvc:  "example",
+		wantRule: "||example.com^",
+	}, {
+		name:     "broad",
+		host:     "example-static.net",
+		wantSvc:  "example",
+		wantRule: "||example",
+	}, {
+		name:     "youtube",
+		host:     "www.youtube.com",
+		wantSvc:  "youtube",
+		wantRule: "||youtube.com^",
+	}, {
+		name:     "youtube_nocookie",
+		host:     "youtube-nocookie.com",
+		wantSvc:  "youtube",
+		wantRule: "||youtube-nocookie.com^",
+	}, {
+		name:     "youtube_broad",
+		host:     "youtube.googleusercontent.net",
+		wantSvc:  "youtube",
+		wantRule: "||youtube",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.True(t, res.IsFiltered)
+			assert.Equal(t, FilteredBlockedService, res.Reason)
+			assert.Equal(t, tc.wantSvc, res.ServiceName)
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+			assert.Equal(t, int64(BlockedSvcsListID), res.Rules[0].FilterListID)
+		})
+	}
+
+	t.Run("entry", func(t *testing.T) {
+		assert.Equal(t, "youtube", youtube.Name)
+		assert.Equal(t, serviceRules["youtube"], youtube.Rules)
+		assert.Contains(t, youtube.RuleTexts(), "||youtube.com^")
+
+		_, ok = BlockedServiceEntry("unknown_service")
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,155 @@
+package filtering
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// blockLogSize is the maximum number of the recent block decisions tracked by
+// blockLogger.
+const blockLogSize = 1000
+
+// blockLogKey is the key of a block decision.
+type blockLogKey struct {
+	host   string
+	reason Reason
+}
+
+// blockLogEntry is a block decision being coalesced.
+type blockLogEntry struct {
+	// start is the time of the first decision within the window.
+	start time.Time
+	key   blockLogKey
+	// repeated is the number of the identical decisions after the first one
+	// which weren't logged yet.
+	repeated uint
+}
+
+// blockLogger coalesces the log messages about the identical block decisions
+// made within a time window.  The first decision is logged immediately, and
+// the ones repeated within the window are logged as a single message with
+// their count once the window is over.
+type blockLogger struct {
+	// now returns the current time.  It's time.Now unless replaced in
+	// tests.
+	now func() time.Time
+	// logf writes a log message.  It's log.Debug unless replaced in tests.
+	logf func(format string, args ...interface{})
+
+	// mu protects entries and recent.
+	mu *sync.Mutex
+	// entries maps the keys to the elements of recent.
+	entries map[blockLogKey]*list.Element
+	// recent is the list of *blockLogEntry with the most recently started
+	// ones in front.
+	recent *list.List
+
+	window time.Duration
+}
+
+// newBlockLogger returns a new blockLogger.  l is nil if window is zero, which
+// means that all block decisions are logged.
+func newBlockLogger(window time.Duration) (l *blockLogger) {
+	if window == 0 {
+		return nil
+	}
+
+	return &blockLogger{
+		now:     time.Now,
+		logf:    log.Debug,
+		mu:      &sync.Mutex{},
+		entries: map[blockLogKey]*list.Element{},
+		recent:  list.New(),
+		window:  window,
+	}
+}
+
+// logBlocked logs the decision to block host for reason.  l may be nil.
+func (l *blockLogger) logBlocked(host string, reason Reason) {
+	if l == nil {
+		log.Debug("filtering: blocked %q, reason %s", host, reason)
+
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.flushExpired(now)
+
+	k := blockLogKey{host: host, reason: reason}
+	if elem, ok := l.entries[k]; ok {
+		// The entry is within the window, since the expired ones are
+		// flushed above.
+		elem.Value.(*blockLogEntry).repeated++
+
+		return
+	}
+
+	l.logf("filtering: blocked %q, reason %s", host, reason)
+
+	if l.recent.Len() >= blockLogSize {
+		oldest := l.recent.Back()
+		e := oldest.Value.(*blockLogEntry)
+		l.flushEntry(e)
+		l.recent.Remove(oldest)
+		delete(l.entries, e.key)
+	}
+
+	l.entries[k] = l.recent.PushFront(&blockLogEntry{
+		start: now,
+		key:   k,
+	})
+}
+
+// flushExpired logs and forgets the entries which windows are over at now.
+// l.mu is expected to be locked.
+func (l *blockLogger) flushExpired(now time.Time) {
+	for elem := l.recent.Back(); elem != nil; elem = l.recent.Back() {
+		e := elem.Value.(*blockLogEntry)
+		if now.Sub(e.start) < l.window {
+			return
+		}
+
+		l.flushEntry(e)
+		l.recent.Remove(elem)
+		delete(l.entries, e.key)
+	}
+}
+
+// flushEntry logs the coalesced decisions of e, if any.  l.mu is expected to
+// be locked.
+func (l *blockLogger) flushEntry(e *blockLogEntry) {
+	if e.repeated == 0 {
+		return
+	}
+
+	l.logf(
+		"filtering: blocked %q %d more times within %s, reason %s",
+		e.key.host,
+		e.repeated,
+		l.window,
+		e.key.reason,
+	)
+}
+
+// flush logs all the coalesced decisions and forgets them.  l may be nil.
+func (l *blockLogger) flush() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for elem := l.recent.Back(); elem != nil; elem = elem.Prev() {
+		l.flushEntry(elem.Value.(*blockLogEntry))
+	}
+
+	l.entries = map[blockLogKey]*list.Element{}
+	l.recent.Init()
+}
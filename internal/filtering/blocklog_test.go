@@ -0,0 +1,128 @@
+package filtering
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBlockLogger returns a blockLogger with a controlled clock which
+// writes the messages to msgs.
+func newTestBlockLogger(window time.Duration) (l *blockLogger, now *time.Time, msgs *[]string) {
+	now = &time.Time{}
+	msgs = &[]string{}
+
+	l = newBlockLogger(window)
+	l.now = func() (t time.Time) { return *now }
+	l.logf = func(format string, args ...interface{}) {
+		*msgs = append(*msgs, fmt.Sprintf(format, args...))
+	}
+
+	return l, now, msgs
+}
+
+func TestBlockLogger(t *testing.T) {
+	const window = 10 * time.Second
+
+	t.Run("coalesce", func(t *testing.T) {
+		l, now, msgs := newTestBlockLogger(window)
+
+		for i := 0; i < 5; i++ {
+			l.logBlocked("example.org", FilteredBlockList)
+			*now = now.Add(time.Second)
+		}
+
+		require.Equal(t, []string{
+			`filtering: blocked "example.org", reason FilteredBlackList`,
+		}, *msgs)
+
+		*now = now.Add(window)
+		l.logBlocked("example.org", FilteredBlockList)
+
+		assert.Equal(t, []string{
+			`filtering: blocked "example.org", reason FilteredBlackList`,
+			`filtering: blocked "example.org" 4 more times within 10s, reason FilteredBlackList`,
+			`filtering: blocked "example.org", reason FilteredBlackList`,
+		}, *msgs)
+	})
+
+	t.Run("other_host", func(t *testing.T) {
+		l, now, msgs := newTestBlockLogger(window)
+
+		l.logBlocked("example.org", FilteredBlockList)
+		l.logBlocked("example.org", FilteredBlockList)
+
+		*now = now.Add(window)
+		l.logBlocked("example.com", FilteredBlockList)
+
+		assert.Equal(t, []string{
+			`filtering: blocked "example.org", reason FilteredBlackList`,
+			`filtering: blocked "example.org" 1 more times within 10s, reason FilteredBlackList`,
+			`filtering: blocked "example.com", reason FilteredBlackList`,
+		}, *msgs)
+	})
+
+	t.Run("different", func(t *testing.T) {
+		l, _, msgs := newTestBlockLogger(window)
+
+		l.logBlocked("example.org", FilteredBlockList)
+		l.logBlocked("example.org", FilteredBlockedService)
+		l.logBlocked("example.com", FilteredBlockList)
+
+		assert.Len(t, *msgs, 3)
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		l, _, msgs := newTestBlockLogger(window)
+
+		l.logBlocked("example.org", FilteredBlockList)
+		l.logBlocked("example.org", FilteredBlockList)
+		l.logBlocked("example.org", FilteredBlockList)
+		l.flush()
+
+		assert.Equal(t, []string{
+			`filtering: blocked "example.org", reason FilteredBlackList`,
+			`filtering: blocked "example.org" 2 more times within 10s, reason FilteredBlackList`,
+		}, *msgs)
+
+		// Flushing again doesn't repeat the message.
+		l.flush()
+
+		assert.Len(t, *msgs, 2)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, newBlockLogger(0))
+	})
+}
+
+func TestDNSFilter_CheckHost_logCoalesce(t *testing.T) {
+	d := newForTest(t, &Config{LogCoalesceWindow: 60}, []Filter{{
+		ID: 1, Data: []byte("||example.org^\n"),
+	}})
+
+	l, _, msgs := newTestBlockLogger(time.Minute)
+	d.blockLog = l
+
+	for i := 0; i < 3; i++ {
+		res, err := d.CheckHost("example.org", dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+	}
+
+	// Not blocked hosts aren't logged.
+	_, err := d.CheckHost("example.com", dns.TypeA, &setts)
+	require.NoError(t, err)
+
+	d.Close()
+
+	assert.Equal(t, []string{
+		`filtering: blocked "example.org", reason FilteredBlackList`,
+		`filtering: blocked "example.org" 2 more times within 1m0s, reason FilteredBlackList`,
+	}, *msgs)
+}
@@ -0,0 +1,49 @@
+package filtering
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// maxBlockTXTLen is the maximum length of Config.BlockTXT, since it's sent as
+// a single character string, see RFC 1035, Section 3.3.
+const maxBlockTXTLen = 255
+
+// validateBlockTXT returns an error if text can't be used as the TXT answer
+// for the blocked hosts.
+func validateBlockTXT(text string) (err error) {
+	if l := len(text); l > maxBlockTXTLen {
+		return fmt.Errorf("block txt is too long: %d bytes, max %d", l, maxBlockTXTLen)
+	}
+
+	return nil
+}
+
+// withBlockTXT returns res with the TXT answer from Config.BlockTXT if res
+// blocks a TXT request and doesn't already define the response.
+func (d *DNSFilter) withBlockTXT(res Result, qtype uint16) (withTXT Result) {
+	if qtype != dns.TypeTXT ||
+		!res.IsFiltered ||
+		res.DNSRewriteResult != nil ||
+		res.CanonName != "" {
+		return res
+	}
+
+	d.confLock.RLock()
+	text := d.BlockTXT
+	d.confLock.RUnlock()
+
+	if text == "" {
+		return res
+	}
+
+	res.DNSRewriteResult = &DNSRewriteResult{
+		Response: DNSRewriteResultResponse{
+			dns.TypeTXT: {text},
+		},
+		RCode: dns.RcodeSuccess,
+	}
+
+	return res
+}
@@ -0,0 +1,104 @@
+package filtering
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_blockTXT(t *testing.T) {
+	const (
+		data = "||blocked.example^\n" +
+			"||refused.example^$dnsrewrite=REFUSED\n"
+		text = "blocked by the network policy"
+	)
+
+	d := newForTest(t, &Config{BlockTXT: text}, []Filter{{ID: 0, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantTXT    []rules.RRValue
+		qtype      uint16
+		wantReason Reason
+		wantRCode  int
+	}{{
+		name:       "blocked_txt",
+		host:       "blocked.example",
+		wantTXT:    []rules.RRValue{text},
+		qtype:      dns.TypeTXT,
+		wantReason: FilteredBlockList,
+		wantRCode:  dns.RcodeSuccess,
+	}, {
+		name:       "blocked_a",
+		host:       "blocked.example",
+		wantTXT:    nil,
+		qtype:      dns.TypeA,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "allowed_txt",
+		host:       "example.org",
+		wantTXT:    nil,
+		qtype:      dns.TypeTXT,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "rewritten_txt",
+		host:       "refused.example",
+		wantTXT:    nil,
+		qtype:      dns.TypeTXT,
+		wantReason: RewrittenRule,
+		wantRCode:  dns.RcodeRefused,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantTXT == nil && tc.wantRCode == dns.RcodeSuccess {
+				assert.Nil(t, res.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, tc.wantRCode, res.DNSRewriteResult.RCode)
+			assert.Equal(t, tc.wantTXT, res.DNSRewriteResult.Response[dns.TypeTXT])
+		})
+	}
+
+	t.Run("records", func(t *testing.T) {
+		res, err := d.CheckHost("blocked.example", dns.TypeTXT, &setts)
+		require.NoError(t, err)
+		require.NotNil(t, res.DNSRewriteResult)
+
+		rrs, err := res.DNSRewriteResult.Records("blocked.example.", dns.TypeTXT, 10)
+		require.NoError(t, err)
+		require.Len(t, rrs, 1)
+
+		txt, ok := rrs[0].(*dns.TXT)
+		require.True(t, ok)
+
+		assert.Equal(t, []string{text}, txt.Txt)
+	})
+
+	t.Run("too_long", func(t *testing.T) {
+		ld := newForTest(t, &Config{
+			BlockTXT: strings.Repeat("a", maxBlockTXTLen+1),
+		}, []Filter{{ID: 0, Data: []byte(data)}})
+		t.Cleanup(ld.Close)
+
+		res, err := ld.CheckHost("blocked.example", dns.TypeTXT, &setts)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+		assert.Nil(t, res.DNSRewriteResult)
+	})
+}
@@ -0,0 +1,104 @@
+package filtering
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// WarningSeverity is the severity of a RuleWarning.
+type WarningSeverity string
+
+// Warning severities.
+const (
+	// SeverityLow means that the rule is likely intended but is worth a
+	// look.
+	SeverityLow WarningSeverity = "low"
+	// SeverityHigh means that the rule is almost certainly a mistake.
+	SeverityHigh WarningSeverity = "high"
+)
+
+// RuleWarning is a valid filtering rule which is likely a mistake.
+type RuleWarning struct {
+	// Text is the rule without the surrounding spaces.
+	Text string `json:"text"`
+	// Reason is the description of the problem.
+	Reason string `json:"reason"`
+	// Severity is the severity of the problem.
+	Severity WarningSeverity `json:"severity"`
+	// Line is the 1-based number of the line.
+	Line int `json:"line"`
+}
+
+// BroadAllowRules returns the warnings about the allowlist rules from the list
+// data which unblock too large part of the namespace, such as "@@||com^" or
+// "@@||*^".  The rules matching all domains or an ICANN public suffix are
+// reported with SeverityHigh, the ones matching a private public suffix, like
+// "github.io", with SeverityLow.  The regular expression rules aren't
+// checked.  It doesn't change the filters of d.
+func (d *DNSFilter) BroadAllowRules(data []byte) (warnings []RuleWarning) {
+	for i, l := range bytes.Split(data, []byte("\n")) {
+		text := strings.TrimSpace(string(l))
+		if !strings.HasPrefix(text, "@@") {
+			continue
+		}
+
+		r, err := rules.NewRule(text, CustomListID)
+		if err != nil {
+			continue
+		}
+
+		nr, ok := r.(*rules.NetworkRule)
+		if !ok || !nr.Whitelist {
+			continue
+		}
+
+		reason, sev, ok := d.broadAllowReason(text)
+		if ok {
+			warnings = append(warnings, RuleWarning{
+				Text:     text,
+				Reason:   reason,
+				Severity: sev,
+				Line:     i + 1,
+			})
+		}
+	}
+
+	return warnings
+}
+
+// broadAllowReason returns the description and the severity of the problem of
+// the allowlist rule text.  ok is false if the rule isn't too broad.
+func (d *DNSFilter) broadAllowReason(text string) (reason string, sev WarningSeverity, ok bool) {
+	pat := strings.TrimPrefix(text, "@@")
+	if i := strings.IndexByte(pat, '$'); i >= 0 {
+		pat = pat[:i]
+	}
+
+	if strings.HasPrefix(pat, "/") && strings.HasSuffix(pat, "/") && len(pat) > 1 {
+		return "", "", false
+	}
+
+	pat = strings.TrimPrefix(pat, "||")
+	pat = strings.TrimPrefix(pat, "|")
+	pat = strings.TrimRight(pat, "^|")
+	pat = strings.TrimPrefix(pat, "*.")
+	pat = strings.Trim(pat, ".")
+	if strings.Trim(pat, "*^.|") == "" {
+		return "matches all domains", SeverityHigh, true
+	} else if strings.ContainsAny(pat, "*^|/") {
+		return "", "", false
+	}
+
+	pat = strings.ToLower(pat)
+	suffix, icann := d.psl.publicSuffix(pat)
+	if suffix != pat {
+		return "", "", false
+	} else if icann {
+		return fmt.Sprintf("matches public suffix %q", pat), SeverityHigh, true
+	}
+
+	return fmt.Sprintf("matches private public suffix %q", pat), SeverityLow, true
+}
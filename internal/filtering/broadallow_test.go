@@ -0,0 +1,55 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSFilter_BroadAllowRules(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	const list = "! Allowlist.\n" +
+		"@@||com^\n" +
+		"@@||example.com^\n" +
+		"@@||co.uk^$important\n" +
+		"@@||*.org^\n" +
+		"@@||*^\n" +
+		"@@||github.io^\n" +
+		"@@||user.github.io^\n" +
+		"||net^\n" +
+		"@@/.*\\.com$/\n" +
+		"@@||ads.*.example^\n"
+
+	assert.Equal(t, []RuleWarning{{
+		Text:     "@@||com^",
+		Reason:   `matches public suffix "com"`,
+		Severity: SeverityHigh,
+		Line:     2,
+	}, {
+		Text:     "@@||co.uk^$important",
+		Reason:   `matches public suffix "co.uk"`,
+		Severity: SeverityHigh,
+		Line:     4,
+	}, {
+		Text:     "@@||*.org^",
+		Reason:   `matches public suffix "org"`,
+		Severity: SeverityHigh,
+		Line:     5,
+	}, {
+		Text:     "@@||*^",
+		Reason:   "matches all domains",
+		Severity: SeverityHigh,
+		Line:     6,
+	}, {
+		Text:     "@@||github.io^",
+		Reason:   `matches private public suffix "github.io"`,
+		Severity: SeverityLow,
+		Line:     7,
+	}}, d.BroadAllowRules([]byte(list)))
+
+	t.Run("specific", func(t *testing.T) {
+		assert.Empty(t, d.BroadAllowRules([]byte("@@||example.com^\n@@||www.example.co.uk^\n")))
+	})
+}
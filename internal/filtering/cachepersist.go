@@ -0,0 +1,220 @@
+package filtering
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/google/renameio/maybe"
+)
+
+// persistentCache is a cache.Cache which keeps track of its keys, so that its
+// contents can be saved to a file and loaded back, see Config.CachePersistDir.
+type persistentCache struct {
+	cache.Cache
+
+	// path is the file the entries are saved to.
+	path string
+
+	// keysLock protects keys.
+	keysLock *sync.Mutex
+
+	// keys are the keys of the entries.  Those may include the keys of the
+	// entries removed concurrently, which are skipped on saving.
+	keys map[string]struct{}
+}
+
+// type check
+var _ cache.Cache = (*persistentCache)(nil)
+
+// newPersistentCache returns a new cache with conf saved to the file at path.
+func newPersistentCache(conf cache.Config, path string) (c *persistentCache) {
+	c = &persistentCache{
+		path:     path,
+		keysLock: &sync.Mutex{},
+		keys:     map[string]struct{}{},
+	}
+
+	onDelete := conf.OnDelete
+	conf.OnDelete = func(key, val []byte) {
+		c.forget(key)
+		if onDelete != nil {
+			onDelete(key, val)
+		}
+	}
+	c.Cache = cache.New(conf)
+
+	return c
+}
+
+// Set implements the cache.Cache interface for *persistentCache.
+func (c *persistentCache) Set(key, val []byte) (replaced bool) {
+	replaced = c.Cache.Set(key, val)
+
+	c.keysLock.Lock()
+	defer c.keysLock.Unlock()
+
+	c.keys[string(key)] = struct{}{}
+
+	return replaced
+}
+
+// Del implements the cache.Cache interface for *persistentCache.
+func (c *persistentCache) Del(key []byte) {
+	c.Cache.Del(key)
+	c.forget(key)
+}
+
+// Clear implements the cache.Cache interface for *persistentCache.
+func (c *persistentCache) Clear() {
+	c.Cache.Clear()
+
+	c.keysLock.Lock()
+	defer c.keysLock.Unlock()
+
+	c.keys = map[string]struct{}{}
+}
+
+// forget removes key from the tracked keys.
+func (c *persistentCache) forget(key []byte) {
+	c.keysLock.Lock()
+	defer c.keysLock.Unlock()
+
+	delete(c.keys, string(key))
+}
+
+// cacheEntry is a single saved entry of a persistentCache.
+type cacheEntry struct {
+	Key []byte
+	Val []byte
+}
+
+// cacheEntryExpiry returns the expiration time, in Unix seconds, stored at the
+// beginning of the values of the safe browsing, parental control, and safe
+// search caches.  ok is false if val is too short.
+func cacheEntryExpiry(val []byte) (exp int64, ok bool) {
+	if len(val) < 4 {
+		return 0, false
+	}
+
+	return int64(binary.BigEndian.Uint32(val[:4])), true
+}
+
+// save writes the entries of c which haven't expired at now to the file.
+func (c *persistentCache) save(now time.Time) (err error) {
+	c.keysLock.Lock()
+	keys := make([]string, 0, len(c.keys))
+	for k := range c.keys {
+		keys = append(keys, k)
+	}
+	c.keysLock.Unlock()
+
+	entries := make([]cacheEntry, 0, len(keys))
+	for _, k := range keys {
+		val := c.Cache.Get([]byte(k))
+		if exp, ok := cacheEntryExpiry(val); ok && exp > now.Unix() {
+			entries = append(entries, cacheEntry{Key: []byte(k), Val: val})
+		}
+	}
+
+	var buf bytes.Buffer
+	err = gob.NewEncoder(&buf).Encode(entries)
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	return maybe.WriteFile(c.path, buf.Bytes(), 0o644)
+}
+
+// load adds the entries from the file to c.  The entries which have expired at
+// now or which expire later than maxTTL after now, for example because
+// Config.CacheTime has been decreased, are skipped.  It's not an error if there
+// is no file.
+func (c *persistentCache) load(now time.Time, maxTTL time.Duration) (n int, err error) {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var entries []cacheEntry
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&entries)
+	if err != nil {
+		return 0, fmt.Errorf("decoding %q: %w", c.path, err)
+	}
+
+	minExp, maxExp := now.Unix(), now.Add(maxTTL).Unix()
+	for _, e := range entries {
+		exp, ok := cacheEntryExpiry(e.Val)
+		if !ok || exp <= minExp || exp > maxExp {
+			continue
+		}
+
+		c.Set(e.Key, e.Val)
+		n++
+	}
+
+	return n, nil
+}
+
+// maxCacheTTL returns the maximum TTL of the entries of the security caches
+// configured with c, including the jitter.
+func maxCacheTTL(c *Config) (ttl time.Duration) {
+	jitter := c.CacheTimeJitter
+	if jitter > maxCacheJitter {
+		jitter = maxCacheJitter
+	}
+
+	ttl = time.Duration(c.CacheTime) * time.Minute
+
+	return ttl + ttl*time.Duration(jitter)/100
+}
+
+// newLRUCache returns a new LRU cache of the security service with name and
+// the maximum size of maxSize bytes.  If c.CachePersistDir is set, the cache
+// is loaded from the file in it and is saved to it on Close.  A file which
+// can't be loaded is reported and the cache starts empty.
+func (d *DNSFilter) newLRUCache(name string, maxSize uint, c *Config) (lc cache.Cache) {
+	conf := cache.Config{
+		EnableLRU: true,
+		MaxSize:   maxSize,
+		OnDelete:  evictionHook(name, c.OnCacheEvict),
+	}
+
+	if c.CachePersistDir == "" {
+		return cache.New(conf)
+	}
+
+	pc := newPersistentCache(conf, filepath.Join(c.CachePersistDir, name+".cache"))
+	n, err := pc.load(d.now(), maxCacheTTL(c))
+	if err != nil {
+		log.Info("warning: filtering: loading %s cache, starting empty: %s", name, err)
+	} else {
+		log.Debug("filtering: loaded %d entries into %s cache", n, name)
+	}
+
+	d.persistentCaches = append(d.persistentCaches, pc)
+
+	return pc
+}
+
+// saveCaches saves the persistent caches, see Config.CachePersistDir.
+func (d *DNSFilter) saveCaches() {
+	now := d.now()
+	for _, pc := range d.persistentCaches {
+		err := pc.save(now)
+		if err != nil {
+			log.Error("filtering: saving cache to %q: %s", pc.path, err)
+		}
+	}
+}
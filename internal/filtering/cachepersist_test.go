@@ -0,0 +1,122 @@
+package filtering
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cacheValue returns a cache value expiring at exp with data.
+func cacheValue(exp time.Time, data string) (val []byte) {
+	val = make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(val, uint32(exp.Unix()))
+
+	return append(val, data...)
+}
+
+func TestDNSFilter_CachePersistDir(t *testing.T) {
+	dir := t.TempDir()
+	conf := func() (c *Config) {
+		return &Config{
+			SafeBrowsingCacheSize: 10000,
+			ParentalCacheSize:     10000,
+			SafeSearchCacheSize:   10000,
+			CacheTime:             30,
+			CachePersistDir:       dir,
+		}
+	}
+
+	now := time.Now()
+	fresh := cacheValue(now.Add(10*time.Minute), "fresh")
+	expired := cacheValue(now.Add(-time.Minute), "expired")
+
+	d := New(conf(), nil)
+	require.NotNil(t, d)
+
+	d.safebrowsingCache.Set([]byte("sb"), fresh)
+	d.safebrowsingCache.Set([]byte("sb-expired"), expired)
+	d.parentalCache.Set([]byte("pc"), fresh)
+	d.safeSearchCache.Set([]byte("ss"), fresh)
+	d.safeSearchCache.Set([]byte("ss-deleted"), fresh)
+	d.safeSearchCache.Del([]byte("ss-deleted"))
+	d.Close()
+
+	for _, name := range []string{CacheNameSafeBrowsing, CacheNameParental, CacheNameSafeSearch} {
+		assert.FileExists(t, filepath.Join(dir, name+".cache"))
+	}
+
+	t.Run("restored", func(t *testing.T) {
+		d = New(conf(), nil)
+		require.NotNil(t, d)
+		t.Cleanup(d.Close)
+
+		assert.Equal(t, fresh, d.safebrowsingCache.Get([]byte("sb")))
+		assert.Nil(t, d.safebrowsingCache.Get([]byte("sb-expired")))
+		assert.Equal(t, fresh, d.parentalCache.Get([]byte("pc")))
+		assert.Equal(t, fresh, d.safeSearchCache.Get([]byte("ss")))
+		assert.Nil(t, d.safeSearchCache.Get([]byte("ss-deleted")))
+	})
+
+	t.Run("cache_time_decreased", func(t *testing.T) {
+		c := conf()
+		c.CacheTime = 5
+
+		d = New(c, nil)
+		require.NotNil(t, d)
+		t.Cleanup(d.Close)
+
+		assert.Nil(t, d.safebrowsingCache.Get([]byte("sb")))
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		path := filepath.Join(dir, CacheNameParental+".cache")
+		err := os.WriteFile(path, []byte("not a cache"), 0o644)
+		require.NoError(t, err)
+
+		d = New(conf(), nil)
+		require.NotNil(t, d)
+		t.Cleanup(d.Close)
+
+		assert.Nil(t, d.parentalCache.Get([]byte("pc")))
+	})
+}
+
+func TestPersistentCache_evict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.cache")
+
+	var evicted int
+	c := newPersistentCache(cache.Config{
+		EnableLRU: true,
+		MaxSize:   40,
+		OnDelete: func(_, _ []byte) {
+			evicted++
+		},
+	}, path)
+
+	exp := time.Now().Add(time.Hour)
+	for _, k := range []string{"k1", "k2", "k3"} {
+		c.Set([]byte(k), cacheValue(exp, "0123456789"))
+	}
+
+	// Each entry takes 2 bytes of the key and 14 bytes of the value, so only
+	// two of those fit.
+	assert.Equal(t, 1, evicted)
+	assert.Len(t, c.keys, 2)
+
+	err := c.save(time.Now())
+	require.NoError(t, err)
+
+	loaded := newPersistentCache(cache.Config{}, path)
+	n, err := loaded.load(time.Now(), 2*time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, n)
+	assert.Nil(t, loaded.Get([]byte("k1")))
+	assert.NotNil(t, loaded.Get([]byte("k3")))
+}
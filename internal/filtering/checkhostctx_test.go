@@ -0,0 +1,202 @@
+package filtering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHostCtx(t *testing.T) {
+	const data = "||blocked.example^\n"
+
+	d := newForTest(t, &Config{}, []Filter{{ID: 0, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	// newCheckers returns the checkers which run the filtering stage, cancel
+	// ctx, and then run the stage blocking everything, which is only
+	// expected to run if ctx isn't done.
+	newCheckers := func(cancel context.CancelFunc, lastRun *bool) (hcs []hostChecker) {
+		return []hostChecker{{
+			check: func(host string, qtype uint16, setts *Settings) (res Result, err error) {
+				res, err = d.matchHost(host, qtype, setts)
+				cancel()
+
+				return res, err
+			},
+			name: "filtering",
+		}, {
+			check: func(_ string, _ uint16, _ *Settings) (res Result, err error) {
+				*lastRun = true
+
+				return Result{
+					IsFiltered: true,
+					Reason:     FilteredSafeBrowsing,
+				}, nil
+			},
+			name: "safe browsing",
+		}}
+	}
+
+	t.Run("blocked_before_cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		var lastRun bool
+		d.hostCheckers = newCheckers(cancel, &lastRun)
+
+		res, partial, err := d.CheckHostCtx(ctx, "blocked.example", dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.False(t, partial)
+		assert.True(t, res.IsFiltered)
+		assert.Equal(t, FilteredBlockList, res.Reason)
+		assert.False(t, lastRun)
+	})
+
+	t.Run("cancelled_between_stages", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		var lastRun bool
+		d.hostCheckers = newCheckers(cancel, &lastRun)
+
+		res, partial, err := d.CheckHostCtx(ctx, "other.example", dns.TypeA, &setts)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.True(t, partial)
+		assert.False(t, res.IsFiltered)
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+		assert.False(t, lastRun)
+	})
+
+	t.Run("not_cancelled", func(t *testing.T) {
+		var lastRun bool
+		d.hostCheckers = newCheckers(func() {}, &lastRun)
+
+		res, partial, err := d.CheckHostCtx(
+			context.Background(),
+			"other.example",
+			dns.TypeA,
+			&setts,
+		)
+		require.NoError(t, err)
+
+		assert.False(t, partial)
+		assert.True(t, res.IsFiltered)
+		assert.Equal(t, FilteredSafeBrowsing, res.Reason)
+		assert.True(t, lastRun)
+	})
+
+	t.Run("cancelled_before", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var lastRun bool
+		d.hostCheckers = newCheckers(cancel, &lastRun)
+
+		res, partial, err := d.CheckHostCtx(ctx, "blocked.example", dns.TypeA, &setts)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.True(t, partial)
+		assert.False(t, res.IsFiltered)
+		assert.False(t, lastRun)
+	})
+
+	const testErr errors.Error = "test error"
+
+	errCheckers := func(cancel context.CancelFunc) (hcs []hostChecker) {
+		return []hostChecker{{
+			check: func(_ string, _ uint16, _ *Settings) (res Result, err error) {
+				cancel()
+
+				return Result{}, testErr
+			},
+			name: "safe browsing",
+		}}
+	}
+
+	t.Run("error_after_cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		d.hostCheckers = errCheckers(cancel)
+
+		res, partial, err := d.CheckHostCtx(ctx, "other.example", dns.TypeA, &setts)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, testErr)
+
+		assert.True(t, partial)
+		assert.False(t, res.IsFiltered)
+	})
+
+	t.Run("blocking_checker", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		t.Cleanup(cancel)
+
+		d.hostCheckers = []hostChecker{{
+			check: d.matchHost,
+			name:  "filtering",
+		}, {
+			checkCtx: func(
+				ctx context.Context,
+				_ string,
+				_ uint16,
+				_ *Settings,
+			) (res Result, err error) {
+				<-ctx.Done()
+
+				return Result{}, ctx.Err()
+			},
+			name:   "safe browsing",
+			remote: true,
+		}}
+
+		res, partial, err := d.CheckHostCtx(ctx, "other.example", dns.TypeA, &setts)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		assert.True(t, partial)
+		assert.False(t, res.IsFiltered)
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d.hostCheckers = errCheckers(func() {})
+
+		_, partial, err := d.CheckHostCtx(
+			context.Background(),
+			"other.example",
+			dns.TypeA,
+			&setts,
+		)
+		assert.ErrorIs(t, err, testErr)
+		assert.False(t, partial)
+	})
+}
+
+func TestDNSFilter_CheckHostCtx_remote(t *testing.T) {
+	d := newForTest(t, &Config{SafeBrowsingEnabled: true}, nil)
+	t.Cleanup(d.Close)
+
+	// The upstream responds much later than ctx is done.
+	d.SetSafeBrowsingUpstream(&flakyUpstream{
+		ups:   &aghtest.TestBlockUpstream{Hostname: "example.org", Block: true},
+		delay: time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	t.Cleanup(cancel)
+
+	start := time.Now()
+	res, partial, err := d.CheckHostCtx(ctx, "example.org", dns.TypeA, &setts)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	assert.True(t, partial)
+	assert.False(t, res.IsFiltered)
+}
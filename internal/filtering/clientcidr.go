@@ -0,0 +1,69 @@
+package filtering
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// clientCIDRError returns an error if the $client value v looks like a subnet,
+// that is an IP address followed by a slash, but isn't a valid one.  urlfilter
+// matches the valid subnets, like "192.168.1.0/24" or "fd00::/64", against the
+// address of the client, but silently takes the malformed ones for the client
+// names, so that those never match.
+func clientCIDRError(v string) (err error) {
+	v = unquoteClient(strings.TrimPrefix(v, "~"))
+	if isClientPattern(v) {
+		return nil
+	}
+
+	i := strings.IndexByte(v, '/')
+	if i < 0 || net.ParseIP(v[:i]) == nil {
+		return nil
+	}
+
+	if _, _, err = net.ParseCIDR(v); err != nil {
+		return fmt.Errorf("bad client subnet %q", v)
+	}
+
+	return nil
+}
+
+// ruleClientCIDRErrors returns the errors about the malformed subnets in the
+// $client modifiers of the network rule with text, both permitting and
+// restricting.
+func ruleClientCIDRErrors(text string) (errs []error) {
+	for _, m := range ruleModifiers(text) {
+		if !strings.HasPrefix(m, "client=") {
+			continue
+		}
+
+		for _, v := range splitClientValues(strings.TrimPrefix(m, "client=")) {
+			if err := clientCIDRError(v); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// ValidateRuleClients returns an error if rule isn't a valid network filtering
+// rule or if its $client modifier contains malformed subnets, which urlfilter
+// would otherwise take for the client names.
+func ValidateRuleClients(rule string) (err error) {
+	_, err = rules.NewNetworkRule(rule, CustomListID)
+	if err != nil {
+		return fmt.Errorf("parsing rule: %w", err)
+	}
+
+	errs := ruleClientCIDRErrors(rule)
+	if len(errs) > 0 {
+		return errors.List("validating clients", errs...)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_clientCIDR(t *testing.T) {
+	const text = "||v4.example^$client=192.168.1.0/24\n" +
+		"||v6.example^$client=fd00:1::/64\n" +
+		"||restricted.example^$client=~10.0.0.0/8\n" +
+		"||mixed.example^$client=192.168.2.5|fd00:2::/48\n"
+
+	d := newForTest(t, nil, []Filter{{ID: 1, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		ip         string
+		host       string
+		wantReason Reason
+	}{{
+		name:       "v4_in_subnet",
+		ip:         "192.168.1.42",
+		host:       "v4.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "v4_out_of_subnet",
+		ip:         "192.168.2.42",
+		host:       "v4.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "v6_in_subnet",
+		ip:         "fd00:1::1234",
+		host:       "v6.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "v6_out_of_subnet",
+		ip:         "fd00:2::1234",
+		host:       "v6.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "restricted_in_subnet",
+		ip:         "10.1.2.3",
+		host:       "restricted.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "restricted_out_of_subnet",
+		ip:         "192.168.1.1",
+		host:       "restricted.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "mixed_exact",
+		ip:         "192.168.2.5",
+		host:       "mixed.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "mixed_v6_subnet",
+		ip:         "fd00:2:0:1::1",
+		host:       "mixed.example",
+		wantReason: FilteredBlockList,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := setts
+			s.ClientIP = net.ParseIP(tc.ip)
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+		})
+	}
+}
+
+func TestValidateRuleClients(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rule       string
+		wantErrMsg string
+	}{{
+		name:       "v4",
+		rule:       "||ads.example^$client=192.168.1.0/24",
+		wantErrMsg: "",
+	}, {
+		name:       "v6",
+		rule:       "||ads.example^$client=fd00::/64|~fd00::1/128",
+		wantErrMsg: "",
+	}, {
+		name:       "names_and_patterns",
+		rule:       "||ads.example^$client=laptop|/^kid-/|'tv 1'|192.168.1.5",
+		wantErrMsg: "",
+	}, {
+		name:       "bad_v4_length",
+		rule:       "||ads.example^$client=192.168.1.0/33",
+		wantErrMsg: `validating clients: bad client subnet "192.168.1.0/33"`,
+	}, {
+		name:       "bad_v6_length",
+		rule:       "||ads.example^$client=~fd00::/129",
+		wantErrMsg: `validating clients: bad client subnet "fd00::/129"`,
+	}, {
+		name:       "bad_length_syntax",
+		rule:       "||ads.example^$client=laptop|10.0.0.0/x",
+		wantErrMsg: `validating clients: bad client subnet "10.0.0.0/x"`,
+	}, {
+		name: "several",
+		rule: "||ads.example^$client=10.0.0.0/40|fd00::/200",
+		wantErrMsg: "validating clients: 2 errors: " +
+			`"bad client subnet \"10.0.0.0/40\"", "bad client subnet \"fd00::/200\""`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRuleClients(tc.rule)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			require.Error(t, err)
+
+			assert.Equal(t, tc.wantErrMsg, err.Error())
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package filtering
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// clientPattern is a pattern of client names used in the $client modifier
+// instead of an exact name.  Those are either regular expressions, like
+// "/^kid-/", or globs, like "kid-*".  Since urlfilter splits the modifiers at
+// the last "$", the regular expressions can't use it as an anchor.
+type clientPattern struct {
+	re *regexp.Regexp
+	// text is the pattern as it's written in the rule, without the quotes.
+	text string
+}
+
+// isClientPattern returns true if the $client value v is a pattern and not an
+// exact client name.
+func isClientPattern(v string) (ok bool) {
+	if len(v) > 2 && v[0] == '/' && v[len(v)-1] == '/' {
+		return true
+	}
+
+	return strings.ContainsAny(v, "*?")
+}
+
+// newClientPattern compiles the $client pattern text.
+func newClientPattern(text string) (p *clientPattern, err error) {
+	var expr string
+	if len(text) > 2 && text[0] == '/' && text[len(text)-1] == '/' {
+		expr = text[1 : len(text)-1]
+	} else {
+		expr = regexp.QuoteMeta(text)
+		expr = strings.ReplaceAll(expr, `\*`, `.*`)
+		expr = strings.ReplaceAll(expr, `\?`, `.`)
+		expr = "^" + expr + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientPattern{
+		re:   re,
+		text: text,
+	}, nil
+}
+
+// ruleClientPatterns returns the patterns from the permitting $client
+// modifier of the network rule with text.  The restricting patterns, like
+// "~/^kid-/", aren't supported.
+func ruleClientPatterns(text string) (pats []string) {
+	for _, m := range ruleModifiers(text) {
+		if !strings.HasPrefix(m, "client=") {
+			continue
+		}
+
+		for _, v := range splitClients(strings.TrimPrefix(m, "client=")) {
+			if isClientPattern(v) {
+				pats = append(pats, v)
+			}
+		}
+	}
+
+	return pats
+}
+
+// splitClients splits the $client modifier value the same way urlfilter does
+// and returns the permitted clients without the quotes.
+func splitClients(value string) (clients []string) {
+	for _, v := range splitClientValues(value) {
+		if strings.HasPrefix(v, "~") {
+			continue
+		}
+
+		if v = unquoteClient(v); v != "" {
+			clients = append(clients, v)
+		}
+	}
+
+	return clients
+}
+
+// unquoteClient returns the $client value v without the quotes, if any.
+func unquoteClient(v string) (unquoted string) {
+	if len(v) >= 2 && (v[0] == '\'' || v[0] == '"') && v[0] == v[len(v)-1] {
+		q := v[:1]
+
+		return strings.ReplaceAll(v[1:len(v)-1], `\`+q, q)
+	}
+
+	return v
+}
+
+// splitClientValues splits the $client modifier value at the unescaped
+// separators.  The values keep the quotes and the "~" prefixes.
+func splitClientValues(value string) (vals []string) {
+	var sb strings.Builder
+	escaped := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case escaped:
+			if c != '|' {
+				sb.WriteByte('\\')
+			}
+
+			sb.WriteByte(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '|':
+			vals = append(vals, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	vals = append(vals, sb.String())
+
+	return vals
+}
+
+// clientPatternSet collects the client name patterns used in the network
+// rules.
+type clientPatternSet struct {
+	// texts are the texts of the collected patterns, including the malformed
+	// ones.
+	texts *stringutil.Set
+	pats  []*clientPattern
+}
+
+// add collects the client name patterns from nr.  It also reports the
+// malformed client subnets, see ruleClientCIDRErrors.
+func (s *clientPatternSet) add(nr *rules.NetworkRule) {
+	text := nr.Text()
+	if !strings.Contains(text, "client=") {
+		return
+	}
+
+	for _, err := range ruleClientCIDRErrors(text) {
+		log.Info("filtering: %s in rule %q", err, text)
+	}
+
+	if s.texts == nil {
+		s.texts = stringutil.NewSet()
+	}
+
+	for _, pt := range ruleClientPatterns(text) {
+		if s.texts.Has(pt) {
+			continue
+		}
+
+		s.texts.Add(pt)
+
+		p, err := newClientPattern(pt)
+		if err != nil {
+			log.Info("filtering: bad client pattern %q in rule %q: %s", pt, text, err)
+
+			continue
+		}
+
+		s.pats = append(s.pats, p)
+	}
+}
+
+// clientAliases returns the texts of the client name patterns matching name.
+// d.engineLock is expected to be locked.
+func (d *DNSFilter) clientAliases(name string) (aliases []string) {
+	if name == "" {
+		return nil
+	}
+
+	for _, p := range d.clientPatterns {
+		if p.re.MatchString(name) {
+			aliases = append(aliases, p.text)
+		}
+	}
+
+	return aliases
+}
+
+// matchWithAliases matches req using e, and then matches it again for each of
+// the client name patterns in aliases, using the pattern as the client name.
+// Only the network rules permitting the pattern are taken from the latter
+// matches.  dnsrw are the matched $dnsrewrite rules, see
+// urlfilter.DNSResult.DNSRewrites.
+func matchWithAliases(
+	e *urlfilter.DNSEngine,
+	req urlfilter.DNSRequest,
+	aliases []string,
+) (res *urlfilter.DNSResult, dnsrw []*rules.NetworkRule, ok bool) {
+	res, ok = e.MatchRequest(req)
+	dnsrw = res.DNSRewrites()
+	if len(aliases) == 0 {
+		return res, dnsrw, ok
+	}
+
+	merged := *res
+	for _, alias := range aliases {
+		req.ClientName = alias
+		ares, _ := e.MatchRequest(req)
+		for _, nr := range ares.DNSRewrites() {
+			if permitsClient(nr, alias) {
+				dnsrw = append(dnsrw, nr)
+			}
+		}
+
+		nr := ares.NetworkRule
+		if nr == nil || !permitsClient(nr, alias) {
+			continue
+		}
+
+		if merged.NetworkRule == nil || nr.IsHigherPriority(merged.NetworkRule) {
+			merged.NetworkRule = nr
+			ok = true
+		}
+	}
+
+	if merged.NetworkRule != nil {
+		// Network rules always have higher priority.
+		merged.HostRulesV4, merged.HostRulesV6 = nil, nil
+	}
+
+	return &merged, dnsrw, ok
+}
+
+// permitsClient returns true if nr has the client name pattern alias in its
+// $client modifier.
+func permitsClient(nr *rules.NetworkRule, alias string) (ok bool) {
+	for _, p := range ruleClientPatterns(nr.Text()) {
+		if p == alias {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,114 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_clientPattern(t *testing.T) {
+	const text = "||regex.example^$client=/^kid-/\n" +
+		"||glob.example^$client=*-tablet\n" +
+		"||quoted.example^$client='tv-*'|laptop\n" +
+		"||exact.example^$client=kid-phone\n" +
+		"||rewrite.example^$client=/^kid-/,dnsrewrite=NOERROR;A;1.2.3.4\n" +
+		"@@||allowed.regex.example^$client=/-ph.ne/\n" +
+		"||restricted.example^$client=~kid-phone\n"
+
+	d := newForTest(t, nil, []Filter{{ID: 1, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		client     string
+		host       string
+		wantReason Reason
+	}{{
+		name:       "regex_match",
+		client:     "kid-laptop",
+		host:       "regex.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "regex_no_match",
+		client:     "parent-laptop",
+		host:       "regex.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "glob_match",
+		client:     "kid-tablet",
+		host:       "glob.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "glob_no_match",
+		client:     "kid-tablet-2",
+		host:       "glob.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "quoted_glob",
+		client:     "tv-living-room",
+		host:       "quoted.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "quoted_exact",
+		client:     "laptop",
+		host:       "quoted.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "exact_not_pattern",
+		client:     "kid-phone-2",
+		host:       "exact.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "rewrite",
+		client:     "kid-laptop",
+		host:       "rewrite.example",
+		wantReason: RewrittenRule,
+	}, {
+		name:       "rewrite_no_match",
+		client:     "dad",
+		host:       "rewrite.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "allowlist",
+		client:     "kid-phone",
+		host:       "allowed.regex.example",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "restricted_exact",
+		client:     "kid-phone",
+		host:       "restricted.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "restricted_other",
+		client:     "kid-laptop",
+		host:       "restricted.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "no_name",
+		client:     "",
+		host:       "regex.example",
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := setts
+			s.ClientName = tc.client
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+		})
+	}
+}
+
+func TestSplitClients(t *testing.T) {
+	assert.Equal(
+		t,
+		[]string{"/^a|b/", "c*", "it's"},
+		splitClients(`/^a\|b/|~d|c*|'it\'s'`),
+	)
+}
@@ -0,0 +1,144 @@
+package filtering
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultClientStatsWindow is the default window of the per-client counters,
+// see Config.ClientStatsWindow.
+const defaultClientStatsWindow = 24 * time.Hour
+
+// clientStatsEntry is the counters of a single client within the current
+// window.
+type clientStatsEntry struct {
+	// start is the beginning of the window.
+	start   time.Time
+	id      string
+	checked uint64
+	blocked uint64
+}
+
+// clientStats counts the checked and the blocked requests of the most
+// recently active clients within a time window.  Once the window of a client
+// is over, its counters start over with the next request.
+type clientStats struct {
+	// now returns the current time.  It's time.Now unless replaced in
+	// tests.
+	now func() time.Time
+
+	// mu protects entries and recent.
+	mu *sync.Mutex
+	// entries maps the client IDs to the elements of recent.
+	entries map[string]*list.Element
+	// recent is the list of *clientStatsEntry with the most recently active
+	// clients in front.
+	recent *list.List
+
+	window time.Duration
+	size   int
+}
+
+// newClientStats returns a new clientStats keeping at most size clients.  s is
+// nil if size is zero, which means that the requests aren't counted.  If
+// window is zero, defaultClientStatsWindow is used.
+func newClientStats(size uint, window time.Duration) (s *clientStats) {
+	if size == 0 {
+		return nil
+	}
+
+	if window == 0 {
+		window = defaultClientStatsWindow
+	}
+
+	return &clientStats{
+		now:     time.Now,
+		mu:      &sync.Mutex{},
+		entries: map[string]*list.Element{},
+		recent:  list.New(),
+		window:  window,
+		size:    int(size),
+	}
+}
+
+// clientStatsID returns the ID of the client making the request with setts,
+// which is its name, if known, or its IP address otherwise.  id is empty if
+// neither is known.
+func clientStatsID(setts *Settings) (id string) {
+	if setts.ClientName != "" {
+		return setts.ClientName
+	} else if setts.ClientIP != nil {
+		return setts.ClientIP.String()
+	}
+
+	return ""
+}
+
+// record counts the request of the client with id.  s may be nil.
+func (s *clientStats) record(id string, blocked bool) {
+	if s == nil || id == "" {
+		return
+	}
+
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e *clientStatsEntry
+	if elem, ok := s.entries[id]; ok {
+		s.recent.MoveToFront(elem)
+		e = elem.Value.(*clientStatsEntry)
+	} else {
+		e = &clientStatsEntry{id: id}
+		s.entries[id] = s.recent.PushFront(e)
+		for s.recent.Len() > s.size {
+			old := s.recent.Remove(s.recent.Back()).(*clientStatsEntry)
+			delete(s.entries, old.id)
+		}
+	}
+
+	if e.start.IsZero() || now.Sub(e.start) >= s.window {
+		e.start, e.checked, e.blocked = now, 0, 0
+	}
+
+	e.checked++
+	if blocked {
+		e.blocked++
+	}
+}
+
+// counters returns the counters of the client with id within the current
+// window.  s may be nil.
+func (s *clientStats) counters(id string) (checked, blocked uint64) {
+	if s == nil {
+		return 0, 0
+	}
+
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[id]
+	if !ok {
+		return 0, 0
+	}
+
+	e := elem.Value.(*clientStatsEntry)
+	if now.Sub(e.start) >= s.window {
+		return 0, 0
+	}
+
+	return e.checked, e.blocked
+}
+
+// ClientBlockRate returns the numbers of the requests checked by CheckHost and
+// of the blocked ones for the client with id within the current window, see
+// Config.ClientStatsWindow.  id is the client's name, if it's known, or its IP
+// address.  Both are zero if the client wasn't active within the window, was
+// evicted, or if the counting is disabled, see Config.ClientStatsSize.
+func (d *DNSFilter) ClientBlockRate(id string) (checked, blocked uint64) {
+	return d.clientStats.counters(id)
+}
@@ -0,0 +1,92 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_ClientBlockRate(t *testing.T) {
+	d := newForTest(t, &Config{ClientStatsSize: 2}, []Filter{{
+		ID: 1, Data: []byte("||blocked.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	now := time.Unix(0, 0)
+	d.clientStats.now = func() (t time.Time) { return now }
+
+	check := func(t *testing.T, host, name string, ip net.IP) {
+		t.Helper()
+
+		s := setts
+		s.ClientName, s.ClientIP = name, ip
+		_, err := d.CheckHost(host, dns.TypeA, &s)
+		require.NoError(t, err)
+	}
+
+	t.Run("counters", func(t *testing.T) {
+		check(t, "blocked.example", "phone", nil)
+		check(t, "allowed.example", "phone", nil)
+		check(t, "www.blocked.example", "phone", nil)
+		check(t, "allowed.example", "", net.IP{192, 168, 0, 1})
+
+		checked, blocked := d.ClientBlockRate("phone")
+		assert.Equal(t, uint64(3), checked)
+		assert.Equal(t, uint64(2), blocked)
+
+		checked, blocked = d.ClientBlockRate("192.168.0.1")
+		assert.Equal(t, uint64(1), checked)
+		assert.Equal(t, uint64(0), blocked)
+
+		checked, blocked = d.ClientBlockRate("unknown")
+		assert.Zero(t, checked)
+		assert.Zero(t, blocked)
+	})
+
+	t.Run("window", func(t *testing.T) {
+		now = now.Add(defaultClientStatsWindow)
+
+		checked, blocked := d.ClientBlockRate("phone")
+		assert.Zero(t, checked)
+		assert.Zero(t, blocked)
+
+		check(t, "blocked.example", "phone", nil)
+
+		checked, blocked = d.ClientBlockRate("phone")
+		assert.Equal(t, uint64(1), checked)
+		assert.Equal(t, uint64(1), blocked)
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		// "phone" is the most recently active client, so "192.168.0.1" is
+		// evicted first.
+		check(t, "allowed.example", "laptop", nil)
+
+		checked, _ := d.ClientBlockRate("192.168.0.1")
+		assert.Zero(t, checked)
+
+		checked, _ = d.ClientBlockRate("phone")
+		assert.Equal(t, uint64(1), checked)
+
+		checked, _ = d.ClientBlockRate("laptop")
+		assert.Equal(t, uint64(1), checked)
+	})
+}
+
+func TestDNSFilter_ClientBlockRate_disabled(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	s := setts
+	s.ClientName = "phone"
+	_, err := d.CheckHost("example.org", dns.TypeA, &s)
+	require.NoError(t, err)
+
+	checked, blocked := d.ClientBlockRate("phone")
+	assert.Zero(t, checked)
+	assert.Zero(t, blocked)
+}
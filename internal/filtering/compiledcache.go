@@ -0,0 +1,335 @@
+package filtering
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// defaultCompiledCacheSize is the default number of the compiled blocklist
+// sets kept by the cache, see Config.CompiledCacheSize.  A single entry
+// doesn't take any additional memory, since it's the one currently used.
+const defaultCompiledCacheSize = 1
+
+// compiledKey is the hash identifying a set of filter lists.
+type compiledKey [sha256.Size]byte
+
+// compiledLists is the blocklist set compiled into the rule storage and the
+// engine along with the data gathered from its rules.
+type compiledLists struct {
+	storage *filterlist.RuleStorage
+	engine  *urlfilter.DNSEngine
+	info    rulesInfo
+	key     compiledKey
+}
+
+// rulesInfo is the data gathered from the rules of a storage while compiling
+// it, see scanRulesInfo.
+type rulesInfo struct {
+	// cosmetic are the cosmetic rules, if those are kept.
+	cosmetic []*ResultRule
+
+	// clientPats are the client name patterns used in the $client
+	// modifiers.
+	clientPats []*clientPattern
+
+	// serverIPTagged is true if any of the rules is scoped by the server's
+	// address, see hasServerIPTag.
+	serverIPTagged bool
+}
+
+// scanRulesInfo gathers the data from the rules of rs in a single pass.  The
+// cosmetic rules are only collected if keepCosmetic is true.  It must only be
+// called before rs is used by the engines, since scanning the file-based lists
+// with the storage scanner isn't safe for concurrent use.
+func scanRulesInfo(rs *filterlist.RuleStorage, keepCosmetic bool) (info rulesInfo) {
+	pats := &clientPatternSet{}
+
+	sc := rs.NewRuleStorageScanner()
+	for sc.Scan() {
+		r, _ := sc.Rule()
+		switch r := r.(type) {
+		case *rules.NetworkRule:
+			pats.add(r)
+			info.serverIPTagged = info.serverIPTagged || hasServerIPTag(r.Text())
+		case *rules.CosmeticRule:
+			if keepCosmetic {
+				info.cosmetic = append(info.cosmetic, &ResultRule{
+					Text:         r.Text(),
+					FilterListID: int64(r.GetFilterListID()),
+				})
+			}
+		default:
+			// Go on.
+		}
+	}
+
+	info.clientPats = pats.pats
+
+	return info
+}
+
+// compiledCache is the LRU cache of the compiled blocklist sets keyed by the
+// hashes identifying them, see compiledListsKey.  The cache owns the storages of its entries, so
+// those must only be closed once evicted.  The allowlists aren't cached, since
+// those are usually small and are rebuilt by AddException.
+type compiledCache struct {
+	// mu protects entries and order.
+	mu      sync.Mutex
+	entries map[compiledKey]*list.Element
+	order   *list.List
+	size    int
+}
+
+// newCompiledCache returns a new compiled lists cache keeping at most size
+// entries.  If size is zero, defaultCompiledCacheSize is used.
+func newCompiledCache(size uint) (c *compiledCache) {
+	if size == 0 {
+		size = defaultCompiledCacheSize
+	}
+
+	return &compiledCache{
+		entries: map[compiledKey]*list.Element{},
+		order:   list.New(),
+		size:    int(size),
+	}
+}
+
+// get returns the cached compiled lists for key and marks those as recently
+// used.  ok is false if there are none.
+func (c *compiledCache) get(key compiledKey) (cl *compiledLists, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*compiledLists), true
+}
+
+// put adds cl to the cache and returns the entries evicted to make room for it
+// or replaced by it.  The caller is responsible for closing the storages of
+// the evicted entries once those aren't used anymore.
+func (c *compiledCache) put(cl *compiledLists) (evicted []*compiledLists) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[cl.key]; ok {
+		prev := e.Value.(*compiledLists)
+		if prev == cl {
+			c.order.MoveToFront(e)
+
+			return nil
+		}
+
+		c.order.Remove(e)
+		evicted = append(evicted, prev)
+	}
+
+	c.entries[cl.key] = c.order.PushFront(cl)
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		old := c.order.Remove(e).(*compiledLists)
+		delete(c.entries, old.key)
+		evicted = append(evicted, old)
+	}
+
+	return evicted
+}
+
+// has returns true if rs is the storage of one of the cached entries.
+func (c *compiledCache) has(rs *filterlist.RuleStorage) (ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		if e.Value.(*compiledLists).storage == rs {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clear removes all the entries from the cache and returns them.
+func (c *compiledCache) clear() (removed []*compiledLists) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		removed = append(removed, e.Value.(*compiledLists))
+	}
+
+	c.entries = map[compiledKey]*list.Element{}
+	c.order.Init()
+
+	return removed
+}
+
+// compiledListsKey returns the hash identifying filters along with the
+// parameters of their compilation.  The lists kept in memory are hashed by
+// their contents, while the files are only identified by their paths, sizes,
+// and modification times, so that the large files aren't read twice.  ok is
+// false if the filters can't be cached, for example because some of those are
+// backed by a database, which may change without notice.
+func compiledListsKey(
+	filters []Filter,
+	ignoreCosmetic bool,
+	normalizeDomains bool,
+) (key compiledKey, ok bool, err error) {
+	h := sha256.New()
+
+	var buf [8]byte
+	if ignoreCosmetic {
+		buf[0] |= 1
+	}
+
+	if normalizeDomains {
+		buf[0] |= 2
+	}
+	_, _ = h.Write(buf[:1])
+
+	for _, f := range filters {
+		binary.BigEndian.PutUint64(buf[:], uint64(f.ID))
+		_, _ = h.Write(buf[:])
+
+		var data []byte
+		switch {
+		case len(f.Data) > 0:
+			_, _ = h.Write([]byte{'d'})
+			data = f.Data
+		case f.FilePath != "":
+			var fi fs.FileInfo
+			fi, err = os.Stat(f.FilePath)
+			if errors.Is(err, fs.ErrNotExist) {
+				_, _ = h.Write([]byte{'n'})
+
+				continue
+			} else if err != nil {
+				return compiledKey{}, false, fmt.Errorf("filter list %d: %w", f.ID, err)
+			}
+
+			_, _ = h.Write([]byte{'f'})
+			binary.BigEndian.PutUint64(buf[:], uint64(fi.Size()))
+			_, _ = h.Write(buf[:])
+			binary.BigEndian.PutUint64(buf[:], uint64(fi.ModTime().UnixNano()))
+			_, _ = h.Write(buf[:])
+			data = []byte(f.FilePath)
+		case f.DB != nil:
+			return compiledKey{}, false, nil
+		default:
+			_, _ = h.Write([]byte{'e'})
+
+			continue
+		}
+
+		binary.BigEndian.PutUint64(buf[:], uint64(len(data)))
+		_, _ = h.Write(buf[:])
+		_, _ = h.Write(data)
+	}
+
+	copy(key[:], h.Sum(nil))
+
+	return key, true, nil
+}
+
+// closeStorages closes the storages which aren't used by d anymore, skipping
+// the nil ones, the duplicates, and the ones owned by the compiled lists
+// cache.  d.engineLock is expected to be locked.
+func (d *DNSFilter) closeStorages(storages ...*filterlist.RuleStorage) {
+	closed := map[*filterlist.RuleStorage]struct{}{}
+	for _, rs := range storages {
+		if rs == nil || rs == d.rulesStorage || rs == d.rulesStorageAllow {
+			continue
+		} else if _, ok := closed[rs]; ok {
+			continue
+		} else if d.compiled != nil && d.compiled.has(rs) {
+			continue
+		}
+
+		closed[rs] = struct{}{}
+		err := rs.Close()
+		if err != nil {
+			log.Error("filtering: closing rule storage: %s", err)
+		}
+	}
+}
+
+// compileBlockLists returns the compiled blocklists, either from the cache or
+// freshly compiled ones.  cached is true if cl is owned by the cache.  The
+// lists which fail to load are skipped the same way newRuleStorage does, and
+// such sets are never cached.  cl is nil only if the storage itself can't be
+// created.
+func (d *DNSFilter) compileBlockLists(
+	filters []Filter,
+	ignoreCosmetic bool,
+	normalizeDomains bool,
+) (cl *compiledLists, cached bool, err error) {
+	key, ok := compiledKey{}, false
+	if d.compiled != nil {
+		key, ok, err = compiledListsKey(filters, ignoreCosmetic, normalizeDomains)
+		if err != nil {
+			// Go on and let newRuleStorage report the error properly.
+			log.Debug("filtering: not caching blocklists: %s", err)
+		} else if ok {
+			if cl, cached = d.compiled.get(key); cached {
+				log.Debug("filtering: using cached blocklists")
+
+				return cl, true, nil
+			}
+		}
+	}
+
+	rs, err := newRuleStorage(filters, ignoreCosmetic, normalizeDomains)
+	if rs == nil {
+		return nil, false, err
+	}
+
+	cl = &compiledLists{
+		storage: rs,
+		info:    scanRulesInfo(rs, !ignoreCosmetic),
+	}
+
+	cl.engine = urlfilter.NewDNSEngine(rs)
+	if ok && err == nil {
+		cl.key = key
+	}
+
+	return cl, false, err
+}
+
+// mergeClientPatterns returns the patterns from both a and b without the
+// duplicates.
+func mergeClientPatterns(a, b []*clientPattern) (pats []*clientPattern) {
+	pats = append(pats, a...)
+	for _, p := range b {
+		dup := false
+		for _, ap := range a {
+			if ap.text == p.text {
+				dup = true
+
+				break
+			}
+		}
+
+		if !dup {
+			pats = append(pats, p)
+		}
+	}
+
+	return pats
+}
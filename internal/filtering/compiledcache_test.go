@@ -0,0 +1,179 @@
+package filtering
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_SetFilters_compiledCache(t *testing.T) {
+	const (
+		dataA = "||a.example^\n"
+		dataB = "||b.example^\n"
+	)
+
+	filters := func(data string) (res []Filter) {
+		// Rebuild the slice and the data each time like the configuration
+		// reload does.
+		return []Filter{{ID: 1, Data: []byte(data)}}
+	}
+
+	engine := func(d *DNSFilter) (e *urlfilter.DNSEngine) {
+		d.engineLock.RLock()
+		defer d.engineLock.RUnlock()
+
+		return d.filteringEngine
+	}
+
+	isBlocked := func(t *testing.T, d *DNSFilter, host string) (ok bool) {
+		t.Helper()
+
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		return res.IsFiltered
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		d := newForTest(t, &Config{}, filters(dataA))
+		t.Cleanup(d.Close)
+
+		prev := engine(d)
+		err := d.SetFilters(filters(dataA), nil, false)
+		require.NoError(t, err)
+
+		assert.Same(t, prev, engine(d))
+		assert.True(t, isBlocked(t, d, "a.example"))
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		d := newForTest(t, &Config{}, filters(dataA))
+		t.Cleanup(d.Close)
+
+		prev := engine(d)
+		err := d.SetFilters(filters(dataB), nil, false)
+		require.NoError(t, err)
+
+		assert.NotSame(t, prev, engine(d))
+		assert.False(t, isBlocked(t, d, "a.example"))
+		assert.True(t, isBlocked(t, d, "b.example"))
+
+		// The default size only keeps the current set.
+		err = d.SetFilters(filters(dataA), nil, false)
+		require.NoError(t, err)
+
+		assert.NotSame(t, prev, engine(d))
+		assert.True(t, isBlocked(t, d, "a.example"))
+	})
+
+	t.Run("size", func(t *testing.T) {
+		d := newForTest(t, &Config{CompiledCacheSize: 2}, filters(dataA))
+		t.Cleanup(d.Close)
+
+		prev := engine(d)
+		err := d.SetFilters(filters(dataB), nil, false)
+		require.NoError(t, err)
+
+		err = d.SetFilters(filters(dataA), nil, false)
+		require.NoError(t, err)
+
+		assert.Same(t, prev, engine(d))
+		assert.True(t, isBlocked(t, d, "a.example"))
+		assert.False(t, isBlocked(t, d, "b.example"))
+	})
+
+	t.Run("cosmetic_setting", func(t *testing.T) {
+		d := newForTest(t, &Config{}, filters(dataA))
+		t.Cleanup(d.Close)
+
+		prev := engine(d)
+
+		d.confLock.Lock()
+		d.KeepCosmeticRules = true
+		d.confLock.Unlock()
+
+		err := d.SetFilters(filters(dataA), nil, false)
+		require.NoError(t, err)
+
+		assert.NotSame(t, prev, engine(d))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "1.txt")
+		err := os.WriteFile(path, []byte(dataA), 0o644)
+		require.NoError(t, err)
+
+		fileFilters := []Filter{{ID: 1, FilePath: path}}
+		d := newForTest(t, &Config{}, fileFilters)
+		t.Cleanup(d.Close)
+
+		prev := engine(d)
+		err = d.SetFilters(fileFilters, nil, false)
+		require.NoError(t, err)
+
+		assert.Same(t, prev, engine(d))
+
+		// The files are identified by their sizes and modification times, so
+		// rewriting the file with the same ones keeps the cached lists.
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+
+		err = os.WriteFile(path, []byte(dataB), 0o644)
+		require.NoError(t, err)
+
+		err = os.Chtimes(path, fi.ModTime(), fi.ModTime())
+		require.NoError(t, err)
+
+		err = d.SetFilters(fileFilters, nil, false)
+		require.NoError(t, err)
+
+		assert.Same(t, prev, engine(d))
+
+		mtime := fi.ModTime().Add(time.Second)
+		err = os.Chtimes(path, mtime, mtime)
+		require.NoError(t, err)
+
+		err = d.SetFilters(fileFilters, nil, false)
+		require.NoError(t, err)
+
+		assert.NotSame(t, prev, engine(d))
+		assert.False(t, isBlocked(t, d, "a.example"))
+		assert.True(t, isBlocked(t, d, "b.example"))
+	})
+}
+
+func BenchmarkDNSFilter_SetFilters(b *testing.B) {
+	sb := &strings.Builder{}
+	for i := 0; i < 50_000; i++ {
+		_, _ = fmt.Fprintf(sb, "||host%d.example^\n", i)
+	}
+
+	data := sb.String()
+	d := newForTest(b, &Config{}, []Filter{{ID: 1, Data: []byte(data)}})
+	b.Cleanup(d.Close)
+
+	b.Run("unchanged", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			err := d.SetFilters([]Filter{{ID: 1, Data: []byte(data)}}, nil, false)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("changed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			changed := fmt.Sprintf("%s||changed%d.example^\n", data, i)
+			err := d.SetFilters([]Filter{{ID: 1, Data: []byte(changed)}}, nil, false)
+			require.NoError(b, err)
+		}
+	})
+}
@@ -0,0 +1,323 @@
+package filtering
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// ConfigDiff is the difference between two filtering configurations.
+type ConfigDiff struct {
+	// Rewrites is the difference between the rewrite entries.
+	Rewrites RewritesDiff
+
+	// BlockedServices is the difference between the globally blocked
+	// services.
+	BlockedServices ServicesDiff
+
+	// Toggles are the boolean settings which have changed.
+	Toggles []ToggleChange
+
+	// BlockFilters and AllowFilters are the differences between the filter
+	// lists.  Those are only filled by DiffSnapshots, since Config doesn't
+	// contain the filter lists.
+	BlockFilters FiltersDiff
+	AllowFilters FiltersDiff
+}
+
+// IsEmpty returns true if there are no differences in diff.
+func (diff *ConfigDiff) IsEmpty() (ok bool) {
+	return len(diff.Rewrites.Added) == 0 &&
+		len(diff.Rewrites.Removed) == 0 &&
+		len(diff.Rewrites.Changed) == 0 &&
+		len(diff.BlockedServices.Added) == 0 &&
+		len(diff.BlockedServices.Removed) == 0 &&
+		len(diff.Toggles) == 0 &&
+		diff.BlockFilters.isEmpty() &&
+		diff.AllowFilters.isEmpty()
+}
+
+// RewritesDiff is the difference between two sets of rewrite entries.
+type RewritesDiff struct {
+	// Added and Removed are the entries only present in the new and the old
+	// configuration respectively.
+	Added   []RewriteEntry
+	Removed []RewriteEntry
+
+	// Changed are the entries for the same domain which have a different
+	// answer or different properties.
+	Changed []RewriteChange
+}
+
+// RewriteChange is a single changed rewrite entry.
+type RewriteChange struct {
+	Old RewriteEntry
+	New RewriteEntry
+}
+
+// ServicesDiff is the difference between two sets of blocked services.  The
+// names are normalized.
+type ServicesDiff struct {
+	Added   []string
+	Removed []string
+}
+
+// ToggleChange is a single changed boolean setting.
+type ToggleChange struct {
+	// Name is the YAML name of the setting.
+	Name string
+	Old  bool
+	New  bool
+}
+
+// FiltersDiff is the difference between two sets of filter lists, which are
+// identified by their IDs.
+type FiltersDiff struct {
+	// Added and Removed are the IDs of the lists only present in the new and
+	// the old set respectively.
+	Added   []int64
+	Removed []int64
+
+	// Changed are the IDs of the lists present in both sets with different
+	// contents.
+	Changed []int64
+}
+
+// isEmpty returns true if there are no differences in diff.
+func (diff *FiltersDiff) isEmpty() (ok bool) {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// DiffConfigs returns the difference between the rewrites, the blocked
+// services, and the boolean settings of oldConf and newConf.  The filter lists
+// of the result are empty, see DiffSnapshots.
+func DiffConfigs(oldConf, newConf *Config) (diff ConfigDiff) {
+	return ConfigDiff{
+		Rewrites:        diffRewrites(oldConf.Rewrites, newConf.Rewrites),
+		BlockedServices: diffServices(oldConf.BlockedServices, newConf.BlockedServices),
+		Toggles:         diffToggles(oldConf, newConf),
+	}
+}
+
+// DiffSnapshots is like DiffConfigs but also returns the difference between
+// the filter lists of oldSnap and newSnap.  The lists are compared by the hash
+// of their contents, so the lists loaded from files are read.  The
+// database-backed lists are only compared by their IDs.
+func DiffSnapshots(oldSnap, newSnap *FilterSnapshot) (diff ConfigDiff, err error) {
+	diff = DiffConfigs(&oldSnap.Config, &newSnap.Config)
+
+	diff.BlockFilters, err = diffFilters(oldSnap.BlockFilters, newSnap.BlockFilters)
+	if err != nil {
+		return ConfigDiff{}, fmt.Errorf("blocklists: %w", err)
+	}
+
+	diff.AllowFilters, err = diffFilters(oldSnap.AllowFilters, newSnap.AllowFilters)
+	if err != nil {
+		return ConfigDiff{}, fmt.Errorf("allowlists: %w", err)
+	}
+
+	return diff, nil
+}
+
+// sameRewrite returns true if a and b are the same entry with the same
+// properties.  The fields derived from the answer and the subnet on
+// normalizing aren't compared.
+func sameRewrite(a, b RewriteEntry) (ok bool) {
+	if !a.equal(b) {
+		return false
+	}
+
+	a.IP, a.Type, a.Subnet, a.ClientSubnet = nil, 0, "", nil
+	b.IP, b.Type, b.Subnet, b.ClientSubnet = nil, 0, "", nil
+
+	return reflect.DeepEqual(a, b)
+}
+
+// diffRewrites returns the difference between the entries of oldRws and
+// newRws.  The entries for the same domain which can't be matched exactly are
+// paired as changed in the order of their appearance.
+func diffRewrites(oldRws, newRws []RewriteEntry) (diff RewritesDiff) {
+	matched := make([]bool, len(newRws))
+
+	var removed []RewriteEntry
+	for _, o := range oldRws {
+		found := false
+		for i, n := range newRws {
+			if !matched[i] && sameRewrite(o, n) {
+				matched[i], found = true, true
+
+				break
+			}
+		}
+
+		if !found {
+			removed = append(removed, o)
+		}
+	}
+
+	for _, o := range removed {
+		paired := false
+		for i, n := range newRws {
+			if !matched[i] && n.Domain == o.Domain {
+				matched[i], paired = true, true
+				diff.Changed = append(diff.Changed, RewriteChange{Old: o, New: n})
+
+				break
+			}
+		}
+
+		if !paired {
+			diff.Removed = append(diff.Removed, o)
+		}
+	}
+
+	for i, n := range newRws {
+		if !matched[i] {
+			diff.Added = append(diff.Added, n)
+		}
+	}
+
+	return diff
+}
+
+// diffServices returns the difference between the blocked services oldSvcs
+// and newSvcs.  The names are normalized and the duplicates are ignored.
+func diffServices(oldSvcs, newSvcs []string) (diff ServicesDiff) {
+	oldSet := make(map[string]struct{}, len(oldSvcs))
+	for _, s := range oldSvcs {
+		oldSet[normalizeServiceName(s)] = struct{}{}
+	}
+
+	newSet := make(map[string]struct{}, len(newSvcs))
+	for _, s := range newSvcs {
+		name := normalizeServiceName(s)
+		if _, ok := newSet[name]; ok {
+			continue
+		}
+
+		newSet[name] = struct{}{}
+		if _, ok := oldSet[name]; !ok {
+			diff.Added = append(diff.Added, name)
+		}
+	}
+
+	for _, s := range oldSvcs {
+		name := normalizeServiceName(s)
+		if _, ok := newSet[name]; ok {
+			continue
+		}
+
+		// Mark the name as seen to skip the duplicates.
+		newSet[name] = struct{}{}
+		diff.Removed = append(diff.Removed, name)
+	}
+
+	return diff
+}
+
+// diffToggles returns the boolean settings which differ between oldConf and
+// newConf.
+func diffToggles(oldConf, newConf *Config) (changes []ToggleChange) {
+	toggles := []struct {
+		name     string
+		old, new bool
+	}{
+		{"parental_enabled", oldConf.ParentalEnabled, newConf.ParentalEnabled},
+		{"safesearch_enabled", oldConf.SafeSearchEnabled, newConf.SafeSearchEnabled},
+		{"safebrowsing_enabled", oldConf.SafeBrowsingEnabled, newConf.SafeBrowsingEnabled},
+		{"rotate_rewrite_ips", oldConf.RotateRewriteIPs, newConf.RotateRewriteIPs},
+		{"service_names_as_parents", oldConf.ServiceNamesAsParents, newConf.ServiceNamesAsParents},
+		{"self_rewrite_nodata", oldConf.SelfRewriteNoData, newConf.SelfRewriteNoData},
+		{"host_rule_mismatch_nodata", oldConf.HostRuleMismatchNoData, newConf.HostRuleMismatchNoData},
+		{"rewrite_https_nodata", oldConf.RewriteHTTPSNoData, newConf.RewriteHTTPSNoData},
+		{"default_deny", oldConf.DefaultDeny, newConf.DefaultDeny},
+		{"block_until_ready", oldConf.BlockUntilReady, newConf.BlockUntilReady},
+		{"strict_wildcards", oldConf.StrictWildcards, newConf.StrictWildcards},
+		{"keep_cosmetic_rules", oldConf.KeepCosmeticRules, newConf.KeepCosmeticRules},
+		{"normalize_rule_domains", oldConf.NormalizeRuleDomains, newConf.NormalizeRuleDomains},
+		{"block_trackers", oldConf.BlockTrackers, newConf.BlockTrackers},
+	}
+
+	for _, t := range toggles {
+		if t.old != t.new {
+			changes = append(changes, ToggleChange{Name: t.name, Old: t.old, New: t.new})
+		}
+	}
+
+	return changes
+}
+
+// filterHash returns the hash of the contents of f.  The missing files are
+// treated as empty.
+func filterHash(f Filter) (sum [sha256.Size]byte, err error) {
+	data := f.Data
+	if len(data) == 0 && f.FilePath != "" {
+		data, err = os.ReadFile(f.FilePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return sum, fmt.Errorf("filter list %d: %w", f.ID, err)
+		}
+	}
+
+	return sha256.Sum256(data), nil
+}
+
+// diffFilters returns the difference between the filter lists oldFilters and
+// newFilters.
+func diffFilters(oldFilters, newFilters []Filter) (diff FiltersDiff, err error) {
+	oldByID := make(map[int64]Filter, len(oldFilters))
+	for _, f := range oldFilters {
+		oldByID[f.ID] = f
+	}
+
+	newIDs := make(map[int64]struct{}, len(newFilters))
+	for _, n := range newFilters {
+		newIDs[n.ID] = struct{}{}
+
+		o, ok := oldByID[n.ID]
+		if !ok {
+			diff.Added = append(diff.Added, n.ID)
+
+			continue
+		}
+
+		var changed bool
+		changed, err = filtersDiffer(o, n)
+		if err != nil {
+			return FiltersDiff{}, err
+		} else if changed {
+			diff.Changed = append(diff.Changed, n.ID)
+		}
+	}
+
+	for _, o := range oldFilters {
+		if _, ok := newIDs[o.ID]; !ok {
+			diff.Removed = append(diff.Removed, o.ID)
+		}
+	}
+
+	return diff, nil
+}
+
+// filtersDiffer returns true if the contents of the lists a and b with the
+// same ID differ.
+func filtersDiffer(a, b Filter) (ok bool, err error) {
+	if a.DB != nil || b.DB != nil {
+		return (a.DB == nil) != (b.DB == nil), nil
+	}
+
+	aSum, err := filterHash(a)
+	if err != nil {
+		return false, err
+	}
+
+	bSum, err := filterHash(b)
+	if err != nil {
+		return false, err
+	}
+
+	return aSum != bSum, nil
+}
@@ -0,0 +1,147 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	oldConf := &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "same.example",
+			Answer: "1.2.3.4",
+		}, {
+			Domain: "changed.example",
+			Answer: "1.1.1.1",
+		}, {
+			Domain: "pinned.example",
+			Answer: "2.2.2.2",
+		}, {
+			Domain: "removed.example",
+			Answer: "3.3.3.3",
+		}},
+		BlockedServices:     []string{"facebook", "TikTok", "facebook"},
+		SafeBrowsingEnabled: true,
+		DefaultDeny:         false,
+	}
+
+	newConf := &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "added.example",
+			Answer: "4.4.4.4",
+		}, {
+			Domain: "same.example",
+			Answer: "1.2.3.4",
+		}, {
+			Domain: "changed.example",
+			Answer: "5.5.5.5",
+		}, {
+			Domain: "pinned.example",
+			Answer: "2.2.2.2",
+			Pinned: true,
+		}},
+		BlockedServices:     []string{" facebook ", "youtube"},
+		SafeBrowsingEnabled: false,
+		DefaultDeny:         true,
+	}
+
+	diff := DiffConfigs(oldConf, newConf)
+
+	assert.Equal(t, RewritesDiff{
+		Added:   []RewriteEntry{newConf.Rewrites[0]},
+		Removed: []RewriteEntry{oldConf.Rewrites[3]},
+		Changed: []RewriteChange{{
+			Old: oldConf.Rewrites[1],
+			New: newConf.Rewrites[2],
+		}, {
+			Old: oldConf.Rewrites[2],
+			New: newConf.Rewrites[3],
+		}},
+	}, diff.Rewrites)
+
+	assert.Equal(t, ServicesDiff{
+		Added:   []string{"youtube"},
+		Removed: []string{"tiktok"},
+	}, diff.BlockedServices)
+
+	assert.Equal(t, []ToggleChange{{
+		Name: "safebrowsing_enabled",
+		Old:  true,
+		New:  false,
+	}, {
+		Name: "default_deny",
+		Old:  false,
+		New:  true,
+	}}, diff.Toggles)
+
+	assert.False(t, diff.IsEmpty())
+
+	t.Run("same", func(t *testing.T) {
+		diff = DiffConfigs(oldConf, oldConf)
+		assert.True(t, diff.IsEmpty())
+	})
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	dir := t.TempDir()
+
+	fileSame := filepath.Join(dir, "same.txt")
+	err := os.WriteFile(fileSame, []byte("||file.example^\n"), 0o644)
+	require.NoError(t, err)
+
+	fileChanged := filepath.Join(dir, "changed.txt")
+	err = os.WriteFile(fileChanged, []byte("||other.example^\n"), 0o644)
+	require.NoError(t, err)
+
+	oldSnap := &FilterSnapshot{
+		Config: Config{
+			BlockedServices: []string{"facebook"},
+		},
+		BlockFilters: []Filter{
+			{ID: 1, Data: []byte("||same.example^\n")},
+			{ID: 2, Data: []byte("||old.example^\n")},
+			{ID: 3, Data: []byte("||removed.example^\n")},
+			{ID: 4, FilePath: fileSame},
+		},
+		AllowFilters: []Filter{
+			{ID: 10, FilePath: fileSame},
+		},
+	}
+
+	newSnap := &FilterSnapshot{
+		Config: Config{
+			BlockedServices: []string{"facebook"},
+		},
+		BlockFilters: []Filter{
+			{ID: 1, Data: []byte("||same.example^\n")},
+			{ID: 2, Data: []byte("||new.example^\n")},
+			// The same contents loaded from the file.
+			{ID: 4, Data: []byte("||file.example^\n")},
+			{ID: 5, Data: []byte("||added.example^\n")},
+		},
+		AllowFilters: []Filter{
+			{ID: 10, FilePath: fileChanged},
+		},
+	}
+
+	diff, err := DiffSnapshots(oldSnap, newSnap)
+	require.NoError(t, err)
+
+	assert.Equal(t, FiltersDiff{
+		Added:   []int64{5},
+		Removed: []int64{3},
+		Changed: []int64{2},
+	}, diff.BlockFilters)
+
+	assert.Equal(t, FiltersDiff{
+		Changed: []int64{10},
+	}, diff.AllowFilters)
+
+	assert.Empty(t, diff.BlockedServices)
+	assert.Empty(t, diff.Toggles)
+	assert.Empty(t, diff.Rewrites)
+}
@@ -0,0 +1,21 @@
+package filtering
+
+// CosmeticRules returns the cosmetic rules from the currently loaded filter
+// lists.  It returns nil unless Config.KeepCosmeticRules was set when the
+// lists were loaded.
+func (d *DNSFilter) CosmeticRules() (res []*ResultRule) {
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	if d.cosmeticRules == nil {
+		return nil
+	}
+
+	res = make([]*ResultRule, len(d.cosmeticRules))
+	for i, r := range d.cosmeticRules {
+		rr := *r
+		res[i] = &rr
+	}
+
+	return res
+}
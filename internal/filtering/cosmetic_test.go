@@ -0,0 +1,63 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CosmeticRules(t *testing.T) {
+	const (
+		blockData = "||example.org^\n" +
+			"example.com##.banner\n" +
+			"##.ad\n"
+		allowData = "example.net#@#.banner\n"
+	)
+
+	testCases := []struct {
+		name string
+		want []*ResultRule
+		keep bool
+	}{{
+		name: "ignored",
+		want: nil,
+		keep: false,
+	}, {
+		name: "kept",
+		want: []*ResultRule{{
+			Text:         "example.com##.banner",
+			FilterListID: 1,
+		}, {
+			Text:         "##.ad",
+			FilterListID: 1,
+		}, {
+			Text:         "example.net#@#.banner",
+			FilterListID: 2,
+		}},
+		keep: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{KeepCosmeticRules: tc.keep}, nil)
+			t.Cleanup(d.Close)
+
+			err := d.SetFilters(
+				[]Filter{{ID: 1, Data: []byte(blockData)}},
+				[]Filter{{ID: 2, Data: []byte(allowData)}},
+				false,
+			)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, d.CosmeticRules())
+
+			// The network rules still work the same way.
+			res, err := d.CheckHost("example.org", dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.True(t, res.IsFiltered)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// Errors of the custom blocked services.
+const (
+	// errServiceExists is returned when adding a service with the name of
+	// an already known one.
+	errServiceExists errors.Error = "service already exists"
+
+	// errServiceNotCustom is returned when removing a service which isn't a
+	// custom one, including the built-in ones.
+	errServiceNotCustom errors.Error = "not a custom service"
+
+	// errServiceRuleUnsupported is returned when adding a service with a
+	// rule which doesn't simply block requests, like an exception, a
+	// $dnsrewrite, or a $badfilter one.
+	errServiceRuleUnsupported errors.Error = "only blocking rules are supported"
+)
+
+// customServices are the names of the services added with AddBlockedService.
+// It's protected by serviceRulesLock.
+var customServices map[string]struct{}
+
+// AddBlockedService adds the custom blocked service with name and the rules
+// from ruleTexts, so that it can be blocked like the built-in ones, see
+// BlockedSvcKnown.  The name is normalized, see normalizeServiceName.  The
+// empty lines and the comments starting with "!" are skipped.  Only the
+// blocking rules are allowed, the exception, $dnsrewrite, and $badfilter ones
+// are rejected.  The errors about the invalid rules contain the number of the
+// line, starting from 1.
+//
+// The services added with AddBlockedService are removed by InitModule.
+func AddBlockedService(name string, ruleTexts []string) (err error) {
+	name = normalizeServiceName(name)
+	if name == "" {
+		return errors.Error("empty service name")
+	}
+
+	netRules, err := customServiceRules(ruleTexts)
+	if err != nil {
+		return fmt.Errorf("service %q: %w", name, err)
+	}
+
+	serviceRulesLock.Lock()
+	defer serviceRulesLock.Unlock()
+
+	if _, ok := serviceRules[name]; ok {
+		return fmt.Errorf("service %q: %w", name, errServiceExists)
+	}
+
+	if serviceRules == nil {
+		serviceRules = map[string][]*rules.NetworkRule{}
+	}
+
+	if customServices == nil {
+		customServices = map[string]struct{}{}
+	}
+
+	serviceRules[name] = netRules
+	customServices[name] = struct{}{}
+
+	return nil
+}
+
+// customServiceRules returns the rules compiled from texts, see
+// AddBlockedService.
+func customServiceRules(texts []string) (netRules []*rules.NetworkRule, err error) {
+	for i, text := range texts {
+		text = strings.TrimSpace(text)
+		if text == "" || text[0] == '!' {
+			continue
+		}
+
+		var r *rules.NetworkRule
+		r, err = rules.NewNetworkRule(text, BlockedSvcsListID)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q: %w", i+1, text, err)
+		}
+
+		if r.Whitelist || r.DNSRewrite != nil || r.IsOptionEnabled(rules.OptionBadfilter) {
+			return nil, fmt.Errorf("line %d: %q: %w", i+1, text, errServiceRuleUnsupported)
+		}
+
+		netRules = append(netRules, r)
+	}
+
+	if len(netRules) == 0 {
+		return nil, errors.Error("no rules")
+	}
+
+	return netRules, nil
+}
+
+// RemoveBlockedService removes the custom blocked service with name added with
+// AddBlockedService.  The built-in services can't be removed.  The settings
+// already containing the service keep blocking it.
+func RemoveBlockedService(name string) (err error) {
+	name = normalizeServiceName(name)
+
+	serviceRulesLock.Lock()
+	defer serviceRulesLock.Unlock()
+
+	if _, ok := customServices[name]; !ok {
+		return fmt.Errorf("service %q: %w", name, errServiceNotCustom)
+	}
+
+	delete(customServices, name)
+	delete(serviceRules, name)
+
+	return nil
+}
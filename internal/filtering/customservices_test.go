@@ -0,0 +1,139 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddBlockedService(t *testing.T) {
+	InitModule()
+	t.Cleanup(InitModule)
+
+	err := AddBlockedService(" MyApp ", []string{
+		"! The app itself.",
+		"||myapp.example^",
+		"",
+		"||cdn.myapp.example^",
+	})
+	require.NoError(t, err)
+
+	require.True(t, BlockedSvcKnown("myapp"))
+
+	e, ok := BlockedServiceEntry("myapp")
+	require.True(t, ok)
+
+	assert.Equal(t, []string{"||myapp.example^", "||cdn.myapp.example^"}, e.RuleTexts())
+
+	d := newForTest(t, &Config{BlockedServices: []string{"myapp"}}, nil)
+	t.Cleanup(d.Close)
+
+	s := setts
+	d.ApplyBlockedServices(&s, nil, true)
+
+	res, err := d.CheckHost("img.cdn.myapp.example", dns.TypeA, &s)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, FilteredBlockedService, res.Reason)
+	assert.Equal(t, "myapp", res.ServiceName)
+
+	require.Len(t, res.Rules, 1)
+
+	assert.Equal(t, "||cdn.myapp.example^", res.Rules[0].Text)
+	assert.Equal(t, int64(BlockedSvcsListID), res.Rules[0].FilterListID)
+
+	testCases := []struct {
+		wantErrIs  error
+		name       string
+		svc        string
+		wantErrMsg string
+		rules      []string
+	}{{
+		wantErrIs:  errServiceExists,
+		name:       "duplicate_custom",
+		svc:        "myapp",
+		wantErrMsg: `service "myapp": service already exists`,
+		rules:      []string{"||other.example^"},
+	}, {
+		wantErrIs:  errServiceExists,
+		name:       "duplicate_builtin",
+		svc:        "YouTube",
+		wantErrMsg: `service "youtube": service already exists`,
+		rules:      []string{"||other.example^"},
+	}, {
+		wantErrIs:  nil,
+		name:       "invalid_rule",
+		svc:        "invalid",
+		wantErrMsg: `service "invalid": line 3: "||bad.example^$unknown_modifier"`,
+		rules:      []string{"||good.example^", "", "||bad.example^$unknown_modifier"},
+	}, {
+		wantErrIs:  errServiceRuleUnsupported,
+		name:       "exception",
+		svc:        "exception",
+		wantErrMsg: `service "exception": line 2: "@@||allowed.example^"`,
+		rules:      []string{"||good.example^", "@@||allowed.example^"},
+	}, {
+		wantErrIs:  errServiceRuleUnsupported,
+		name:       "dnsrewrite",
+		svc:        "dnsrewrite",
+		wantErrMsg: `service "dnsrewrite": line 1: "||rw.example^$dnsrewrite=1.2.3.4"`,
+		rules:      []string{"||rw.example^$dnsrewrite=1.2.3.4"},
+	}, {
+		wantErrIs:  errServiceRuleUnsupported,
+		name:       "badfilter",
+		svc:        "badfilter",
+		wantErrMsg: `service "badfilter": line 1: "||youtube.com^$badfilter"`,
+		rules:      []string{"||youtube.com^$badfilter"},
+	}, {
+		wantErrIs:  nil,
+		name:       "no_rules",
+		svc:        "empty",
+		wantErrMsg: `service "empty": no rules`,
+		rules:      []string{"! Nothing."},
+	}, {
+		wantErrIs:  nil,
+		name:       "no_name",
+		svc:        " ",
+		wantErrMsg: "empty service name",
+		rules:      []string{"||other.example^"},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addErr := AddBlockedService(tc.svc, tc.rules)
+			require.Error(t, addErr)
+
+			assert.Contains(t, addErr.Error(), tc.wantErrMsg)
+			if tc.wantErrIs != nil {
+				assert.ErrorIs(t, addErr, tc.wantErrIs)
+			}
+		})
+	}
+
+	for _, name := range []string{"invalid", "exception", "dnsrewrite", "badfilter"} {
+		assert.False(t, BlockedSvcKnown(name))
+	}
+
+	t.Run("remove", func(t *testing.T) {
+		err = RemoveBlockedService("myapp")
+		require.NoError(t, err)
+
+		assert.False(t, BlockedSvcKnown("myapp"))
+
+		err = RemoveBlockedService("myapp")
+		assert.ErrorIs(t, err, errServiceNotCustom)
+
+		err = RemoveBlockedService("youtube")
+		assert.ErrorIs(t, err, errServiceNotCustom)
+
+		assert.True(t, BlockedSvcKnown("youtube"))
+
+		ns := setts
+		d.ApplyBlockedServices(&ns, nil, true)
+
+		assert.Empty(t, ns.ServicesRules)
+	})
+}
@@ -0,0 +1,38 @@
+package filtering
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// debounceFilters waits for more filter updates until none arrive during
+// Config.ReloadDebounce and returns the one to apply, which is the last one
+// received.  The refreshes don't replace the pending updates, since those
+// rebuild the engines anyway.  It returns params immediately if the interval
+// isn't configured.
+func (d *DNSFilter) debounceFilters(params filtersInitializerParams) (last filtersInitializerParams) {
+	d.confLock.RLock()
+	interval := time.Duration(d.ReloadDebounce) * time.Millisecond
+	d.confLock.RUnlock()
+
+	if interval == 0 {
+		return params
+	}
+
+	last = params
+	for coalesced := 0; ; coalesced++ {
+		select {
+		case p := <-d.filtersInitializerChan:
+			if !p.refresh || last.refresh {
+				last = p
+			}
+		case <-d.after(interval):
+			if coalesced > 0 {
+				log.Debug("filtering: coalesced %d filter updates", coalesced+1)
+			}
+
+			return last
+		}
+	}
+}
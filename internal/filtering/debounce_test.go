@@ -0,0 +1,76 @@
+package filtering
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_SetFilters_debounce(t *testing.T) {
+	d := newForTest(t, &Config{
+		ReloadDebounce:    100,
+		CompiledCacheSize: 10,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	// Replace the clock to only fire when the test says so.
+	fire := make(chan time.Time)
+	waits := make(chan time.Duration, 10)
+	d.after = func(dur time.Duration) (c <-chan time.Time) {
+		waits <- dur
+
+		return fire
+	}
+
+	d.Start()
+
+	listFor := func(n int) (f []Filter) {
+		return []Filter{{
+			ID:   1,
+			Data: []byte(fmt.Sprintf("||list%d.example^\n", n)),
+		}}
+	}
+
+	isBlocked := func(host string) (ok bool) {
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		return res.IsFiltered
+	}
+
+	err := d.SetFilters(listFor(0), nil, false)
+	require.NoError(t, err)
+	require.True(t, isBlocked("list0.example"))
+
+	for i := 1; i <= 3; i++ {
+		err = d.SetFilters(listFor(i), nil, true)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, 100*time.Millisecond, <-waits)
+
+	// Wait for the initializer to take all the updates.
+	require.Eventually(t, func() (ok bool) {
+		return len(d.filtersInitializerChan) == 0
+	}, time.Second, time.Millisecond)
+
+	// The filters aren't applied until the interval ends.
+	assert.True(t, isBlocked("list0.example"))
+
+	fire <- time.Time{}
+
+	assert.Eventually(t, func() (ok bool) {
+		return isBlocked("list3.example")
+	}, time.Second, time.Millisecond)
+	assert.False(t, isBlocked("list0.example"))
+
+	// Only the initial and the last filter sets were compiled.
+	d.compiled.mu.Lock()
+	defer d.compiled.mu.Unlock()
+
+	assert.Len(t, d.compiled.entries, 2)
+}
@@ -0,0 +1,154 @@
+package filtering
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// DecisionRecord is a filtering decision made by CheckHost.
+type DecisionRecord struct {
+	// Time is the moment the decision was made.
+	Time time.Time
+	// Host is the checked hostname.
+	Host string
+	// ClientName is the name of the client, if any.
+	ClientName string
+	// ClientIP is the address of the client, if any.
+	ClientIP net.IP
+	// Rules are the matched rules, if any.
+	Rules []*ResultRule
+	// QType is the type of the DNS request.
+	QType uint16
+	// Reason is the reason of the decision.
+	Reason Reason
+	// IsFiltered is true if the request is blocked.
+	IsFiltered bool
+}
+
+// DecisionSink receives the filtering decisions, see Config.DecisionSink.
+type DecisionSink interface {
+	// Write receives the decision.  It's called from a single goroutine, so
+	// it may block, but the decisions made meanwhile are buffered and
+	// dropped once the buffer is full.
+	Write(rec DecisionRecord)
+}
+
+// defaultDecisionBufferSize is the default size of the buffer of the decisions
+// waiting to be written to the sink, see Config.DecisionBufferSize.
+const defaultDecisionBufferSize = 1024
+
+// decisionStream asynchronously writes the decisions to the sink.  A nil
+// *decisionStream is a no-op.
+type decisionStream struct {
+	// dropped is the number of the decisions dropped due to the buffer
+	// overflow.  It is accessed atomically, so it must be the first field to
+	// be properly aligned on 32-bit platforms.
+	dropped uint64
+
+	sink     DecisionSink
+	ch       chan DecisionRecord
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newDecisionStream returns a new properly initialized decision stream writing
+// to sink.  It returns nil if sink is nil.
+func newDecisionStream(sink DecisionSink, bufSize uint) (s *decisionStream) {
+	if sink == nil {
+		return nil
+	}
+
+	if bufSize == 0 {
+		bufSize = defaultDecisionBufferSize
+	}
+
+	s = &decisionStream{
+		sink: sink,
+		ch:   make(chan DecisionRecord, bufSize),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// run writes the decisions to the sink until s is closed.
+func (s *decisionStream) run() {
+	defer close(s.done)
+	defer log.OnPanic("filtering: decision sink")
+
+	for {
+		select {
+		case rec := <-s.ch:
+			s.sink.Write(rec)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// send queues rec to be written to the sink.  rec is dropped if the buffer is
+// full, so send never blocks.
+func (s *decisionStream) send(rec DecisionRecord) {
+	if s == nil {
+		return
+	}
+
+	select {
+	case s.ch <- rec:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// close stops writing the decisions and waits for the sink to finish writing
+// the current one.  The buffered decisions are discarded.
+func (s *decisionStream) close() {
+	if s == nil {
+		return
+	}
+
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// sendDecision queues the decision about the request for host with qtype from
+// the client with setts.
+func (d *DNSFilter) sendDecision(host string, qtype uint16, setts *Settings, res Result) {
+	if d.decisions == nil {
+		return
+	}
+
+	rec := DecisionRecord{
+		Time:       d.now(),
+		Host:       host,
+		QType:      qtype,
+		Rules:      res.Rules,
+		Reason:     res.Reason,
+		IsFiltered: res.IsFiltered,
+	}
+
+	if setts != nil {
+		rec.ClientName = setts.ClientName
+		rec.ClientIP = setts.ClientIP
+	}
+
+	d.decisions.send(rec)
+}
+
+// DroppedDecisions returns the number of the filtering decisions dropped
+// because the decision sink couldn't keep up with them.
+func (d *DNSFilter) DroppedDecisions() (n uint64) {
+	if d.decisions == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&d.decisions.dropped)
+}
@@ -0,0 +1,142 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDecisionSink is a DecisionSink for tests.
+type testDecisionSink struct {
+	onWrite func(rec DecisionRecord)
+}
+
+// Write implements the DecisionSink interface for *testDecisionSink.
+func (s *testDecisionSink) Write(rec DecisionRecord) {
+	s.onWrite(rec)
+}
+
+func TestDNSFilter_CheckHost_decisionSink(t *testing.T) {
+	const data = "||blocked.example^\n"
+
+	recs := make(chan DecisionRecord, 2)
+	sink := &testDecisionSink{
+		onWrite: func(rec DecisionRecord) { recs <- rec },
+	}
+
+	d := newForTest(t, &Config{DecisionSink: sink}, []Filter{{ID: 0, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	s := setts
+	s.ClientName = "kid-phone"
+	s.ClientIP = net.IP{192, 168, 0, 2}
+
+	_, err := d.CheckHost("Blocked.Example", dns.TypeA, &s)
+	require.NoError(t, err)
+
+	_, err = d.CheckHost("allowed.example", dns.TypeAAAA, &s)
+	require.NoError(t, err)
+
+	receive := func(t *testing.T) (rec DecisionRecord) {
+		t.Helper()
+
+		select {
+		case rec = <-recs:
+			return rec
+		case <-time.After(time.Second):
+			t.Fatal("no decision received")
+		}
+
+		return rec
+	}
+
+	rec := receive(t)
+	assert.Equal(t, "blocked.example", rec.Host)
+	assert.Equal(t, dns.TypeA, rec.QType)
+	assert.Equal(t, FilteredBlockList, rec.Reason)
+	assert.True(t, rec.IsFiltered)
+	assert.Equal(t, "kid-phone", rec.ClientName)
+	assert.Equal(t, s.ClientIP, rec.ClientIP)
+	assert.False(t, rec.Time.IsZero())
+
+	require.Len(t, rec.Rules, 1)
+
+	assert.Equal(t, "||blocked.example^", rec.Rules[0].Text)
+
+	rec = receive(t)
+	assert.Equal(t, "allowed.example", rec.Host)
+	assert.Equal(t, dns.TypeAAAA, rec.QType)
+	assert.Equal(t, NotFilteredNotFound, rec.Reason)
+	assert.False(t, rec.IsFiltered)
+
+	assert.Zero(t, d.DroppedDecisions())
+}
+
+func TestDNSFilter_CheckHost_decisionSinkOverflow(t *testing.T) {
+	const queries = 10
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sink := &testDecisionSink{
+		onWrite: func(_ DecisionRecord) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+
+			<-release
+		},
+	}
+
+	d := newForTest(t, &Config{
+		DecisionSink:       sink,
+		DecisionBufferSize: 1,
+	}, nil)
+	t.Cleanup(d.Close)
+	// Release the sink before closing d, since the cleanups are called in
+	// the reverse order.
+	t.Cleanup(func() { close(release) })
+
+	// Make the sink block on the first decision.
+	_, err := d.CheckHost("first.example", dns.TypeA, &setts)
+	require.NoError(t, err)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("sink isn't called")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < queries; i++ {
+			_, cerr := d.CheckHost("other.example", dns.TypeA, &setts)
+			assert.NoError(t, cerr)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queries are blocked by the sink")
+	}
+
+	// One decision is buffered, the rest are dropped.
+	assert.Equal(t, uint64(queries-1), d.DroppedDecisions())
+}
+
+func TestDNSFilter_DroppedDecisions_noSink(t *testing.T) {
+	d := newForTest(t, &Config{}, nil)
+	t.Cleanup(d.Close)
+
+	_, err := d.CheckHost("example.org", dns.TypeA, &setts)
+	require.NoError(t, err)
+
+	assert.Zero(t, d.DroppedDecisions())
+}
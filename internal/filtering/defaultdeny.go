@@ -0,0 +1,48 @@
+package filtering
+
+import "github.com/AdguardTeam/golibs/log"
+
+// defaultDenyRuleText is the text of the pseudo-rule reported in the results
+// of the requests blocked by default.
+const defaultDenyRuleText = "default deny"
+
+// defaultDenyStageName is the name of the last stage of checking a request,
+// which blocks the hosts matched by none of the previous ones.
+const defaultDenyStageName = "default deny"
+
+// matchDefaultDeny returns the result for host which matched none of the
+// previous stages.  It's a block with the FilteredDefaultDeny reason if
+// Config.DefaultDeny is enabled, the filtering is enabled for setts, and the
+// schedule of setts is active, and an empty result otherwise.  Since it's the
+// last of the host checkers, the rewrites and the other stages are honored
+// first, and the names from the rewrite chains, see matchRewriteChain, aren't
+// blocked by default.  err is always nil, it is only there to make this a
+// valid hostChecker function.
+func (d *DNSFilter) matchDefaultDeny(
+	host string,
+	_ uint16,
+	setts *Settings,
+) (res Result, err error) {
+	if !setts.EffectiveFiltering() || !d.scheduleActive(setts) {
+		return Result{}, nil
+	}
+
+	d.confLock.RLock()
+	deny := d.DefaultDeny
+	d.confLock.RUnlock()
+
+	if !deny {
+		return Result{}, nil
+	}
+
+	log.Debug("filtering: host %q is not allowlisted, blocking by default", host)
+
+	return Result{
+		IsFiltered: true,
+		Reason:     FilteredDefaultDeny,
+		Rules: []*ResultRule{{
+			Text:         defaultDenyRuleText,
+			FilterListID: DefaultDenyListID,
+		}},
+	}, nil
+}
@@ -0,0 +1,152 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_defaultDeny(t *testing.T) {
+	d := newForTest(t, &Config{
+		DefaultDeny: true,
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "192.168.0.1",
+		}},
+	}, []Filter{{
+		ID:   1,
+		Data: []byte("@@||allowed.example^\n||blocked.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name         string
+		host         string
+		wantReason   Reason
+		wantFiltered bool
+	}{{
+		name:         "unlisted",
+		host:         "unlisted.example",
+		wantReason:   FilteredDefaultDeny,
+		wantFiltered: true,
+	}, {
+		name:         "allowlisted",
+		host:         "www.allowed.example",
+		wantReason:   NotFilteredAllowList,
+		wantFiltered: false,
+	}, {
+		name:         "blocked",
+		host:         "blocked.example",
+		wantReason:   FilteredBlockList,
+		wantFiltered: true,
+	}, {
+		name:         "rewritten",
+		host:         "rewritten.example",
+		wantReason:   Rewritten,
+		wantFiltered: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantFiltered, res.IsFiltered)
+		})
+	}
+
+	t.Run("rule", func(t *testing.T) {
+		res, err := d.CheckHost("unlisted.example", dns.TypeAAAA, &setts)
+		require.NoError(t, err)
+		require.Len(t, res.Rules, 1)
+
+		assert.Equal(t, int64(DefaultDenyListID), res.Rules[0].FilterListID)
+		assert.Equal(t, "FilteredDefaultDeny", res.Reason.String())
+	})
+
+	t.Run("protection_disabled", func(t *testing.T) {
+		s := setts
+		s.ProtectionEnabled = false
+
+		res, err := d.CheckHost("unlisted.example", dns.TypeA, &s)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		nd := newForTest(t, nil, nil)
+		t.Cleanup(nd.Close)
+
+		res, err := nd.CheckHost("unlisted.example", dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+	})
+}
+
+func TestDNSFilter_CheckHost_defaultDenyRewrites(t *testing.T) {
+	rewrites := []RewriteEntry{{
+		Domain: "a.example",
+		Answer: "192.168.0.1",
+	}, {
+		Domain: "cname.example",
+		Answer: "target.example",
+	}, {
+		Domain: "target.example",
+		Answer: "192.168.0.2",
+	}}
+
+	testCases := []struct {
+		name     string
+		host     string
+		wantName string
+		wantIP   net.IP
+	}{{
+		name:     "a",
+		host:     "a.example",
+		wantName: "",
+		wantIP:   net.IP{192, 168, 0, 1},
+	}, {
+		name:     "cname",
+		host:     "cname.example",
+		wantName: "target.example",
+		wantIP:   net.IP{192, 168, 0, 2},
+	}}
+
+	for _, prec := range []string{RewritePrecedenceRewrite, RewritePrecedenceBlock} {
+		d := newForTest(t, &Config{
+			DefaultDeny:       true,
+			RewritePrecedence: prec,
+			Rewrites:          rewrites,
+		}, nil)
+		t.Cleanup(d.Close)
+
+		for _, tc := range testCases {
+			t.Run(prec+"_"+tc.name, func(t *testing.T) {
+				res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+				require.NoError(t, err)
+
+				assert.False(t, res.IsFiltered)
+				assert.Equal(t, Rewritten, res.Reason)
+				assert.Equal(t, tc.wantName, res.CanonName)
+				require.Len(t, res.IPList, 1)
+
+				assert.Equal(t, tc.wantIP, res.IPList[0])
+			})
+		}
+
+		t.Run(prec+"_unlisted", func(t *testing.T) {
+			res, err := d.CheckHost("unlisted.example", dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, FilteredDefaultDeny, res.Reason)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package filtering
+
+import (
+	"context"
+	"strings"
+)
+
+// Finding is a filtering mechanism which acts on a host.
+type Finding struct {
+	// Mechanism is the name of the mechanism, for example "rewrites" or
+	// "filtering".
+	Mechanism string
+
+	// Result is the result of the mechanism.
+	Result
+}
+
+// DiagnoseHost returns all the mechanisms that would act on the request for
+// host with qtype with the global settings, unlike CheckHost which stops at the
+// first one.  It helps to find the overlapping rewrites and rules.  The
+// mechanisms are the stages of CheckHost, and those are checked regardless of
+// whether the filtering is enabled, but the network-based ones, like safe
+// browsing, aren't checked at all.  The default deny is only reported if no
+// other mechanism acts on the request.  The texts of the rules are redacted
+// the same way CheckHost does.
+func (d *DNSFilter) DiagnoseHost(host string, qtype uint16) (findings []Finding, err error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return nil, nil
+	}
+
+	setts := d.GetConfig()
+	setts.FilteringEnabled = true
+	setts.ProtectionEnabled = true
+	d.ApplyBlockedServices(&setts, nil, true)
+
+	collect := func(hc *hostChecker, res Result) (cont bool) {
+		if res.Reason.Matched() {
+			d.redactRules(&res)
+			findings = append(findings, Finding{Mechanism: hc.name, Result: res})
+		}
+
+		return true
+	}
+
+	_, err = d.walkCheckers(context.Background(), host, qtype, &setts, true, collect)
+	if err != nil {
+		return nil, err
+	}
+
+	return findings, nil
+}
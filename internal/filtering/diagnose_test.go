@@ -0,0 +1,139 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_DiagnoseHost(t *testing.T) {
+	const text = "||both.example^\n" +
+		"||rule.example^\n" +
+		"||dnsrw.example^$dnsrewrite=1.2.3.4\n" +
+		"@@||allowed.example^\n"
+
+	d := newForTest(t, &Config{
+		ServerNames: []string{"dns.lan"},
+		ServerIPs:   []net.IP{{192, 168, 0, 1}},
+		Rewrites: []RewriteEntry{{
+			Domain: "both.example",
+			Answer: "1.1.1.1",
+		}, {
+			Domain: "allowed.example",
+			Answer: "2.2.2.2",
+		}},
+	}, []Filter{{ID: 1, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name        string
+		host        string
+		wantMechs   []string
+		wantReasons []Reason
+	}{{
+		name:        "rewrite_and_rule",
+		host:        "both.example",
+		wantMechs:   []string{"rewrites", "filtering"},
+		wantReasons: []Reason{Rewritten, FilteredBlockList},
+	}, {
+		name:        "rule",
+		host:        "rule.example",
+		wantMechs:   []string{"filtering"},
+		wantReasons: []Reason{FilteredBlockList},
+	}, {
+		name:        "dnsrewrite",
+		host:        "dnsrw.example",
+		wantMechs:   []string{"filtering"},
+		wantReasons: []Reason{RewrittenRule},
+	}, {
+		name:        "rewrite_and_allowlist",
+		host:        "allowed.example",
+		wantMechs:   []string{"rewrites", "filtering"},
+		wantReasons: []Reason{Rewritten, NotFilteredAllowList},
+	}, {
+		name:        "server_host",
+		host:        "dns.lan",
+		wantMechs:   []string{"server host"},
+		wantReasons: []Reason{RewrittenRule},
+	}, {
+		name:        "none",
+		host:        "none.example",
+		wantMechs:   nil,
+		wantReasons: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			findings, err := d.DiagnoseHost(tc.host, dns.TypeA)
+			require.NoError(t, err)
+
+			var mechs []string
+			var reasons []Reason
+			for _, f := range findings {
+				mechs = append(mechs, f.Mechanism)
+				reasons = append(reasons, f.Reason)
+			}
+
+			assert.Equal(t, tc.wantMechs, mechs)
+			assert.Equal(t, tc.wantReasons, reasons)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		d.SetEnabled(false)
+		t.Cleanup(func() { d.SetEnabled(true) })
+
+		findings, err := d.DiagnoseHost("both.example", dns.TypeA)
+		require.NoError(t, err)
+
+		assert.Len(t, findings, 2)
+	})
+}
+
+func TestDNSFilter_DiagnoseHost_defaultDeny(t *testing.T) {
+	d := newForTest(t, &Config{
+		DefaultDeny: true,
+		RuleTextRedactor: func(_ string) (redacted string) {
+			return "redacted"
+		},
+	}, []Filter{{
+		ID:   1,
+		Data: []byte("||blocked.example^\n@@||allowed.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+	}{{
+		name:       "allowed",
+		host:       "allowed.example",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "blocked",
+		host:       "blocked.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "denied",
+		host:       "other.example",
+		wantReason: FilteredDefaultDeny,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			findings, err := d.DiagnoseHost(tc.host, dns.TypeA)
+			require.NoError(t, err)
+			require.Len(t, findings, 1)
+
+			f := findings[0]
+			assert.Equal(t, tc.wantReason, f.Reason)
+
+			require.Len(t, f.Rules, 1)
+			assert.Equal(t, "redacted", f.Rules[0].Text)
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package filtering
 
 import (
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 )
@@ -15,6 +20,105 @@ type DNSRewriteResult struct {
 // the server returns.
 type DNSRewriteResultResponse map[rules.RRType][]rules.RRValue
 
+// Records returns the answer resource records of type qtype for the question
+// name with the TTL of ttl.  rrs is empty if the response has no values of
+// that type, which is a valid NODATA answer for the success RCode.  The
+// values of unsupported types are skipped.
+func (res *DNSRewriteResult) Records(name string, qtype uint16, ttl uint32) (rrs []dns.RR, err error) {
+	hdr := dns.RR_Header{
+		Name:   name,
+		Rrtype: qtype,
+		Ttl:    ttl,
+		Class:  dns.ClassINET,
+	}
+
+	for i, v := range res.Response[qtype] {
+		var rr dns.RR
+		rr, err = newRewriteRR(hdr, v)
+		if err != nil {
+			return nil, fmt.Errorf("dns rewrite response for %d[%d]: %w", qtype, i, err)
+		} else if rr != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs, nil
+}
+
+// newRewriteRR returns the resource record with the value v for the type set
+// in hdr.  rr is nil if the type isn't supported.
+func newRewriteRR(hdr dns.RR_Header, v rules.RRValue) (rr dns.RR, err error) {
+	// TODO(a.garipov): As more types are added, we will probably want to
+	// use a handler-oriented approach here.
+	rrType := hdr.Rrtype
+	switch rrType {
+	case dns.TypeA, dns.TypeAAAA:
+		ip, ok := v.(net.IP)
+		if !ok {
+			return nil, fmt.Errorf("value for rr type %d has type %T, not net.IP", rrType, v)
+		}
+
+		if rrType == dns.TypeA {
+			return &dns.A{Hdr: hdr, A: ip.To4()}, nil
+		}
+
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case dns.TypeCNAME, dns.TypePTR, dns.TypeTXT:
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("value for rr type %d has type %T, not string", rrType, v)
+		}
+
+		switch rrType {
+		case dns.TypeCNAME:
+			return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(str)}, nil
+		case dns.TypePTR:
+			return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(str)}, nil
+		default:
+			return &dns.TXT{Hdr: hdr, Txt: []string{str}}, nil
+		}
+	case dns.TypeMX:
+		mx, ok := v.(*rules.DNSMX)
+		if !ok {
+			return nil, fmt.Errorf("value for rr type %d has type %T, not *rules.DNSMX", rrType, v)
+		}
+
+		return &dns.MX{
+			Hdr:        hdr,
+			Preference: mx.Preference,
+			Mx:         dns.Fqdn(mx.Exchange),
+		}, nil
+	case dns.TypeHTTPS, dns.TypeSVCB:
+		svcb, ok := v.(*rules.DNSSVCB)
+		if !ok {
+			return nil, fmt.Errorf("value for rr type %d has type %T, not *rules.DNSSVCB", rrType, v)
+		}
+
+		if rrType == dns.TypeHTTPS {
+			return newAnswerHTTPS(hdr, svcb), nil
+		}
+
+		return newAnswerSVCB(hdr, svcb), nil
+	case dns.TypeSRV:
+		srv, ok := v.(*rules.DNSSRV)
+		if !ok {
+			return nil, fmt.Errorf("value for rr type %d has type %T, not *rules.DNSSRV", rrType, v)
+		}
+
+		return &dns.SRV{
+			Hdr:      hdr,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			Port:     srv.Port,
+			Target:   dns.Fqdn(srv.Target),
+		}, nil
+	default:
+		log.Debug("don't know how to handle dns rr type %d, skipping", rrType)
+
+		return nil, nil
+	}
+}
+
 // processDNSRewrites processes DNS rewrite rules in dnsr.  It returns an empty
 // result if dnsr is empty.  Otherwise, the result will have either CanonName or
 // DNSRewriteResult set.  dnsr is expected to be non-empty.
@@ -28,10 +132,7 @@ func (d *DNSFilter) processDNSRewrites(dnsr []*rules.NetworkRule) (res Result) {
 		dr := nr.DNSRewrite
 		if dr.NewCNAME != "" {
 			// NewCNAME rules have a higher priority than other rules.
-			rules = []*ResultRule{{
-				FilterListID: int64(nr.GetFilterListID()),
-				Text:         nr.RuleText,
-			}}
+			rules = []*ResultRule{newResultRule(nr)}
 
 			return Result{
 				Reason:    RewrittenRule,
@@ -44,17 +145,11 @@ func (d *DNSFilter) processDNSRewrites(dnsr []*rules.NetworkRule) (res Result) {
 		case dns.RcodeSuccess:
 			dnsrr.RCode = dr.RCode
 			dnsrr.Response[dr.RRType] = append(dnsrr.Response[dr.RRType], dr.Value)
-			rules = append(rules, &ResultRule{
-				FilterListID: int64(nr.GetFilterListID()),
-				Text:         nr.RuleText,
-			})
+			rules = append(rules, newResultRule(nr))
 		default:
 			// RcodeRefused and other such codes have higher priority.  Return
 			// immediately.
-			rules = []*ResultRule{{
-				FilterListID: int64(nr.GetFilterListID()),
-				Text:         nr.RuleText,
-			}}
+			rules = []*ResultRule{newResultRule(nr)}
 			dnsrr = &DNSRewriteResult{
 				RCode: dr.RCode,
 			}
@@ -73,3 +168,44 @@ func (d *DNSFilter) processDNSRewrites(dnsr []*rules.NetworkRule) (res Result) {
 		DNSRewriteResult: dnsrr,
 	}
 }
+
+// ValidateDNSRewrite returns an error if rule isn't a valid $dnsrewrite
+// filtering rule.  Besides the syntax, it checks that the rewrite has a value
+// the filter is able to respond with.
+func ValidateDNSRewrite(rule string) (err error) {
+	nr, err := rules.NewNetworkRule(rule, CustomListID)
+	if err != nil {
+		return fmt.Errorf("parsing rule: %w", err)
+	}
+
+	dr := nr.DNSRewrite
+	if dr == nil {
+		return errors.Error("not a $dnsrewrite rule")
+	}
+
+	if dr.NewCNAME == "" && dr.RCode == dns.RcodeSuccess && dr.RRType == 0 {
+		// An empty $dnsrewrite only makes sense in exceptions, where it
+		// disables all rewrites for the host.
+		if nr.Whitelist {
+			return nil
+		}
+
+		return errors.Error("empty $dnsrewrite in a non-exception rule")
+	}
+
+	res := (&DNSFilter{}).processDNSRewrites([]*rules.NetworkRule{nr})
+	if res.DNSRewriteResult == nil {
+		// CNAME rewrites are validated by the rule parser.
+		return nil
+	}
+
+	for rrType, vals := range res.DNSRewriteResult.Response {
+		for _, v := range vals {
+			if v == nil {
+				return fmt.Errorf("unsupported rr type %s", dns.TypeToString[rrType])
+			}
+		}
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"path"
 	"testing"
 
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -202,3 +203,231 @@ func TestDNSFilter_CheckHostRules_dnsrewrite(t *testing.T) {
 		assert.Equal(t, "new-ptr-with-dot.", ptr)
 	})
 }
+
+func TestDNSFilter_CheckHost_selfRewrite(t *testing.T) {
+	const text = "|self.example^$dnsrewrite=self.example\n" +
+		"||self.example^\n" +
+		"|other.example^$dnsrewrite=other.example\n"
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+		noData     bool
+		wantNoData bool
+	}{{
+		name:       "continue_blocked",
+		host:       "self.example",
+		wantReason: FilteredBlockList,
+		noData:     false,
+		wantNoData: false,
+	}, {
+		name:       "continue_not_found",
+		host:       "other.example",
+		wantReason: NotFilteredNotFound,
+		noData:     false,
+		wantNoData: false,
+	}, {
+		name:       "nodata_blocked",
+		host:       "self.example",
+		wantReason: RewrittenRule,
+		noData:     true,
+		wantNoData: true,
+	}, {
+		name:       "nodata_not_found",
+		host:       "other.example",
+		wantReason: RewrittenRule,
+		noData:     true,
+		wantNoData: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				SelfRewriteNoData: tc.noData,
+			}, []Filter{{ID: 0, Data: []byte(text)}})
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if !tc.wantNoData {
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, dns.RcodeSuccess, res.DNSRewriteResult.RCode)
+			assert.Empty(t, res.DNSRewriteResult.Response)
+			assert.Empty(t, res.CanonName)
+			assert.False(t, res.IsFiltered)
+		})
+	}
+}
+
+func TestValidateDNSRewrite(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rule       string
+		wantErrMsg string
+	}{{
+		name:       "a",
+		rule:       "|a-record^$dnsrewrite=127.0.0.1",
+		wantErrMsg: "",
+	}, {
+		name:       "aaaa",
+		rule:       "|aaaa-record^$dnsrewrite=NOERROR;AAAA;::1",
+		wantErrMsg: "",
+	}, {
+		name:       "cname",
+		rule:       "|cname^$dnsrewrite=new-cname",
+		wantErrMsg: "",
+	}, {
+		name:       "txt",
+		rule:       "|txt-record^$dnsrewrite=NOERROR;TXT;hello-world",
+		wantErrMsg: "",
+	}, {
+		name:       "refused",
+		rule:       "|refused^$dnsrewrite=REFUSED",
+		wantErrMsg: "",
+	}, {
+		name:       "exception_all",
+		rule:       "@@||disable-all^$dnsrewrite",
+		wantErrMsg: "",
+	}, {
+		name:       "bad_ip",
+		rule:       "|a-record^$dnsrewrite=NOERROR;A;127.0.0.256",
+		wantErrMsg: `parsing rule: invalid ipv4: "127.0.0.256"`,
+	}, {
+		name:       "ipv4_as_aaaa",
+		rule:       "|aaaa-record^$dnsrewrite=NOERROR;AAAA;127.0.0.1",
+		wantErrMsg: `parsing rule: want ipv6, got ipv4: "127.0.0.1"`,
+	}, {
+		name:       "unknown_type",
+		rule:       "|host^$dnsrewrite=NOERROR;BAD;value",
+		wantErrMsg: `parsing rule: dns rr type "BAD" is invalid`,
+	}, {
+		name:       "soa",
+		rule:       "|host^$dnsrewrite=NOERROR;SOA;ns. hostmaster. 1 2 3",
+		wantErrMsg: "unsupported rr type SOA",
+	}, {
+		name:       "empty_block",
+		rule:       "||host^$dnsrewrite",
+		wantErrMsg: "empty $dnsrewrite in a non-exception rule",
+	}, {
+		name:       "not_dnsrewrite",
+		rule:       "||host^",
+		wantErrMsg: "not a $dnsrewrite rule",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateDNSRewrite(tc.rule)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			require.Error(t, err)
+
+			assert.Contains(t, err.Error(), tc.wantErrMsg)
+		})
+	}
+}
+
+func TestDNSRewriteResult_Records(t *testing.T) {
+	const (
+		name = "example.org."
+		ttl  = 10
+	)
+
+	hdr := func(rrType uint16) (h dns.RR_Header) {
+		return dns.RR_Header{
+			Name:   name,
+			Rrtype: rrType,
+			Ttl:    ttl,
+			Class:  dns.ClassINET,
+		}
+	}
+
+	res := &DNSRewriteResult{
+		RCode: dns.RcodeSuccess,
+		Response: DNSRewriteResultResponse{
+			dns.TypeA: []rules.RRValue{
+				net.IP{1, 2, 3, 4},
+				net.IPv4(5, 6, 7, 8),
+			},
+			dns.TypeAAAA:  []rules.RRValue{net.ParseIP("::1")},
+			dns.TypeCNAME: []rules.RRValue{"target.example"},
+			dns.TypeTXT:   []rules.RRValue{"hello", "world"},
+			dns.TypeMX: []rules.RRValue{&rules.DNSMX{
+				Exchange:   "mail.example",
+				Preference: 10,
+			}},
+			dns.TypeNS: []rules.RRValue{net.IP{1, 2, 3, 4}},
+		},
+	}
+
+	testCases := []struct {
+		name  string
+		want  []dns.RR
+		qtype uint16
+	}{{
+		name: "a",
+		want: []dns.RR{
+			&dns.A{Hdr: hdr(dns.TypeA), A: net.IP{1, 2, 3, 4}},
+			&dns.A{Hdr: hdr(dns.TypeA), A: net.IP{5, 6, 7, 8}},
+		},
+		qtype: dns.TypeA,
+	}, {
+		name:  "aaaa",
+		want:  []dns.RR{&dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: net.ParseIP("::1")}},
+		qtype: dns.TypeAAAA,
+	}, {
+		name:  "cname",
+		want:  []dns.RR{&dns.CNAME{Hdr: hdr(dns.TypeCNAME), Target: "target.example."}},
+		qtype: dns.TypeCNAME,
+	}, {
+		name: "txt",
+		want: []dns.RR{
+			&dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: []string{"hello"}},
+			&dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: []string{"world"}},
+		},
+		qtype: dns.TypeTXT,
+	}, {
+		name:  "mx",
+		want:  []dns.RR{&dns.MX{Hdr: hdr(dns.TypeMX), Preference: 10, Mx: "mail.example."}},
+		qtype: dns.TypeMX,
+	}, {
+		name:  "nodata",
+		want:  nil,
+		qtype: dns.TypeSRV,
+	}, {
+		name:  "unsupported",
+		want:  nil,
+		qtype: dns.TypeNS,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rrs, err := res.Records(name, tc.qtype, ttl)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.want, rrs)
+		})
+	}
+
+	t.Run("bad_value", func(t *testing.T) {
+		bad := &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{
+				dns.TypeA: []rules.RRValue{"not an ip"},
+			},
+		}
+
+		_, err := bad.Records(name, dns.TypeA, ttl)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,236 @@
+package filtering
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// estimateFPRate is the desired false-positive rate of a single bloom filter
+// lookup performed by EstimateBlocked.
+const estimateFPRate = 0.01
+
+// minBloomBits is the minimum size of a bloom filter in bits.  Double hashing
+// performs poorly with very small filters, so those are enlarged.
+const minBloomBits = 1024
+
+// bloomFilter is a simple bloom filter for strings.  It uses double hashing
+// with a single 64-bit FNV-1a hash to emulate k independent hash functions.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloomFilter returns a bloom filter sized to hold n elements with the
+// false-positive rate of p.
+func newBloomFilter(n int, p float64) (bf *bloomFilter) {
+	if n < 1 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if m < minBloomBits {
+		m = minBloomBits
+	}
+
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hashes returns the two base hashes for s.
+func (bf *bloomFilter) hashes(s string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	sum := h.Sum64()
+
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+// add adds s into bf.
+func (bf *bloomFilter) add(s string) {
+	h1, h2 := bf.hashes(s)
+	for i := uint64(0); i < bf.k; i++ {
+		idx := (h1 + i*h2) % bf.m
+		bf.bits[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+// has returns true if s may be in bf.  It never returns false for an added s.
+func (bf *bloomFilter) has(s string) (ok bool) {
+	h1, h2 := bf.hashes(s)
+	for i := uint64(0); i < bf.k; i++ {
+		idx := (h1 + i*h2) % bf.m
+		if bf.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// blockedEstimator approximately matches hostnames against the blocking rules
+// that match exact hostnames or domains with their subdomains.
+type blockedEstimator struct {
+	// exact contains hostnames from the /etc/hosts-syntax rules.
+	exact *bloomFilter
+	// domains contains domains from the basic ||domain^ rules.
+	domains *bloomFilter
+}
+
+// exactRuleDomain returns the domain blocked by the rule of the ||domain^
+// form.  ok is false if the rule has any other form.
+func exactRuleDomain(nr *rules.NetworkRule) (domain string, ok bool) {
+	if nr.Whitelist || nr.DNSRewrite != nil {
+		return "", false
+	}
+
+	text := nr.Text()
+	if !strings.HasPrefix(text, "||") || !strings.HasSuffix(text, "^") {
+		return "", false
+	}
+
+	domain = text[len("||") : len(text)-len("^")]
+	if domain == "" || strings.ContainsAny(domain, "*|^/$") {
+		return "", false
+	}
+
+	return strings.ToLower(domain), true
+}
+
+// newBlockedEstimator scans the rules in rs and returns an estimator built
+// from those.  rs must not be nil and must not be closed while it's scanned.
+// The lists which can't be read are skipped.
+func newBlockedEstimator(rs *filterlist.RuleStorage) (be *blockedEstimator) {
+	var hosts, domains []string
+
+	for _, l := range rs.Lists {
+		sc, err := listScanner(l)
+		if err != nil {
+			log.Debug("filtering: building estimator: %s", err)
+
+			continue
+		}
+
+		for sc.Scan() {
+			r, _ := sc.Rule()
+			switch r := r.(type) {
+			case *rules.HostRule:
+				for _, h := range r.Hostnames {
+					hosts = append(hosts, strings.ToLower(h))
+				}
+			case *rules.NetworkRule:
+				if domain, ok := exactRuleDomain(r); ok {
+					domains = append(domains, domain)
+				}
+			default:
+				// Go on.
+			}
+		}
+	}
+
+	be = &blockedEstimator{
+		exact:   newBloomFilter(len(hosts), estimateFPRate),
+		domains: newBloomFilter(len(domains), estimateFPRate),
+	}
+
+	for _, h := range hosts {
+		be.exact.add(h)
+	}
+
+	for _, d := range domains {
+		be.domains.add(d)
+	}
+
+	return be
+}
+
+// mayBeBlocked returns true if host is probably blocked by the rules be was
+// built from.
+func (be *blockedEstimator) mayBeBlocked(host string) (ok bool) {
+	if be.exact.has(host) {
+		return true
+	}
+
+	for {
+		if be.domains.has(host) {
+			return true
+		}
+
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return false
+		}
+
+		host = host[i+1:]
+	}
+}
+
+// EstimateBlocked returns the approximate number of hosts in sample which are
+// blocked by the currently loaded blocklists.  It doesn't perform the full
+// matching and only considers the /etc/hosts-syntax rules and the basic
+// ||domain^ rules without modifiers, so the result may be lower than the
+// actual one for lists using other syntaxes.  The allowlists are ignored.
+//
+// Each bloom filter lookup has the false-positive rate of about 1%.  Since a
+// host is checked once against the hosts rules and once per each of its
+// domain levels against the domain rules, a host with n labels may be counted
+// as blocked by mistake with the probability of at most (n+1)%.
+func (d *DNSFilter) EstimateBlocked(sample []string) (approx int) {
+	be := d.estimator()
+	if be == nil {
+		return 0
+	}
+
+	for _, host := range sample {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host != "" && be.mayBeBlocked(host) {
+			approx++
+		}
+	}
+
+	return approx
+}
+
+// estimator returns the estimator for the current blocklists.  It's built on
+// the first call after the blocklists are loaded, since it's only used by
+// EstimateBlocked.  be is nil if there are no blocklists loaded.
+func (d *DNSFilter) estimator() (be *blockedEstimator) {
+	// Hold the lock while building, so that the lists aren't closed.
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	rs := d.rulesStorage
+	if rs == nil {
+		return nil
+	}
+
+	d.estimatorLock.Lock()
+	defer d.estimatorLock.Unlock()
+
+	if d.estimatorStorage != rs {
+		d.blockedEstimator = newBlockedEstimator(rs)
+		d.estimatorStorage = rs
+	}
+
+	return d.blockedEstimator
+}
+
+// resetEstimator drops the estimator built from the previous blocklists, so
+// that those could be garbage collected.  d.engineLock is expected to be
+// locked.
+func (d *DNSFilter) resetEstimator() {
+	d.estimatorLock.Lock()
+	defer d.estimatorLock.Unlock()
+
+	d.blockedEstimator = nil
+	d.estimatorStorage = nil
+}
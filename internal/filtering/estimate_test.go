@@ -0,0 +1,120 @@
+package filtering
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const n = 1000
+
+	bf := newBloomFilter(n, estimateFPRate)
+	for i := 0; i < n; i++ {
+		bf.add(fmt.Sprintf("host%d.example", i))
+	}
+
+	for i := 0; i < n; i++ {
+		require.True(t, bf.has(fmt.Sprintf("host%d.example", i)))
+	}
+
+	fps := 0
+	for i := 0; i < n; i++ {
+		if bf.has(fmt.Sprintf("other%d.example", i)) {
+			fps++
+		}
+	}
+
+	// Allow the rate to be three times as high as the desired one.
+	assert.LessOrEqual(t, fps, int(3*n*estimateFPRate))
+}
+
+func TestDNSFilter_EstimateBlocked(t *testing.T) {
+	const text = `
+||block.example^
+||ads.example.org^
+0.0.0.0 hosts.example
+/regex-block/
+@@||allowed.block.example^
+||modified.example^$dnstype=AAAA
+`
+
+	d := newForTest(t, nil, []Filter{{ID: 0, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	sample := []string{
+		"block.example",
+		"sub.block.example",
+		"BLOCK.example.",
+		"ads.example.org",
+		"deep.sub.ads.example.org",
+		"hosts.example",
+		"sub.hosts.example",
+		"example.org",
+		"regex-block.example",
+		"allowed.block.example",
+		"modified.example",
+		"",
+	}
+	for i := 0; i < 100; i++ {
+		sample = append(sample, fmt.Sprintf("unblocked%d.example.net", i))
+	}
+
+	exact := 0
+	for _, host := range sample {
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		if res.IsFiltered {
+			exact++
+		}
+	}
+
+	approx := d.EstimateBlocked(sample)
+
+	// The regular expression rule isn't considered by the estimator, while
+	// the allowlisted host and the FQDN are counted.
+	assert.InDelta(t, exact, approx, 2)
+	assert.GreaterOrEqual(t, approx, 6)
+
+	t.Run("no_filters", func(t *testing.T) {
+		empty := newForTest(t, nil, nil)
+		t.Cleanup(empty.Close)
+
+		assert.Zero(t, empty.EstimateBlocked(sample))
+	})
+
+	t.Run("lazy", func(t *testing.T) {
+		be := d.estimator()
+		require.NotNil(t, be)
+
+		assert.Same(t, be, d.estimator())
+
+		err := d.SetFilters([]Filter{{
+			ID: 0, Data: []byte("||lazy.example^\n"),
+		}}, nil, false)
+		require.NoError(t, err)
+
+		d.estimatorLock.Lock()
+		assert.Nil(t, d.blockedEstimator)
+		d.estimatorLock.Unlock()
+
+		assert.Equal(t, 1, d.EstimateBlocked([]string{"lazy.example"}))
+		assert.NotSame(t, be, d.estimator())
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		err := d.SetFilters([]Filter{{
+			ID: 0, Data: []byte("||unblocked1.example.net^\n"),
+		}}, nil, false)
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, d.EstimateBlocked([]string{
+			"unblocked1.example.net",
+			"block.example",
+		}))
+	})
+}
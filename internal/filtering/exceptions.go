@@ -0,0 +1,118 @@
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// allowlistPrefix is the prefix of the Adblock-syntax exception rules.
+const allowlistPrefix = "@@"
+
+// exceptionRule returns the exception rule text for pattern.  The "@@" prefix
+// is added if pattern doesn't have it already.
+func exceptionRule(pattern string) (rule string, err error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return "", errors.Error("empty pattern")
+	}
+
+	rule = pattern
+	if !strings.HasPrefix(rule, allowlistPrefix) {
+		rule = allowlistPrefix + rule
+	}
+
+	_, err = rules.NewNetworkRule(rule, CustomListID)
+	if err != nil {
+		return "", fmt.Errorf("parsing rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+// exceptionsFilter returns the filter containing the exceptions added with
+// AddException.  ok is false if there are no exceptions.  d.exceptionsLock is
+// expected to be locked.
+func (d *DNSFilter) exceptionsFilter() (f Filter, ok bool) {
+	if len(d.exceptions) == 0 {
+		return Filter{}, false
+	}
+
+	return Filter{
+		ID:   ExceptionsListID,
+		Data: []byte(strings.Join(d.exceptions, "\n")),
+	}, true
+}
+
+// AddException adds an allowlist rule for pattern, which is a rule in the
+// Adblock syntax with or without the "@@" prefix.  Only the allowlist engine
+// is recompiled, so it's much cheaper than SetFilters.  The exceptions are
+// kept across the calls to SetFilters.
+func (d *DNSFilter) AddException(pattern string) (err error) {
+	rule, err := exceptionRule(pattern)
+	if err != nil {
+		return fmt.Errorf("adding exception %q: %w", pattern, err)
+	}
+
+	d.exceptionsLock.Lock()
+	defer d.exceptionsLock.Unlock()
+
+	d.exceptions = append(d.exceptions, rule)
+	f, _ := d.exceptionsFilter()
+	exceptionsList, err := newRuleList(f, true, false)
+	if err != nil {
+		// Shouldn't happen, since the string rule lists are always created
+		// successfully.
+		d.exceptions = d.exceptions[:len(d.exceptions)-1]
+
+		return fmt.Errorf("adding exception %q: %w", pattern, err)
+	}
+
+	d.engineLock.Lock()
+	defer d.engineLock.Unlock()
+
+	if hasServerIPTag(rule) {
+		setServerIPTagged(&d.serverIPTagged, true)
+	}
+
+	// Reuse the already opened allowlists except for the previous version of
+	// the exceptions list.  The previous storage itself mustn't be closed,
+	// since it shares the other lists with the new one, so only the replaced
+	// exceptions list is closed.
+	lists := []filterlist.RuleList{exceptionsList}
+	var prevList filterlist.RuleList
+	if d.rulesStorageAllow != nil {
+		for _, l := range d.rulesStorageAllow.Lists {
+			if l.GetID() == ExceptionsListID {
+				prevList = l
+			} else {
+				lists = append(lists, l)
+			}
+		}
+	}
+
+	rs, err := filterlist.NewRuleStorage(lists)
+	if err != nil {
+		d.exceptions = d.exceptions[:len(d.exceptions)-1]
+
+		return fmt.Errorf("adding exception %q: creating rule storage: %w", pattern, err)
+	}
+
+	d.rulesStorageAllow = rs
+	d.filteringEngineAllow = urlfilter.NewDNSEngine(rs)
+
+	if prevList != nil {
+		if err = prevList.Close(); err != nil {
+			log.Error("filtering: closing previous exceptions: %s", err)
+		}
+	}
+
+	log.Debug("filtering: added exception %q", rule)
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_AddException(t *testing.T) {
+	const (
+		blockRules = "||ads.example.com^\n"
+		allowRules = "@@||listed.ads.example.com^\n"
+	)
+
+	d := newForTest(t, nil, []Filter{{ID: 1, Data: []byte(blockRules)}})
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters(
+		[]Filter{{ID: 1, Data: []byte(blockRules)}},
+		[]Filter{{ID: 2, Data: []byte(allowRules)}},
+		false,
+	)
+	require.NoError(t, err)
+
+	err = d.AddException("||allowed.ads.example.com^")
+	require.NoError(t, err)
+
+	err = d.AddException("@@||other.ads.example.com^$important")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantReason Reason
+	}{{
+		name:       "exception",
+		host:       "allowed.ads.example.com",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "exception_subdomain",
+		host:       "sub.allowed.ads.example.com",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "exception_prefixed",
+		host:       "other.ads.example.com",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "allowlist",
+		host:       "listed.ads.example.com",
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "blocked_parent",
+		host:       "ads.example.com",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "blocked_sibling",
+		host:       "tracker.ads.example.com",
+		wantReason: FilteredBlockList,
+	}}
+
+	check := func(t *testing.T) {
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				res, cerr := d.CheckHost(tc.host, dns.TypeA, &setts)
+				require.NoError(t, cerr)
+
+				assert.Equal(t, tc.wantReason, res.Reason)
+			})
+		}
+	}
+
+	t.Run("added", check)
+
+	t.Run("set_filters", func(t *testing.T) {
+		err = d.SetFilters(
+			[]Filter{{ID: 1, Data: []byte(blockRules)}},
+			[]Filter{{ID: 2, Data: []byte(allowRules)}},
+			false,
+		)
+		require.NoError(t, err)
+
+		check(t)
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		err = d.AddException("")
+		assert.Error(t, err)
+
+		err = d.AddException("||bad.example^$unknownmodifier")
+		assert.Error(t, err)
+
+		check(t)
+	})
+}
+
+func TestDNSFilter_AddException_customAllowlist(t *testing.T) {
+	const blockRules = "||ads.example.com^\n"
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters(
+		[]Filter{{ID: 1, Data: []byte(blockRules)}},
+		[]Filter{{ID: CustomListID, Data: []byte("@@||custom.ads.example.com^\n")}},
+		false,
+	)
+	require.NoError(t, err)
+
+	err = d.AddException("||first.ads.example.com^")
+	require.NoError(t, err)
+
+	err = d.AddException("||second.ads.example.com^")
+	require.NoError(t, err)
+
+	for _, host := range []string{
+		"custom.ads.example.com",
+		"first.ads.example.com",
+		"second.ads.example.com",
+	} {
+		res, cerr := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, cerr)
+
+		assert.Equalf(t, NotFilteredAllowList, res.Reason, "host %q", host)
+	}
+
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	var ids []int
+	for _, l := range d.rulesStorageAllow.Lists {
+		ids = append(ids, l.GetID())
+	}
+
+	assert.ElementsMatch(t, []int{ExceptionsListID, CustomListID}, ids)
+}
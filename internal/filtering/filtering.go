@@ -2,9 +2,13 @@
 package filtering
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
+	"io"
 	"io/fs"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +17,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/dnsproxy/upstream"
@@ -36,6 +41,9 @@ const (
 	ParentalListID
 	SafeBrowsingListID
 	SafeSearchListID
+	TrackersListID
+	DefaultDenyListID
+	ExceptionsListID
 )
 
 // ServiceEntry - blocked service array element
@@ -50,15 +58,76 @@ type Settings struct {
 	ClientIP   net.IP
 	ClientTags []string
 
+	// ServerIP is the address of the server's interface which has received
+	// the request.  If set, the rules may be scoped by it using the $ctag
+	// modifier, see serverIPTag.  The callers may leave it unset unless
+	// DNSFilter.UsesServerIP returns true.
+	ServerIP net.IP
+
+	// Opcode is the opcode of the request.  The requests with any opcode
+	// other than dns.OpcodeQuery, such as the dynamic updates and the
+	// notifications, aren't filtered and get the FilteredInvalid result.
+	// The zero value is dns.OpcodeQuery.
+	Opcode int
+
+	// ECS is the EDNS Client Subnet of the request, if it has one.  It
+	// selects the scoped answers of the rewrites, see
+	// RewriteEntry.ECSAnswers.
+	ECS *net.IPNet
+
+	// Resolver, if not nil, is used instead of Config.CustomResolver to
+	// look up the addresses of the safe search hosts for this request.  The
+	// results resolved with it aren't cached, since they may differ between
+	// the resolvers.
+	Resolver Resolver
+
 	ServicesRules []ServiceEntry
 
-	ProtectionEnabled   bool
-	FilteringEnabled    bool
+	// Schedule, if not nil, limits the time when the filtering rules and the
+	// blocked services apply.  Outside of it, those match nothing.
+	Schedule *ClientSchedule
+
+	// ProtectionEnabled defines if the requests may be blocked at all, see
+	// EffectiveProtection.
+	ProtectionEnabled bool
+
+	// FilteringEnabled defines if the filtering rules, the rewrites, and
+	// the system hosts are used.  Only the rewriting results of those apply
+	// when the protection is disabled.
+	FilteringEnabled bool
+
 	SafeSearchEnabled   bool
 	SafeBrowsingEnabled bool
 	ParentalEnabled     bool
 }
 
+// EffectiveProtection returns true if the requests may be blocked with s.  It
+// doesn't take FilteringEnabled into account, since the blocked services, safe
+// browsing, parental control, and safe search don't depend on it, see
+// EffectiveFiltering for the filtering rules.  The flags interact as follows:
+//
+//   - the rewrites, the system hosts, and the $dnsrewrite rules only depend
+//     on FilteringEnabled;
+//
+//   - the blocking and allowing results of the filtering rules require both
+//     FilteringEnabled and the effective protection, see EffectiveFiltering;
+//
+//   - the blocked services, safe browsing, parental control, and safe search
+//     only require the effective protection and their own flags.
+//
+// s may be nil, in which case the protection is disabled.
+func (s *Settings) EffectiveProtection() (ok bool) {
+	return s != nil && s.ProtectionEnabled
+}
+
+// EffectiveFiltering returns true if the requests may be blocked or allowed by
+// the filtering rules with s, that is if both FilteringEnabled and the
+// effective protection are set.  s may be nil, in which case the filtering is
+// disabled.
+func (s *Settings) EffectiveFiltering() (ok bool) {
+	return s.EffectiveProtection() && s.FilteringEnabled
+}
+
 // Resolver is the interface for net.Resolver to simplify testing.
 type Resolver interface {
 	LookupIP(ctx context.Context, network, host string) (ips []net.IP, err error)
@@ -66,11 +135,6 @@ type Resolver interface {
 
 // Config allows you to configure DNS filtering with New() or just change variables directly.
 type Config struct {
-	// enabled is used to be returned within Settings.
-	//
-	// It is of type uint32 to be accessed by atomic.
-	enabled uint32
-
 	ParentalEnabled     bool `yaml:"parental_enabled"`
 	SafeSearchEnabled   bool `yaml:"safesearch_enabled"`
 	SafeBrowsingEnabled bool `yaml:"safebrowsing_enabled"`
@@ -80,14 +144,271 @@ type Config struct {
 	ParentalCacheSize     uint `yaml:"parental_cache_size"`     // (in bytes)
 	CacheTime             uint `yaml:"cache_time"`              // Element's TTL (in minutes)
 
+	// CacheTimeJitter is the maximum random deviation of the TTL of the
+	// safe browsing and parental control cache entries, in percent of
+	// CacheTime.  It spreads the expiration of the entries inserted at the
+	// same time.  Values over 100 are treated as 100.
+	CacheTimeJitter uint `yaml:"cache_time_jitter"`
+
+	// SafeBrowsingCache and ParentalCache are the storages for the responses
+	// of the corresponding services.  If nil, the in-memory LRU caches of
+	// SafeBrowsingCacheSize and ParentalCacheSize bytes are used.
+	SafeBrowsingCache VerdictCache `yaml:"-"`
+	ParentalCache     VerdictCache `yaml:"-"`
+
+	// CachePersistDir, if not empty, is the directory to which the in-memory
+	// safe browsing, parental control, and safe search caches are saved on
+	// Close and from which those are loaded on New, so that the entries
+	// survive the restarts.  The expired entries are dropped on loading.
+	// The custom SafeBrowsingCache and ParentalCache aren't saved.
+	CachePersistDir string `yaml:"cache_persist_dir"`
+
+	// OnCacheEvict, if not nil, is called with one of the CacheName
+	// constants each time the least recently used entry is evicted from the
+	// corresponding in-memory cache to free space.  It isn't called for the
+	// custom caches set in SafeBrowsingCache and ParentalCache.
+	OnCacheEvict func(cacheName string) `yaml:"-"`
+
+	// ServerNames are the hostnames of the server itself.  The A and AAAA
+	// requests for those are answered with ServerIPs, and the PTR requests
+	// for ServerIPs are answered with the first of those, without using the
+	// upstreams.
+	ServerNames []string `yaml:"server_names"`
+
+	// ServerIPs are the addresses of the server itself, see ServerNames.
+	ServerIPs []net.IP `yaml:"server_ips"`
+
+	// SelfNames and SelfIPs are the additional names and addresses of the
+	// server itself, usually taken from its DNS configuration.  Unlike
+	// ServerNames and ServerIPs, those aren't answered with and are only
+	// used to detect the rewrite upstreams pointing at the server, see
+	// RewriteEntry.Upstream.  "localhost" and the loopback addresses always
+	// point at the server.
+	SelfNames []string `yaml:"-"`
+	SelfIPs   []net.IP `yaml:"-"`
+
+	// PublicSuffixList is the path to the file with the public suffix list,
+	// see https://publicsuffix.org/list/.  It's used for the matching
+	// depending on the registrable domains, for example by the safe browsing
+	// and the parental control.  If empty, the built-in list is used.
+	PublicSuffixList string `yaml:"public_suffix_list"`
+
+	// DecisionSink, if not nil, asynchronously receives the decisions made
+	// by CheckHost.
+	DecisionSink DecisionSink `yaml:"-"`
+
+	// DecisionBufferSize is the number of the decisions waiting to be written
+	// to DecisionSink, after which the new ones are dropped, see
+	// DNSFilter.DroppedDecisions.  If zero, defaultDecisionBufferSize is
+	// used.
+	DecisionBufferSize uint `yaml:"decision_buffer_size"`
+
+	// CompiledCacheSize is the number of the compiled blocklist sets kept to
+	// be reused when the filters are set again with the same content.  If
+	// zero, defaultCompiledCacheSize is used.
+	CompiledCacheSize uint `yaml:"compiled_cache_size"`
+
+	// BlockTXT, if not empty, is the text of the TXT answer to the TXT
+	// requests for the blocked hosts.  It must not be longer than
+	// maxBlockTXTLen bytes.
+	BlockTXT string `yaml:"block_txt"`
+
+	// SecurityCheckTypes are the types of the requests checked by the safe
+	// browsing and the parental control.  If empty,
+	// defaultSecurityCheckTypes are used.
+	SecurityCheckTypes []uint16 `yaml:"security_check_types"`
+
+	// PreloadConcurrency is the maximum number of the concurrent lookups
+	// performed by PreloadSecurityCache.  If zero,
+	// defaultPreloadConcurrency is used.
+	PreloadConcurrency uint `yaml:"preload_concurrency"`
+
+	// The timeouts of a single request to the safe browsing and parental
+	// control upstreams and the numbers of additional attempts after the
+	// failed ones.  The zero timeouts mean the default of 3 seconds.
+	SafeBrowsingTimeout uint `yaml:"safebrowsing_timeout"` // (in milliseconds)
+	SafeBrowsingRetries uint `yaml:"safebrowsing_retries"`
+	ParentalTimeout     uint `yaml:"parental_timeout"` // (in milliseconds)
+	ParentalRetries     uint `yaml:"parental_retries"`
+
+	// SecurityFailMode defines what happens when the safe browsing or the
+	// parental control lookup fails.  It's either SecurityFailOpen,
+	// SecurityFailClosed, or empty, in which case the error is returned from
+	// CheckHost.
+	SecurityFailMode string `yaml:"security_fail_mode"`
+
 	Rewrites []RewriteEntry `yaml:"rewrites"`
 
+	// GeoIP, if not nil, is used to determine the countries of the clients
+	// for the rewrites with RewriteEntry.CountryAnswers.  If nil, those
+	// answers aren't used.
+	GeoIP GeoIP `yaml:"-"`
+
+	// RewritePrecedence defines which of the rewrites, including the
+	// $dnsrewrite rules, and the blocking rules apply when both match a
+	// host.  It's either RewritePrecedenceRewrite, RewritePrecedenceBlock,
+	// or empty, which is the same as RewritePrecedenceRewrite.
+	RewritePrecedence string `yaml:"rewrite_precedence"`
+
+	// MaxRewriteLookups is the maximum number of the rewrites lookups
+	// performed for a single request while following the CNAME rewrites.
+	// Once it's reached, the last found canonical name is returned as is.  If
+	// zero, defaultMaxRewriteLookups is used.
+	MaxRewriteLookups uint `yaml:"max_rewrite_lookups"`
+
+	// MaxRewriteIPs is the maximum number of the addresses returned by the
+	// rewrites for a single request, so that the responses don't grow too
+	// large.  If zero, the number isn't limited.
+	MaxRewriteIPs uint `yaml:"max_rewrite_ips"`
+
+	// RotateRewriteIPs makes the rewrites return a different subset of the
+	// addresses each time once MaxRewriteIPs is reached, in a round-robin
+	// manner.  Otherwise, the first MaxRewriteIPs addresses are returned.
+	RotateRewriteIPs bool `yaml:"rotate_rewrite_ips"`
+
+	// NAT64Prefix, if not nil, is the IPv6 prefix used to synthesize the
+	// answers to the AAAA requests for the hosts having only the A rewrites,
+	// see RFC 6052.  Its length must be 32, 40, 48, 56, 64, or 96 bits.
+	NAT64Prefix *net.IPNet `yaml:"-"`
+
+	// NAT64PrefixCIDR is NAT64Prefix in the CIDR notation, like
+	// "64:ff9b::/96", as written in the configuration file.  It's only used
+	// if NAT64Prefix is nil.
+	NAT64PrefixCIDR string `yaml:"nat64_prefix"`
+
+	// ServiceNamesAsParents makes the service names, like
+	// "_dmarc.example.com" or "_sip._tcp.example.com", which aren't matched
+	// by the filtering rules themselves, be matched as their parent domains,
+	// like "example.com".  It's useful with the hosts-style lists, which only
+	// match exact hostnames.
+	ServiceNamesAsParents bool `yaml:"service_names_as_parents"`
+
+	// ReadRewrites, if not nil, returns the rewrites from the configuration
+	// file.  It's used by the HTTP API to reload the rewrites, see
+	// ReloadRewrites.
+	ReadRewrites func() (entries []RewriteEntry, err error) `yaml:"-"`
+
+	// FindClient, if not nil, returns the settings of the persistent client
+	// with name or, if there is no such client, with clientIP.  clientIP may
+	// be nil.  cs is nil if there is no such client.  It's used by
+	// ResolveSettings.
+	FindClient func(clientIP net.IP, name string) (cs *ClientSettings) `yaml:"-"`
+
+	// RuleTextRedactor, if not nil, replaces the texts of the matched rules
+	// in the results, for example to hide the sensitive rules from the query
+	// log.  The filter list IDs of the rules are kept.
+	RuleTextRedactor func(text string) (redacted string) `yaml:"-"`
+
+	// SelfRewriteNoData makes the $dnsrewrite rules rewriting a host to
+	// itself, like "||example.com^$dnsrewrite=example.com", answer with an
+	// empty NOERROR response.  Otherwise, such rules are ignored and the host
+	// is matched against the other rules.
+	SelfRewriteNoData bool `yaml:"self_rewrite_nodata"`
+
+	// HostRuleMismatchNoData makes the requests of the type other than the
+	// one of the matched /etc/hosts-syntax rules, like the AAAA request for
+	// "192.168.0.1 nas.lan", answered with an empty NOERROR response instead
+	// of being blocked.  The rules with the unspecified addresses, like
+	// "0.0.0.0 ads.example", still block the requests of all types.
+	HostRuleMismatchNoData bool `yaml:"host_rule_mismatch_nodata"`
+
+	// RewriteHTTPSNoData makes the HTTPS and SVCB requests for the hosts
+	// rewritten with the A or AAAA rewrites, including the ones at the end
+	// of CNAME rewrites, answered with an empty NOERROR response.
+	// Otherwise, the clients get the service bindings of the original host
+	// from the upstream, with its address hints and Encrypted Client Hello
+	// configurations, and may bypass the rewrite.
+	RewriteHTTPSNoData bool `yaml:"rewrite_https_nodata"`
+
+	// DefaultDeny makes the hosts matched neither by the allowlist nor by the
+	// blocklist rules blocked with the FilteredDefaultDeny reason.  The
+	// rewrites, the local zones, and the /etc/hosts entries still apply.  It
+	// only applies to the requested hosts, so neither the names from the
+	// rewrite chains nor the ones from the upstream responses are blocked by
+	// it.
+	DefaultDeny bool `yaml:"default_deny"`
+
+	// BlockUntilReady makes the requests answered with SERVFAIL until the
+	// filtering rules are loaded for the first time, instead of letting
+	// those through unfiltered.
+	BlockUntilReady bool `yaml:"block_until_ready"`
+
+	// StrictWildcards makes the wildcard rewrites, like "*.example.com",
+	// only match the hosts with a single additional label.
+	StrictWildcards bool `yaml:"strict_wildcards"`
+
+	// LocalDomains are the domains of the local zones which aren't signed.
+	// DNSKEY and DS queries for those and their subdomains are refused
+	// instead of being sent upstream.
+	LocalDomains []string `yaml:"local_domains"`
+
+	// BlockedServiceResponse defines the response to the requests blocked
+	// by the blocked services.  It's either one of the BlockedSvcResp
+	// constants or an IP address to respond with.  An empty string means
+	// using the server's blocking mode.
+	BlockedServiceResponse string `yaml:"blocked_service_response"`
+
+	// RemovedListsGrace is the time, in seconds, during which the filter
+	// lists removed with SetFilters are still used for filtering.  Zero
+	// means the lists are removed immediately.  The file-based lists are only
+	// kept while their files exist.
+	RemovedListsGrace uint `yaml:"removed_lists_grace"`
+
+	// ReloadDebounce is the time, in milliseconds, to wait for more
+	// asynchronous SetFilters calls before applying the filters.  Each call
+	// within the interval restarts it and only the filters of the last one
+	// are applied.  Zero means applying the filters immediately.
+	ReloadDebounce uint `yaml:"reload_debounce"`
+
+	// LogCoalesceWindow is the time window, in seconds, within which the
+	// identical block decisions for the same host are logged as a single
+	// message with their count.  Zero disables coalescing.  It's only
+	// applied in New.
+	LogCoalesceWindow uint `yaml:"log_coalesce_window"`
+
+	// ClientStatsSize is the maximum number of the most recently active
+	// clients whose checked and blocked requests are counted, see
+	// ClientBlockRate.  Zero disables counting.  It's only applied in New.
+	ClientStatsSize uint `yaml:"client_stats_size"`
+
+	// ClientStatsWindow is the time window, in seconds, of the per-client
+	// counters.  If zero, defaultClientStatsWindow is used.  It's only
+	// applied in New.
+	ClientStatsWindow uint `yaml:"client_stats_window"`
+
+	// KeepCosmeticRules makes the filter lists retain the cosmetic rules,
+	// which are otherwise ignored, so that those could be retrieved with
+	// CosmeticRules.  Those are never used for filtering DNS requests.
+	KeepCosmeticRules bool `yaml:"keep_cosmetic_rules"`
+
+	// NormalizeRuleDomains makes the filter lists normalize the domains of
+	// the rules, so that "|| example.com. ^" matches example.com.  The lists
+	// from files are then loaded into memory.
+	NormalizeRuleDomains bool `yaml:"normalize_rule_domains"`
+
+	// BlockCNAME is the host of the block page.  If set, the A and AAAA
+	// requests blocked by the filtering rules are answered with a CNAME
+	// record pointing to it, so that the browsers show the page explaining
+	// the block instead of failing to connect.
+	BlockCNAME string `yaml:"block_cname"`
+
+	// ResponseTTLByReason are the TTLs, in seconds, of the responses to the
+	// requests filtered for each reason, see ResponseTTL.  The keys are the
+	// names of the reasons, like "FilteredSafeBrowsing".
+	ResponseTTLByReason map[Reason]uint32 `yaml:"response_ttl_by_reason"`
+
+	// BlockTrackers enables the built-in list of the known tracking domains
+	// and disposable email providers.
+	BlockTrackers bool `yaml:"block_trackers"`
+
 	// Names of services to block (globally).
 	// Per-client settings can override this configuration.
 	BlockedServices []string `yaml:"blocked_services"`
 
 	// EtcHosts is a container of IP-hostname pairs taken from the operating
-	// system configuration files (e.g. /etc/hosts).
+	// system configuration files (e.g. /etc/hosts).  The container reloads
+	// the files itself and swaps its rules under its own lock only once
+	// those are fully parsed, so the lookups never see a partial state.
 	EtcHosts *aghnet.HostsContainer `yaml:"-"`
 
 	// Called when the configuration is changed by HTTP request
@@ -100,7 +421,8 @@ type Config struct {
 	CustomResolver Resolver `yaml:"-"`
 }
 
-// LookupStats store stats collected during safebrowsing or parental checks
+// LookupStats store stats collected during safebrowsing or parental checks.
+// The fields are accessed atomically, see DNSFilter.CollectStats.
 type LookupStats struct {
 	Requests   uint64 // number of HTTP requests that were sent
 	CacheHits  uint64 // number of lookups that didn't need HTTP requests
@@ -119,30 +441,193 @@ type Stats struct {
 type filtersInitializerParams struct {
 	allowFilters []Filter
 	blockFilters []Filter
+
+	// refresh, if true, makes the engines be rebuilt from the current
+	// filters instead of the ones above.
+	refresh bool
 }
 
+// hostChecker is a stage of checking a request, see DNSFilter.hostCheckers.
 type hostChecker struct {
+	// check returns the result of the stage.  The stage decides on the
+	// request if the reason of res is a matched one, see Reason.Matched.
 	check func(host string, qtype uint16, setts *Settings) (res Result, err error)
-	name  string
+
+	// checkCtx, if not nil, is used instead of check.  It's like check, but
+	// stops once ctx is done, in which case err is ctx.Err().  It's set for
+	// the stages which may take long, like the remote ones.
+	checkCtx func(
+		ctx context.Context,
+		host string,
+		qtype uint16,
+		setts *Settings,
+	) (res Result, err error)
+
+	// name is the name of the stage, like "rewrites" or "filtering".
+	name string
+
+	// remote is true if the stage uses the network, like the safe browsing.
+	remote bool
+
+	// fallback is true if the stage is only run when none of the previous
+	// ones matched, like the default deny.
+	fallback bool
 }
 
 // DNSFilter matches hostnames and DNS requests against filtering rules.
 type DNSFilter struct {
+	// pausedUntil is the Unix time, in nanoseconds, until which the filtering
+	// is paused, see PauseFor.  It's zero if the filtering isn't paused.  It
+	// is accessed atomically, so it must be the first field to be properly
+	// aligned on 32-bit platforms.
+	pausedUntil int64
+
+	// stats are the counters of the lookups of the security services, see
+	// CollectStats.  Those are accessed atomically, so the field must follow
+	// pausedUntil to be properly aligned on 32-bit platforms.
+	stats Stats
+
+	// enabled is used to be returned within Settings.  It's kept outside of
+	// Config, since the configuration is copied as a whole, see
+	// WriteDiskConfig and Snapshot.
+	//
+	// It is of type uint32 to be accessed by atomic.
+	enabled uint32
+
 	rulesStorage         *filterlist.RuleStorage
 	filteringEngine      *urlfilter.DNSEngine
 	rulesStorageAllow    *filterlist.RuleStorage
 	filteringEngineAllow *urlfilter.DNSEngine
 	engineLock           sync.RWMutex
 
+	// estimatorLock protects blockedEstimator and estimatorStorage.
+	estimatorLock sync.Mutex
+
+	// blockedEstimator is built from estimatorStorage on the first call of
+	// EstimateBlocked after the blocklists are loaded, see estimator.
+	blockedEstimator *blockedEstimator
+
+	// estimatorStorage is the blocklist storage blockedEstimator is built
+	// from.
+	estimatorStorage *filterlist.RuleStorage
+
+	// blockFilters and allowFilters are the filter lists the engines were
+	// last built from, not including the exceptions.  Those are protected by
+	// engineLock.
+	blockFilters []Filter
+	allowFilters []Filter
+
+	// cosmeticRules are the cosmetic rules from the filter lists.  It's only
+	// filled if Config.KeepCosmeticRules is true and is protected by
+	// engineLock.
+	cosmeticRules []*ResultRule
+
+	// clientPatterns are the client name patterns used in the $client
+	// modifiers of the loaded rules.  Those are protected by engineLock.
+	clientPatterns []*clientPattern
+
+	// sqlLists are the database-backed blocklists, which are queried after
+	// the blocklist engine.  Those are protected by engineLock.
+	sqlLists []*sqlRuleList
+
+	// nonEnforcing are the IDs of the non-enforcing blocklists, see
+	// Filter.NonEnforcing.  It's protected by engineLock.
+	nonEnforcing map[int64]struct{}
+
+	// allowComments are the comments preceding the allow rules in their
+	// lists, see ResultRule.Comment.  It's protected by engineLock.
+	allowComments map[allowCommentKey]string
+
+	// lastReload is the information about the last initialization of the
+	// engines, see EngineStatus.  It's protected by engineLock.
+	lastReload reloadInfo
+
+	// reloadListeners are the functions registered with OnReload in the
+	// order of registration.  Those are protected by reloadListenersLock.
+	reloadListeners     []reloadListener
+	reloadListenersLock sync.Mutex
+	// lastListenerID is the ID of the most recently registered listener.
+	// It's protected by reloadListenersLock.
+	lastListenerID uint64
+
+	// graceLists are the recently removed filter lists which are still used
+	// until their grace periods end, see Config.RemovedListsGrace.  Those
+	// are protected by exceptionsLock.
+	graceLists map[graceKey]graceList
+	// graceTimer rebuilds the engines once the earliest grace period ends.
+	// It's protected by exceptionsLock.
+	graceTimer *time.Timer
+
+	// compiled keeps the compiled blocklists to reuse those when the lists
+	// are reloaded with the same content.
+	compiled *compiledCache
+
+	// zones are the authoritative zones loaded with LoadZone by their
+	// origins.  Those are protected by zonesLock.
+	zones     map[string]*zone
+	zonesLock sync.RWMutex
+
+	// rewriteRotation is the number of the rewrites answers rotated so far,
+	// see Config.RotateRewriteIPs.  It's accessed atomically.
+	rewriteRotation uint32
+
+	// randInt63n returns a random number in [0, n).  It's rand.Int63n unless
+	// replaced in tests.
+	randInt63n func(n int64) (r int64)
+
+	// now returns the current time.  It's time.Now unless replaced in tests.
+	now func() time.Time
+
+	// after waits for the duration to elapse, see time.After.  It's
+	// time.After unless replaced in tests.
+	after func(d time.Duration) (c <-chan time.Time)
+
+	// psl is the public suffix list from Config.PublicSuffixList.  It's nil
+	// if the built-in one is used.
+	psl *suffixList
+
+	// blockLog coalesces the log messages about the repeated block
+	// decisions.  It's nil if those aren't coalesced.
+	blockLog *blockLogger
+
+	// decisions streams the decisions to Config.DecisionSink.  It's nil if
+	// there is no sink.
+	decisions *decisionStream
+
+	// clientStats counts the requests of the clients.  It's nil if those
+	// aren't counted.
+	clientStats *clientStats
+
+	// routing is the compiled routing filters.  It's protected by
+	// routingLock.
+	routing     *routing
+	routingLock sync.RWMutex
+
+	// serverIPTagged and routingServerIPTagged are non-zero if the filtering
+	// and the routing rules respectively are scoped by the server's address,
+	// see UsesServerIP.  Those are accessed atomically.
+	serverIPTagged        uint32
+	routingServerIPTagged uint32
+
+	// exceptions are the allowlist rules added with AddException.
+	exceptions []string
+	// exceptionsLock protects exceptions and serializes the rebuilding of
+	// the allowlist engine.
+	exceptionsLock sync.Mutex
+
 	parentalServer       string // access via methods
 	safeBrowsingServer   string // access via methods
 	parentalUpstream     upstream.Upstream
 	safeBrowsingUpstream upstream.Upstream
 
-	safebrowsingCache cache.Cache
-	parentalCache     cache.Cache
+	safebrowsingCache VerdictCache
+	parentalCache     VerdictCache
 	safeSearchCache   cache.Cache
 
+	// persistentCaches are the caches saved on Close, see
+	// Config.CachePersistDir.
+	persistentCaches []*persistentCache
+
 	Config // for direct access by library users, even a = assignment
 	// confLock protects Config.
 	confLock sync.RWMutex
@@ -156,6 +641,9 @@ type DNSFilter struct {
 	// TODO(e.burkov): Use upstream that configured in dnsforward instead.
 	resolver Resolver
 
+	// hostCheckers are the stages CheckHost runs the requests through, in
+	// order.  The other ways of checking the requests, like CheckHostAll,
+	// use them as well, so that those never diverge.
 	hostCheckers []hostChecker
 }
 
@@ -164,6 +652,19 @@ type Filter struct {
 	ID       int64  // auto-assigned when filter is added (see nextFilterID)
 	Data     []byte `yaml:"-"` // List of rules divided by '\n'
 	FilePath string `yaml:"-"` // Path to a filtering rules file
+
+	// DB is the database of the blocked domains, see sqlRuleList.  It's
+	// only used if both Data and FilePath are empty, and only for the
+	// blocklists.
+	DB *sql.DB `yaml:"-"`
+
+	// NonEnforcing makes the blocks by the blocklist only reported in the
+	// results, which keep the reason and the rules but aren't filtered.
+	// It's useful for trying out a list before enforcing it.  A request
+	// is still blocked if any of the matched rules is from an enforcing
+	// list.  It's not used for the allowlists and the database-backed
+	// lists.
+	NonEnforcing bool `yaml:"non_enforcing,omitempty"`
 }
 
 // Reason holds an enum detailing why it was filtered or not filtered
@@ -210,6 +711,15 @@ const (
 	//
 	// See https://github.com/AdguardTeam/AdGuardHome/issues/2499.
 	RewrittenRule
+
+	// FilteredDefaultDeny is returned when the host matched no rules while
+	// Config.DefaultDeny is enabled.
+	FilteredDefaultDeny
+
+	// FilteredSecurityFailure is returned when the lookup of the host by the
+	// safe browsing or the parental control has failed while
+	// Config.SecurityFailMode is SecurityFailClosed.
+	FilteredSecurityFailure
 )
 
 // TODO(a.garipov): Resync with actual code names or replace completely
@@ -229,6 +739,9 @@ var reasonNames = []string{
 	Rewritten:          "Rewrite",
 	RewrittenAutoHosts: "RewriteEtcHosts",
 	RewrittenRule:      "RewriteRule",
+
+	FilteredDefaultDeny:     "FilteredDefaultDeny",
+	FilteredSecurityFailure: "FilteredSecurityFailure",
 }
 
 func (r Reason) String() string {
@@ -250,7 +763,8 @@ func (r Reason) In(reasons ...Reason) (ok bool) {
 	return false
 }
 
-// SetEnabled sets the status of the *DNSFilter.
+// SetEnabled sets the status of the *DNSFilter.  The pause, if any, is kept,
+// see PauseFor.
 func (d *DNSFilter) SetEnabled(enabled bool) {
 	var i int32
 	if enabled {
@@ -265,7 +779,7 @@ func (d *DNSFilter) GetConfig() (s Settings) {
 	defer d.confLock.RUnlock()
 
 	return Settings{
-		FilteringEnabled:    atomic.LoadUint32(&d.Config.enabled) != 0,
+		FilteringEnabled:    d.Enabled(),
 		SafeSearchEnabled:   d.Config.SafeSearchEnabled,
 		SafeBrowsingEnabled: d.Config.SafeBrowsingEnabled,
 		ParentalEnabled:     d.Config.ParentalEnabled,
@@ -324,7 +838,13 @@ func (d *DNSFilter) SetFilters(blockFilters, allowFilters []Filter, async bool)
 // Starts initializing new filters by signal from channel
 func (d *DNSFilter) filtersInitializer() {
 	for {
-		params := <-d.filtersInitializerChan
+		params := d.debounceFilters(<-d.filtersInitializerChan)
+		if params.refresh {
+			d.engineLock.RLock()
+			params.allowFilters, params.blockFilters = d.allowFilters, d.blockFilters
+			d.engineLock.RUnlock()
+		}
+
 		err := d.initFiltering(params.allowFilters, params.blockFilters)
 		if err != nil {
 			log.Error("Can't initialize filtering subsystem: %s", err)
@@ -335,27 +855,29 @@ func (d *DNSFilter) filtersInitializer() {
 
 // Close - close the object
 func (d *DNSFilter) Close() {
+	d.stopGraceTimer()
+	d.closeRouting()
+	d.blockLog.flush()
+	d.decisions.close()
+	d.saveCaches()
+
 	d.engineLock.Lock()
 	defer d.engineLock.Unlock()
 	d.reset()
 }
 
+// reset closes all the rule storages including the cached ones.
+// d.engineLock is expected to be locked.
 func (d *DNSFilter) reset() {
-	var err error
-
-	if d.rulesStorage != nil {
-		err = d.rulesStorage.Close()
-		if err != nil {
-			log.Error("filtering: rulesStorage.Close: %s", err)
+	storages := []*filterlist.RuleStorage{d.rulesStorage, d.rulesStorageAllow}
+	if d.compiled != nil {
+		for _, cl := range d.compiled.clear() {
+			storages = append(storages, cl.storage)
 		}
 	}
 
-	if d.rulesStorageAllow != nil {
-		err = d.rulesStorageAllow.Close()
-		if err != nil {
-			log.Error("filtering: rulesStorageAllow.Close: %s", err)
-		}
-	}
+	d.rulesStorage, d.rulesStorageAllow = nil, nil
+	d.closeStorages(storages...)
 }
 
 // ResultRule contains information about applied rules.
@@ -367,6 +889,82 @@ type ResultRule struct {
 	IP net.IP `json:",omitempty"`
 	// FilterListID is the ID of the rule's filter list.
 	FilterListID int64 `json:",omitempty"`
+	// Modifiers are the modifiers of the rule, such as "important" or
+	// "dnstype=AAAA", in the order of their appearance in Text.  It is nil
+	// unless the rule uses the Adblock syntax and has modifiers.
+	Modifiers []string `json:",omitempty"`
+	// Comment is the comment preceding the allow rule in its filter list,
+	// usually explaining the exception, like "Breaks the login page" for
+	// "! Breaks the login page".  It's only set for the allowlist matches.
+	Comment string `json:",omitempty"`
+}
+
+// newResultRule returns a new *ResultRule for the matched rule r.
+func newResultRule(r rules.Rule) (rr *ResultRule) {
+	rr = &ResultRule{
+		FilterListID: int64(r.GetFilterListID()),
+		Text:         r.Text(),
+	}
+
+	if _, ok := r.(*rules.NetworkRule); ok {
+		rr.Modifiers = ruleModifiers(rr.Text)
+	}
+
+	return rr
+}
+
+// ruleModifiers returns the modifiers of the network rule with text.  It
+// splits the text the same way urlfilter does.
+func ruleModifiers(text string) (mods []string) {
+	text = strings.TrimPrefix(text, "@@")
+	if len(text) > 1 && text[0] == '/' && text[len(text)-1] == '/' &&
+		!strings.Contains(text, "replace=") {
+		// A regular expression without modifiers.
+		return nil
+	}
+
+	opts := ""
+	for i := len(text) - 2; i >= 0; i-- {
+		if text[i] == '$' && (i == 0 || text[i-1] != '\\') {
+			opts = text[i+1:]
+
+			break
+		}
+	}
+
+	if opts == "" {
+		return nil
+	}
+
+	var sb strings.Builder
+	escaped := false
+	for i := 0; i < len(opts); i++ {
+		c := opts[i]
+		switch {
+		case escaped:
+			if c != ',' {
+				sb.WriteByte('\\')
+			}
+
+			sb.WriteByte(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == ',':
+			if sb.Len() > 0 {
+				mods = append(mods, sb.String())
+				sb.Reset()
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	if sb.Len() > 0 {
+		mods = append(mods, sb.String())
+	}
+
+	return mods
 }
 
 // Result contains the result of a request check.
@@ -391,9 +989,20 @@ type Result struct {
 	IPList []net.IP `json:",omitempty"`
 
 	// CanonName is the CNAME value from the lookup rewrite result.  It is empty
-	// unless Reason is set to Rewritten or RewrittenRule.
+	// unless Reason is set to Rewritten or RewrittenRule, or to
+	// FilteredBlockList when Config.BlockCNAME is set.
 	CanonName string `json:",omitempty"`
 
+	// TTL is the minimal TTL, in seconds, of the rewrite entries used for
+	// the lookup rewrite result, see RewriteEntry.TTL.  It is zero if none
+	// of those has a TTL.
+	TTL uint32 `json:",omitempty"`
+
+	// Upstream is the address of the DNS server to resolve CanonName with
+	// instead of the default upstreams, see RewriteEntry.Upstream.  It is
+	// empty unless CanonName is set by a rewrite with an upstream.
+	Upstream string `json:",omitempty"`
+
 	// ServiceName is the name of the blocked service.  It is empty unless
 	// Reason is set to FilteredBlockedService.
 	ServiceName string `json:",omitempty"`
@@ -416,7 +1025,14 @@ func (d *DNSFilter) CheckHostRules(host string, qtype uint16, setts *Settings) (
 
 	host = strings.ToLower(host)
 
-	return d.matchHost(host, qtype, setts)
+	res, err := d.matchHost(host, qtype, setts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	d.redactRules(&res)
+
+	return res, nil
 }
 
 // CheckHost tries to match the host against filtering rules, then safebrowsing
@@ -426,33 +1042,260 @@ func (d *DNSFilter) CheckHost(
 	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
+	res, _, err = d.checkHost(context.Background(), host, qtype, setts)
+
+	return res, err
+}
+
+// CheckHostCtx is like CheckHost, but it stops checking once ctx is done.  The
+// checking is performed in stages, see CheckHost, ctx is checked before each
+// of those, and the remote stages, like the safe browsing, are interrupted once
+// it's done.  Then the result of the last completed stage is returned with
+// partial set to true and err set to ctx.Err().  The matched results, like the
+// blocks, end the checking, so those are always returned complete.
+func (d *DNSFilter) CheckHostCtx(
+	ctx context.Context,
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, partial bool, err error) {
+	return d.checkHost(ctx, host, qtype, setts)
+}
+
+// checkHost is the implementation of CheckHost and CheckHostCtx.
+func (d *DNSFilter) checkHost(
+	ctx context.Context,
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, partial bool, err error) {
 	// Sometimes clients try to resolve ".", which is a request to get root
 	// servers.
 	if host == "" {
-		return Result{}, nil
+		return Result{}, false, nil
 	}
 
 	host = strings.ToLower(host)
 
-	if setts.FilteringEnabled {
-		res = d.processRewrites(host, qtype)
-		if res.Reason == Rewritten {
-			return res, nil
+	defer func() {
+		if err == nil || partial {
+			d.redactRules(&res)
+			d.sendDecision(host, qtype, setts, res)
+			d.clientStats.record(clientStatsID(setts), res.IsFiltered)
+		}
+	}()
+
+	decide := func(_ *hostChecker, hcRes Result) (cont bool) {
+		res = hcRes
+
+		return !res.Reason.Matched()
+	}
+
+	partial, err = d.walkCheckers(ctx, host, qtype, setts, false, decide)
+	if partial {
+		return res, true, err
+	} else if err != nil {
+		return Result{}, false, err
+	} else if !res.Reason.Matched() {
+		return res, false, nil
+	}
+
+	if res.IsFiltered {
+		d.blockLog.logBlocked(host, res.Reason)
+	}
+
+	return d.withBlockTXT(res, qtype), false, nil
+}
+
+// walkCheckers runs the request for host through the checkers of d in order
+// and calls f with the result of each of those, including the ones which
+// haven't matched.  It stops once f returns false.  The remote checkers, like
+// the safe browsing, are skipped if localOnly is true, and the fallback ones
+// are skipped if any of the previous checkers matched.  ctx is checked before
+// each checker and passed to the ones which accept it, see hostChecker.  If
+// it's done, partial is true and err is ctx.Err().
+func (d *DNSFilter) walkCheckers(
+	ctx context.Context,
+	host string,
+	qtype uint16,
+	setts *Settings,
+	localOnly bool,
+	f func(hc *hostChecker, res Result) (cont bool),
+) (partial bool, err error) {
+	matched := false
+	for i := range d.hostCheckers {
+		hc := &d.hostCheckers[i]
+		if (localOnly && hc.remote) || (matched && hc.fallback) {
+			continue
+		}
+
+		if err = ctx.Err(); err != nil {
+			log.Debug("filtering: checking %q cut short before %s", host, hc.name)
+
+			return true, err
+		}
+
+		var res Result
+		if hc.checkCtx != nil {
+			res, err = hc.checkCtx(ctx, host, qtype, setts)
+		} else {
+			res, err = hc.check(host, qtype, setts)
+		}
+
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				// The error is likely caused by the cancellation, so
+				// prefer the result obtained so far.
+				log.Debug("filtering: checking %q cut short during %s", host, hc.name)
+
+				return true, ctxErr
+			}
+
+			return false, fmt.Errorf("%s: %w", hc.name, err)
+		}
+
+		matched = matched || res.Reason.Matched()
+		if !f(hc, res) {
+			return false, nil
 		}
 	}
 
-	for _, hc := range d.hostCheckers {
-		res, err = hc.check(host, qtype, setts)
+	return false, nil
+}
+
+// checkOpcode refuses the requests with opcodes other than QUERY.  err is
+// always nil.
+func (d *DNSFilter) checkOpcode(host string, _ uint16, setts *Settings) (res Result, err error) {
+	if setts.Opcode == dns.OpcodeQuery {
+		return Result{}, nil
+	}
+
+	log.Debug("filtering: refusing %s request for %q", dns.OpcodeToString[setts.Opcode], host)
+
+	return Result{
+		IsFiltered: true,
+		Reason:     FilteredInvalid,
+	}, nil
+}
+
+// checkLocalDNSSEC refuses the DNSSEC requests for the local domains, see
+// Config.LocalDomains.  err is always nil.
+func (d *DNSFilter) checkLocalDNSSEC(host string, qtype uint16, _ *Settings) (res Result, err error) {
+	if (qtype != dns.TypeDNSKEY && qtype != dns.TypeDS) || !d.isLocalDomain(host) {
+		return Result{}, nil
+	}
+
+	log.Debug("filtering: refusing dnssec query for local host %q", host)
+
+	return Result{
+		Reason: RewrittenRule,
+		DNSRewriteResult: &DNSRewriteResult{
+			RCode: dns.RcodeRefused,
+		},
+	}, nil
+}
+
+// checkServerHost is the hostChecker version of matchServerHost.  err is
+// always nil.
+func (d *DNSFilter) checkServerHost(host string, qtype uint16, _ *Settings) (res Result, err error) {
+	res, _ = d.matchServerHost(host, qtype)
+
+	return res, nil
+}
+
+// checkZone is the hostChecker version of matchZone.  err is always nil.
+func (d *DNSFilter) checkZone(host string, qtype uint16, _ *Settings) (res Result, err error) {
+	res, _ = d.matchZone(host, qtype)
+
+	return res, nil
+}
+
+// checkNAT64PTR is the hostChecker version of matchNAT64PTR.  err is always
+// nil.
+func (d *DNSFilter) checkNAT64PTR(host string, qtype uint16, setts *Settings) (res Result, err error) {
+	if !setts.FilteringEnabled {
+		return Result{}, nil
+	}
+
+	res, _ = d.matchNAT64PTR(host, qtype, setts)
+
+	return res, nil
+}
+
+// matchRewrites returns the result of the rewrites for host, see
+// processRewrites.  The canonical names host is rewritten to, as well as host
+// itself if the blocks override the rewrites, are matched against the filtering
+// rules, and the result for the blocked one is returned instead, see
+// matchRewriteChain.  res is empty if host isn't rewritten.
+func (d *DNSFilter) matchRewrites(host string, qtype uint16, setts *Settings) (res Result, err error) {
+	if !setts.FilteringEnabled {
+		return Result{}, nil
+	}
+
+	res, chain := d.rewriteChain(host, qtype, setts)
+	if res.Reason == RewrittenRule {
+		// The upstream of the rewrite is refused, see
+		// refusedUpstreamResult.
+		return res, nil
+	} else if res.Reason != Rewritten {
+		return Result{}, nil
+	}
+
+	if d.blockOverridesRewrites() {
+		chain = append([]string{host}, chain...)
+	}
+
+	blocked, ok, err := d.matchRewriteChain(chain, qtype, setts)
+	if err != nil {
+		return Result{}, err
+	} else if ok {
+		return blocked, nil
+	}
+
+	return res, nil
+}
+
+// matchRewriteChain matches the canonical names from the rewrites chain against
+// the filtering rules, since the rewrites only follow each other and not the
+// blocklists.  ok is true if any of those is blocked, in which case res is the
+// result for the first blocked one.
+func (d *DNSFilter) matchRewriteChain(
+	chain []string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, ok bool, err error) {
+	for _, name := range chain {
+		res, err = d.matchHost(name, qtype, setts)
 		if err != nil {
-			return Result{}, fmt.Errorf("%s: %w", hc.name, err)
+			return Result{}, false, fmt.Errorf("matching rewritten name %q: %w", name, err)
+		} else if res.IsFiltered {
+			log.Debug("filtering: rewritten name %q is blocked by %s", name, res.Reason)
+
+			return res, true, nil
+		}
+	}
+
+	return Result{}, false, nil
+}
+
+// isLocalDomain returns true if host is one of the configured local domains or
+// their subdomain.
+func (d *DNSFilter) isLocalDomain(host string) (ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	for _, ld := range d.LocalDomains {
+		ld = strings.ToLower(strings.Trim(ld, "."))
+		if ld == "" {
+			continue
 		}
 
-		if res.Reason.Matched() {
-			return res, nil
+		if host == ld || strings.HasSuffix(host, "."+ld) {
+			return true
 		}
 	}
 
-	return Result{}, nil
+	return false
 }
 
 // matchSysHosts tries to match the host against the operating system's hosts
@@ -462,13 +1305,17 @@ func (d *DNSFilter) matchSysHosts(
 	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
-	if !setts.FilteringEnabled || d.EtcHosts == nil {
+	d.confLock.RLock()
+	hc := d.EtcHosts
+	d.confLock.RUnlock()
+
+	if !setts.FilteringEnabled || hc == nil {
 		return res, nil
 	}
 
-	return d.matchSysHostsIntl(&urlfilter.DNSRequest{
+	return d.matchSysHostsIntl(hc, &urlfilter.DNSRequest{
 		Hostname:         host,
-		SortedClientTags: setts.ClientTags,
+		SortedClientTags: requestTags(setts),
 		// TODO(e.burkov):  Wait for urlfilter update to pass net.IP.
 		ClientIP:   setts.ClientIP.String(),
 		ClientName: setts.ClientName,
@@ -477,11 +1324,14 @@ func (d *DNSFilter) matchSysHosts(
 }
 
 // matchSysHostsIntl actually matches the request.  It's separated to avoid
-// perfoming checks twice.
+// perfoming checks twice.  hc is passed explicitly to use the same container
+// for the whole chain of aliases even if the configuration is replaced in the
+// meantime.
 func (d *DNSFilter) matchSysHostsIntl(
+	hc *aghnet.HostsContainer,
 	req *urlfilter.DNSRequest,
 ) (res Result, err error) {
-	dnsres, _ := d.EtcHosts.MatchRequest(*req)
+	dnsres, _ := hc.MatchRequest(*req)
 	if dnsres == nil {
 		return res, nil
 	}
@@ -496,29 +1346,65 @@ func (d *DNSFilter) matchSysHostsIntl(
 		// Probably an alias.
 		req.Hostname = cn
 
-		return d.matchSysHostsIntl(req)
+		return d.matchSysHostsIntl(hc, req)
 	}
 
 	res.Reason = RewrittenAutoHosts
 	for _, r := range res.Rules {
-		r.Text = stringutil.Coalesce(d.EtcHosts.Translate(r.Text), r.Text)
+		r.Text = stringutil.Coalesce(hc.Translate(r.Text), r.Text)
 	}
 
 	return res, nil
 }
 
+// defaultMaxRewriteLookups is the default maximum number of the rewrites
+// lookups for a single request, see Config.MaxRewriteLookups.
+const defaultMaxRewriteLookups = 16
+
 // Process rewrites table
 // . Find CNAME for a domain name (exact match or by wildcard)
 //  . if found and CNAME equals to domain name - this is an exception;  exit
 //  . if found, set domain name to canonical name
 //  . repeat for the new domain name (Note: we return only the last CNAME)
+//  . if the CNAME entry has an upstream pointing at the server itself, the
+//    rewrite is refused;  exit
 // . Find A or AAAA record for a domain name (exact match or by wildcard)
 //  . if found, set IP addresses (IPv4 or IPv6 depending on qtype) in Result.IPList array
-func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
+// . Entries restricted to a subnet are only used for the clients from it
+// . Find MX records for a domain name and set those in Result.DNSRewriteResult
+// . Find PTR records for a reversed address and set those in
+//   Result.DNSRewriteResult, falling back to the A and AAAA records with the
+//   address
+// . AAAA records are synthesized from A records, if Config.NAT64Prefix is set
+// . The number of lookups is limited by Config.MaxRewriteLookups
+// . The number of addresses is limited by Config.MaxRewriteIPs
+func (d *DNSFilter) processRewrites(host string, qtype uint16, setts *Settings) (res Result) {
+	res, _ = d.rewriteChain(host, qtype, setts)
+
+	return res
+}
+
+// rewriteChain is like processRewrites but also returns the canonical names
+// the host was rewritten to, in the order of the lookups.
+func (d *DNSFilter) rewriteChain(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, chain []string) {
 	d.confLock.RLock()
 	defer d.confLock.RUnlock()
 
-	rr := findRewrites(d.Rewrites, host, qtype)
+	limit := d.MaxRewriteLookups
+	if limit == 0 {
+		limit = defaultMaxRewriteLookups
+	}
+
+	rr := findRewrites(d.Rewrites, host, qtype, setts.ClientIP, d.StrictWildcards)
+	if len(rr) == 0 && qtype == dns.TypePTR {
+		return d.addrPTRRewrite(host, setts), nil
+	}
+
+	lookups := uint(1)
 	if len(rr) != 0 {
 		res.Reason = Rewritten
 	}
@@ -531,19 +1417,60 @@ func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
 		if host == rr[0].Answer { // "host == CNAME" is an exception
 			res.Reason = NotFilteredNotFound
 
-			return res
+			return res, chain
 		}
 
 		host = rr[0].Answer
 		if cnames.Has(host) {
 			log.Info("rewrite: breaking CNAME redirection loop: %s.  Question: %s", host, origHost)
 
-			return res
+			return res, chain
+		}
+
+		ups := rr[0].Upstream
+		if ups != "" && d.isSelfUpstream(ups) {
+			log.Error(
+				"rewrite: refusing upstream %s pointing at the server.  Question: %s",
+				ups,
+				origHost,
+			)
+
+			return refusedUpstreamResult(), nil
 		}
 
 		cnames.Add(host)
+		chain = append(chain, host)
 		res.CanonName = rr[0].Answer
-		rr = findRewrites(d.Rewrites, host, qtype)
+		res.Upstream = ups
+		res.TTL = minRewriteTTL(res.TTL, rr[0].TTL)
+		if lookups >= limit {
+			log.Info(
+				"warning: rewrite: stopping after %d lookups at %s.  Question: %s",
+				lookups,
+				host,
+				origHost,
+			)
+
+			return res, chain
+		}
+
+		rr = findRewrites(d.Rewrites, host, qtype, setts.ClientIP, d.StrictWildcards)
+		lookups++
+	}
+
+	if isSvcbType(qtype) && d.RewriteHTTPSNoData {
+		if ttl, ok := d.addrRewritesTTL(host, setts); ok {
+			log.Debug("rewrite: %s for %s is empty", dns.TypeToString[qtype], host)
+
+			res.Reason = Rewritten
+			res.TTL = minRewriteTTL(res.TTL, ttl)
+			res.DNSRewriteResult = &DNSRewriteResult{
+				Response: DNSRewriteResultResponse{},
+				RCode:    dns.RcodeSuccess,
+			}
+
+			return res, chain
+		}
 	}
 
 	for _, r := range rr {
@@ -551,26 +1478,49 @@ func (d *DNSFilter) processRewrites(host string, qtype uint16) (res Result) {
 			if r.IP == nil { // IP exception
 				res.Reason = NotFilteredNotFound
 
-				return res
+				return res, chain
 			}
 
-			res.IPList = append(res.IPList, r.IP)
-			log.Debug("rewrite: A/AAAA for %s is %s", host, r.IP)
+			ip := r.answerIP(setts, d.GeoIP)
+			res.IPList = append(res.IPList, ip)
+			log.Debug("rewrite: A/AAAA for %s is %s", host, ip)
+		} else if r.Type == dns.TypeMX && qtype == dns.TypeMX {
+			res.DNSRewriteResult = appendRewriteMX(res.DNSRewriteResult, r.MX)
+			log.Debug("rewrite: MX for %s is %d %s", host, r.MX.Preference, r.MX.Exchange)
+		} else if r.Type == dns.TypePTR && qtype == dns.TypePTR {
+			res.DNSRewriteResult = appendRewritePTR(res.DNSRewriteResult, r.Answer)
+			log.Debug("rewrite: PTR for %s is %s", host, r.Answer)
+		} else {
+			continue
+		}
+
+		res.TTL = minRewriteTTL(res.TTL, r.TTL)
+	}
+
+	if qtype == dns.TypeAAAA && len(res.IPList) == 0 && d.NAT64Prefix != nil {
+		if lookups >= limit {
+			log.Info("warning: rewrite: not synthesizing AAAA after %d lookups for %s", lookups, host)
+
+			return res, chain
 		}
+
+		res = d.synthesizeNAT64(res, host, setts)
 	}
 
-	return res
+	res.IPList = d.limitRewriteIPs(res.IPList)
+
+	return res, chain
 }
 
 // matchBlockedServicesRules checks the host against the blocked services rules
 // in settings, if any.  The err is always nil, it is only there to make this
 // a valid hostChecker function.
-func matchBlockedServicesRules(
+func (d *DNSFilter) matchBlockedServicesRules(
 	host string,
 	_ uint16,
 	setts *Settings,
 ) (res Result, err error) {
-	if !setts.ProtectionEnabled {
+	if !setts.EffectiveProtection() || !d.scheduleActive(setts) {
 		return Result{}, nil
 	}
 
@@ -581,24 +1531,21 @@ func matchBlockedServicesRules(
 
 	req := rules.NewRequestForHostname(host)
 	for _, s := range svcs {
-		for _, rule := range s.Rules {
-			if rule.Match(req) {
-				res.Reason = FilteredBlockedService
-				res.IsFiltered = true
-				res.ServiceName = s.Name
-
-				ruleText := rule.Text()
-				res.Rules = []*ResultRule{{
-					FilterListID: int64(rule.GetFilterListID()),
-					Text:         ruleText,
-				}}
-
-				log.Debug("blocked services: matched rule: %s  host: %s  service: %s",
-					ruleText, host, s.Name)
-
-				return res, nil
-			}
+		rule := s.mostSpecificRule(req)
+		if rule == nil {
+			continue
 		}
+
+		res.Reason = FilteredBlockedService
+		res.IsFiltered = true
+		res.ServiceName = s.Name
+		res.Rules = []*ResultRule{newResultRule(rule)}
+		res.DNSRewriteResult = d.blockedServiceRewrite()
+
+		log.Debug("blocked services: matched rule: %s  host: %s  service: %s",
+			rule.Text(), host, s.Name)
+
+		return res, nil
 	}
 
 	return res, nil
@@ -608,45 +1555,165 @@ func matchBlockedServicesRules(
 // Adding rule and matching against the rules
 //
 
-func newRuleStorage(filters []Filter) (rs *filterlist.RuleStorage, err error) {
+// newRuleList returns a rule list for f.  list is nil if f has no rules to
+// load.  If ignoreCosmetic is true, the cosmetic rules are skipped.  If
+// normalizeDomains is true, the domains of the rules are normalized, see
+// normalizeRuleDomains, so the lists from files are loaded into memory.
+func newRuleList(
+	f Filter,
+	ignoreCosmetic bool,
+	normalizeDomains bool,
+) (list filterlist.RuleList, err error) {
+	switch id := int(f.ID); {
+	case len(f.Data) != 0:
+		return &filterlist.StringRuleList{
+			ID:             id,
+			RulesText:      normalizeRulesText(f.Data, normalizeDomains),
+			IgnoreCosmetic: ignoreCosmetic,
+		}, nil
+	case f.FilePath == "" && f.DB != nil:
+		return newSQLRuleList(id, f.DB), nil
+	case f.FilePath == "":
+		return nil, nil
+	case runtime.GOOS == "windows", normalizeDomains:
+		// On Windows we don't pass a file to urlfilter because it's
+		// difficult to update this file while it's being used.
+		return newStringRuleListFromFile(id, f.FilePath, ignoreCosmetic, normalizeDomains)
+	default:
+		var fileList *filterlist.FileRuleList
+		fileList, err = filterlist.NewFileRuleList(id, f.FilePath, ignoreCosmetic)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("creating file rule list with %q: %w", f.FilePath, err)
+		}
+
+		var fi fs.FileInfo
+		fi, err = fileList.File.Stat()
+		if err == nil && !fi.Mode().IsRegular() {
+			err = fmt.Errorf("%q is not a regular file", f.FilePath)
+		}
+
+		var normalize bool
+		if err == nil {
+			normalize, err = needsNormalization(fileList.File)
+		}
+
+		if err != nil {
+			return nil, errors.WithDeferred(err, fileList.Close())
+		} else if normalize {
+			// The file is most probably written on Windows, so load it into
+			// memory to normalize it.
+			err = fileList.Close()
+			if err != nil {
+				return nil, fmt.Errorf("closing %q: %w", f.FilePath, err)
+			}
+
+			return newStringRuleListFromFile(id, f.FilePath, ignoreCosmetic, false)
+		}
+
+		return fileList, nil
+	}
+}
+
+// newStringRuleListFromFile reads the rules from the file at path and returns
+// the normalized in-memory list.  list is nil if there is no such file.
+func newStringRuleListFromFile(
+	id int,
+	path string,
+	ignoreCosmetic bool,
+	normalizeDomains bool,
+) (list filterlist.RuleList, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading filter content: %w", err)
+	}
+
+	return &filterlist.StringRuleList{
+		ID:             id,
+		RulesText:      normalizeRulesText(data, normalizeDomains),
+		IgnoreCosmetic: ignoreCosmetic,
+	}, nil
+}
+
+// utf8BOM is the byte order mark of the UTF-8 texts.
+const utf8BOM = "\xef\xbb\xbf"
+
+// normalizeHeadLen is the number of bytes at the beginning of a file checked
+// by needsNormalization.
+const normalizeHeadLen = 4 * 1024
+
+// needsNormalization returns true if the beginning of the file starts with
+// the UTF-8 byte order mark or contains carriage returns.
+func needsNormalization(f io.ReadSeeker) (ok bool, err error) {
+	head := make([]byte, normalizeHeadLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, fmt.Errorf("reading filter content: %w", err)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, fmt.Errorf("seeking filter content: %w", err)
+	}
+
+	head = head[:n]
+
+	return bytes.HasPrefix(head, []byte(utf8BOM)) || bytes.IndexByte(head, '\r') >= 0, nil
+}
+
+// normalizeRulesText removes the UTF-8 byte order mark from the beginning of
+// data and replaces the CRLF and CR line endings with LF.  If normalizeDomains
+// is true, it also normalizes the domains of the rules, see
+// normalizeRuleDomains.
+func normalizeRulesText(data []byte, normalizeDomains bool) (text string) {
+	data = bytes.TrimPrefix(data, []byte(utf8BOM))
+	if bytes.IndexByte(data, '\r') >= 0 {
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+		data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+	}
+
+	if normalizeDomains {
+		return normalizeRuleDomains(string(data))
+	}
+
+	return string(data)
+}
+
+// newRuleStorage creates a new rule storage from filters.  The lists which
+// fail to load are skipped, so that rs is built from the rest of them, and err
+// describes all the failures.  rs is nil only if the storage itself can't be
+// created.  See newRuleList for ignoreCosmetic and normalizeDomains.
+func newRuleStorage(
+	filters []Filter,
+	ignoreCosmetic bool,
+	normalizeDomains bool,
+) (rs *filterlist.RuleStorage, err error) {
+	var errs []error
+
 	lists := make([]filterlist.RuleList, 0, len(filters))
+	ids := map[int64]struct{}{}
 	for _, f := range filters {
-		switch id := int(f.ID); {
-		case len(f.Data) != 0:
-			lists = append(lists, &filterlist.StringRuleList{
-				ID:             id,
-				RulesText:      string(f.Data),
-				IgnoreCosmetic: true,
-			})
-		case f.FilePath == "":
+		if _, ok := ids[f.ID]; ok {
+			errs = append(errs, fmt.Errorf("filter list %d: duplicate list id", f.ID))
+
 			continue
-		case runtime.GOOS == "windows":
-			// On Windows we don't pass a file to urlfilter because it's
-			// difficult to update this file while it's being used.
-			var data []byte
-			data, err = os.ReadFile(f.FilePath)
-			if errors.Is(err, fs.ErrNotExist) {
-				continue
-			} else if err != nil {
-				return nil, fmt.Errorf("reading filter content: %w", err)
-			}
+		}
 
-			lists = append(lists, &filterlist.StringRuleList{
-				ID:             id,
-				RulesText:      string(data),
-				IgnoreCosmetic: true,
-			})
-		default:
-			var list *filterlist.FileRuleList
-			list, err = filterlist.NewFileRuleList(id, f.FilePath, true)
-			if errors.Is(err, fs.ErrNotExist) {
-				continue
-			} else if err != nil {
-				return nil, fmt.Errorf("creating file rule list with %q: %w", f.FilePath, err)
-			}
+		var list filterlist.RuleList
+		list, err = newRuleList(f, ignoreCosmetic, normalizeDomains)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("filter list %d: %w", f.ID, err))
 
-			lists = append(lists, list)
+			continue
+		} else if list == nil {
+			continue
 		}
+
+		ids[f.ID] = struct{}{}
+		lists = append(lists, list)
 	}
 
 	rs, err = filterlist.NewRuleStorage(lists)
@@ -654,39 +1721,119 @@ func newRuleStorage(filters []Filter) (rs *filterlist.RuleStorage, err error) {
 		return nil, fmt.Errorf("creating rule storage: %w", err)
 	}
 
+	if len(errs) > 0 {
+		return rs, errors.List("loading filter lists", errs...)
+	}
+
 	return rs, nil
 }
 
-// Initialize urlfilter objects.
-func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) error {
-	rulesStorage, err := newRuleStorage(blockFilters)
+// initFiltering initializes urlfilter objects.  If some of the lists fail to
+// load, the engines are still initialized with the rest of them and err
+// describes the failures.
+func (d *DNSFilter) initFiltering(allowFilters, blockFilters []Filter) (err error) {
+	var errs []error
+
+	// Notify the listeners once all the locks are released.
+	var ev ReloadEvent
+	defer func() { d.notifyReload(ev) }()
+
+	d.exceptionsLock.Lock()
+	defer d.exceptionsLock.Unlock()
+
+	start := d.now()
+	defer func() { ev = d.recordReload(start, err) }()
+
+	loadedBlock, loadedAllow := blockFilters, allowFilters
+	blockFilters, allowFilters = d.withGraceLists(blockFilters, allowFilters)
+	nonEnforcing := nonEnforcingLists(blockFilters)
+	allowComments := allowRuleComments(blockFilters, allowFilters)
+	blockFilters, allowFilters, err = splitMixedLists(blockFilters, allowFilters)
 	if err != nil {
-		return err
+		errs = append(errs, err)
 	}
 
-	rulesStorageAllow, err := newRuleStorage(allowFilters)
-	if err != nil {
-		return err
+	if f, ok := d.exceptionsFilter(); ok {
+		allowFilters = append(allowFilters[:len(allowFilters):len(allowFilters)], f)
+	}
+
+	if f, ok := d.trackersFilter(); ok {
+		blockFilters = append(blockFilters[:len(blockFilters):len(blockFilters)], f)
+	}
+
+	d.confLock.RLock()
+	ignoreCosmetic := !d.KeepCosmeticRules
+	normalizeDomains := d.NormalizeRuleDomains
+	d.confLock.RUnlock()
+
+	block, cached, err := d.compileBlockLists(blockFilters, ignoreCosmetic, normalizeDomains)
+	if block == nil {
+		return fmt.Errorf("blocklists: %w", err)
+	} else if err != nil {
+		errs = append(errs, fmt.Errorf("blocklists: %w", err))
 	}
 
-	filteringEngine := urlfilter.NewDNSEngine(rulesStorage)
+	rulesStorageAllow, err := newRuleStorage(allowFilters, ignoreCosmetic, normalizeDomains)
+	if rulesStorageAllow == nil {
+		err = fmt.Errorf("allowlists: %w", err)
+		if cached {
+			return err
+		}
+
+		return errors.WithDeferred(err, block.storage.Close())
+	} else if err != nil {
+		errs = append(errs, fmt.Errorf("allowlists: %w", err))
+	}
+
+	allowInfo := scanRulesInfo(rulesStorageAllow, !ignoreCosmetic)
+	cosmetic := block.info.cosmetic
+	cosmetic = append(cosmetic[:len(cosmetic):len(cosmetic)], allowInfo.cosmetic...)
+	clientPats := mergeClientPatterns(block.info.clientPats, allowInfo.clientPats)
+	sqlLists := sqlRuleLists(block.storage)
+
 	filteringEngineAllow := urlfilter.NewDNSEngine(rulesStorageAllow)
 
 	func() {
 		d.engineLock.Lock()
 		defer d.engineLock.Unlock()
 
-		d.reset()
-		d.rulesStorage = rulesStorage
-		d.filteringEngine = filteringEngine
+		prev, prevAllow := d.rulesStorage, d.rulesStorageAllow
+
+		var evicted []*compiledLists
+		if d.compiled != nil && block.key != (compiledKey{}) {
+			evicted = d.compiled.put(block)
+		}
+
+		d.rulesStorage = block.storage
+		d.filteringEngine = block.engine
 		d.rulesStorageAllow = rulesStorageAllow
 		d.filteringEngineAllow = filteringEngineAllow
+		d.blockFilters = loadedBlock
+		d.allowFilters = loadedAllow
+		d.cosmeticRules = cosmetic
+		d.clientPatterns = clientPats
+		d.sqlLists = sqlLists
+		d.nonEnforcing = nonEnforcing
+		d.allowComments = allowComments
+		d.resetEstimator()
+		setServerIPTagged(&d.serverIPTagged, block.info.serverIPTagged || allowInfo.serverIPTagged)
+
+		storages := []*filterlist.RuleStorage{prev, prevAllow}
+		for _, cl := range evicted {
+			storages = append(storages, cl.storage)
+		}
+
+		d.closeStorages(storages...)
 	}()
 
 	// Make sure that the OS reclaims memory as soon as possible.
 	debug.FreeOSMemory()
 	log.Debug("initialized filtering engine")
 
+	if len(errs) > 0 {
+		return errors.List("some filter lists were skipped", errs...)
+	}
+
 	return nil
 }
 
@@ -705,6 +1852,48 @@ func hostRulesToRules(netRules []*rules.HostRule) (res []rules.Rule) {
 	return res
 }
 
+// newDNSRequest returns the urlfilter request for host with qtype from the
+// client with setts.
+func newDNSRequest(host string, qtype uint16, setts *Settings) (ureq urlfilter.DNSRequest) {
+	return urlfilter.DNSRequest{
+		Hostname:         host,
+		SortedClientTags: requestTags(setts),
+		// TODO(e.burkov): Wait for urlfilter update to pass net.IP.
+		ClientIP:   setts.ClientIP.String(),
+		ClientName: setts.ClientName,
+		DNSType:    qtype,
+	}
+}
+
+// matchAllowlist matches host against the allowlists only.  ok is false if
+// none of the allowlist rules match.  The caller is expected to check
+// setts.FilteringEnabled.
+func (d *DNSFilter) matchAllowlist(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, ok bool, err error) {
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	if d.filteringEngineAllow == nil {
+		return Result{}, false, nil
+	}
+
+	dnsres, _, ok := matchWithAliases(
+		d.filteringEngineAllow,
+		newDNSRequest(host, qtype, setts),
+		d.clientAliases(setts.ClientName),
+	)
+	if !ok {
+		return Result{}, false, nil
+	}
+
+	res, err = d.matchHostProcessAllowList(host, dnsres)
+
+	return res, err == nil, err
+}
+
 // matchHostProcessAllowList processes the allowlist logic of host
 // matching.
 func (d *DNSFilter) matchHostProcessAllowList(
@@ -726,7 +1915,15 @@ func (d *DNSFilter) matchHostProcessAllowList(
 
 	log.Debug("filtering: allowlist rules for host %q: %+v", host, matchedRules)
 
-	return makeResult(matchedRules, NotFilteredAllowList), nil
+	return d.withAllowComments(makeResult(matchedRules, NotFilteredAllowList)), nil
+}
+
+// blockCNAME returns the host of the block page, if any.
+func (d *DNSFilter) blockCNAME() (host string) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	return strings.ToLower(strings.TrimSuffix(d.BlockCNAME, "."))
 }
 
 // matchHostProcessDNSResult processes the matched DNS filtering result.
@@ -740,7 +1937,12 @@ func (d *DNSFilter) matchHostProcessDNSResult(
 			reason = NotFilteredAllowList
 		}
 
-		return makeResult([]rules.Rule{dnsres.NetworkRule}, reason)
+		res = d.withAllowComments(makeResult([]rules.Rule{dnsres.NetworkRule}, reason))
+		if reason == FilteredBlockList && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+			res.CanonName = d.blockCNAME()
+		}
+
+		return res
 	}
 
 	if qtype == dns.TypeA && dnsres.HostRulesV4 != nil {
@@ -764,39 +1966,102 @@ func (d *DNSFilter) matchHostProcessDNSResult(
 	if dnsres.HostRulesV4 != nil || dnsres.HostRulesV6 != nil {
 		// Question type doesn't match the host rules.  Return the first matched
 		// host rule, but without an IP address.
-		var matchedRules []rules.Rule
+		var hr *rules.HostRule
 		if dnsres.HostRulesV4 != nil {
-			matchedRules = []rules.Rule{dnsres.HostRulesV4[0]}
+			hr = dnsres.HostRulesV4[0]
 		} else if dnsres.HostRulesV6 != nil {
-			matchedRules = []rules.Rule{dnsres.HostRulesV6[0]}
+			hr = dnsres.HostRulesV6[0]
+		}
+
+		if !hr.IP.IsUnspecified() && d.hostRuleMismatchNoData() {
+			return Result{
+				Reason: RewrittenRule,
+				Rules:  []*ResultRule{newResultRule(hr)},
+				DNSRewriteResult: &DNSRewriteResult{
+					Response: DNSRewriteResultResponse{},
+					RCode:    dns.RcodeSuccess,
+				},
+			}
 		}
 
-		return makeResult(matchedRules, FilteredBlockList)
+		return makeResult([]rules.Rule{hr}, FilteredBlockList)
 	}
 
 	return Result{}
 }
 
 // matchHost is a low-level way to check only if hostname is filtered by rules,
-// skipping expensive safebrowsing and parental lookups.
+// skipping expensive safebrowsing and parental lookups.  If the service name
+// host isn't matched, its parent domain is matched instead, if configured, see
+// Config.ServiceNamesAsParents.
 func (d *DNSFilter) matchHost(
 	host string,
 	qtype uint16,
 	setts *Settings,
+) (res Result, err error) {
+	return d.matchHostCtx(context.Background(), host, qtype, setts)
+}
+
+// matchHostCtx is like matchHost, but the queries to the database-backed
+// blocklists are bounded by ctx.
+func (d *DNSFilter) matchHostCtx(
+	ctx context.Context,
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	if !d.scheduleActive(setts) {
+		return Result{}, nil
+	}
+
+	res, err = d.matchHostName(ctx, host, qtype, setts)
+	if err != nil || res.Reason.Matched() {
+		return res, err
+	}
+
+	parent, ok := d.serviceNameParent(host)
+	if ok {
+		log.Debug("filtering: matching service name %q as %q", host, parent)
+
+		return d.matchHostName(ctx, parent, qtype, setts)
+	}
+
+	return Result{}, nil
+}
+
+// matchHostName matches host against the filtering rules and then, if none of
+// them matched, against the database-backed blocklists.
+func (d *DNSFilter) matchHostName(
+	ctx context.Context,
+	host string,
+	qtype uint16,
+	setts *Settings,
 ) (res Result, err error) {
 	if !setts.FilteringEnabled {
 		return Result{}, nil
 	}
 
-	ureq := urlfilter.DNSRequest{
-		Hostname:         host,
-		SortedClientTags: setts.ClientTags,
-		// TODO(e.burkov): Wait for urlfilter update to pass net.IP.
-		ClientIP:   setts.ClientIP.String(),
-		ClientName: setts.ClientName,
-		DNSType:    qtype,
+	res, sqlLists, err := d.matchHostEngines(host, qtype, setts)
+	if err != nil || len(sqlLists) == 0 {
+		return res, err
 	}
 
+	// Query the databases without holding d.engineLock.
+	res, _ = matchSQLLists(ctx, sqlLists, host)
+
+	return res, nil
+}
+
+// matchHostEngines matches host against the filtering engines.  sqlLists are
+// the database-backed blocklists host should be matched against next, if
+// none of the rules matched.
+func (d *DNSFilter) matchHostEngines(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, sqlLists []*sqlRuleList, err error) {
+	ureq := newDNSRequest(host, qtype, setts)
+
 	d.engineLock.RLock()
 	// Keep in mind that this lock must be held no just when calling Match() but
 	// also while using the rules returned by it.
@@ -804,37 +2069,64 @@ func (d *DNSFilter) matchHost(
 	// TODO(e.burkov):  Inspect if the above is true.
 	defer d.engineLock.RUnlock()
 
-	if setts.ProtectionEnabled && d.filteringEngineAllow != nil {
-		dnsres, ok := d.filteringEngineAllow.MatchRequest(ureq)
+	aliases := d.clientAliases(setts.ClientName)
+	if setts.EffectiveFiltering() && d.filteringEngineAllow != nil {
+		dnsres, _, ok := matchWithAliases(d.filteringEngineAllow, ureq, aliases)
 		if ok {
-			return d.matchHostProcessAllowList(host, dnsres)
+			res, err = d.matchHostProcessAllowList(host, dnsres)
+
+			return res, nil, err
 		}
 	}
 
 	if d.filteringEngine == nil {
-		return Result{}, nil
+		return d.notReady(host, setts), nil, nil
+	}
+
+	dnsres, dnsr, ok := matchWithAliases(d.filteringEngine, ureq, aliases)
+	if len(dnsr) > 0 && ok && setts.EffectiveFiltering() && d.blockOverridesRewrites() {
+		if nr := dnsres.NetworkRule; nr == nil || nr.DNSRewrite == nil {
+			blocked := d.softBlock(d.matchHostProcessDNSResult(qtype, dnsres))
+			if blocked.IsFiltered {
+				return blocked, nil, nil
+			}
+		}
 	}
 
-	dnsres, ok := d.filteringEngine.MatchRequest(ureq)
 	// Check DNS rewrites first, because the API there is a bit awkward.
-	if dnsr := dnsres.DNSRewrites(); len(dnsr) > 0 {
+	if len(dnsr) > 0 {
 		res = d.processDNSRewrites(dnsr)
-		if res.Reason == RewrittenRule && res.CanonName == host {
-			// A rewrite of a host to itself.  Go on and try matching other
-			// things.
-		} else {
-			return res, nil
+		if res.Reason != RewrittenRule || res.CanonName != host {
+			return res, nil, nil
+		} else if d.selfRewriteNoData() {
+			// A rewrite of a host to itself is configured to stop the
+			// matching with an empty answer.
+			return Result{
+				Reason: RewrittenRule,
+				Rules:  res.Rules,
+				DNSRewriteResult: &DNSRewriteResult{
+					Response: DNSRewriteResultResponse{},
+					RCode:    dns.RcodeSuccess,
+				},
+			}, nil, nil
 		}
+
+		// A rewrite of a host to itself.  Go on and try matching other
+		// things.
 	} else if !ok {
-		return Result{}, nil
+		if !setts.EffectiveFiltering() {
+			return Result{}, nil, nil
+		}
+
+		return Result{}, d.sqlLists, nil
 	}
 
-	if !setts.ProtectionEnabled {
+	if !setts.EffectiveFiltering() {
 		// Don't check non-dnsrewrite filtering results.
-		return Result{}, nil
+		return Result{}, nil, nil
 	}
 
-	res = d.matchHostProcessDNSResult(qtype, dnsres)
+	res = d.softBlock(d.matchHostProcessDNSResult(qtype, dnsres))
 	for _, r := range res.Rules {
 		log.Debug(
 			"filtering: found rule %q for host %q, filter list id: %d",
@@ -844,17 +2136,73 @@ func (d *DNSFilter) matchHost(
 		)
 	}
 
-	return res, nil
+	return res, nil, nil
+}
+
+// notReadyRuleText is the text of the pseudo-rule reported in the results of
+// the requests refused before the filtering rules are loaded.
+const notReadyRuleText = "filtering not ready"
+
+// notReady returns the result for host requested before the filtering engine is
+// initialized.  It's a block answered with SERVFAIL if Config.BlockUntilReady
+// is enabled and the filtering rules apply to setts, see
+// Settings.EffectiveFiltering, and an empty result otherwise.
+func (d *DNSFilter) notReady(host string, setts *Settings) (res Result) {
+	d.confLock.RLock()
+	block := d.BlockUntilReady
+	d.confLock.RUnlock()
+
+	if !block || !setts.EffectiveFiltering() {
+		return Result{}
+	}
+
+	log.Debug("filtering: rules not loaded yet, refusing %q", host)
+
+	return Result{
+		IsFiltered: true,
+		Reason:     FilteredBlockList,
+		Rules: []*ResultRule{{
+			Text: notReadyRuleText,
+		}},
+		DNSRewriteResult: &DNSRewriteResult{
+			RCode: dns.RcodeServerFailure,
+		},
+	}
+}
+
+// selfRewriteNoData returns true if the rewrites of a host to itself should
+// result in an empty answer, see Config.SelfRewriteNoData.
+func (d *DNSFilter) selfRewriteNoData() (ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	return d.SelfRewriteNoData
+}
+
+// blockOverridesRewrites returns true if the blocking rules should take
+// precedence over the rewrites, see Config.RewritePrecedence.
+func (d *DNSFilter) blockOverridesRewrites() (ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	return d.RewritePrecedence == RewritePrecedenceBlock
+}
+
+// hostRuleMismatchNoData returns true if the requests of the types other than
+// the one of the matched hosts rules should result in an empty answer, see
+// Config.HostRuleMismatchNoData.
+func (d *DNSFilter) hostRuleMismatchNoData() (ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	return d.HostRuleMismatchNoData
 }
 
 // makeResult returns a properly constructed Result.
 func makeResult(matchedRules []rules.Rule, reason Reason) (res Result) {
 	resRules := make([]*ResultRule, len(matchedRules))
 	for i, mr := range matchedRules {
-		resRules[i] = &ResultRule{
-			FilterListID: int64(mr.GetFilterListID()),
-			Text:         mr.Text(),
-		}
+		resRules[i] = newResultRule(mr)
 	}
 
 	return Result{
@@ -873,49 +2221,100 @@ func InitModule() {
 // New creates properly initialized DNS Filter that is ready to be used.
 func New(c *Config, blockFilters []Filter) (d *DNSFilter) {
 	d = &DNSFilter{
-		resolver: net.DefaultResolver,
+		resolver:   net.DefaultResolver,
+		randInt63n: rand.Int63n,
+		now:        time.Now,
+		after:      time.After,
 	}
 	if c != nil {
 
-		d.safebrowsingCache = cache.New(cache.Config{
-			EnableLRU: true,
-			MaxSize:   c.SafeBrowsingCacheSize,
-		})
-		d.safeSearchCache = cache.New(cache.Config{
-			EnableLRU: true,
-			MaxSize:   c.SafeSearchCacheSize,
-		})
-		d.parentalCache = cache.New(cache.Config{
-			EnableLRU: true,
-			MaxSize:   c.ParentalCacheSize,
-		})
+		d.safebrowsingCache = c.SafeBrowsingCache
+		if d.safebrowsingCache == nil {
+			d.safebrowsingCache = &lruVerdictCache{
+				Cache: d.newLRUCache(CacheNameSafeBrowsing, c.SafeBrowsingCacheSize, c),
+			}
+		}
+
+		d.safeSearchCache = d.newLRUCache(CacheNameSafeSearch, c.SafeSearchCacheSize, c)
+
+		d.parentalCache = c.ParentalCache
+		if d.parentalCache == nil {
+			d.parentalCache = &lruVerdictCache{
+				Cache: d.newLRUCache(CacheNameParental, c.ParentalCacheSize, c),
+			}
+		}
 
 		if c.CustomResolver != nil {
 			d.resolver = c.CustomResolver
 		}
+
+		d.blockLog = newBlockLogger(time.Duration(c.LogCoalesceWindow) * time.Second)
+		d.decisions = newDecisionStream(c.DecisionSink, c.DecisionBufferSize)
+		d.clientStats = newClientStats(
+			c.ClientStatsSize,
+			time.Duration(c.ClientStatsWindow)*time.Second,
+		)
+		d.compiled = newCompiledCache(c.CompiledCacheSize)
+
+		if c.PublicSuffixList != "" {
+			var err error
+			d.psl, err = readSuffixList(c.PublicSuffixList)
+			if err != nil {
+				log.Error("filtering: reading public suffix list, using built-in one: %s", err)
+			}
+		}
 	}
 
 	d.hostCheckers = []hostChecker{{
+		check: d.checkOpcode,
+		name:  "opcode",
+	}, {
+		check: d.checkLocalDNSSEC,
+		name:  "dnssec",
+	}, {
+		check: d.checkServerHost,
+		name:  "server host",
+	}, {
+		check: d.checkZone,
+		name:  "zone",
+	}, {
+		check: d.checkNAT64PTR,
+		name:  "nat64 ptr",
+	}, {
+		check: d.matchRewrites,
+		name:  "rewrites",
+	}, {
 		check: d.matchSysHosts,
 		name:  "hosts container",
 	}, {
-		check: d.matchHost,
-		name:  "filtering",
+		check:    d.matchHost,
+		checkCtx: d.matchHostCtx,
+		name:     filteringStageName,
 	}, {
-		check: matchBlockedServicesRules,
+		check: d.matchBlockedServicesRules,
 		name:  "blocked services",
 	}, {
-		check: d.checkSafeBrowsing,
-		name:  "safe browsing",
+		check:    d.checkSafeBrowsing,
+		checkCtx: d.checkSafeBrowsingCtx,
+		name:     "safe browsing",
+		remote:   true,
+	}, {
+		check:    d.checkParental,
+		checkCtx: d.checkParentalCtx,
+		name:     "parental",
+		remote:   true,
 	}, {
-		check: d.checkParental,
-		name:  "parental",
+		check:    d.checkSafeSearch,
+		checkCtx: d.checkSafeSearchCtx,
+		name:     "safe search",
+		remote:   true,
 	}, {
-		check: d.checkSafeSearch,
-		name:  "safe search",
+		check:    d.matchDefaultDeny,
+		name:     defaultDenyStageName,
+		fallback: true,
 	}}
 
-	err := d.initSecurityServices()
+	err := d.initSecurityServices(c)
 	if err != nil {
 		log.Error("filtering: initialize services: %s", err)
 		return nil
@@ -923,11 +2322,55 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter) {
 
 	if c != nil {
 		d.Config = *c
-		d.prepareRewrites()
+	}
+
+	d.normalizeConfig()
+
+	if blockFilters != nil || d.BlockTrackers {
+		err = d.initFiltering(nil, blockFilters)
+		if err != nil {
+			log.Error("Can't initialize filtering subsystem: %s", err)
+
+			// Only fail if none of the engines were initialized.
+			if d.filteringEngine == nil {
+				d.Close()
+
+				return nil
+			}
+		}
+	}
+
+	return d
+}
+
+// normalizeConfig normalizes the rewrites and the blocked services of d and
+// drops the invalid optional settings.  d.confLock is expected to be locked,
+// if d is already in use.
+func (d *DNSFilter) normalizeConfig() {
+	d.prepareRewrites()
+
+	err := validateBlockTXT(d.BlockTXT)
+	if err != nil {
+		log.Error("filtering: not answering blocked txt requests: %s", err)
+		d.BlockTXT = ""
+	}
+
+	if d.NAT64Prefix == nil && d.NAT64PrefixCIDR != "" {
+		d.NAT64Prefix, err = parseNAT64Prefix(d.NAT64PrefixCIDR)
+		if err != nil {
+			log.Error("filtering: not synthesizing aaaa rewrites: %s", err)
+		}
+	} else if d.NAT64Prefix != nil {
+		err = validateNAT64Prefix(d.NAT64Prefix)
+		if err != nil {
+			log.Error("filtering: not synthesizing aaaa rewrites: %s", err)
+			d.NAT64Prefix = nil
+		}
 	}
 
 	bsvcs := []string{}
 	for _, s := range d.BlockedServices {
+		s = normalizeServiceName(s)
 		if !BlockedSvcKnown(s) {
 			log.Debug("skipping unknown blocked-service %q", s)
 			continue
@@ -935,17 +2378,6 @@ func New(c *Config, blockFilters []Filter) (d *DNSFilter) {
 		bsvcs = append(bsvcs, s)
 	}
 	d.BlockedServices = bsvcs
-
-	if blockFilters != nil {
-		err = d.initFiltering(nil, blockFilters)
-		if err != nil {
-			log.Error("Can't initialize filtering subsystem: %s", err)
-			d.Close()
-			return nil
-		}
-	}
-
-	return d
 }
 
 // Start - start the module:
@@ -5,11 +5,16 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghnet"
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
-	"github.com/AdguardTeam/golibs/cache"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
@@ -28,12 +33,12 @@ var setts = Settings{
 // Helpers.
 
 func purgeCaches(d *DNSFilter) {
-	for _, c := range []cache.Cache{
+	for _, c := range []interface{}{
 		d.safebrowsingCache,
 		d.parentalCache,
 		d.safeSearchCache,
 	} {
-		if c != nil {
+		if c, ok := c.(interface{ Clear() }); ok {
 			c.Clear()
 		}
 	}
@@ -59,6 +64,44 @@ func newForTest(t testing.TB, c *Config, filters []Filter) *DNSFilter {
 	return d
 }
 
+// Filter list IDs used by newForTestRules.
+const (
+	testBlockListID = 1
+	testAllowListID = 2
+)
+
+// newForTestRules returns a new enabled *DNSFilter with the blocklist
+// containing blockRules and the allowlist containing allowRules, with the IDs
+// testBlockListID and testAllowListID respectively.
+func newForTestRules(t testing.TB, blockRules, allowRules []string) (d *DNSFilter) {
+	t.Helper()
+
+	d = newForTest(t, &Config{}, nil)
+	t.Cleanup(d.Close)
+
+	var block, allow []Filter
+	if len(blockRules) > 0 {
+		block = []Filter{{
+			ID:   testBlockListID,
+			Data: []byte(strings.Join(blockRules, "\n")),
+		}}
+	}
+
+	if len(allowRules) > 0 {
+		allow = []Filter{{
+			ID:   testAllowListID,
+			Data: []byte(strings.Join(allowRules, "\n")),
+		}}
+	}
+
+	err := d.SetFilters(block, allow, false)
+	require.NoError(t, err)
+
+	d.SetEnabled(true)
+
+	return d
+}
+
 func (d *DNSFilter) checkMatch(t *testing.T, hostname string) {
 	t.Helper()
 
@@ -164,6 +207,180 @@ func TestEtcHostsMatching(t *testing.T) {
 	assert.Equal(t, res.Rules[0].IP, net.IPv6loopback)
 }
 
+func TestEtcHostsMatching_typeMismatch(t *testing.T) {
+	const text = "192.168.0.1 nas.lan\n" +
+		"fd00::1 v6.lan\n" +
+		"0.0.0.0 block.example\n"
+
+	testCases := []struct {
+		name       string
+		host       string
+		qtype      uint16
+		noData     bool
+		wantReason Reason
+	}{{
+		name:       "aaaa_blocked",
+		host:       "nas.lan",
+		qtype:      dns.TypeAAAA,
+		noData:     false,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "aaaa_nodata",
+		host:       "nas.lan",
+		qtype:      dns.TypeAAAA,
+		noData:     true,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "a_nodata",
+		host:       "v6.lan",
+		qtype:      dns.TypeA,
+		noData:     true,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "unspecified_nodata",
+		host:       "block.example",
+		qtype:      dns.TypeAAAA,
+		noData:     true,
+		wantReason: FilteredBlockList,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				HostRuleMismatchNoData: tc.noData,
+			}, []Filter{{ID: 1, Data: []byte(text)}})
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantReason == FilteredBlockList, res.IsFiltered)
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Empty(t, res.Rules[0].IP)
+
+			if tc.wantReason != RewrittenRule {
+				assert.Nil(t, res.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, dns.RcodeSuccess, res.DNSRewriteResult.RCode)
+			assert.Empty(t, res.DNSRewriteResult.Response)
+		})
+	}
+}
+
+func newTestHostsContainer(t *testing.T, data string) (hc *aghnet.HostsContainer) {
+	t.Helper()
+
+	const hostsFilename = "hosts"
+
+	testFS := fstest.MapFS{
+		hostsFilename: &fstest.MapFile{Data: []byte(data)},
+	}
+
+	hc, err := aghnet.NewHostsContainer(SysHostsListID, testFS, &aghtest.FSWatcher{
+		OnEvents: func() (e <-chan struct{}) { return nil },
+		OnAdd:    func(_ string) (err error) { return nil },
+		OnClose:  func() (err error) { return nil },
+	}, hostsFilename)
+	require.NoError(t, err)
+
+	return hc
+}
+
+func TestDNSFilter_CheckHost_etcHostsReload(t *testing.T) {
+	ipOld := net.IPv4(1, 2, 3, 4)
+	ipNew := net.IPv4(4, 3, 2, 1)
+
+	const hostsFilename = "hosts"
+
+	dir := t.TempDir()
+	hostsPath := filepath.Join(dir, hostsFilename)
+	writeHosts := func(t *testing.T, ip net.IP) {
+		t.Helper()
+
+		err := os.WriteFile(hostsPath, []byte(ip.String()+" host alias\n"), 0o644)
+		require.NoError(t, err)
+	}
+
+	writeHosts(t, ipOld)
+
+	events := make(chan struct{})
+	hc, err := aghnet.NewHostsContainer(SysHostsListID, os.DirFS(dir), &aghtest.FSWatcher{
+		OnEvents: func() (e <-chan struct{}) { return events },
+		OnAdd:    func(_ string) (err error) { return nil },
+		OnClose:  func() (err error) { return nil },
+	}, hostsFilename)
+	require.NoError(t, err)
+	t.Cleanup(func() { close(events) })
+
+	d := newForTest(t, &Config{EtcHosts: hc}, nil)
+	t.Cleanup(d.Close)
+
+	stngs := &Settings{
+		FilteringEnabled: true,
+	}
+
+	// lookup returns the address of host.  It's called from several
+	// goroutines, so it uses assert instead of require and returns nil on the
+	// first failure.
+	lookup := func(host string) (ip net.IP) {
+		res, cErr := d.CheckHost(host, dns.TypeA, stngs)
+		if !assert.NoError(t, cErr) ||
+			!assert.Equal(t, RewrittenAutoHosts, res.Reason) ||
+			!assert.NotNil(t, res.DNSRewriteResult) {
+			return nil
+		}
+
+		ips := res.DNSRewriteResult.Response[dns.TypeA]
+		if !assert.Len(t, ips, 1) {
+			return nil
+		}
+
+		ip, _ = ips[0].(net.IP)
+		if !assert.True(t, ip.Equal(ipOld) || ip.Equal(ipNew), "got %s", ip) {
+			return nil
+		}
+
+		return ip
+	}
+
+	require.True(t, lookup("host").Equal(ipOld))
+
+	const lookupsNum = 100
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < lookupsNum; j++ {
+				if lookup("host") == nil || lookup("alias") == nil {
+					return
+				}
+			}
+		}()
+	}
+
+	// Reload the file the same way the watcher does it on changes while the
+	// lookups are running.
+	writeHosts(t, ipNew)
+	events <- struct{}{}
+
+	assert.Eventually(t, func() (ok bool) {
+		return lookup("alias").Equal(ipNew)
+	}, time.Second, 10*time.Millisecond)
+
+	wg.Wait()
+}
+
 // Safe Browsing.
 
 func TestSafeBrowsing(t *testing.T) {
@@ -291,6 +508,169 @@ func TestCheckHostSafeSearchGoogle(t *testing.T) {
 	}
 }
 
+func TestCheckHostSafeSearch_allowlist(t *testing.T) {
+	resolver := &aghtest.TestResolver{}
+	d := newForTest(t, &Config{
+		SafeSearchEnabled: true,
+		CustomResolver:    resolver,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters(nil, []Filter{{
+		ID: 1,
+		Data: []byte(
+			"@@||www.google.it^\n" +
+				"@@||www.google.de^$client=192.168.0.10\n" +
+				"@@||allowed.example^\n",
+		),
+	}}, false)
+	require.NoError(t, err)
+
+	ip, _ := resolver.HostToIPs("forcesafesearch.google.com")
+
+	testCases := []struct {
+		name       string
+		host       string
+		clientIP   net.IP
+		filtering  bool
+		wantReason Reason
+	}{{
+		name:       "allowlisted",
+		host:       "www.google.it",
+		filtering:  true,
+		wantReason: NotFilteredAllowList,
+	}, {
+		// The allowlist is a part of the filtering, so it doesn't apply
+		// when the filtering is disabled.
+		name:       "allowlisted_no_filtering",
+		host:       "www.google.it",
+		filtering:  false,
+		wantReason: FilteredSafeSearch,
+	}, {
+		name:       "not_allowlisted",
+		host:       "www.google.com",
+		filtering:  true,
+		wantReason: FilteredSafeSearch,
+	}, {
+		name:       "allowlisted_client",
+		host:       "www.google.de",
+		clientIP:   net.IP{192, 168, 0, 10},
+		filtering:  true,
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "other_client",
+		host:       "www.google.de",
+		clientIP:   net.IP{192, 168, 0, 20},
+		filtering:  true,
+		wantReason: FilteredSafeSearch,
+	}, {
+		// Make sure the cached result of the other client isn't used.
+		name:       "allowlisted_client_cached",
+		host:       "www.google.de",
+		clientIP:   net.IP{192, 168, 0, 10},
+		filtering:  true,
+		wantReason: NotFilteredAllowList,
+	}, {
+		// The allowlist isn't reported for the hosts safe search doesn't
+		// apply to.
+		name:       "not_search_no_filtering",
+		host:       "allowed.example",
+		filtering:  false,
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Settings{
+				ClientIP:          tc.clientIP,
+				ProtectionEnabled: true,
+				FilteringEnabled:  tc.filtering,
+				SafeSearchEnabled: true,
+			}
+
+			res, cerr := d.CheckHost(tc.host, dns.TypeA, s)
+			require.NoError(t, cerr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantReason != FilteredSafeSearch {
+				assert.False(t, res.IsFiltered)
+
+				return
+			}
+
+			assert.True(t, res.IsFiltered)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, ip, res.Rules[0].IP)
+		})
+	}
+
+	t.Run("check_safe_search", func(t *testing.T) {
+		// Check the stage directly, since the filtering stage decides on
+		// the allowlisted hosts before it.
+		s := &Settings{
+			ProtectionEnabled: true,
+			FilteringEnabled:  true,
+			SafeSearchEnabled: true,
+		}
+
+		res, cerr := d.checkSafeSearch("www.google.it", dns.TypeA, s)
+		require.NoError(t, cerr)
+
+		assert.Equal(t, NotFilteredAllowList, res.Reason)
+
+		s.FilteringEnabled = false
+		res, cerr = d.checkSafeSearch("www.google.it", dns.TypeA, s)
+		require.NoError(t, cerr)
+
+		assert.Equal(t, FilteredSafeSearch, res.Reason)
+	})
+}
+
+func TestCheckHostSafeSearch_resolver(t *testing.T) {
+	defaultResolver := &aghtest.TestResolver{}
+	d := newForTest(t, &Config{
+		SafeSearchEnabled: true,
+		CustomResolver:    defaultResolver,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	ip, _ := defaultResolver.HostToIPs("forcesafesearch.google.com")
+
+	t.Run("per_request", func(t *testing.T) {
+		resolver := &aghtest.TestResolver{}
+		reqSetts := setts
+		reqSetts.Resolver = resolver
+
+		res, err := d.CheckHost("www.google.com", dns.TypeA, &reqSetts)
+		require.NoError(t, err)
+		require.True(t, res.IsFiltered)
+		require.Len(t, res.Rules, 1)
+
+		assert.Equal(t, ip, res.Rules[0].IP)
+		assert.Equal(t, 1, resolver.Counter())
+		assert.Zero(t, defaultResolver.Counter())
+
+		// The results of the per-request resolver aren't cached.
+		_, err = d.CheckHost("www.google.com", dns.TypeA, &reqSetts)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, resolver.Counter())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		purgeCaches(d)
+
+		res, err := d.CheckHost("www.google.com", dns.TypeA, &setts)
+		require.NoError(t, err)
+		require.True(t, res.IsFiltered)
+		require.Len(t, res.Rules, 1)
+
+		assert.Equal(t, ip, res.Rules[0].IP)
+		assert.Equal(t, 1, defaultResolver.Counter())
+	})
+}
+
 func TestSafeSearchCacheYandex(t *testing.T) {
 	d := newForTest(t, nil, nil)
 	t.Cleanup(d.Close)
@@ -740,6 +1120,281 @@ func TestWhitelist(t *testing.T) {
 	assert.Equal(t, "||host2^", res.Rules[0].Text)
 }
 
+func TestDNSFilter_SetFilters_partial(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	// A directory can be opened, but not read as a list of rules.
+	dir := t.TempDir()
+
+	err := d.SetFilters([]Filter{{
+		ID: 1, Data: []byte("||host1^\n"),
+	}, {
+		ID: 2, FilePath: dir,
+	}, {
+		ID: 3, Data: []byte("||host3^\n"),
+	}, {
+		ID: 3, Data: []byte("||host4^\n"),
+	}}, nil, false)
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "filter list 2")
+	assert.Contains(t, err.Error(), "filter list 3: duplicate list id")
+
+	for _, host := range []string{"host1", "host3"} {
+		var res Result
+		res, err = d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered, host)
+	}
+
+	res, err := d.CheckHost("host4", dns.TypeA, &setts)
+	require.NoError(t, err)
+
+	assert.False(t, res.IsFiltered)
+}
+
+func TestDNSFilter_CheckHost_localDNSSEC(t *testing.T) {
+	d := newForTest(t, &Config{
+		LocalDomains: []string{"lan", "Corp.Example."},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name        string
+		host        string
+		qtype       uint16
+		wantRefused bool
+	}{{
+		name:        "dnskey_local",
+		host:        "lan",
+		qtype:       dns.TypeDNSKEY,
+		wantRefused: true,
+	}, {
+		name:        "ds_local_subdomain",
+		host:        "printer.lan",
+		qtype:       dns.TypeDS,
+		wantRefused: true,
+	}, {
+		name:        "dnskey_local_case",
+		host:        "host.CORP.example",
+		qtype:       dns.TypeDNSKEY,
+		wantRefused: true,
+	}, {
+		name:        "a_local",
+		host:        "printer.lan",
+		qtype:       dns.TypeA,
+		wantRefused: false,
+	}, {
+		name:        "dnskey_public",
+		host:        "example.com",
+		qtype:       dns.TypeDNSKEY,
+		wantRefused: false,
+	}, {
+		name:        "ds_similar_suffix",
+		host:        "notlan",
+		qtype:       dns.TypeDS,
+		wantRefused: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, err)
+
+			if !tc.wantRefused {
+				assert.Equal(t, NotFilteredNotFound, res.Reason)
+
+				return
+			}
+
+			assert.Equal(t, RewrittenRule, res.Reason)
+			assert.False(t, res.IsFiltered)
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, dns.RcodeRefused, res.DNSRewriteResult.RCode)
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_modifiers(t *testing.T) {
+	const text = `
+||important.example^$important,dnstype=A|AAAA,client=127.0.0.1
+||plain.example^
+0.0.0.0 hosts.example
+@@||allowed.example^$important
+`
+
+	d := newForTest(t, nil, []Filter{{ID: 1, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name string
+		host string
+		want []string
+	}{{
+		name: "several",
+		host: "important.example",
+		want: []string{"important", "dnstype=A|AAAA", "client=127.0.0.1"},
+	}, {
+		name: "none",
+		host: "plain.example",
+		want: nil,
+	}, {
+		name: "hosts",
+		host: "hosts.example",
+		want: nil,
+	}, {
+		name: "allowlist",
+		host: "allowed.example",
+		want: []string{"important"},
+	}}
+
+	s := setts
+	s.ClientIP = net.IP{127, 0, 0, 1}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.want, res.Rules[0].Modifiers)
+		})
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		assert.Equal(t, []string{"client='Frank\\'s laptop'"}, ruleModifiers(
+			"||escaped.example^$client='Frank\\'s laptop'",
+		))
+		assert.Equal(t, []string{"replace=/a,b/c/", "important"}, ruleModifiers(
+			"/path/$replace=/a\\,b/c/,important",
+		))
+		assert.Nil(t, ruleModifiers("/regex$/"))
+		assert.Nil(t, ruleModifiers("||host.example^"))
+	})
+}
+
+func TestDNSFilter_SetFilters_normalize(t *testing.T) {
+	const rulesText = "\xef\xbb\xbf||first.example^\r\n||second.example^\r\n0.0.0.0 third.example\r\n||fourth.example^\r||fifth.example^"
+
+	hosts := []string{
+		"first.example",
+		"second.example",
+		"third.example",
+		"fourth.example",
+		"fifth.example",
+	}
+
+	filePath := filepath.Join(t.TempDir(), "filter.txt")
+	err := os.WriteFile(filePath, []byte(rulesText), 0o644)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		filter Filter
+	}{{
+		name:   "data",
+		filter: Filter{ID: 1, Data: []byte(rulesText)},
+	}, {
+		name:   "file",
+		filter: Filter{ID: 1, FilePath: filePath},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, nil, []Filter{tc.filter})
+			t.Cleanup(d.Close)
+
+			for _, host := range hosts {
+				d.checkMatch(t, host)
+			}
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_listIDs(t *testing.T) {
+	d := newForTestRules(t, []string{
+		"||blocked.example^",
+		"0.0.0.0 hosts.example",
+		"@@||exception.blocked.example^",
+	}, []string{
+		"||allowed.blocked.example^",
+	})
+
+	s := &Settings{
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantRule   string
+		wantReason Reason
+		wantListID int64
+	}{{
+		name:       "blocked",
+		host:       "sub.blocked.example",
+		wantRule:   "||blocked.example^",
+		wantReason: FilteredBlockList,
+		wantListID: testBlockListID,
+	}, {
+		name:       "hosts",
+		host:       "hosts.example",
+		wantRule:   "0.0.0.0 hosts.example",
+		wantReason: FilteredBlockList,
+		wantListID: testBlockListID,
+	}, {
+		name:       "exception",
+		host:       "exception.blocked.example",
+		wantRule:   "@@||exception.blocked.example^",
+		wantReason: NotFilteredAllowList,
+		wantListID: testBlockListID,
+	}, {
+		name:       "allowlist",
+		host:       "allowed.blocked.example",
+		wantRule:   "||allowed.blocked.example^",
+		wantReason: NotFilteredAllowList,
+		wantListID: testAllowListID,
+	}, {
+		name:       "not_found",
+		host:       "other.example",
+		wantRule:   "",
+		wantReason: NotFilteredNotFound,
+		wantListID: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantRule == "" {
+				assert.Empty(t, res.Rules)
+
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		ed := newForTestRules(t, nil, nil)
+
+		res, err := ed.CheckHost("blocked.example", dns.TypeA, s)
+		require.NoError(t, err)
+
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+	})
+}
+
 // Client Settings.
 
 func applyClientSettings(setts *Settings) {
@@ -831,6 +1486,84 @@ func TestClientSettings(t *testing.T) {
 	}
 }
 
+func TestDNSFilter_CheckHost_blockedServiceResponse(t *testing.T) {
+	rule, err := rules.NewNetworkRule("||facebook.com^", BlockedSvcsListID)
+	require.NoError(t, err)
+
+	s := Settings{
+		ProtectionEnabled: true,
+		ServicesRules: []ServiceEntry{{
+			Name:  "facebook",
+			Rules: []*rules.NetworkRule{rule},
+		}},
+	}
+
+	testCases := []struct {
+		want  *DNSRewriteResult
+		name  string
+		resp  string
+		qtype uint16
+	}{{
+		want:  nil,
+		name:  "default",
+		resp:  BlockedSvcRespDefault,
+		qtype: dns.TypeA,
+	}, {
+		want:  &DNSRewriteResult{RCode: dns.RcodeNameError},
+		name:  "nxdomain",
+		resp:  BlockedSvcRespNXDomain,
+		qtype: dns.TypeA,
+	}, {
+		want: &DNSRewriteResult{
+			RCode:    dns.RcodeSuccess,
+			Response: DNSRewriteResultResponse{},
+		},
+		name:  "nodata",
+		resp:  BlockedSvcRespNoData,
+		qtype: dns.TypeA,
+	}, {
+		want: &DNSRewriteResult{
+			RCode: dns.RcodeSuccess,
+			Response: DNSRewriteResultResponse{
+				dns.TypeA: []rules.RRValue{net.IP{1, 2, 3, 4}},
+			},
+		},
+		name:  "sink_ipv4",
+		resp:  "1.2.3.4",
+		qtype: dns.TypeA,
+	}, {
+		want: &DNSRewriteResult{
+			RCode: dns.RcodeSuccess,
+			Response: DNSRewriteResultResponse{
+				dns.TypeAAAA: []rules.RRValue{net.ParseIP("::1")},
+			},
+		},
+		name:  "sink_ipv6",
+		resp:  "::1",
+		qtype: dns.TypeAAAA,
+	}, {
+		want:  nil,
+		name:  "bad",
+		resp:  "bad",
+		qtype: dns.TypeA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{BlockedServiceResponse: tc.resp}, nil)
+			t.Cleanup(d.Close)
+
+			res, cerr := d.CheckHost("www.facebook.com", tc.qtype, &s)
+			require.NoError(t, cerr)
+
+			assert.True(t, res.IsFiltered)
+			assert.Equal(t, FilteredBlockedService, res.Reason)
+			assert.Equal(t, "facebook", res.ServiceName)
+			assert.Equal(t, tc.want, res.DNSRewriteResult)
+		})
+	}
+}
+
 // Benchmarks.
 
 func BenchmarkSafeBrowsing(b *testing.B) {
@@ -0,0 +1,12 @@
+package filtering
+
+import "net"
+
+// GeoIP is the interface for the databases determining the countries of the
+// IP addresses, see Config.GeoIP.
+type GeoIP interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the country of ip, like
+	// "DE".  ok is false if the country is unknown.  It must be safe for
+	// concurrent use.
+	Country(ip net.IP) (code string, ok bool)
+}
@@ -0,0 +1,222 @@
+package filtering
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+)
+
+// graceKey is the key of a removed filter list kept during the grace period.
+type graceKey struct {
+	id    int64
+	allow bool
+}
+
+// graceList is a removed filter list which is still used until the grace
+// period ends, see Config.RemovedListsGrace.
+type graceList struct {
+	until  time.Time
+	filter Filter
+}
+
+// withGraceLists returns the filters to build the engines from, which are
+// block and allow with the recently removed lists added, if the grace period
+// is configured.  It also schedules the rebuilding of the engines once the
+// earliest grace period ends.  d.exceptionsLock is expected to be locked.
+func (d *DNSFilter) withGraceLists(block, allow []Filter) (withBlock, withAllow []Filter) {
+	d.confLock.RLock()
+	grace := time.Duration(d.RemovedListsGrace) * time.Second
+	d.confLock.RUnlock()
+
+	if grace == 0 && len(d.graceLists) == 0 {
+		return block, allow
+	}
+
+	now := d.now()
+	if d.graceLists == nil {
+		d.graceLists = map[graceKey]graceList{}
+	}
+
+	if grace > 0 {
+		// Keep the engine lock while adding the lists, so that the
+		// storages they're read from aren't closed.
+		d.engineLock.RLock()
+		d.addGraceLists(d.blockFilters, block, d.rulesStorage, false, now.Add(grace))
+		d.addGraceLists(d.allowFilters, allow, d.rulesStorageAllow, true, now.Add(grace))
+		d.engineLock.RUnlock()
+	}
+
+	ids := map[graceKey]struct{}{}
+	for _, f := range block {
+		ids[graceKey{id: f.ID, allow: false}] = struct{}{}
+	}
+
+	for _, f := range allow {
+		ids[graceKey{id: f.ID, allow: true}] = struct{}{}
+	}
+
+	var keys []graceKey
+	var earliest time.Time
+	for k, gl := range d.graceLists {
+		if _, ok := ids[k]; ok {
+			// The list is back.
+			delete(d.graceLists, k)
+
+			continue
+		} else if !gl.until.After(now) {
+			log.Debug("filtering: grace period for removed list %d ended", k.id)
+			delete(d.graceLists, k)
+
+			continue
+		}
+
+		keys = append(keys, k)
+		if earliest.IsZero() || gl.until.Before(earliest) {
+			earliest = gl.until
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) (less bool) { return keys[i].id < keys[j].id })
+
+	withBlock, withAllow = block, allow
+	for _, k := range keys {
+		f := d.graceLists[k].filter
+		if k.allow {
+			withAllow = append(withAllow[:len(withAllow):len(withAllow)], f)
+		} else {
+			withBlock = append(withBlock[:len(withBlock):len(withBlock)], f)
+		}
+	}
+
+	if !earliest.IsZero() {
+		d.scheduleGraceEnd(earliest.Sub(now))
+	}
+
+	return withBlock, withAllow
+}
+
+// addGraceLists adds the lists from prev which are absent from next to the
+// ones used until the time until.  The lists already kept aren't prolonged.
+// The contents of the lists are taken from rs, which the engines were built
+// from, since the files of the removed lists may be renamed or deleted by
+// then.  d.exceptionsLock is expected to be locked, and d.engineLock is
+// expected to be locked for reading.
+func (d *DNSFilter) addGraceLists(
+	prev []Filter,
+	next []Filter,
+	rs *filterlist.RuleStorage,
+	allow bool,
+	until time.Time,
+) {
+	ids := map[int64]struct{}{}
+	for _, f := range next {
+		ids[f.ID] = struct{}{}
+	}
+
+	for _, f := range prev {
+		k := graceKey{id: f.ID, allow: allow}
+		if _, ok := ids[f.ID]; ok {
+			continue
+		} else if _, ok = d.graceLists[k]; ok {
+			continue
+		}
+
+		if len(f.Data) == 0 && f.FilePath != "" {
+			data, err := storedListData(rs, f.ID)
+			if err != nil {
+				log.Debug("filtering: keeping removed list %d by path: %s", f.ID, err)
+			} else {
+				f.Data, f.FilePath = data, ""
+			}
+		}
+
+		log.Debug("filtering: keeping removed list %d until %s", f.ID, until)
+		d.graceLists[k] = graceList{
+			until:  until,
+			filter: f,
+		}
+	}
+}
+
+// errNotStored is returned by storedListData when the list isn't in the
+// storage.
+const errNotStored errors.Error = "list is not in the storage"
+
+// storedListData returns the rules text of the list with id from rs.  The
+// file-based lists are read from their already opened files with ReadAt, so
+// that the file offset used by the engines isn't moved.  rs may be nil.
+func storedListData(rs *filterlist.RuleStorage, id int64) (data []byte, err error) {
+	if rs == nil {
+		return nil, errNotStored
+	}
+
+	for _, l := range rs.Lists {
+		if int64(l.GetID()) != id {
+			continue
+		}
+
+		switch l := l.(type) {
+		case *filterlist.StringRuleList:
+			return []byte(l.RulesText), nil
+		case *filterlist.FileRuleList:
+			var fi fs.FileInfo
+			fi, err = l.File.Stat()
+			if err != nil {
+				return nil, fmt.Errorf("reading list %d: %w", id, err)
+			}
+
+			data = make([]byte, fi.Size())
+			_, err = io.ReadFull(io.NewSectionReader(l.File, 0, fi.Size()), data)
+			if err != nil {
+				return nil, fmt.Errorf("reading list %d: %w", id, err)
+			}
+
+			return data, nil
+		default:
+			return nil, fmt.Errorf("list %d: unsupported type %T", id, l)
+		}
+	}
+
+	return nil, errNotStored
+}
+
+// scheduleGraceEnd schedules the asynchronous rebuilding of the engines after
+// dur to drop the lists with the ended grace period.  d.exceptionsLock is
+// expected to be locked.
+func (d *DNSFilter) scheduleGraceEnd(dur time.Duration) {
+	if d.graceTimer != nil {
+		d.graceTimer.Stop()
+	}
+
+	d.graceTimer = time.AfterFunc(dur, d.refreshFilters)
+}
+
+// refreshFilters asynchronously rebuilds the engines from the current filter
+// lists, unless there is a pending update already.
+func (d *DNSFilter) refreshFilters() {
+	d.filtersInitializerLock.Lock()
+	defer d.filtersInitializerLock.Unlock()
+
+	select {
+	case d.filtersInitializerChan <- filtersInitializerParams{refresh: true}:
+		// Go on.
+	default:
+		// The pending update rebuilds the engines anyway.
+	}
+}
+
+// stopGraceTimer stops the scheduled rebuilding of the engines, if any.
+func (d *DNSFilter) stopGraceTimer() {
+	d.exceptionsLock.Lock()
+	defer d.exceptionsLock.Unlock()
+
+	if d.graceTimer != nil {
+		d.graceTimer.Stop()
+	}
+}
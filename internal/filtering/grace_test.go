@@ -0,0 +1,161 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_SetFilters_removedListsGrace(t *testing.T) {
+	listA := Filter{ID: 1, Data: []byte("||a.example^\n")}
+	listB := Filter{ID: 2, Data: []byte("||b.example^\n")}
+	allowB := Filter{ID: 3, Data: []byte("@@||allowed.b.example^\n")}
+
+	isBlocked := func(t *testing.T, d *DNSFilter, host string) (ok bool) {
+		t.Helper()
+
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		return res.IsFiltered
+	}
+
+	// newFilter returns a filter with both lists and the clock set to the
+	// returned pointer.
+	newFilter := func(t *testing.T, grace uint) (d *DNSFilter, now *time.Time) {
+		t.Helper()
+
+		d = newForTest(t, &Config{RemovedListsGrace: grace}, nil)
+		t.Cleanup(d.Close)
+
+		now = &time.Time{}
+		*now = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		d.now = func() (t time.Time) { return *now }
+
+		err := d.SetFilters([]Filter{listA, listB}, []Filter{allowB}, false)
+		require.NoError(t, err)
+
+		require.True(t, isBlocked(t, d, "b.example"))
+		require.False(t, isBlocked(t, d, "allowed.b.example"))
+
+		return d, now
+	}
+
+	t.Run("grace", func(t *testing.T) {
+		d, now := newFilter(t, 60)
+
+		err := d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, isBlocked(t, d, "a.example"))
+		assert.True(t, isBlocked(t, d, "b.example"))
+		assert.False(t, isBlocked(t, d, "allowed.b.example"))
+
+		// Setting the filters again doesn't prolong the period.
+		*now = now.Add(30 * time.Second)
+		err = d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, isBlocked(t, d, "b.example"))
+
+		*now = now.Add(31 * time.Second)
+		err = d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, isBlocked(t, d, "a.example"))
+		assert.False(t, isBlocked(t, d, "b.example"))
+	})
+
+	t.Run("async_refresh", func(t *testing.T) {
+		d, now := newFilter(t, 60)
+		d.Start()
+
+		err := d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		require.True(t, isBlocked(t, d, "b.example"))
+
+		// Emulate the timer firing after the period has ended.
+		*now = now.Add(time.Minute)
+		d.refreshFilters()
+
+		assert.Eventually(t, func() (ok bool) {
+			return !isBlocked(t, d, "b.example")
+		}, time.Second, 10*time.Millisecond)
+		assert.True(t, isBlocked(t, d, "a.example"))
+	})
+
+	t.Run("readded", func(t *testing.T) {
+		d, now := newFilter(t, 60)
+
+		err := d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		err = d.SetFilters([]Filter{listA, listB}, []Filter{allowB}, false)
+		require.NoError(t, err)
+
+		d.exceptionsLock.Lock()
+		assert.Empty(t, d.graceLists)
+		d.exceptionsLock.Unlock()
+
+		// Removing it again starts a new period.
+		*now = now.Add(50 * time.Second)
+		err = d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		*now = now.Add(50 * time.Second)
+		err = d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, isBlocked(t, d, "b.example"))
+	})
+
+	t.Run("no_grace", func(t *testing.T) {
+		d, _ := newFilter(t, 0)
+
+		err := d.SetFilters([]Filter{listA}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, isBlocked(t, d, "a.example"))
+		assert.False(t, isBlocked(t, d, "b.example"))
+	})
+
+	t.Run("removed_file", func(t *testing.T) {
+		d, now := newFilter(t, 60)
+
+		dir := t.TempDir()
+		pathA, pathB := filepath.Join(dir, "1.txt"), filepath.Join(dir, "2.txt")
+		for path, data := range map[string][]byte{pathA: listA.Data, pathB: listB.Data} {
+			err := os.WriteFile(path, data, 0o644)
+			require.NoError(t, err)
+		}
+
+		fileA := Filter{ID: listA.ID, FilePath: pathA}
+		fileB := Filter{ID: listB.ID, FilePath: pathB}
+
+		err := d.SetFilters([]Filter{fileA, fileB}, nil, false)
+		require.NoError(t, err)
+		require.True(t, isBlocked(t, d, "b.example"))
+
+		// Removing a list renames its file before the engines are rebuilt.
+		err = os.Rename(pathB, pathB+".old")
+		require.NoError(t, err)
+
+		err = d.SetFilters([]Filter{fileA}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, isBlocked(t, d, "a.example"))
+		assert.True(t, isBlocked(t, d, "b.example"))
+
+		*now = now.Add(time.Minute)
+		err = d.SetFilters([]Filter{fileA}, nil, false)
+		require.NoError(t, err)
+
+		assert.False(t, isBlocked(t, d, "b.example"))
+	})
+}
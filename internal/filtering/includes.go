@@ -0,0 +1,94 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// includeDirective is the directive including another list into an Adblock
+// Plus style subscription, like "!#include common.txt".
+const includeDirective = "!#include"
+
+// errIncludeCycle is returned when a list includes itself, directly or through
+// the other lists.
+const errIncludeCycle errors.Error = "include cycle"
+
+// ExpandIncludes returns data with the "!#include" directives replaced by the
+// contents of the included lists, so that the result can be loaded as a single
+// list.  resolve returns the contents of the list by the name from the
+// directive.  The includes of the included lists are expanded as well.  It
+// returns an error wrapping errIncludeCycle if a list includes itself.
+func ExpandIncludes(
+	data []byte,
+	resolve func(name string) (data []byte, err error),
+) (expanded []byte, err error) {
+	buf := &bytes.Buffer{}
+	err = expandIncludes(buf, data, resolve, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// expandIncludes writes data with the includes expanded into buf.  stack are
+// the names of the lists being expanded, from the outermost one.
+func expandIncludes(
+	buf *bytes.Buffer,
+	data []byte,
+	resolve func(name string) (data []byte, err error),
+	stack []string,
+) (err error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		name, ok := includeName(line)
+		if !ok {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+
+			continue
+		}
+
+		if stringutil.InSlice(stack, name) {
+			return fmt.Errorf("line %d: %q: %w", n, name, errIncludeCycle)
+		}
+
+		var inc []byte
+		inc, err = resolve(name)
+		if err != nil {
+			return fmt.Errorf("line %d: including %q: %w", n, name, err)
+		}
+
+		err = expandIncludes(buf, inc, resolve, append(stack[:len(stack):len(stack)], name))
+		if err != nil {
+			return fmt.Errorf("line %d: %q: %w", n, name, err)
+		}
+	}
+
+	return s.Err()
+}
+
+// includeName returns the name of the included list if line is an include
+// directive.
+func includeName(line string) (name string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, includeDirective) {
+		return "", false
+	}
+
+	rest := line[len(includeDirective):]
+	name = strings.TrimSpace(rest)
+	if name == "" || name == rest {
+		// Either no name at all or something like "!#includes", which is
+		// just a comment.
+		return "", false
+	}
+
+	return name, true
+}
@@ -0,0 +1,75 @@
+package filtering
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandIncludes(t *testing.T) {
+	lists := map[string]string{
+		"common.txt":  "||common.example^\n!#include nested.txt\n",
+		"nested.txt":  "||nested.example^\n",
+		"cycle_a.txt": "||a.example^\n!#include cycle_b.txt\n",
+		"cycle_b.txt": "!#include cycle_a.txt\n",
+		"self.txt":    "!#include self.txt\n",
+	}
+
+	resolve := func(name string) (data []byte, err error) {
+		l, ok := lists[name]
+		if !ok {
+			return nil, fmt.Errorf("no list %q", name)
+		}
+
+		return []byte(l), nil
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		data, err := ExpandIncludes([]byte(
+			"! Title: Main\n||main.example^\n  !#include  common.txt \n!#includes\n",
+		), resolve)
+		require.NoError(t, err)
+
+		assert.Equal(t, "! Title: Main\n"+
+			"||main.example^\n"+
+			"||common.example^\n"+
+			"||nested.example^\n"+
+			"!#includes\n", string(data))
+
+		d := newForTest(t, nil, []Filter{{ID: 1, Data: data}})
+		t.Cleanup(d.Close)
+
+		for _, host := range []string{"main.example", "common.example", "nested.example"} {
+			res, cErr := d.CheckHost(host, dns.TypeA, &setts)
+			require.NoError(t, cErr)
+
+			assert.True(t, res.IsFiltered, host)
+		}
+	})
+
+	t.Run("same_twice", func(t *testing.T) {
+		data, err := ExpandIncludes([]byte(
+			"!#include nested.txt\n!#include nested.txt\n",
+		), resolve)
+		require.NoError(t, err)
+
+		assert.Equal(t, "||nested.example^\n||nested.example^\n", string(data))
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, err := ExpandIncludes([]byte("!#include cycle_a.txt\n"), resolve)
+		assert.ErrorIs(t, err, errIncludeCycle)
+
+		_, err = ExpandIncludes([]byte("!#include self.txt\n"), resolve)
+		assert.ErrorIs(t, err, errIncludeCycle)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := ExpandIncludes([]byte("!#include unknown.txt\n"), resolve)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, errIncludeCycle)
+	})
+}
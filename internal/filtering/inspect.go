@@ -0,0 +1,80 @@
+package filtering
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// InvalidLine is a line of a filtering rule list which can't be parsed.
+type InvalidLine struct {
+	// Text is the line without the surrounding spaces.
+	Text string `json:"text"`
+	// Reason is the description of the parsing error.
+	Reason string `json:"reason"`
+	// Line is the 1-based number of the line.
+	Line int `json:"line"`
+}
+
+// ListInspection is the summary of a filtering rule list.  Each line is counted
+// in exactly one of Empty, Comments, Valid, Duplicates, and Invalid, so that
+// their sum is Total.
+type ListInspection struct {
+	// Invalid are the lines which can't be parsed.
+	Invalid []InvalidLine `json:"invalid"`
+	// Total is the number of lines.
+	Total int `json:"total"`
+	// Empty is the number of the lines containing only spaces.
+	Empty int `json:"empty"`
+	// Comments is the number of the comment lines.
+	Comments int `json:"comments"`
+	// Valid is the number of the unique valid rules, including the cosmetic
+	// ones.
+	Valid int `json:"valid"`
+	// Duplicates is the number of the valid rules which have already been met
+	// in the list.
+	Duplicates int `json:"duplicates"`
+}
+
+// InspectList parses the filtering rule list data and returns its summary.  It
+// doesn't change the filters of d.
+func (d *DNSFilter) InspectList(data []byte) (li ListInspection) {
+	lines := bytes.Split(data, []byte("\n"))
+	if n := len(lines); n > 0 && len(lines[n-1]) == 0 {
+		// Don't count the line after the trailing newline.
+		lines = lines[:n-1]
+	}
+
+	seen := stringutil.NewSet()
+	for i, l := range lines {
+		li.Total++
+
+		text := strings.TrimSpace(string(l))
+		if text == "" {
+			li.Empty++
+
+			continue
+		}
+
+		r, err := rules.NewRule(text, CustomListID)
+		switch {
+		case err != nil:
+			li.Invalid = append(li.Invalid, InvalidLine{
+				Text:   text,
+				Reason: err.Error(),
+				Line:   i + 1,
+			})
+		case r == nil:
+			li.Comments++
+		case seen.Has(text):
+			li.Duplicates++
+		default:
+			seen.Add(text)
+			li.Valid++
+		}
+	}
+
+	return li
+}
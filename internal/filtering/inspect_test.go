@@ -0,0 +1,61 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_InspectList(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	t.Run("representative", func(t *testing.T) {
+		const data = "! Title: Test list\r\n" +
+			"# Hosts-style comment\n" +
+			"||example.org^\n" +
+			"\n" +
+			"0.0.0.0 example.com\n" +
+			"  ||example.org^  \n" +
+			"||example.net^$badmodifier\n" +
+			"example.com##.banner\n" +
+			"@@||example.org^$important\n" +
+			"0.0.0.0 example.com\n" +
+			"||example.info^$dnsrewrite=bad;rr;value\n"
+
+		li := d.InspectList([]byte(data))
+
+		assert.Equal(t, 11, li.Total)
+		assert.Equal(t, 1, li.Empty)
+		assert.Equal(t, 2, li.Comments)
+		assert.Equal(t, 4, li.Valid)
+		assert.Equal(t, 2, li.Duplicates)
+
+		require.Len(t, li.Invalid, 2)
+
+		assert.Equal(t, 7, li.Invalid[0].Line)
+		assert.Equal(t, "||example.net^$badmodifier", li.Invalid[0].Text)
+		assert.NotEmpty(t, li.Invalid[0].Reason)
+
+		assert.Equal(t, 11, li.Invalid[1].Line)
+		assert.NotEmpty(t, li.Invalid[1].Reason)
+
+		assert.Equal(
+			t,
+			li.Total,
+			li.Empty+li.Comments+li.Valid+li.Duplicates+len(li.Invalid),
+		)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Equal(t, ListInspection{}, d.InspectList(nil))
+	})
+
+	t.Run("no_trailing_newline", func(t *testing.T) {
+		li := d.InspectList([]byte("||example.org^\n||example.com^"))
+
+		assert.Equal(t, 2, li.Total)
+		assert.Equal(t, 2, li.Valid)
+	})
+}
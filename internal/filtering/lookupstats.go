@@ -0,0 +1,117 @@
+package filtering
+
+import "sync/atomic"
+
+// cacheHit counts the lookup answered from the cache.  s may be nil.
+func (s *LookupStats) cacheHit() {
+	if s != nil {
+		atomic.AddUint64(&s.CacheHits, 1)
+	}
+}
+
+// startRequest counts the request to the service and marks it as pending
+// until done is called.  s may be nil.
+func (s *LookupStats) startRequest() (done func()) {
+	if s == nil {
+		return func() {}
+	}
+
+	atomic.AddUint64(&s.Requests, 1)
+	pending := atomic.AddInt64(&s.Pending, 1)
+	for {
+		prevMax := atomic.LoadInt64(&s.PendingMax)
+		if pending <= prevMax || atomic.CompareAndSwapInt64(&s.PendingMax, prevMax, pending) {
+			break
+		}
+	}
+
+	return func() { atomic.AddInt64(&s.Pending, -1) }
+}
+
+// snapshot returns the copy of s with each field loaded atomically.
+func (s *LookupStats) snapshot() (c LookupStats) {
+	return LookupStats{
+		Requests:   atomic.LoadUint64(&s.Requests),
+		CacheHits:  atomic.LoadUint64(&s.CacheHits),
+		Pending:    atomic.LoadInt64(&s.Pending),
+		PendingMax: atomic.LoadInt64(&s.PendingMax),
+	}
+}
+
+// CollectStats returns the current counters of the safe browsing, parental
+// control, and safe search lookups.  It doesn't lock, each of the counters is
+// loaded atomically, so the snapshot may mix the values from the concurrent
+// lookups.
+func (d *DNSFilter) CollectStats() (s Stats) {
+	return Stats{
+		Safebrowsing: d.stats.Safebrowsing.snapshot(),
+		Parental:     d.stats.Parental.snapshot(),
+		Safesearch:   d.stats.Safesearch.snapshot(),
+	}
+}
+
+// Metric is a single lookup counter of DNSFilter, see RegisterMetrics.
+type Metric struct {
+	// Value returns the current value of the metric.  It's safe for
+	// concurrent use.
+	Value func() (v float64)
+
+	// Name is the name of the metric in the Prometheus style, like
+	// "safebrowsing_cache_hits_total".
+	Name string
+
+	// Help is the description of the metric.
+	Help string
+
+	// Gauge is true if the value may decrease, like the number of the
+	// pending requests.  Otherwise, the metric is a counter.
+	Gauge bool
+}
+
+// RegisterMetrics calls register with each of the lookup counters, see
+// CollectStats.  It's intended to expose those using a metrics library, for
+// example with the prometheus.NewCounterFunc and prometheus.NewGaugeFunc
+// collectors, without making the package depend on it.
+func (d *DNSFilter) RegisterMetrics(register func(m Metric)) {
+	for _, svc := range []struct {
+		stats *LookupStats
+		name  string
+		desc  string
+	}{{
+		stats: &d.stats.Safebrowsing,
+		name:  "safebrowsing",
+		desc:  "safe browsing",
+	}, {
+		stats: &d.stats.Parental,
+		name:  "parental",
+		desc:  "parental control",
+	}, {
+		stats: &d.stats.Safesearch,
+		name:  "safesearch",
+		desc:  "safe search",
+	}} {
+		s := svc.stats
+		register(Metric{
+			Value: func() (v float64) { return float64(atomic.LoadUint64(&s.Requests)) },
+			Name:  svc.name + "_requests_total",
+			Help:  "The number of the " + svc.desc + " requests sent.",
+		})
+		register(Metric{
+			Value: func() (v float64) { return float64(atomic.LoadUint64(&s.CacheHits)) },
+			Name:  svc.name + "_cache_hits_total",
+			Help:  "The number of the " + svc.desc + " lookups answered from the cache.",
+		})
+		register(Metric{
+			Value: func() (v float64) { return float64(atomic.LoadInt64(&s.Pending)) },
+			Name:  svc.name + "_pending",
+			Help:  "The number of the pending " + svc.desc + " requests.",
+			Gauge: true,
+		})
+		register(Metric{
+			Value: func() (v float64) { return float64(atomic.LoadInt64(&s.PendingMax)) },
+			Name:  svc.name + "_pending_max",
+			Help:  "The maximum number of the pending " + svc.desc + " requests.",
+			Gauge: true,
+		})
+	}
+}
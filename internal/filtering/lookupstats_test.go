@@ -0,0 +1,158 @@
+package filtering
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testExporter is a sample exporter of the lookup metrics writing those in the
+// Prometheus text format.
+type testExporter struct {
+	mu      sync.Mutex
+	metrics []Metric
+}
+
+// register implements the register function for DNSFilter.RegisterMetrics.
+func (e *testExporter) register(m Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.metrics = append(e.metrics, m)
+}
+
+// values returns the current values of the registered metrics by their names.
+func (e *testExporter) values() (vals map[string]float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	vals = make(map[string]float64, len(e.metrics))
+	for _, m := range e.metrics {
+		vals[m.Name] = m.Value()
+	}
+
+	return vals
+}
+
+// text returns the metrics in the Prometheus text format.
+func (e *testExporter) text() (s string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	b := &strings.Builder{}
+	for _, m := range e.metrics {
+		typ := "counter"
+		if m.Gauge {
+			typ = "gauge"
+		}
+
+		_, _ = fmt.Fprintf(b, "# HELP %s %s\n", m.Name, m.Help)
+		_, _ = fmt.Fprintf(b, "# TYPE %s %s\n", m.Name, typ)
+		_, _ = fmt.Fprintf(b, "%s %g\n", m.Name, m.Value())
+	}
+
+	return b.String()
+}
+
+func TestDNSFilter_CollectStats(t *testing.T) {
+	d := newForTest(t, &Config{
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+		SafeSearchEnabled:   true,
+		CustomResolver:      &aghtest.TestResolver{},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	const hostname = "example.org"
+
+	d.SetSafeBrowsingUpstream(&aghtest.TestBlockUpstream{Hostname: hostname, Block: true})
+	d.SetParentalUpstream(&aghtest.TestBlockUpstream{Hostname: hostname, Block: false})
+
+	exp := &testExporter{}
+	d.RegisterMetrics(exp.register)
+
+	names := make([]string, 0, len(exp.metrics))
+	for _, m := range exp.metrics {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+
+	assert.Equal(t, []string{
+		"parental_cache_hits_total",
+		"parental_pending",
+		"parental_pending_max",
+		"parental_requests_total",
+		"safebrowsing_cache_hits_total",
+		"safebrowsing_pending",
+		"safebrowsing_pending_max",
+		"safebrowsing_requests_total",
+		"safesearch_cache_hits_total",
+		"safesearch_pending",
+		"safesearch_pending_max",
+		"safesearch_requests_total",
+	}, names)
+
+	assert.Equal(t, Stats{}, d.CollectStats())
+
+	s := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+		SafeSearchEnabled:   true,
+	}
+
+	for i := 0; i < 3; i++ {
+		res, err := d.checkSafeBrowsing(hostname, dns.TypeA, s)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+
+		res, err = d.checkParental(hostname, dns.TypeA, s)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := d.checkSafeSearch("www.google.com", dns.TypeA, s)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+	}
+
+	// The first lookups are sent, the rest are answered from the cache.
+	want := LookupStats{
+		Requests:   1,
+		CacheHits:  2,
+		Pending:    0,
+		PendingMax: 1,
+	}
+	assert.Equal(t, Stats{
+		Safebrowsing: want,
+		Parental:     want,
+		Safesearch: LookupStats{
+			Requests:   1,
+			CacheHits:  1,
+			Pending:    0,
+			PendingMax: 1,
+		},
+	}, d.CollectStats())
+
+	vals := exp.values()
+	assert.Equal(t, float64(1), vals["safebrowsing_requests_total"])
+	assert.Equal(t, float64(2), vals["parental_cache_hits_total"])
+	assert.Equal(t, float64(0), vals["safesearch_pending"])
+	assert.Equal(t, float64(1), vals["safesearch_pending_max"])
+
+	text := exp.text()
+	assert.Contains(t, text, "# TYPE safebrowsing_cache_hits_total counter\n")
+	assert.Contains(t, text, "# TYPE parental_pending gauge\n")
+	assert.Contains(t, text, "\nsafebrowsing_cache_hits_total 2\n")
+}
@@ -0,0 +1,100 @@
+package filtering
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// MinimizeRules returns the sorted minimal set of the blocking rules, like
+// "||example.org^", blocking all the hosts.  The hosts covered by the other
+// ones, like "a.example.org" by "example.org", are always dropped.
+//
+// minSiblings controls the aggressiveness of merging: if there are at least
+// minSiblings hosts with the same parent domain, they are replaced with the
+// parent, which blocks all its other subdomains as well.  It's only safe when
+// all the subdomains of the parent are meant to be blocked, so the public
+// suffixes, like "com" or "co.uk", are never used as parents, see
+// Config.PublicSuffixList.  Zero disables merging.
+func (d *DNSFilter) MinimizeRules(hosts []string, minSiblings int) (rules []string) {
+	set := stringutil.NewSet()
+	for _, h := range hosts {
+		h = strings.ToLower(strings.Trim(strings.TrimSpace(h), "."))
+		if h != "" {
+			set.Add(h)
+		}
+	}
+
+	for {
+		removeCovered(set)
+
+		if minSiblings <= 0 || !mergeSiblings(set, minSiblings, d.psl) {
+			break
+		}
+	}
+
+	rules = make([]string, 0, set.Len())
+	for _, h := range set.Values() {
+		rules = append(rules, "||"+h+"^")
+	}
+
+	sort.Strings(rules)
+
+	return rules
+}
+
+// removeCovered removes the hosts which are subdomains of the other hosts from
+// set.
+func removeCovered(set *stringutil.Set) {
+	for _, h := range set.Values() {
+		for p := parentDomain(h); p != ""; p = parentDomain(p) {
+			if set.Has(p) {
+				set.Del(h)
+
+				break
+			}
+		}
+	}
+}
+
+// mergeSiblings replaces the groups of at least minSiblings hosts with the
+// same parent domain in set with the parent.  The public suffixes from psl are
+// never used as parents.  It returns true if anything was merged.
+func mergeSiblings(set *stringutil.Set, minSiblings int, psl *suffixList) (merged bool) {
+	children := map[string][]string{}
+	for _, h := range set.Values() {
+		p := parentDomain(h)
+		if p == "" || psl.isPublicSuffix(p) {
+			continue
+		}
+
+		children[p] = append(children[p], h)
+	}
+
+	for p, hosts := range children {
+		if len(hosts) < minSiblings {
+			continue
+		}
+
+		for _, h := range hosts {
+			set.Del(h)
+		}
+
+		set.Add(p)
+		merged = true
+	}
+
+	return merged
+}
+
+// parentDomain returns the domain one level above host or an empty string if
+// host is a top-level domain.
+func parentDomain(host string) (p string) {
+	i := strings.IndexByte(host, '.')
+	if i < 0 {
+		return ""
+	}
+
+	return host[i+1:]
+}
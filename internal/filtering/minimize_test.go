@@ -0,0 +1,70 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSFilter_MinimizeRules(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name        string
+		hosts       []string
+		want        []string
+		minSiblings int
+	}{{
+		name:        "empty",
+		hosts:       nil,
+		want:        []string{},
+		minSiblings: 2,
+	}, {
+		name:        "normalize",
+		hosts:       []string{"Example.ORG.", " example.org ", "", "."},
+		want:        []string{"||example.org^"},
+		minSiblings: 0,
+	}, {
+		name:        "covered",
+		hosts:       []string{"a.ads.com", "ads.com", "b.c.ads.com", "example.org"},
+		want:        []string{"||ads.com^", "||example.org^"},
+		minSiblings: 0,
+	}, {
+		name:        "no_merge_disabled",
+		hosts:       []string{"a.ads.com", "b.ads.com"},
+		want:        []string{"||a.ads.com^", "||b.ads.com^"},
+		minSiblings: 0,
+	}, {
+		name:        "merge",
+		hosts:       []string{"a.ads.com", "b.ads.com"},
+		want:        []string{"||ads.com^"},
+		minSiblings: 2,
+	}, {
+		name:        "no_merge_too_few",
+		hosts:       []string{"a.ads.com", "b.ads.com"},
+		want:        []string{"||a.ads.com^", "||b.ads.com^"},
+		minSiblings: 3,
+	}, {
+		name:        "merge_partial",
+		hosts:       []string{"a.ads.com", "b.ads.com", "c.ads.com", "x.other.com"},
+		want:        []string{"||ads.com^", "||x.other.com^"},
+		minSiblings: 3,
+	}, {
+		name:        "merge_cascade",
+		hosts:       []string{"a.x.ads.com", "b.x.ads.com", "a.y.ads.com", "b.y.ads.com"},
+		want:        []string{"||ads.com^"},
+		minSiblings: 2,
+	}, {
+		name:        "no_merge_public_suffix",
+		hosts:       []string{"ads.com", "trackers.com", "example.co.uk", "test.co.uk"},
+		want:        []string{"||ads.com^", "||example.co.uk^", "||test.co.uk^", "||trackers.com^"},
+		minSiblings: 2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, d.MinimizeRules(tc.hosts, tc.minSiblings))
+		})
+	}
+}
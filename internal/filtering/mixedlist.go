@@ -0,0 +1,141 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// mixedListHeader is the header comment marking a blocklist as mixed, so that
+// its exception rules are used as the allowlist rules, as if those were in a
+// separate allowlist.  Otherwise, those only cancel the rules of the
+// blocklists and don't affect, for example, the safe search.
+const mixedListHeader = "! type: mixed"
+
+// splitMixedLists returns the filters with the mixed blocklists among block
+// split into the blocklists without the exception rules and the allowlists
+// with only those, see mixedListHeader.  Both parts keep the ID of the list.
+// The lists which can't be read are left as is, and err describes those.
+func splitMixedLists(block, allow []Filter) (splitBlock, splitAllow []Filter, err error) {
+	var errs []error
+
+	splitBlock, splitAllow = block, allow
+	copied := false
+	for i, f := range block {
+		var data []byte
+		data, err = mixedListData(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("filter list %d: %w", f.ID, err))
+
+			continue
+		} else if data == nil {
+			continue
+		}
+
+		if !copied {
+			// Don't modify the caller's slice.
+			splitBlock = append([]Filter(nil), block...)
+			copied = true
+		}
+
+		blockData, allowData := splitExceptions(data)
+		splitBlock[i] = Filter{ID: f.ID, Data: blockData}
+		splitAllow = append(
+			splitAllow[:len(splitAllow):len(splitAllow)],
+			Filter{ID: f.ID, Data: allowData},
+		)
+	}
+
+	if len(errs) > 0 {
+		return splitBlock, splitAllow, errors.List("splitting mixed lists", errs...)
+	}
+
+	return splitBlock, splitAllow, nil
+}
+
+// mixedListData returns the content of the list if it's a mixed one.  data is
+// nil if it isn't.
+func mixedListData(f Filter) (data []byte, err error) {
+	if len(f.Data) != 0 {
+		if !hasMixedHeader(bytes.NewReader(f.Data)) {
+			return nil, nil
+		}
+
+		return f.Data, nil
+	} else if f.FilePath == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(f.FilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Let the rule list creation handle it.
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening: %w", err)
+	}
+	defer func() { err = errors.WithDeferred(err, file.Close()) }()
+
+	if !hasMixedHeader(file) {
+		return nil, nil
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("seeking: %w", err)
+	}
+
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading: %w", err)
+	}
+
+	return data, nil
+}
+
+// hasMixedHeader returns true if the comments at the beginning of the list
+// read from r contain mixedListHeader.  The case is ignored.
+func hasMixedHeader(r io.Reader) (ok bool) {
+	s := bufio.NewScanner(r)
+	for first := true; s.Scan(); first = false {
+		line := strings.TrimSpace(s.Text())
+		if first {
+			line = strings.TrimPrefix(line, utf8BOM)
+		}
+
+		if line == "" {
+			continue
+		} else if line[0] != '!' && line[0] != '#' {
+			return false
+		}
+
+		if strings.EqualFold(line, mixedListHeader) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitExceptions splits the rules text data into the lines with the exception
+// rules and all the others.
+func splitExceptions(data []byte) (block, allow []byte) {
+	text := normalizeRulesText(data, false)
+	blockBuf, allowBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	for _, line := range strings.Split(text, "\n") {
+		buf := blockBuf
+		if strings.HasPrefix(strings.TrimSpace(line), "@@") {
+			buf = allowBuf
+		}
+
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	return blockBuf.Bytes(), allowBuf.Bytes()
+}
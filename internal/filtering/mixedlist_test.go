@@ -0,0 +1,90 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_SetFilters_mixedList(t *testing.T) {
+	const rules = "||blocked.example^\n" +
+		"@@||allowed.example^\n" +
+		"  @@||spaced.example^\n" +
+		"0.0.0.0 hosts.example\n"
+
+	const mixed = "! Title: Mixed list\n" +
+		"! Type: mixed\n" +
+		rules
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "mixed.txt")
+	err := os.WriteFile(path, []byte("\r\n! TYPE: MIXED\r\n"+rules), 0o644)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		filter        Filter
+		wantAllowlist bool
+	}{{
+		name:          "data",
+		filter:        Filter{ID: 1, Data: []byte(mixed)},
+		wantAllowlist: true,
+	}, {
+		name:          "file",
+		filter:        Filter{ID: 1, FilePath: path},
+		wantAllowlist: true,
+	}, {
+		name:          "not_mixed",
+		filter:        Filter{ID: 1, Data: []byte(rules)},
+		wantAllowlist: false,
+	}, {
+		name:          "header_after_rules",
+		filter:        Filter{ID: 1, Data: []byte(rules + "! Type: mixed\n")},
+		wantAllowlist: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, nil, nil)
+			t.Cleanup(d.Close)
+
+			sErr := d.SetFilters([]Filter{tc.filter}, nil, false)
+			require.NoError(t, sErr)
+
+			for _, host := range []string{"blocked.example", "hosts.example"} {
+				res, cErr := d.CheckHost(host, dns.TypeA, &setts)
+				require.NoError(t, cErr)
+
+				assert.True(t, res.IsFiltered, host)
+
+				_, ok, mErr := d.matchAllowlist(host, dns.TypeA, &setts)
+				require.NoError(t, mErr)
+
+				assert.False(t, ok, host)
+			}
+
+			for _, host := range []string{"allowed.example", "spaced.example"} {
+				res, cErr := d.CheckHost(host, dns.TypeA, &setts)
+				require.NoError(t, cErr)
+
+				assert.False(t, res.IsFiltered, host)
+
+				_, ok, mErr := d.matchAllowlist(host, dns.TypeA, &setts)
+				require.NoError(t, mErr)
+
+				assert.Equal(t, tc.wantAllowlist, ok, host)
+			}
+
+			// The filters are reported as set.
+			d.engineLock.RLock()
+			defer d.engineLock.RUnlock()
+
+			assert.Equal(t, []Filter{tc.filter}, d.blockFilters)
+			assert.Empty(t, d.allowFilters)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package filtering
+
+import (
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// RulesWithModifier returns the currently loaded rules from both the
+// blocklists and the allowlists which have the modifier with name mod, like
+// "dnsrewrite" or "client".  The leading "$" of mod, if any, is ignored.  The
+// value of the modifier and its negation with "~" aren't considered, so mod
+// "client" finds both "$client=1.2.3.4" and "$client=~5.6.7.8".  The rules are
+// returned in the order of the lists.
+func (d *DNSFilter) RulesWithModifier(mod string) (found []ResultRule) {
+	mod = strings.TrimPrefix(mod, "$")
+	if mod == "" {
+		return nil
+	}
+
+	res := Result{}
+
+	// Hold the lock while scanning, so that the lists aren't closed.
+	d.engineLock.RLock()
+	for _, rs := range []*filterlist.RuleStorage{d.rulesStorage, d.rulesStorageAllow} {
+		if rs == nil {
+			continue
+		}
+
+		for _, l := range rs.Lists {
+			sc, err := listScanner(l)
+			if err != nil {
+				log.Debug("filtering: looking for modifier %q: %s", mod, err)
+
+				continue
+			}
+
+			res.Rules = append(res.Rules, scanWithModifier(sc, mod)...)
+		}
+	}
+	d.engineLock.RUnlock()
+
+	d.redactRules(&res)
+
+	found = make([]ResultRule, 0, len(res.Rules))
+	for _, rr := range res.Rules {
+		found = append(found, *rr)
+	}
+
+	return found
+}
+
+// scanWithModifier returns the network rules from sc which have the modifier
+// with name mod.
+func scanWithModifier(sc *filterlist.RuleScanner, mod string) (found []*ResultRule) {
+	for sc.Scan() {
+		r, _ := sc.Rule()
+		if _, ok := r.(*rules.NetworkRule); !ok {
+			continue
+		}
+
+		rr := newResultRule(r)
+		if hasModifier(rr.Modifiers, mod) {
+			found = append(found, rr)
+		}
+	}
+
+	return found
+}
+
+// hasModifier returns true if mods, as returned by ruleModifiers, contain the
+// modifier with name.
+func hasModifier(mods []string, name string) (ok bool) {
+	for _, m := range mods {
+		if i := strings.IndexByte(m, '='); i >= 0 {
+			m = m[:i]
+		}
+
+		if strings.TrimPrefix(m, "~") == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,121 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_RulesWithModifier(t *testing.T) {
+	const blockData = "||plain.example^\n" +
+		"||rewrite.example^$dnsrewrite=1.2.3.4\n" +
+		"||client.example^$client=192.168.0.1\n" +
+		"||both.example^$client=~192.168.0.2,dnsrewrite=nxdomain\n" +
+		"||important.example^$important\n" +
+		"0.0.0.0 hosts.example\n" +
+		"/regex-with-client=/\n"
+
+	const allowData = "@@||allowed.example^$client=192.168.0.3\n" +
+		"@@||allowed-plain.example^\n"
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters(
+		[]Filter{{ID: 1, Data: []byte(blockData)}},
+		[]Filter{{ID: 2, Data: []byte(allowData)}},
+		false,
+	)
+	require.NoError(t, err)
+
+	texts := func(rrs []ResultRule) (res []string) {
+		for _, rr := range rrs {
+			res = append(res, rr.Text)
+		}
+
+		return res
+	}
+
+	testCases := []struct {
+		name      string
+		mod       string
+		wantTexts []string
+	}{{
+		name: "dnsrewrite",
+		mod:  "dnsrewrite",
+		wantTexts: []string{
+			"||rewrite.example^$dnsrewrite=1.2.3.4",
+			"||both.example^$client=~192.168.0.2,dnsrewrite=nxdomain",
+		},
+	}, {
+		name: "client",
+		mod:  "$client",
+		wantTexts: []string{
+			"||client.example^$client=192.168.0.1",
+			"||both.example^$client=~192.168.0.2,dnsrewrite=nxdomain",
+			"@@||allowed.example^$client=192.168.0.3",
+		},
+	}, {
+		name:      "no_value",
+		mod:       "important",
+		wantTexts: []string{"||important.example^$important"},
+	}, {
+		name:      "none",
+		mod:       "badfilter",
+		wantTexts: nil,
+	}, {
+		name:      "empty",
+		mod:       "",
+		wantTexts: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			found := d.RulesWithModifier(tc.mod)
+			assert.Equal(t, tc.wantTexts, texts(found))
+
+			for _, rr := range found {
+				assert.NotEmpty(t, rr.Modifiers)
+				assert.NotZero(t, rr.FilterListID)
+			}
+		})
+	}
+}
+
+func TestDNSFilter_RulesWithModifier_fileAndExceptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "block.txt")
+	err := os.WriteFile(path, []byte("||file.example^$important\n||plain.example^\n"), 0o644)
+	require.NoError(t, err)
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err = d.SetFilters([]Filter{{ID: 1, FilePath: path}}, nil, false)
+	require.NoError(t, err)
+
+	err = d.AddException("||exception.example^$important")
+	require.NoError(t, err)
+
+	err = d.AddException("||other.example^$important")
+	require.NoError(t, err)
+
+	// Matching doesn't interfere with the listing.
+	_, err = d.CheckHost("plain.example", dns.TypeA, &setts)
+	require.NoError(t, err)
+
+	var texts []string
+	for _, rr := range d.RulesWithModifier("important") {
+		texts = append(texts, rr.Text)
+	}
+
+	assert.Equal(t, []string{
+		"||file.example^$important",
+		"@@||exception.example^$important",
+		"@@||other.example^$important",
+	}, texts)
+}
@@ -0,0 +1,160 @@
+package filtering
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/miekg/dns"
+)
+
+// validateNAT64Prefix returns an error if prefix isn't a valid IPv4-embedding
+// IPv6 prefix, see RFC 6052, Section 2.2.
+func validateNAT64Prefix(prefix *net.IPNet) (err error) {
+	if len(prefix.IP) != net.IPv6len || prefix.IP.To4() != nil {
+		return fmt.Errorf("nat64 prefix %s is not ipv6", prefix)
+	}
+
+	ones, bits := prefix.Mask.Size()
+	if bits != net.IPv6len*8 {
+		return fmt.Errorf("nat64 prefix %s has bad mask", prefix)
+	}
+
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+		// Go on.
+	default:
+		return fmt.Errorf("nat64 prefix %s: length must be 32, 40, 48, 56, 64, or 96", prefix)
+	}
+
+	if ones < 96 && prefix.IP[8] != 0 {
+		return errors.Error("nat64 prefix: bits 64 to 71 must be zero")
+	}
+
+	return nil
+}
+
+// parseNAT64Prefix parses and validates the NAT64 prefix in the CIDR notation,
+// see validateNAT64Prefix.
+func parseNAT64Prefix(cidr string) (prefix *net.IPNet, err error) {
+	_, prefix, err = net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing nat64 prefix: %w", err)
+	}
+
+	err = validateNAT64Prefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return prefix, nil
+}
+
+// nat64Addr returns the IPv6 address with ip4 embedded into prefix according
+// to RFC 6052, Section 2.2.  prefix must be valid, see validateNAT64Prefix.
+func nat64Addr(prefix *net.IPNet, ip4 net.IP) (ip net.IP) {
+	ones, _ := prefix.Mask.Size()
+
+	ip = make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.Mask(prefix.Mask))
+
+	// The IPv4 address follows the prefix, skipping the octet with bits 64
+	// to 71, which must be zero.
+	pos := ones / 8
+	for _, b := range ip4.To4() {
+		if pos == 8 {
+			pos++
+		}
+
+		ip[pos] = b
+		pos++
+	}
+
+	return ip
+}
+
+// synthesizeNAT64 adds the IPv6 addresses synthesized from the A rewrites for
+// host to res, see Config.NAT64Prefix.  d.confLock is expected to be locked.
+func (d *DNSFilter) synthesizeNAT64(res Result, host string, setts *Settings) (synth Result) {
+	rr := findRewrites(d.Rewrites, host, dns.TypeA, setts.ClientIP, d.StrictWildcards)
+	for _, r := range rr {
+		if r.Type != dns.TypeA || r.IP == nil {
+			continue
+		}
+
+		ip := nat64Addr(d.NAT64Prefix, r.answerIP(setts, d.GeoIP))
+		res.IPList = append(res.IPList, ip)
+		res.TTL = minRewriteTTL(res.TTL, r.TTL)
+		log.Debug("rewrite: synthesized AAAA for %s is %s", host, ip)
+	}
+
+	if len(res.IPList) > 0 {
+		res.Reason = Rewritten
+	}
+
+	return res
+}
+
+// nat64IPv4 returns the IPv4 address embedded into ip according to RFC 6052,
+// Section 2.2.  ip4 is nil if ip isn't within prefix.  prefix must be valid,
+// see validateNAT64Prefix.
+func nat64IPv4(prefix *net.IPNet, ip net.IP) (ip4 net.IP) {
+	if len(ip) != net.IPv6len || ip.To4() != nil || !prefix.Contains(ip) {
+		return nil
+	}
+
+	ones, _ := prefix.Mask.Size()
+
+	ip4 = make(net.IP, 0, net.IPv4len)
+	for pos := ones / 8; len(ip4) < net.IPv4len; pos++ {
+		if pos == 8 {
+			continue
+		}
+
+		ip4 = append(ip4, ip[pos])
+	}
+
+	return ip4
+}
+
+// matchNAT64PTR returns the result answering the PTR request for host with the
+// domain of the A rewrite from which the IPv6 address has been synthesized,
+// see synthesizeNAT64.  ok is false if host isn't the reversed address within
+// Config.NAT64Prefix or there is no such rewrite.  The wildcard rewrites are
+// ignored, since there is no single name for those.
+func (d *DNSFilter) matchNAT64PTR(host string, qtype uint16, setts *Settings) (res Result, ok bool) {
+	if qtype != dns.TypePTR {
+		return Result{}, false
+	}
+
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	if d.NAT64Prefix == nil {
+		return Result{}, false
+	}
+
+	ip, err := netutil.IPFromReversedAddr(host)
+	if err != nil {
+		return Result{}, false
+	}
+
+	ip4 := nat64IPv4(d.NAT64Prefix, ip)
+	if ip4 == nil {
+		return Result{}, false
+	}
+
+	domain, ok := addrRewrite(d.Rewrites, ip4, setts, d.GeoIP)
+	if !ok {
+		return Result{}, false
+	}
+
+	log.Debug("rewrite: PTR for synthesized %s is %s", ip, domain)
+
+	return Result{
+		Reason:           RewrittenRule,
+		DNSRewriteResult: appendRewritePTR(nil, domain),
+	}, true
+}
@@ -0,0 +1,320 @@
+package filtering
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNAT64Addr(t *testing.T) {
+	// The examples from RFC 6052, Section 2.4.
+	ip4 := net.IP{192, 0, 2, 33}
+
+	testCases := []struct {
+		prefix string
+		want   string
+	}{{
+		prefix: "2001:db8::/32",
+		want:   "2001:db8:c000:221::",
+	}, {
+		prefix: "2001:db8:100::/40",
+		want:   "2001:db8:1c0:2:21::",
+	}, {
+		prefix: "2001:db8:122::/48",
+		want:   "2001:db8:122:c000:2:2100::",
+	}, {
+		prefix: "2001:db8:122:300::/56",
+		want:   "2001:db8:122:3c0:0:221::",
+	}, {
+		prefix: "2001:db8:122:344::/64",
+		want:   "2001:db8:122:344:c0:2:2100:0",
+	}, {
+		prefix: "2001:db8:122:344::/96",
+		want:   "2001:db8:122:344::192.0.2.33",
+	}, {
+		prefix: "64:ff9b::/96",
+		want:   "64:ff9b::192.0.2.33",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			_, prefix, err := net.ParseCIDR(tc.prefix)
+			require.NoError(t, err)
+			require.NoError(t, validateNAT64Prefix(prefix))
+
+			ip := nat64Addr(prefix, ip4)
+			assert.Equal(t, net.ParseIP(tc.want), ip)
+			assert.Equal(t, ip4, nat64IPv4(prefix, ip))
+		})
+	}
+}
+
+func TestValidateNAT64Prefix(t *testing.T) {
+	testCases := []struct {
+		prefix     string
+		wantErrMsg string
+	}{{
+		prefix:     "64:ff9b::/96",
+		wantErrMsg: "",
+	}, {
+		prefix:     "64:ff9b::/80",
+		wantErrMsg: "nat64 prefix 64:ff9b::/80: length must be 32, 40, 48, 56, 64, or 96",
+	}, {
+		prefix:     "192.0.2.0/24",
+		wantErrMsg: "nat64 prefix 192.0.2.0/24 is not ipv6",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			_, prefix, err := net.ParseCIDR(tc.prefix)
+			require.NoError(t, err)
+
+			err = validateNAT64Prefix(prefix)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestNew_nat64PrefixCIDR(t *testing.T) {
+	rewrites := []RewriteEntry{{
+		Domain: "a-only.example",
+		Answer: "192.0.2.33",
+	}}
+
+	t.Run("valid", func(t *testing.T) {
+		conf := &Config{}
+		err := yaml.Unmarshal([]byte("nat64_prefix: '64:ff9b::/96'\n"), conf)
+		require.NoError(t, err)
+
+		conf.Rewrites = rewrites
+		d := newForTest(t, conf, nil)
+		t.Cleanup(d.Close)
+
+		res := d.processRewrites("a-only.example", dns.TypeAAAA, &setts)
+
+		assert.Equal(t, Rewritten, res.Reason)
+		assert.Equal(t, []net.IP{net.ParseIP("64:ff9b::192.0.2.33")}, res.IPList)
+
+		c := &Config{}
+		d.WriteDiskConfig(c)
+
+		assert.Equal(t, "64:ff9b::/96", c.NAT64PrefixCIDR)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		d := newForTest(t, &Config{
+			Rewrites:        rewrites,
+			NAT64PrefixCIDR: "64:ff9b::/80",
+		}, nil)
+		t.Cleanup(d.Close)
+
+		res := d.processRewrites("a-only.example", dns.TypeAAAA, &setts)
+
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+		assert.Empty(t, res.IPList)
+	})
+}
+
+func TestDNSFilter_processRewrites_nat64(t *testing.T) {
+	_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+	require.NoError(t, err)
+
+	rewrites := []RewriteEntry{{
+		Domain: "a-only.example",
+		Answer: "192.0.2.33",
+	}, {
+		Domain: "a-only.example",
+		Answer: "192.0.2.34",
+	}, {
+		Domain: "both.example",
+		Answer: "192.0.2.1",
+	}, {
+		Domain: "both.example",
+		Answer: "2001:db8::1",
+	}, {
+		Domain: "alias.example",
+		Answer: "a-only.example",
+	}, {
+		Domain: "no-aaaa.example",
+		Answer: "192.0.2.2",
+	}, {
+		Domain: "no-aaaa.example",
+		Answer: "AAAA",
+	}}
+
+	testCases := []struct {
+		prefix        *net.IPNet
+		name          string
+		host          string
+		wantCanonName string
+		wantIPs       []net.IP
+		qtype         uint16
+		wantReason    Reason
+	}{{
+		prefix:        prefix,
+		name:          "synthesized",
+		host:          "a-only.example",
+		wantCanonName: "",
+		wantIPs: []net.IP{
+			net.ParseIP("64:ff9b::192.0.2.33"),
+			net.ParseIP("64:ff9b::192.0.2.34"),
+		},
+		qtype:      dns.TypeAAAA,
+		wantReason: Rewritten,
+	}, {
+		prefix:        prefix,
+		name:          "a_unchanged",
+		host:          "a-only.example",
+		wantCanonName: "",
+		wantIPs:       []net.IP{{192, 0, 2, 33}, {192, 0, 2, 34}},
+		qtype:         dns.TypeA,
+		wantReason:    Rewritten,
+	}, {
+		prefix:        prefix,
+		name:          "aaaa_rewrite_preferred",
+		host:          "both.example",
+		wantCanonName: "",
+		wantIPs:       []net.IP{net.ParseIP("2001:db8::1")},
+		qtype:         dns.TypeAAAA,
+		wantReason:    Rewritten,
+	}, {
+		prefix:        prefix,
+		name:          "cname",
+		host:          "alias.example",
+		wantCanonName: "a-only.example",
+		wantIPs: []net.IP{
+			net.ParseIP("64:ff9b::192.0.2.33"),
+			net.ParseIP("64:ff9b::192.0.2.34"),
+		},
+		qtype:      dns.TypeAAAA,
+		wantReason: Rewritten,
+	}, {
+		prefix:        prefix,
+		name:          "exception",
+		host:          "no-aaaa.example",
+		wantCanonName: "",
+		wantIPs:       nil,
+		qtype:         dns.TypeAAAA,
+		wantReason:    NotFilteredNotFound,
+	}, {
+		prefix:        nil,
+		name:          "no_prefix",
+		host:          "a-only.example",
+		wantCanonName: "",
+		wantIPs:       nil,
+		qtype:         dns.TypeAAAA,
+		wantReason:    NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				Rewrites:    rewrites,
+				NAT64Prefix: tc.prefix,
+			}, nil)
+			t.Cleanup(d.Close)
+
+			res := d.processRewrites(tc.host, tc.qtype, &setts)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantCanonName, res.CanonName)
+			assert.Equal(t, tc.wantIPs, res.IPList)
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_nat64PTR(t *testing.T) {
+	_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+	require.NoError(t, err)
+
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "*.wildcard.example",
+			Answer: "192.0.2.33",
+		}, {
+			Domain: "a-only.example",
+			Answer: "192.0.2.33",
+		}, {
+			Domain: "other.example",
+			Answer: "192.0.2.34",
+		}},
+		NAT64Prefix: prefix,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	res, err := d.CheckHost("a-only.example", dns.TypeAAAA, &setts)
+	require.NoError(t, err)
+	require.Len(t, res.IPList, 1)
+
+	synthesized := res.IPList[0]
+	require.Equal(t, net.ParseIP("64:ff9b::192.0.2.33"), synthesized)
+
+	reversed := func(ip string) (host string) {
+		arpa, rErr := dns.ReverseAddr(ip)
+		require.NoError(t, rErr)
+
+		return strings.TrimSuffix(arpa, ".")
+	}
+
+	testCases := []struct {
+		name       string
+		ip         string
+		wantName   string
+		wantReason Reason
+	}{{
+		name:       "synthesized",
+		ip:         synthesized.String(),
+		wantName:   "a-only.example",
+		wantReason: RewrittenRule,
+	}, {
+		name:       "other_rewrite",
+		ip:         "64:ff9b::192.0.2.34",
+		wantName:   "other.example",
+		wantReason: RewrittenRule,
+	}, {
+		name:       "no_rewrite",
+		ip:         "64:ff9b::192.0.2.35",
+		wantName:   "",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "outside_prefix",
+		ip:         "2001:db8::c000:221",
+		wantName:   "",
+		wantReason: NotFilteredNotFound,
+	}, {
+		// Not synthesized, but still answered by the A rewrite itself.
+		name:       "ipv4",
+		ip:         "192.0.2.33",
+		wantName:   "a-only.example",
+		wantReason: Rewritten,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ptrRes, cErr := d.CheckHost(reversed(tc.ip), dns.TypePTR, &setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantReason, ptrRes.Reason)
+			if tc.wantName == "" {
+				assert.Nil(t, ptrRes.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, ptrRes.DNSRewriteResult)
+			assert.Equal(t, DNSRewriteResultResponse{
+				dns.TypePTR: []rules.RRValue{tc.wantName},
+			}, ptrRes.DNSRewriteResult.Response)
+		})
+	}
+}
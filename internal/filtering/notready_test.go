@@ -0,0 +1,79 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_blockUntilReady(t *testing.T) {
+	testCases := []struct {
+		name      string
+		block     bool
+		wantRCode int
+	}{{
+		name:      "block",
+		block:     true,
+		wantRCode: dns.RcodeServerFailure,
+	}, {
+		name:      "pass",
+		block:     false,
+		wantRCode: -1,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{BlockUntilReady: tc.block}, nil)
+			t.Cleanup(d.Close)
+
+			require.Nil(t, d.filteringEngine)
+
+			res, err := d.CheckHost("example.org", dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			if tc.wantRCode < 0 {
+				assert.False(t, res.IsFiltered)
+				assert.Nil(t, res.DNSRewriteResult)
+			} else {
+				assert.True(t, res.IsFiltered)
+				require.NotNil(t, res.DNSRewriteResult)
+				require.Len(t, res.Rules, 1)
+
+				assert.Equal(t, tc.wantRCode, res.DNSRewriteResult.RCode)
+				assert.Equal(t, notReadyRuleText, res.Rules[0].Text)
+			}
+
+			err = d.SetFilters([]Filter{{
+				ID: 1, Data: []byte("||blocked.example^\n"),
+			}}, nil, false)
+			require.NoError(t, err)
+
+			res, err = d.CheckHost("example.org", dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.False(t, res.IsFiltered)
+			assert.Nil(t, res.DNSRewriteResult)
+
+			res, err = d.CheckHost("blocked.example", dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.True(t, res.IsFiltered)
+			assert.Nil(t, res.DNSRewriteResult)
+		})
+	}
+
+	t.Run("protection_disabled", func(t *testing.T) {
+		d := newForTest(t, &Config{BlockUntilReady: true}, nil)
+		t.Cleanup(d.Close)
+
+		s := setts
+		s.ProtectionEnabled = false
+
+		res, err := d.CheckHost("example.org", dns.TypeA, &s)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+	})
+}
@@ -0,0 +1,67 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_opcode(t *testing.T) {
+	const data = "||blocked.example^\n"
+
+	d := newForTest(t, &Config{}, []Filter{{ID: 0, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		opcode     int
+		wantReason Reason
+		wantFilter bool
+	}{{
+		name:       "query_blocked",
+		host:       "blocked.example",
+		opcode:     dns.OpcodeQuery,
+		wantReason: FilteredBlockList,
+		wantFilter: true,
+	}, {
+		name:       "query_allowed",
+		host:       "example.org",
+		opcode:     dns.OpcodeQuery,
+		wantReason: NotFilteredNotFound,
+		wantFilter: false,
+	}, {
+		name:       "update",
+		host:       "example.org",
+		opcode:     dns.OpcodeUpdate,
+		wantReason: FilteredInvalid,
+		wantFilter: true,
+	}, {
+		name:       "notify",
+		host:       "blocked.example",
+		opcode:     dns.OpcodeNotify,
+		wantReason: FilteredInvalid,
+		wantFilter: true,
+	}, {
+		name:       "status",
+		host:       "example.org",
+		opcode:     dns.OpcodeStatus,
+		wantReason: FilteredInvalid,
+		wantFilter: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := setts
+			s.Opcode = tc.opcode
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantFilter, res.IsFiltered)
+		})
+	}
+}
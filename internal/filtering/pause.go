@@ -0,0 +1,80 @@
+package filtering
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// PauseFor disables the filtering for dur.  The pause is kept separately from
+// the status set with SetEnabled, so the status isn't changed by the pause and
+// the pause isn't cancelled by setting the status, only with ResumeNow.
+// Pausing again during a pause only extends it, so a pause which ends before
+// the current one does nothing.  Since the pause is checked on every call of
+// Enabled, no goroutines are involved.
+func (d *DNSFilter) PauseFor(dur time.Duration) {
+	until := d.now().Add(dur)
+	untilNano := until.UnixNano()
+	for {
+		prev := atomic.LoadInt64(&d.pausedUntil)
+		if prev >= untilNano {
+			log.Debug("filtering: already paused until %s", time.Unix(0, prev))
+
+			return
+		}
+
+		if atomic.CompareAndSwapInt64(&d.pausedUntil, prev, untilNano) {
+			break
+		}
+	}
+
+	log.Info("filtering: paused until %s", until)
+}
+
+// ResumeNow ends the pause started with PauseFor.  It does nothing if the
+// filtering isn't paused.
+func (d *DNSFilter) ResumeNow() {
+	until := atomic.LoadInt64(&d.pausedUntil)
+	if until != 0 {
+		d.resume(until)
+	}
+}
+
+// PausedUntil returns the time at which the current pause ends.  ok is false
+// if the filtering isn't paused.
+func (d *DNSFilter) PausedUntil() (until time.Time, ok bool) {
+	if !d.paused() {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, atomic.LoadInt64(&d.pausedUntil)), true
+}
+
+// Enabled returns true if the filtering is enabled and not paused.
+func (d *DNSFilter) Enabled() (ok bool) {
+	return atomic.LoadUint32(&d.enabled) != 0 && !d.paused()
+}
+
+// paused returns true if the filtering is paused.  It ends the pause if it has
+// elapsed.
+func (d *DNSFilter) paused() (ok bool) {
+	until := atomic.LoadInt64(&d.pausedUntil)
+	if until == 0 {
+		return false
+	} else if d.now().Before(time.Unix(0, until)) {
+		return true
+	}
+
+	d.resume(until)
+
+	return false
+}
+
+// resume ends the pause until the Unix time in nanoseconds.  It does nothing if
+// the pause has already been changed.
+func (d *DNSFilter) resume(until int64) {
+	if atomic.CompareAndSwapInt64(&d.pausedUntil, until, 0) {
+		log.Info("filtering: resumed")
+	}
+}
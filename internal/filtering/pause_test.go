@@ -0,0 +1,131 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_PauseFor(t *testing.T) {
+	const pause = 5 * time.Minute
+
+	d := newForTest(t, &Config{}, nil)
+	t.Cleanup(d.Close)
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() (t time.Time) { return now }
+
+	d.SetEnabled(true)
+
+	t.Run("auto_resume", func(t *testing.T) {
+		now = start
+		d.PauseFor(pause)
+
+		assert.False(t, d.Enabled())
+		assert.False(t, d.GetConfig().FilteringEnabled)
+
+		now = start.Add(pause - time.Second)
+		assert.False(t, d.Enabled())
+		assert.False(t, d.GetConfig().FilteringEnabled)
+
+		now = start.Add(pause)
+		assert.True(t, d.Enabled())
+		assert.True(t, d.GetConfig().FilteringEnabled)
+
+		now = start.Add(2 * pause)
+		assert.True(t, d.Enabled())
+	})
+
+	t.Run("resume_now", func(t *testing.T) {
+		now = start
+		d.PauseFor(pause)
+		assert.False(t, d.Enabled())
+
+		d.ResumeNow()
+		assert.True(t, d.GetConfig().FilteringEnabled)
+
+		// Resuming without a pause changes nothing.
+		d.SetEnabled(false)
+		d.ResumeNow()
+		assert.False(t, d.Enabled())
+
+		d.SetEnabled(true)
+	})
+
+	t.Run("set_enabled_keeps_pause", func(t *testing.T) {
+		now = start
+		d.PauseFor(pause)
+
+		// Refreshing the filters sets the status again.
+		d.SetEnabled(true)
+		assert.False(t, d.Enabled())
+
+		until, ok := d.PausedUntil()
+		assert.True(t, ok)
+		assert.Equal(t, start.Add(pause), until.UTC())
+
+		// The status set during the pause is used after it.
+		d.SetEnabled(false)
+
+		now = start.Add(pause)
+		assert.False(t, d.Enabled())
+
+		_, ok = d.PausedUntil()
+		assert.False(t, ok)
+
+		d.SetEnabled(true)
+		assert.True(t, d.Enabled())
+	})
+
+	t.Run("repause", func(t *testing.T) {
+		now = start
+		d.PauseFor(pause)
+
+		now = start.Add(pause / 2)
+		d.PauseFor(pause)
+
+		now = start.Add(pause)
+		assert.False(t, d.Enabled())
+
+		now = start.Add(pause/2 + pause)
+		assert.True(t, d.Enabled())
+	})
+
+	t.Run("shorter", func(t *testing.T) {
+		now = start
+		d.PauseFor(pause)
+
+		now = start.Add(pause / 2)
+		d.PauseFor(pause / 4)
+
+		until, ok := d.PausedUntil()
+		require.True(t, ok)
+
+		assert.Equal(t, start.Add(pause).UnixNano(), until.UnixNano())
+
+		now = start.Add(pause/2 + pause/4)
+		assert.False(t, d.Enabled())
+
+		now = start.Add(pause)
+		assert.True(t, d.Enabled())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		d.SetEnabled(false)
+
+		now = start
+		d.PauseFor(pause)
+
+		now = start.Add(pause / 2)
+		d.PauseFor(pause)
+
+		now = start.Add(2 * pause)
+		assert.False(t, d.Enabled())
+		assert.False(t, d.GetConfig().FilteringEnabled)
+
+		d.SetEnabled(true)
+	})
+}
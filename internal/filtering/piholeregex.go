@@ -0,0 +1,132 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// Pi-hole regex extensions, see
+// https://docs.pi-hole.net/regex/pi-hole/.
+const (
+	piholeExtQueryType = "querytype="
+	piholeExtInvert    = "invert"
+)
+
+// ParsePiholeRegex converts the Pi-hole regex blocklist read from r into the
+// filtering rules.  Each entry of such a list is an extended regular
+// expression matched against any part of the queried domain name, so that
+// "ads" blocks both "ads.example" and "example-ads.com", unless the
+// expression is anchored with "^" or "$".  The matching is case-insensitive.
+//
+// The entries become the urlfilter regular expression rules, like "/ads/",
+// which have the same semantics for the DNS requests.  The "querytype"
+// extension becomes the $dnstype modifier.  The other extensions, like
+// "invert", aren't supported.  The empty lines and the lines starting with
+// "#" are skipped.
+func ParsePiholeRegex(r io.Reader) (data []byte, err error) {
+	buf := &bytes.Buffer{}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var rule string
+		rule, err = piholeRegexRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		buf.WriteString(rule)
+		buf.WriteByte('\n')
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// piholeRegexRule converts a single Pi-hole regex entry into a filtering rule.
+func piholeRegexRule(entry string) (rule string, err error) {
+	parts := strings.Split(entry, ";")
+	expr := parts[0]
+	if expr == "" {
+		return "", errors.Error("empty regular expression")
+	}
+
+	// urlfilter considers the slashes to be the pattern's bounds, so don't
+	// let them confuse it.  The domain names can't contain those anyway.
+	if strings.Contains(expr, "/") {
+		return "", fmt.Errorf("regular expression %q contains a slash", expr)
+	}
+
+	_, err = regexp.Compile(expr)
+	if err != nil {
+		return "", fmt.Errorf("bad regular expression: %w", err)
+	}
+
+	rule = "/" + expr + "/"
+
+	var dnstype string
+	for _, ext := range parts[1:] {
+		switch {
+		case strings.HasPrefix(ext, piholeExtQueryType):
+			if dnstype != "" {
+				return "", errors.Error("duplicate querytype extension")
+			}
+
+			dnstype, err = piholeQueryTypes(strings.TrimPrefix(ext, piholeExtQueryType))
+			if err != nil {
+				return "", fmt.Errorf("querytype extension: %w", err)
+			}
+		case ext == piholeExtInvert:
+			return "", fmt.Errorf("extension %q is not supported", ext)
+		default:
+			return "", fmt.Errorf("unknown extension %q", ext)
+		}
+	}
+
+	if dnstype != "" {
+		rule += "$dnstype=" + dnstype
+	}
+
+	return rule, nil
+}
+
+// piholeQueryTypes converts the value of the Pi-hole "querytype" extension,
+// like "A,AAAA" or "!A", into the value of the $dnstype modifier.
+func piholeQueryTypes(val string) (dnstype string, err error) {
+	neg := strings.HasPrefix(val, "!")
+	val = strings.TrimPrefix(val, "!")
+	if val == "" {
+		return "", errors.Error("no query types")
+	}
+
+	types := strings.Split(val, ",")
+	for i, t := range types {
+		t = strings.ToUpper(strings.TrimSpace(t))
+		if _, ok := dns.StringToType[t]; !ok {
+			return "", fmt.Errorf("unknown query type %q", t)
+		}
+
+		if neg {
+			t = "~" + t
+		}
+
+		types[i] = t
+	}
+
+	return strings.Join(types, "|"), nil
+}
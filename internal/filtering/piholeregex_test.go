@@ -0,0 +1,147 @@
+package filtering
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePiholeRegex(t *testing.T) {
+	const list = `# Pi-hole regex list.
+
+(^|\.)doubleclick\.net$
+^ad[0-9]+\.
+tracker
+^telemetry\.example\.com$;querytype=AAAA
+^metrics\.;querytype=!A,https
+`
+
+	data, err := ParsePiholeRegex(strings.NewReader(list))
+	require.NoError(t, err)
+
+	assert.Equal(t, `/(^|\.)doubleclick\.net$/
+/^ad[0-9]+\./
+/tracker/
+/^telemetry\.example\.com$/$dnstype=AAAA
+/^metrics\./$dnstype=~A|~HTTPS
+`, string(data))
+
+	d := newForTest(t, nil, []Filter{{ID: 1, Data: data}})
+	t.Cleanup(d.Close)
+
+	// The expressions without the extensions, matched the way Pi-hole does.
+	exprs := []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(^|\.)doubleclick\.net$`),
+		regexp.MustCompile(`(?i)^ad[0-9]+\.`),
+		regexp.MustCompile(`(?i)tracker`),
+	}
+
+	for _, host := range []string{
+		"doubleclick.net",
+		"stats.doubleclick.net",
+		"notdoubleclick.net",
+		"doubleclick.net.example",
+		"ad1.example",
+		"ad.example",
+		"www.ad1.example",
+		"tracker.example",
+		"my-tracker.example",
+		"mytracker.example",
+		"Tracker.Example",
+		"example.com",
+	} {
+		want := false
+		for _, re := range exprs {
+			want = want || re.MatchString(host)
+		}
+
+		t.Run(host, func(t *testing.T) {
+			res, cErr := d.CheckHost(host, dns.TypeA, &setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, want, res.IsFiltered)
+		})
+	}
+
+	testCases := []struct {
+		name         string
+		host         string
+		qtype        uint16
+		wantFiltered bool
+	}{{
+		name:         "querytype_match",
+		host:         "telemetry.example.com",
+		qtype:        dns.TypeAAAA,
+		wantFiltered: true,
+	}, {
+		name:         "querytype_other",
+		host:         "telemetry.example.com",
+		qtype:        dns.TypeA,
+		wantFiltered: false,
+	}, {
+		name:         "querytype_negated_match",
+		host:         "metrics.example",
+		qtype:        dns.TypeAAAA,
+		wantFiltered: true,
+	}, {
+		name:         "querytype_negated_other",
+		host:         "metrics.example",
+		qtype:        dns.TypeHTTPS,
+		wantFiltered: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantFiltered, res.IsFiltered)
+		})
+	}
+}
+
+func TestParsePiholeRegex_errors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		list       string
+		wantErrMsg string
+	}{{
+		name:       "bad_regex",
+		list:       "ok\n(unclosed\n",
+		wantErrMsg: "line 2: bad regular expression: error parsing regexp: " +
+			"missing closing ): `(unclosed`",
+	}, {
+		name:       "slash",
+		list:       "a/b\n",
+		wantErrMsg: `line 1: regular expression "a/b" contains a slash`,
+	}, {
+		name:       "invert",
+		list:       "ads;invert\n",
+		wantErrMsg: `line 1: extension "invert" is not supported`,
+	}, {
+		name:       "unknown_extension",
+		list:       "ads;reply=nxdomain\n",
+		wantErrMsg: `line 1: unknown extension "reply=nxdomain"`,
+	}, {
+		name:       "bad_querytype",
+		list:       "ads;querytype=BAD\n",
+		wantErrMsg: `line 1: querytype extension: unknown query type "BAD"`,
+	}, {
+		name:       "empty",
+		list:       ";querytype=A\n",
+		wantErrMsg: "line 1: empty regular expression",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParsePiholeRegex(strings.NewReader(tc.list))
+			require.Error(t, err)
+
+			assert.Equal(t, tc.wantErrMsg, err.Error())
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package filtering
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// defaultPreloadConcurrency is the default number of the concurrent lookups
+// performed by PreloadSecurityCache, see Config.PreloadConcurrency.
+const defaultPreloadConcurrency = 4
+
+// PreloadSecurityCache looks up hosts with the enabled safe browsing and
+// parental control services to fill their caches, so that the first requests
+// for those hosts don't wait for the lookups.  At most
+// Config.PreloadConcurrency lookups are performed at once.  It stops once ctx
+// is canceled and returns the context's error in that case.
+func (d *DNSFilter) PreloadSecurityCache(ctx context.Context, hosts []string) (err error) {
+	d.confLock.RLock()
+	setts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: d.SafeBrowsingEnabled,
+		ParentalEnabled:     d.ParentalEnabled,
+	}
+	workers := d.PreloadConcurrency
+	d.confLock.RUnlock()
+
+	if !setts.SafeBrowsingEnabled && !setts.ParentalEnabled {
+		return nil
+	} else if workers == 0 {
+		workers = defaultPreloadConcurrency
+	}
+
+	// Use any of the checked types, since the verdicts don't depend on it.
+	qtype := d.securityCheckTypes()[0]
+
+	hostsCh := make(chan string)
+	errCh := make(chan error)
+
+	wg := &sync.WaitGroup{}
+	for i := uint(0); i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for host := range hostsCh {
+				if pErr := d.preloadHost(host, qtype, setts); pErr != nil {
+					errCh <- pErr
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(errCh)
+		defer wg.Wait()
+		defer close(hostsCh)
+
+		set := stringutil.NewSet()
+		for _, host := range hosts {
+			host = strings.ToLower(strings.TrimSuffix(host, "."))
+			if host == "" || set.Has(host) {
+				continue
+			}
+
+			set.Add(host)
+			if ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case hostsCh <- host:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var errs []error
+	for pErr := range errCh {
+		errs = append(errs, pErr)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return fmt.Errorf("preloading security cache: %w", err)
+	} else if len(errs) > 0 {
+		return errors.List("preloading security cache", errs...)
+	}
+
+	log.Debug("filtering: preloaded security cache for %d hosts", len(hosts))
+
+	return nil
+}
+
+// preloadHost looks up host with the services enabled in setts.
+func (d *DNSFilter) preloadHost(host string, qtype uint16, setts *Settings) (err error) {
+	_, err = d.checkSafeBrowsing(host, qtype, setts)
+	if err != nil {
+		return fmt.Errorf("safe browsing: %q: %w", host, err)
+	}
+
+	_, err = d.checkParental(host, qtype, setts)
+	if err != nil {
+		return fmt.Errorf("parental: %q: %w", host, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package filtering
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_PreloadSecurityCache(t *testing.T) {
+	const (
+		blocked = "blocked.example"
+		allowed = "allowed.example"
+	)
+
+	newFilter := func(t *testing.T) (d *DNSFilter, sbUps, pcUps *aghtest.TestBlockUpstream) {
+		t.Helper()
+
+		d = newForTest(t, &Config{
+			SafeBrowsingEnabled: true,
+			ParentalEnabled:     true,
+			PreloadConcurrency:  2,
+		}, nil)
+		t.Cleanup(d.Close)
+
+		sbUps = &aghtest.TestBlockUpstream{Hostname: blocked, Block: true}
+		d.SetSafeBrowsingUpstream(sbUps)
+
+		pcUps = &aghtest.TestBlockUpstream{Hostname: blocked, Block: true}
+		d.SetParentalUpstream(pcUps)
+
+		return d, sbUps, pcUps
+	}
+
+	s := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+	}
+
+	t.Run("cache_hits", func(t *testing.T) {
+		d, sbUps, pcUps := newFilter(t)
+
+		err := d.PreloadSecurityCache(context.Background(), []string{
+			blocked,
+			allowed,
+			"BLOCKED.EXAMPLE.",
+		})
+		require.NoError(t, err)
+
+		sbReqs, pcReqs := sbUps.RequestsCount(), pcUps.RequestsCount()
+		assert.Positive(t, sbReqs)
+		assert.Positive(t, pcReqs)
+
+		sbCache := d.safebrowsingCache.(*lruVerdictCache)
+		sbHits := sbCache.Stats().Hit
+
+		res, err := d.checkSafeBrowsing(blocked, dns.TypeA, s)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+
+		res, err = d.checkSafeBrowsing(allowed, dns.TypeA, s)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+
+		res, err = d.checkParental(blocked, dns.TypeAAAA, s)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+
+		// No more requests are sent to the upstreams.
+		assert.Equal(t, sbReqs, sbUps.RequestsCount())
+		assert.Equal(t, pcReqs, pcUps.RequestsCount())
+		assert.Greater(t, sbCache.Stats().Hit, sbHits)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		d, sbUps, pcUps := newFilter(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := d.PreloadSecurityCache(ctx, []string{blocked, allowed})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.Zero(t, sbUps.RequestsCount())
+		assert.Zero(t, pcUps.RequestsCount())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		d := newForTest(t, &Config{}, nil)
+		t.Cleanup(d.Close)
+
+		sbUps := &aghtest.TestBlockUpstream{Hostname: blocked, Block: true}
+		d.SetSafeBrowsingUpstream(sbUps)
+
+		err := d.PreloadSecurityCache(context.Background(), []string{blocked})
+		require.NoError(t, err)
+
+		assert.Zero(t, sbUps.RequestsCount())
+	})
+}
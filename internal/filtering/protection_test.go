@@ -0,0 +1,127 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettings_EffectiveProtection(t *testing.T) {
+	assert.False(t, (*Settings)(nil).EffectiveProtection())
+	assert.False(t, (&Settings{FilteringEnabled: true}).EffectiveProtection())
+	assert.True(t, (&Settings{ProtectionEnabled: true}).EffectiveProtection())
+}
+
+func TestSettings_EffectiveFiltering(t *testing.T) {
+	assert.False(t, (*Settings)(nil).EffectiveFiltering())
+	assert.False(t, (&Settings{FilteringEnabled: true}).EffectiveFiltering())
+	assert.False(t, (&Settings{ProtectionEnabled: true}).EffectiveFiltering())
+	assert.True(t, (&Settings{
+		ProtectionEnabled: true,
+		FilteringEnabled:  true,
+	}).EffectiveFiltering())
+}
+
+func TestDNSFilter_CheckHost_protectionFlags(t *testing.T) {
+	const text = "||block.example^\n" +
+		"||dnsrw.example^$dnsrewrite=1.2.3.4\n" +
+		"@@||allow.example^\n"
+
+	d := newForTest(t, &Config{
+		CustomResolver: &aghtest.TestResolver{},
+		EtcHosts:       newTestHostsContainer(t, "2.2.2.2 hosts.example\n"),
+		Rewrites: []RewriteEntry{{
+			Domain: "rewrite.example",
+			Answer: "1.1.1.1",
+		}},
+	}, []Filter{{ID: 1, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	ups := &aghtest.TestBlockUpstream{Hostname: "sb.example", Block: true}
+	d.SetSafeBrowsingUpstream(ups)
+
+	svcRule, err := rules.NewNetworkRule("||service.example^", BlockedSvcsListID)
+	require.NoError(t, err)
+
+	// checks maps the hosts to the reasons expected with each combination of
+	// the flags in the order: both disabled, only filtering, only
+	// protection, both enabled.
+	checks := []struct {
+		host string
+		want [4]Reason
+	}{{
+		host: "rewrite.example",
+		want: [4]Reason{0, Rewritten, 0, Rewritten},
+	}, {
+		host: "hosts.example",
+		want: [4]Reason{0, RewrittenAutoHosts, 0, RewrittenAutoHosts},
+	}, {
+		host: "dnsrw.example",
+		want: [4]Reason{0, RewrittenRule, 0, RewrittenRule},
+	}, {
+		host: "block.example",
+		want: [4]Reason{0, 0, 0, FilteredBlockList},
+	}, {
+		host: "allow.example",
+		want: [4]Reason{0, 0, 0, NotFilteredAllowList},
+	}, {
+		host: "service.example",
+		want: [4]Reason{0, 0, FilteredBlockedService, FilteredBlockedService},
+	}, {
+		host: "sb.example",
+		want: [4]Reason{0, 0, FilteredSafeBrowsing, FilteredSafeBrowsing},
+	}, {
+		host: "www.google.com",
+		want: [4]Reason{0, 0, FilteredSafeSearch, FilteredSafeSearch},
+	}}
+
+	flags := []struct {
+		name       string
+		filtering  bool
+		protection bool
+	}{{
+		name:       "none",
+		filtering:  false,
+		protection: false,
+	}, {
+		name:       "filtering",
+		filtering:  true,
+		protection: false,
+	}, {
+		name:       "protection",
+		filtering:  false,
+		protection: true,
+	}, {
+		name:       "both",
+		filtering:  true,
+		protection: true,
+	}}
+
+	for i, f := range flags {
+		s := &Settings{
+			ServicesRules: []ServiceEntry{{
+				Name:  "service",
+				Rules: []*rules.NetworkRule{svcRule},
+			}},
+			ProtectionEnabled:   f.protection,
+			FilteringEnabled:    f.filtering,
+			SafeSearchEnabled:   true,
+			SafeBrowsingEnabled: true,
+		}
+
+		for _, c := range checks {
+			t.Run(f.name+"_"+c.host, func(t *testing.T) {
+				res, cerr := d.CheckHost(c.host, dns.TypeA, s)
+				require.NoError(t, cerr)
+
+				assert.Equal(t, c.want[i], res.Reason)
+			})
+		}
+
+		purgeCaches(d)
+	}
+}
@@ -0,0 +1,180 @@
+package filtering
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// suffixList is a public suffix list, see https://publicsuffix.org/list/.  A
+// nil *suffixList is the list built into golang.org/x/net/publicsuffix.
+type suffixList struct {
+	// rules are the normal rules, like "co.uk".
+	rules map[string]suffixRule
+	// wildcards are the parents of the wildcard rules, like "ck" for "*.ck".
+	wildcards map[string]suffixRule
+	// exceptions are the exception rules without the "!", like "www.ck".
+	exceptions map[string]suffixRule
+}
+
+// suffixRule is the information about a public suffix list rule.
+type suffixRule struct {
+	// icann is true if the rule is in the ICANN section of the list, as
+	// opposed to the private one.
+	icann bool
+}
+
+// Section markers of the public suffix list.
+const (
+	pslICANNBegin   = "===BEGIN ICANN DOMAINS==="
+	pslPrivateBegin = "===BEGIN PRIVATE DOMAINS==="
+)
+
+// parseSuffixList parses the public suffix list in the format described at
+// https://github.com/publicsuffix/list/wiki/Format.  The rules outside of the
+// sections are considered the ICANN ones.
+func parseSuffixList(r io.Reader) (l *suffixList, err error) {
+	l = &suffixList{
+		rules:      map[string]suffixRule{},
+		wildcards:  map[string]suffixRule{},
+		exceptions: map[string]suffixRule{},
+	}
+
+	icann := true
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "//") {
+			if strings.Contains(line, pslICANNBegin) {
+				icann = true
+			} else if strings.Contains(line, pslPrivateBegin) {
+				icann = false
+			}
+
+			continue
+		}
+
+		// Only the first field is the rule.
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+
+		if line == "" {
+			continue
+		}
+
+		err = l.addRule(line, suffixRule{icann: icann})
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(l.rules)+len(l.wildcards)+len(l.exceptions) == 0 {
+		return nil, errors.Error("no rules")
+	}
+
+	return l, nil
+}
+
+// addRule adds the public suffix list rule with text to l.
+func (l *suffixList) addRule(text string, r suffixRule) (err error) {
+	text, err = idna.ToASCII(strings.ToLower(text))
+	if err != nil {
+		return fmt.Errorf("bad rule: %w", err)
+	}
+
+	switch {
+	case strings.HasPrefix(text, "!"):
+		l.exceptions[text[1:]] = r
+	case strings.HasPrefix(text, "*."):
+		l.wildcards[text[2:]] = r
+	default:
+		l.rules[text] = r
+	}
+
+	return nil
+}
+
+// publicSuffix returns the public suffix of domain and true if it's managed by
+// ICANN, just like publicsuffix.PublicSuffix.  l may be nil, in which case the
+// built-in list is used.
+func (l *suffixList) publicSuffix(domain string) (suffix string, icann bool) {
+	if l == nil {
+		return publicsuffix.PublicSuffix(domain)
+	}
+
+	// Check the candidates from the longest to the shortest, so that the
+	// first matched rule is the prevailing one.  The exception rules are
+	// always longer than the wildcard ones they are exceptions for.
+	for cand := domain; cand != ""; cand = parentDomain(cand) {
+		if r, ok := l.exceptions[cand]; ok {
+			return parentDomain(cand), r.icann
+		}
+
+		if r, ok := l.rules[cand]; ok {
+			return cand, r.icann
+		}
+
+		if r, ok := l.wildcards[parentDomain(cand)]; ok {
+			return cand, r.icann
+		}
+	}
+
+	// The default rule is "*".
+	if i := strings.LastIndexByte(domain, '.'); i >= 0 {
+		return domain[i+1:], false
+	}
+
+	return domain, false
+}
+
+// EffectiveTLDPlusOne returns the public suffix of domain with one more label,
+// like "example.co.uk" for "www.example.co.uk", according to the configured
+// public suffix list, see Config.PublicSuffixList.
+func (d *DNSFilter) EffectiveTLDPlusOne(domain string) (etldPlusOne string, err error) {
+	return d.psl.effectiveTLDPlusOne(strings.ToLower(strings.TrimSuffix(domain, ".")))
+}
+
+// effectiveTLDPlusOne returns the public suffix of domain with one more label,
+// like "example.co.uk" for "www.example.co.uk".  l may be nil, in which case
+// the built-in list is used.
+func (l *suffixList) effectiveTLDPlusOne(domain string) (etldPlusOne string, err error) {
+	suffix, _ := l.publicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return "", fmt.Errorf("cannot derive etld+1 for domain %q", domain)
+	}
+
+	i := strings.LastIndexByte(domain[:len(domain)-len(suffix)-1], '.')
+
+	return domain[i+1:], nil
+}
+
+// readSuffixList reads the public suffix list from the file at path.
+func readSuffixList(path string) (l *suffixList, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.WithDeferred(err, f.Close()) }()
+
+	return parseSuffixList(f)
+}
+
+// isPublicSuffix returns true if host is a public suffix, like "com" or
+// "co.uk".  l may be nil, in which case the built-in list is used.
+func (l *suffixList) isPublicSuffix(host string) (ok bool) {
+	suffix, _ := l.publicSuffix(host)
+
+	return suffix == host
+}
@@ -0,0 +1,165 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPSL is a public suffix list for tests.
+const testPSL = `// Test list.
+// ===BEGIN ICANN DOMAINS===
+com
+uk
+co.uk
+*.ck
+!www.ck
+example.org
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+users.example.net  // comment after the rule
+// ===END PRIVATE DOMAINS===
+`
+
+func TestSuffixList_publicSuffix(t *testing.T) {
+	l, err := parseSuffixList(strings.NewReader(testPSL))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		domain     string
+		wantSuffix string
+		wantICANN  bool
+	}{{
+		domain:     "example.com",
+		wantSuffix: "com",
+		wantICANN:  true,
+	}, {
+		domain:     "www.example.co.uk",
+		wantSuffix: "co.uk",
+		wantICANN:  true,
+	}, {
+		domain:     "example.uk",
+		wantSuffix: "uk",
+		wantICANN:  true,
+	}, {
+		domain:     "a.b.ck",
+		wantSuffix: "b.ck",
+		wantICANN:  true,
+	}, {
+		domain:     "www.ck",
+		wantSuffix: "ck",
+		wantICANN:  true,
+	}, {
+		domain:     "a.www.ck",
+		wantSuffix: "ck",
+		wantICANN:  true,
+	}, {
+		domain:     "alice.users.example.net",
+		wantSuffix: "users.example.net",
+		wantICANN:  false,
+	}, {
+		domain:     "example.net",
+		wantSuffix: "net",
+		wantICANN:  false,
+	}, {
+		domain:     "localhost",
+		wantSuffix: "localhost",
+		wantICANN:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.domain, func(t *testing.T) {
+			suffix, icann := l.publicSuffix(tc.domain)
+			assert.Equal(t, tc.wantSuffix, suffix)
+			assert.Equal(t, tc.wantICANN, icann)
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		_, err = parseSuffixList(strings.NewReader("// Only comments.\n\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestDNSFilter_EffectiveTLDPlusOne(t *testing.T) {
+	pslPath := filepath.Join(t.TempDir(), "public_suffix_list.dat")
+	err := os.WriteFile(pslPath, []byte(testPSL), 0o644)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		pslPath string
+		domain  string
+		want    string
+	}{{
+		name:    "builtin",
+		pslPath: "",
+		domain:  "alice.users.example.net",
+		want:    "example.net",
+	}, {
+		name:    "custom",
+		pslPath: pslPath,
+		domain:  "alice.users.example.net",
+		want:    "alice.users.example.net",
+	}, {
+		name:    "custom_common",
+		pslPath: pslPath,
+		domain:  "www.example.co.uk",
+		want:    "example.co.uk",
+	}, {
+		name:    "bad_path",
+		pslPath: filepath.Join(t.TempDir(), "nonexistent.dat"),
+		domain:  "alice.users.example.net",
+		want:    "example.net",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{PublicSuffixList: tc.pslPath}, nil)
+			t.Cleanup(d.Close)
+
+			got, eErr := d.EffectiveTLDPlusOne(tc.domain)
+			require.NoError(t, eErr)
+
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("suffix", func(t *testing.T) {
+		d := newForTest(t, &Config{PublicSuffixList: pslPath}, nil)
+		t.Cleanup(d.Close)
+
+		_, err = d.EffectiveTLDPlusOne("users.example.net")
+		assert.Error(t, err)
+	})
+
+	t.Run("minimize", func(t *testing.T) {
+		d := newForTest(t, &Config{PublicSuffixList: pslPath}, nil)
+		t.Cleanup(d.Close)
+
+		// The custom public suffix isn't used as a parent.
+		got := d.MinimizeRules([]string{"alice.users.example.net", "bob.users.example.net"}, 2)
+		assert.Equal(t, []string{"||alice.users.example.net^", "||bob.users.example.net^"}, got)
+	})
+
+	t.Run("hashes", func(t *testing.T) {
+		l, pErr := parseSuffixList(strings.NewReader(testPSL))
+		require.NoError(t, pErr)
+
+		hosts := func(psl *suffixList) (res []string) {
+			for _, h := range hostnameToHashes("www.example.org", psl) {
+				res = append(res, h)
+			}
+
+			return res
+		}
+
+		// The custom ICANN suffix isn't hashed.
+		assert.ElementsMatch(t, []string{"www.example.org"}, hosts(l))
+		assert.ElementsMatch(t, []string{"www.example.org", "example.org"}, hosts(nil))
+	})
+}
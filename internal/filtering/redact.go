@@ -0,0 +1,27 @@
+package filtering
+
+// redactRules replaces the texts of the rules of res using
+// Config.RuleTextRedactor, if it's set.  The rules are copied, so the ones
+// possibly shared with other results aren't changed.
+func (d *DNSFilter) redactRules(res *Result) {
+	if len(res.Rules) == 0 {
+		return
+	}
+
+	d.confLock.RLock()
+	redact := d.RuleTextRedactor
+	d.confLock.RUnlock()
+
+	if redact == nil {
+		return
+	}
+
+	redacted := make([]*ResultRule, 0, len(res.Rules))
+	for _, r := range res.Rules {
+		rr := *r
+		rr.Text = redact(rr.Text)
+		redacted = append(redacted, &rr)
+	}
+
+	res.Rules = redacted
+}
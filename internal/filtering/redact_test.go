@@ -0,0 +1,74 @@
+package filtering
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_ruleTextRedactor(t *testing.T) {
+	const data = "||secret.internal.example^\n" +
+		"||public.example^\n" +
+		"0.0.0.0 hosts.internal.example\n"
+
+	redactor := func(text string) (redacted string) {
+		if strings.Contains(text, "internal") {
+			return "<redacted>"
+		}
+
+		return text
+	}
+
+	testCases := []struct {
+		redactor func(text string) (redacted string)
+		name     string
+		host     string
+		wantText string
+	}{{
+		redactor: redactor,
+		name:     "redacted",
+		host:     "secret.internal.example",
+		wantText: "<redacted>",
+	}, {
+		redactor: redactor,
+		name:     "redacted_hosts",
+		host:     "hosts.internal.example",
+		wantText: "<redacted>",
+	}, {
+		redactor: redactor,
+		name:     "not_redacted",
+		host:     "public.example",
+		wantText: "||public.example^",
+	}, {
+		redactor: nil,
+		name:     "nil_redactor",
+		host:     "secret.internal.example",
+		wantText: "||secret.internal.example^",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				RuleTextRedactor: tc.redactor,
+			}, []Filter{{ID: 42, Data: []byte(data)}})
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+			require.True(t, res.IsFiltered)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantText, res.Rules[0].Text)
+			assert.Equal(t, int64(42), res.Rules[0].FilterListID)
+
+			res, err = d.CheckHostRules(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantText, res.Rules[0].Text)
+		})
+	}
+}
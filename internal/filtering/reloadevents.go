@@ -0,0 +1,76 @@
+package filtering
+
+import "time"
+
+// ReloadEvent describes a completed initialization of the filtering engines,
+// see OnReload.
+type ReloadEvent struct {
+	// Time is the moment the initialization started.
+	Time time.Time
+
+	// Err is the error returned by the initialization, if any.  It's not nil
+	// if some of the lists were skipped, even though the engines may be
+	// initialized.
+	Err error
+
+	// Duration is the duration of the initialization.
+	Duration time.Duration
+
+	// BlockRulesCount and AllowRulesCount are the numbers of the rules in
+	// the blocklists and the allowlists engines after the initialization.
+	BlockRulesCount int
+	AllowRulesCount int
+}
+
+// reloadListener is a function registered with OnReload.
+type reloadListener struct {
+	fn func(ev ReloadEvent)
+	id uint64
+}
+
+// OnReload registers fn to be called each time the filtering engines are
+// initialized, including the unsuccessful attempts.  fn is called
+// synchronously in the goroutine performing the initialization once it's
+// completed and no locks of d are held, so it may call the methods of d, but
+// shouldn't block for long.  unsubscribe removes fn, it's safe to call it more
+// than once.
+func (d *DNSFilter) OnReload(fn func(ev ReloadEvent)) (unsubscribe func()) {
+	d.reloadListenersLock.Lock()
+	defer d.reloadListenersLock.Unlock()
+
+	d.lastListenerID++
+	id := d.lastListenerID
+	d.reloadListeners = append(d.reloadListeners, reloadListener{fn: fn, id: id})
+
+	return func() { d.removeReloadListener(id) }
+}
+
+// removeReloadListener removes the listener with id, if it's registered.
+func (d *DNSFilter) removeReloadListener(id uint64) {
+	d.reloadListenersLock.Lock()
+	defer d.reloadListenersLock.Unlock()
+
+	for i, l := range d.reloadListeners {
+		if l.id == id {
+			// Don't modify the slice which may be iterated over by
+			// notifyReload.
+			listeners := make([]reloadListener, 0, len(d.reloadListeners)-1)
+			listeners = append(listeners, d.reloadListeners[:i]...)
+			d.reloadListeners = append(listeners, d.reloadListeners[i+1:]...)
+
+			return
+		}
+	}
+}
+
+// notifyReload calls the registered listeners with ev.  It must be called
+// without holding any of the locks of d.
+func (d *DNSFilter) notifyReload(ev ReloadEvent) {
+	d.reloadListenersLock.Lock()
+	listeners := d.reloadListeners
+	d.reloadListenersLock.Unlock()
+
+	for _, l := range listeners {
+		l.fn(ev)
+	}
+}
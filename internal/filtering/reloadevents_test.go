@@ -0,0 +1,72 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_OnReload(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() (t time.Time) {
+		t = now
+		now = now.Add(time.Second)
+
+		return t
+	}
+
+	var events []ReloadEvent
+	var statuses []EngineStatus
+	unsubscribe := d.OnReload(func(ev ReloadEvent) {
+		events = append(events, ev)
+		// Would deadlock if the engine locks were held.
+		statuses = append(statuses, d.EngineStatus())
+	})
+
+	var otherCalls int
+	unsubscribeOther := d.OnReload(func(_ ReloadEvent) { otherCalls++ })
+	t.Cleanup(unsubscribeOther)
+
+	setFilters := func(t *testing.T) {
+		t.Helper()
+
+		err := d.SetFilters([]Filter{{
+			ID:   1,
+			Data: []byte("||blocked.example^\n||other.example^\n"),
+		}}, []Filter{{
+			ID:   2,
+			Data: []byte("@@||allowed.example^\n"),
+		}}, false)
+		require.NoError(t, err)
+	}
+
+	setFilters(t)
+
+	require.Len(t, events, 1)
+	assert.Equal(t, ReloadEvent{
+		Time:            start,
+		Err:             nil,
+		Duration:        time.Second,
+		BlockRulesCount: 2,
+		AllowRulesCount: 1,
+	}, events[0])
+
+	require.Len(t, statuses, 1)
+	assert.Equal(t, start, statuses[0].LastReload)
+	assert.Equal(t, 1, otherCalls)
+
+	unsubscribe()
+	// Calling it again should have no effect.
+	unsubscribe()
+
+	setFilters(t)
+
+	assert.Len(t, events, 1)
+	assert.Equal(t, 2, otherCalls)
+}
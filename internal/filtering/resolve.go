@@ -0,0 +1,85 @@
+package filtering
+
+import "net"
+
+// ClientSettings are the settings of a persistent client overriding the global
+// ones, see Config.FindClient.
+type ClientSettings struct {
+	// Name is the name of the client.  If empty, the name passed to
+	// Config.FindClient is kept.
+	Name string
+
+	// Tags are the tags of the client.  If nil, the tags passed to
+	// Config.FindClient are kept.
+	Tags []string
+
+	// Services are the blocked services directives of the client.  Those
+	// are only used if UseOwnBlockedServices is true.
+	Services ClientServices
+
+	// UseOwnBlockedServices makes Services apply instead of the global
+	// blocked services.
+	UseOwnBlockedServices bool
+
+	// Schedule limits the time when the filtering and the blocked services
+	// apply to the client, see Settings.Schedule.
+	Schedule *ClientSchedule
+
+	// UseOwnSettings makes the toggles below apply instead of the global
+	// ones.
+	UseOwnSettings bool
+
+	FilteringEnabled    bool
+	SafeSearchEnabled   bool
+	SafeBrowsingEnabled bool
+	ParentalEnabled     bool
+}
+
+// ResolveSettings returns the settings which would be used for the requests of
+// the client with clientIP, tags, and name.  Those are the global settings with
+// the global blocked services, overridden by the client's settings returned by
+// Config.FindClient, if any.  ProtectionEnabled isn't known to d, so it's
+// always false and should be set by the caller.
+func (d *DNSFilter) ResolveSettings(clientIP net.IP, tags []string, name string) (s Settings) {
+	s = d.GetConfig()
+	s.ClientIP = clientIP
+	s.ClientTags = tags
+	s.ClientName = name
+	d.ApplyBlockedServices(&s, nil, true)
+
+	d.confLock.RLock()
+	find := d.Config.FindClient
+	d.confLock.RUnlock()
+
+	if find == nil {
+		return s
+	}
+
+	cs := find(clientIP, name)
+	if cs == nil {
+		return s
+	}
+
+	if cs.Name != "" {
+		s.ClientName = cs.Name
+	}
+
+	if cs.Tags != nil {
+		s.ClientTags = cs.Tags
+	}
+
+	s.Schedule = cs.Schedule
+
+	if cs.UseOwnBlockedServices {
+		d.ApplyClientBlockedServices(&s, cs.Services)
+	}
+
+	if cs.UseOwnSettings {
+		s.FilteringEnabled = cs.FilteringEnabled
+		s.SafeSearchEnabled = cs.SafeSearchEnabled
+		s.SafeBrowsingEnabled = cs.SafeBrowsingEnabled
+		s.ParentalEnabled = cs.ParentalEnabled
+	}
+
+	return s
+}
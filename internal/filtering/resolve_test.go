@@ -0,0 +1,133 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSFilter_ResolveSettings(t *testing.T) {
+	InitModule()
+
+	kidIP := net.IP{192, 168, 0, 2}
+	guestIP := net.IP{192, 168, 0, 3}
+
+	clients := map[string]*ClientSettings{
+		"kid": {
+			Name: "kid",
+			Tags: []string{"device_phone"},
+			Services: ClientServices{
+				Block:   []string{"tiktok"},
+				Replace: true,
+			},
+			UseOwnBlockedServices: true,
+			UseOwnSettings:        true,
+			FilteringEnabled:      true,
+			SafeSearchEnabled:     true,
+			ParentalEnabled:       true,
+		},
+		guestIP.String(): {
+			Name: "guest",
+		},
+	}
+
+	d := newForTest(t, &Config{
+		SafeBrowsingEnabled: true,
+		BlockedServices:     []string{"youtube"},
+		FindClient: func(ip net.IP, name string) (cs *ClientSettings) {
+			if cs = clients[name]; cs != nil {
+				return cs
+			}
+
+			return clients[ip.String()]
+		},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	d.SetEnabled(true)
+
+	names := func(svcs []ServiceEntry) (res []string) {
+		for _, s := range svcs {
+			res = append(res, s.Name)
+		}
+
+		return res
+	}
+
+	testCases := []struct {
+		name     string
+		ip       net.IP
+		tags     []string
+		client   string
+		wantName string
+		wantTags []string
+		wantSvcs []string
+		want     Settings
+	}{{
+		name:     "global",
+		ip:       net.IP{192, 168, 0, 1},
+		tags:     []string{"user_admin"},
+		client:   "",
+		wantName: "",
+		wantTags: []string{"user_admin"},
+		wantSvcs: []string{"youtube"},
+		want: Settings{
+			FilteringEnabled:    true,
+			SafeBrowsingEnabled: true,
+		},
+	}, {
+		name:     "own_settings",
+		ip:       kidIP,
+		tags:     nil,
+		client:   "kid",
+		wantName: "kid",
+		wantTags: []string{"device_phone"},
+		wantSvcs: []string{"tiktok"},
+		want: Settings{
+			FilteringEnabled:  true,
+			SafeSearchEnabled: true,
+			ParentalEnabled:   true,
+		},
+	}, {
+		name:     "by_ip",
+		ip:       guestIP,
+		tags:     []string{"user_child"},
+		client:   "",
+		wantName: "guest",
+		wantTags: []string{"user_child"},
+		wantSvcs: []string{"youtube"},
+		want: Settings{
+			FilteringEnabled:    true,
+			SafeBrowsingEnabled: true,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := d.ResolveSettings(tc.ip, tc.tags, tc.client)
+
+			assert.Equal(t, tc.ip, s.ClientIP)
+			assert.Equal(t, tc.wantName, s.ClientName)
+			assert.Equal(t, tc.wantTags, s.ClientTags)
+			assert.Equal(t, tc.wantSvcs, names(s.ServicesRules))
+
+			assert.False(t, s.ProtectionEnabled)
+			assert.Equal(t, tc.want.FilteringEnabled, s.FilteringEnabled)
+			assert.Equal(t, tc.want.SafeSearchEnabled, s.SafeSearchEnabled)
+			assert.Equal(t, tc.want.SafeBrowsingEnabled, s.SafeBrowsingEnabled)
+			assert.Equal(t, tc.want.ParentalEnabled, s.ParentalEnabled)
+		})
+	}
+
+	t.Run("no_finder", func(t *testing.T) {
+		nd := newForTest(t, &Config{ParentalEnabled: true}, nil)
+		t.Cleanup(nd.Close)
+
+		s := nd.ResolveSettings(kidIP, nil, "kid")
+
+		assert.Equal(t, "kid", s.ClientName)
+		assert.True(t, s.ParentalEnabled)
+		assert.Empty(t, s.ServicesRules)
+	})
+}
@@ -0,0 +1,48 @@
+package filtering
+
+import (
+	"fmt"
+)
+
+// MarshalYAML implements the yaml.Marshaler interface for Reason.
+func (r Reason) MarshalYAML() (v interface{}, err error) {
+	return r.String(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for *Reason.
+func (r *Reason) UnmarshalYAML(unmarshal func(v interface{}) (err error)) (err error) {
+	var name string
+	err = unmarshal(&name)
+	if err != nil {
+		return err
+	}
+
+	for i, rn := range reasonNames {
+		if rn != "" && rn == name {
+			*r = Reason(i)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown reason %q", name)
+}
+
+// ResponseTTL returns the TTL, in seconds, of the response to the request
+// filtered with res.  The TTL of the rewrites, see Result.TTL, takes precedence
+// over the one configured for the reason of res.  defaultTTL is returned if
+// there is neither.
+func (d *DNSFilter) ResponseTTL(res *Result, defaultTTL uint32) (ttl uint32) {
+	if res.TTL != 0 {
+		return res.TTL
+	}
+
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	if ttl, ok := d.ResponseTTLByReason[res.Reason]; ok {
+		return ttl
+	}
+
+	return defaultTTL
+}
@@ -0,0 +1,98 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDNSFilter_ResponseTTL(t *testing.T) {
+	const defaultTTL uint32 = 10
+
+	d := newForTest(t, &Config{
+		ResponseTTLByReason: map[Reason]uint32{
+			FilteredSafeBrowsing:   60,
+			FilteredParental:       300,
+			FilteredBlockList:      3600,
+			FilteredBlockedService: 0,
+		},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name   string
+		reason Reason
+		want   uint32
+	}{{
+		name:   "safe_browsing",
+		reason: FilteredSafeBrowsing,
+		want:   60,
+	}, {
+		name:   "parental",
+		reason: FilteredParental,
+		want:   300,
+	}, {
+		name:   "blocklist",
+		reason: FilteredBlockList,
+		want:   3600,
+	}, {
+		name:   "zero",
+		reason: FilteredBlockedService,
+		want:   0,
+	}, {
+		name:   "default_safe_search",
+		reason: FilteredSafeSearch,
+		want:   defaultTTL,
+	}, {
+		name:   "default_rewrite",
+		reason: Rewritten,
+		want:   defaultTTL,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &Result{Reason: tc.reason}
+			assert.Equal(t, tc.want, d.ResponseTTL(res, defaultTTL))
+		})
+	}
+
+	t.Run("not_configured", func(t *testing.T) {
+		nd := newForTest(t, &Config{}, nil)
+		t.Cleanup(nd.Close)
+
+		res := &Result{Reason: FilteredSafeBrowsing}
+		assert.Equal(t, defaultTTL, nd.ResponseTTL(res, defaultTTL))
+	})
+
+	t.Run("rewrite_ttl", func(t *testing.T) {
+		res := &Result{Reason: FilteredBlockList, TTL: 42}
+		assert.Equal(t, uint32(42), d.ResponseTTL(res, defaultTTL))
+	})
+}
+
+func TestReason_yaml(t *testing.T) {
+	const data = "response_ttl_by_reason:\n" +
+		"  FilteredSafeBrowsing: 60\n" +
+		"  FilteredBlackList: 3600\n"
+
+	c := &Config{}
+	err := yaml.Unmarshal([]byte(data), c)
+	require.NoError(t, err)
+
+	want := map[Reason]uint32{
+		FilteredSafeBrowsing: 60,
+		FilteredBlockList:    3600,
+	}
+	assert.Equal(t, want, c.ResponseTTLByReason)
+
+	b, err := yaml.Marshal(&Config{ResponseTTLByReason: want})
+	require.NoError(t, err)
+
+	assert.Contains(t, string(b), "FilteredSafeBrowsing: 60")
+	assert.Contains(t, string(b), "FilteredBlackList: 3600")
+
+	err = yaml.Unmarshal([]byte("response_ttl_by_reason:\n  Unknown: 1\n"), c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,29 @@
+package filtering
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// limitRewriteIPs returns at most Config.MaxRewriteIPs addresses from ips.
+// Those are the first ones, unless Config.RotateRewriteIPs is true, in which
+// case each call starts from the next address of ips.  ips is returned as is
+// if there is no limit.  d.confLock is expected to be locked.
+func (d *DNSFilter) limitRewriteIPs(ips []net.IP) (limited []net.IP) {
+	limit := int(d.MaxRewriteIPs)
+	if limit == 0 || len(ips) <= limit {
+		return ips
+	}
+
+	if !d.RotateRewriteIPs {
+		return ips[:limit:limit]
+	}
+
+	start := int((atomic.AddUint32(&d.rewriteRotation, 1) - 1) % uint32(len(ips)))
+	limited = make([]net.IP, 0, limit)
+	for i := 0; i < limit; i++ {
+		limited = append(limited, ips[(start+i)%len(ips)])
+	}
+
+	return limited
+}
@@ -0,0 +1,77 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_maxRewriteIPs(t *testing.T) {
+	const host = "many.example"
+
+	var rewrites []RewriteEntry
+	var all []net.IP
+	for i := byte(1); i <= 5; i++ {
+		ip := net.IP{192, 0, 2, i}
+		rewrites = append(rewrites, RewriteEntry{Domain: host, Answer: ip.String()})
+		all = append(all, ip)
+	}
+
+	newFilter := func(t *testing.T, limit uint, rotate bool) (d *DNSFilter) {
+		t.Helper()
+
+		d = newForTest(t, &Config{
+			Rewrites:         rewrites,
+			MaxRewriteIPs:    limit,
+			RotateRewriteIPs: rotate,
+		}, nil)
+		t.Cleanup(d.Close)
+
+		return d
+	}
+
+	check := func(t *testing.T, d *DNSFilter) (ips []net.IP) {
+		t.Helper()
+
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+		require.Equal(t, Rewritten, res.Reason)
+
+		return res.IPList
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		d := newFilter(t, 0, false)
+
+		assert.Equal(t, all, check(t, d))
+	})
+
+	t.Run("under_limit", func(t *testing.T) {
+		d := newFilter(t, 10, true)
+
+		assert.Equal(t, all, check(t, d))
+		assert.Equal(t, all, check(t, d))
+	})
+
+	t.Run("stable", func(t *testing.T) {
+		d := newFilter(t, 2, false)
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, all[:2], check(t, d))
+		}
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		d := newFilter(t, 2, true)
+
+		assert.Equal(t, []net.IP{all[0], all[1]}, check(t, d))
+		assert.Equal(t, []net.IP{all[1], all[2]}, check(t, d))
+		assert.Equal(t, []net.IP{all[2], all[3]}, check(t, d))
+		assert.Equal(t, []net.IP{all[3], all[4]}, check(t, d))
+		assert.Equal(t, []net.IP{all[4], all[0]}, check(t, d))
+		assert.Equal(t, []net.IP{all[0], all[1]}, check(t, d))
+	})
+}
@@ -4,12 +4,18 @@ package filtering
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
 
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 )
 
@@ -18,20 +24,152 @@ type RewriteEntry struct {
 	// Domain is the domain for which this rewrite should work.
 	Domain string `yaml:"domain"`
 	// Answer is the IP address, canonical name, or one of the special
-	// values: "A" or "AAAA".
+	// values: "A" or "AAAA".  If Domain is a reversed IP address, like
+	// "1.0.168.192.in-addr.arpa", Answer is the hostname for the PTR
+	// requests.
 	Answer string `yaml:"answer"`
 	// IP is the IP address that should be used in the response if Type is
 	// A or AAAA.
 	IP net.IP `yaml:"-"`
-	// Type is the DNS record type: A, AAAA, or CNAME.
+	// MX, if not nil, makes the entry answer the MX requests with the mail
+	// exchange.  Answer isn't used for such entries.
+	MX *RewriteMX `yaml:"mx,omitempty"`
+	// Type is the DNS record type: A, AAAA, CNAME, MX, or PTR.
 	Type uint16 `yaml:"-"`
+	// Subnet, if not empty, is the subnet in CIDR notation, like
+	// "192.168.0.0/24", restricting the entry to the clients with addresses
+	// within it.  ClientSubnet is parsed from it.
+	Subnet string `yaml:"client_subnet,omitempty"`
+	// ClientSubnet, if not nil, restricts the entry to the clients with
+	// addresses within the subnet.  It's set from Subnet on normalizing, and
+	// Subnet is set from it if empty.
+	ClientSubnet *net.IPNet `yaml:"-"`
+	// ECSAnswers are the answers for the requests with the EDNS Client
+	// Subnet option, see Settings.ECS.  Answer is used for the requests
+	// matching none of them.  Only the A and AAAA entries may have those.
+	ECSAnswers []RewriteECSAnswer `yaml:"ecs_answers,omitempty"`
+	// CountryAnswers are the answers for the clients from the countries,
+	// see Config.GeoIP.  Those are only used if none of ECSAnswers match
+	// the request.  Only the A and AAAA entries may have those.
+	CountryAnswers []RewriteCountryAnswer `yaml:"country_answers,omitempty"`
+	// Pinned makes the entry take precedence over all the entries which
+	// aren't pinned, even the more specific ones, and prevents it from
+	// being deleted with the HTTP API.  The pinned entries can only be set
+	// in the configuration file.
+	Pinned bool `yaml:"pinned,omitempty"`
+	// TTL is the TTL of the answer, in seconds.  Zero means that the
+	// default TTL is used.  A response built from several entries, like a
+	// chain of CNAME rewrites, has the minimal TTL of those, see
+	// Result.TTL.
+	TTL uint32 `yaml:"ttl,omitempty"`
+	// Upstream, if not empty, is the address of the DNS server resolving the
+	// canonical name of a CNAME entry instead of the default upstreams, like
+	// "tls://dns.example".  Only the CNAME entries may have it.  It must not
+	// point at the server itself, see ValidateRewrites.
+	Upstream string `yaml:"upstream,omitempty"`
+}
+
+// RewriteECSAnswer is an answer of a rewrite entry scoped to an EDNS Client
+// Subnet.
+type RewriteECSAnswer struct {
+	// Subnet is the subnet in CIDR notation, like "192.0.2.0/24".  The
+	// answer is used if it contains the address of the request's ECS.
+	Subnet string `yaml:"subnet"`
+	// Answer is the IP address of the same family as the entry's one.
+	Answer string `yaml:"answer"`
+}
+
+// RewriteCountryAnswer is an answer of a rewrite entry scoped to the country of
+// the client.
+type RewriteCountryAnswer struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, like "DE".  The
+	// case is ignored.
+	Country string `yaml:"country"`
+	// Answer is the IP address of the same family as the entry's one.
+	Answer string `yaml:"answer"`
+}
+
+// RewriteMX is the mail exchange of an MX rewrite entry.
+type RewriteMX struct {
+	// Exchange is the hostname of the mail exchange.
+	Exchange string `yaml:"exchange"`
+	// Preference is the preference of the mail exchange, the lower ones
+	// are preferred.
+	Preference uint16 `yaml:"preference"`
+}
+
+// appendRewritePTR returns dnsrr with the PTR record for host added.  dnsrr is
+// created if it's nil.
+func appendRewritePTR(dnsrr *DNSRewriteResult, host string) (res *DNSRewriteResult) {
+	if dnsrr == nil {
+		dnsrr = &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{},
+			RCode:    dns.RcodeSuccess,
+		}
+	}
+
+	dnsrr.Response[dns.TypePTR] = append(dnsrr.Response[dns.TypePTR], host)
+
+	return dnsrr
+}
+
+// appendRewriteMX returns dnsrr with the MX record for mx added.  dnsrr is
+// created if it's nil.
+func appendRewriteMX(dnsrr *DNSRewriteResult, mx *RewriteMX) (res *DNSRewriteResult) {
+	if dnsrr == nil {
+		dnsrr = &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{},
+			RCode:    dns.RcodeSuccess,
+		}
+	}
+
+	dnsrr.Response[dns.TypeMX] = append(dnsrr.Response[dns.TypeMX], &rules.DNSMX{
+		Exchange:   mx.Exchange,
+		Preference: mx.Preference,
+	})
+
+	return dnsrr
+}
+
+// clientSubnet returns the subnet the entry is restricted to in CIDR notation.
+// It's empty if the entry isn't restricted.
+func (e *RewriteEntry) clientSubnet() (subnet string) {
+	if e.ClientSubnet != nil {
+		return e.ClientSubnet.String()
+	}
+
+	_, n, err := net.ParseCIDR(e.Subnet)
+	if err != nil {
+		return e.Subnet
+	}
+
+	return n.String()
 }
 
 // equal returns true if the entry is considered equal to the other.
 func (e *RewriteEntry) equal(other RewriteEntry) (ok bool) {
-	return e.Domain == other.Domain && e.Answer == other.Answer
+	if e.Domain != other.Domain || e.Answer != other.Answer {
+		return false
+	} else if e.clientSubnet() != other.clientSubnet() {
+		return false
+	} else if e.MX == nil || other.MX == nil {
+		return e.MX == other.MX
+	}
+
+	return *e.MX == *other.MX
 }
 
+// Rewrite precedences, see Config.RewritePrecedence.
+const (
+	// RewritePrecedenceRewrite makes the rewrites answer the requests for
+	// the hosts which are also blocked.  The canonical names the host is
+	// rewritten to are still checked against the blocking rules.
+	RewritePrecedenceRewrite = "rewrite"
+	// RewritePrecedenceBlock makes the requests for the hosts which are
+	// blocked by the rules blocked, even if those are also rewritten.
+	RewritePrecedenceBlock = "block"
+)
+
 // matchesQType returns true if the entry matched qtype.
 func (e *RewriteEntry) matchesQType(qtype uint16) (ok bool) {
 	// Add CNAMEs, since they match for all types requests.
@@ -39,6 +177,10 @@ func (e *RewriteEntry) matchesQType(qtype uint16) (ok bool) {
 		return true
 	}
 
+	if e.Type == dns.TypeMX || qtype == dns.TypeMX || e.Type == dns.TypePTR || qtype == dns.TypePTR {
+		return e.Type == qtype
+	}
+
 	// Reject types other than A and AAAA.
 	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
 		return false
@@ -49,6 +191,127 @@ func (e *RewriteEntry) matchesQType(qtype uint16) (ok bool) {
 	return e.Type == qtype || e.IP == nil
 }
 
+// answerIP returns the IP address of the entry for the request with setts.  The
+// scoped answers are used in the following order:
+//
+//  1. the one from ECSAnswers for setts.ECS, see ecsIP;
+//
+//  2. the one from CountryAnswers for the country of setts.ClientIP according
+//     to geo, see countryIP;
+//
+//  3. e.IP.
+//
+// geo may be nil.
+func (e *RewriteEntry) answerIP(setts *Settings, geo GeoIP) (ip net.IP) {
+	if ip = e.ecsIP(setts.ECS); ip != nil {
+		return ip
+	} else if ip = e.countryIP(setts.ClientIP, geo); ip != nil {
+		return ip
+	}
+
+	return e.IP
+}
+
+// isSvcbType returns true if qtype is one of the service binding types, SVCB
+// or HTTPS.
+func isSvcbType(qtype uint16) (ok bool) {
+	return qtype == dns.TypeSVCB || qtype == dns.TypeHTTPS
+}
+
+// addrRewritesTTL returns the minimal TTL of the A and AAAA rewrites for host,
+// see RewriteEntry.TTL.  ok is false if there are no such rewrites, apart from
+// the exceptions like "A" answers, which leave the host to the upstream.
+// d.confLock is expected to be locked.
+func (d *DNSFilter) addrRewritesTTL(host string, setts *Settings) (ttl uint32, ok bool) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rr := findRewrites(d.Rewrites, host, qtype, setts.ClientIP, d.StrictWildcards)
+		for _, r := range rr {
+			if r.Type == qtype && r.IP != nil {
+				ttl, ok = minRewriteTTL(ttl, r.TTL), true
+			}
+		}
+	}
+
+	return ttl, ok
+}
+
+// minRewriteTTL returns the minimum of the TTLs a and b, see RewriteEntry.TTL.
+// Zero means an unset TTL, so it's only returned if both are unset.
+func minRewriteTTL(a, b uint32) (ttl uint32) {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+// ecsIP returns the IP address of the entry for the request with the EDNS
+// Client Subnet ecs.  If several scoped answers match, the one with the most
+// specific subnet is used.  If none do, or ecs is nil, ip is nil.
+func (e *RewriteEntry) ecsIP(ecs *net.IPNet) (ip net.IP) {
+	if ecs == nil || len(e.ECSAnswers) == 0 {
+		return nil
+	}
+
+	bestOnes := -1
+	for _, a := range e.ECSAnswers {
+		_, subnet, err := net.ParseCIDR(a.Subnet)
+		if err != nil || !subnet.Contains(ecs.IP) {
+			continue
+		}
+
+		ones, _ := subnet.Mask.Size()
+		if ones <= bestOnes {
+			continue
+		}
+
+		aIP := net.ParseIP(a.Answer)
+		if aIP == nil {
+			continue
+		} else if aIP4 := aIP.To4(); aIP4 != nil {
+			aIP = aIP4
+		}
+
+		ip, bestOnes = aIP, ones
+	}
+
+	return ip
+}
+
+// countryIP returns the IP address of the entry for the client with clientIP
+// from the country determined by geo.  ip is nil if there is no answer for the
+// country or it can't be determined.  geo may be nil.
+func (e *RewriteEntry) countryIP(clientIP net.IP, geo GeoIP) (ip net.IP) {
+	if geo == nil || clientIP == nil || len(e.CountryAnswers) == 0 {
+		return nil
+	}
+
+	country, ok := geo.Country(clientIP)
+	if !ok {
+		return nil
+	}
+
+	for _, a := range e.CountryAnswers {
+		if !strings.EqualFold(a.Country, country) {
+			continue
+		}
+
+		ip = net.ParseIP(a.Answer)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+
+		return ip
+	}
+
+	return nil
+}
+
+// matchesClient returns true if the entry applies to the client with ip.
+func (e *RewriteEntry) matchesClient(ip net.IP) (ok bool) {
+	return e.ClientSubnet == nil || (ip != nil && e.ClientSubnet.Contains(ip))
+}
+
 // normalize makes sure that the a new or decoded entry is normalized with
 // regards to domain name case, IP length, and so on.
 func (e *RewriteEntry) normalize() {
@@ -56,6 +319,14 @@ func (e *RewriteEntry) normalize() {
 	// and use it in matchDomainWildcard instead of using strings.ToLower
 	// everywhere.
 	e.Domain = strings.ToLower(e.Domain)
+	e.normalizeSubnet()
+
+	if e.MX != nil {
+		e.IP = nil
+		e.Type = dns.TypeMX
+
+		return
+	}
 
 	switch e.Answer {
 	case "AAAA":
@@ -75,6 +346,9 @@ func (e *RewriteEntry) normalize() {
 	ip := net.ParseIP(e.Answer)
 	if ip == nil {
 		e.Type = dns.TypeCNAME
+		if _, err := netutil.IPFromReversedAddr(e.Domain); err == nil {
+			e.Type = dns.TypePTR
+		}
 
 		return
 	}
@@ -89,13 +363,80 @@ func (e *RewriteEntry) normalize() {
 	}
 }
 
+// normalizeSubnet sets ClientSubnet from Subnet or, if the latter is empty,
+// Subnet from ClientSubnet.  An entry with a malformed subnet never matches,
+// see RewriteEntry.validate.
+func (e *RewriteEntry) normalizeSubnet() {
+	if e.Subnet == "" {
+		if e.ClientSubnet != nil {
+			e.Subnet = e.ClientSubnet.String()
+		}
+
+		return
+	}
+
+	_, n, err := net.ParseCIDR(e.Subnet)
+	if err != nil {
+		// Don't let the entry apply to all clients.
+		n = &net.IPNet{}
+	}
+
+	e.ClientSubnet = n
+}
+
 func isWildcard(host string) bool {
 	return len(host) > 1 && host[0] == '*' && host[1] == '.'
 }
 
-// matchDomainWildcard returns true if host matches the wildcard pattern.
-func matchDomainWildcard(host, wildcard string) (ok bool) {
-	return isWildcard(wildcard) && strings.HasSuffix(host, wildcard[1:])
+// validate returns an error if the subnet or the answer of a are invalid or if
+// the answer's family doesn't match the one of the entry.
+func (a RewriteECSAnswer) validate(isIPv4 bool) (err error) {
+	_, _, err = net.ParseCIDR(a.Subnet)
+	if err != nil {
+		return fmt.Errorf("bad subnet: %w", err)
+	}
+
+	ip := net.ParseIP(a.Answer)
+	if ip == nil {
+		return fmt.Errorf("bad answer %q", a.Answer)
+	} else if (ip.To4() != nil) != isIPv4 {
+		return fmt.Errorf("answer %s of other family", ip)
+	}
+
+	return nil
+}
+
+// validate returns an error if the country or the answer of a are invalid or if
+// the answer's family doesn't match the one of the entry.
+func (a RewriteCountryAnswer) validate(isIPv4 bool) (err error) {
+	if len(a.Country) != 2 || !isASCIILetter(a.Country[0]) || !isASCIILetter(a.Country[1]) {
+		return fmt.Errorf("bad country code %q", a.Country)
+	}
+
+	ip := net.ParseIP(a.Answer)
+	if ip == nil {
+		return fmt.Errorf("bad answer %q", a.Answer)
+	} else if (ip.To4() != nil) != isIPv4 {
+		return fmt.Errorf("answer %s of other family", ip)
+	}
+
+	return nil
+}
+
+// isASCIILetter returns true if c is an ASCII letter.
+func isASCIILetter(c byte) (ok bool) {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// matchDomainWildcard returns true if host matches the wildcard pattern.  If
+// strict is true, the wildcard only matches a single additional label, so
+// "*.example.com" matches "a.example.com" but not "a.b.example.com".
+func matchDomainWildcard(host, wildcard string, strict bool) (ok bool) {
+	if !isWildcard(wildcard) || !strings.HasSuffix(host, wildcard[1:]) {
+		return false
+	}
+
+	return !strict || !strings.Contains(host[:len(host)-len(wildcard)+1], ".")
 }
 
 // rewritesSorted is a slice of legacy rewrites for sorting.
@@ -136,28 +477,334 @@ func (a rewritesSorted) Less(i, j int) bool {
 	return len(a[i].Domain) > len(a[j].Domain)
 }
 
+// validate returns an error if the domain or the answer of the entry is empty
+// or if the IP address of the entry doesn't match its type, for example if an A
+// entry has an IPv6 address.  The IP address is taken from the IP field or, if
+// it's nil, from the answer.  The client subnet and the subnets of the ECS
+// answers must be valid, and the latter must have the addresses of the same
+// family as the entry's one.
+func (e *RewriteEntry) validate() (err error) {
+	if e.Domain == "" {
+		return errors.Error("empty domain")
+	} else if err = e.validateUpstream(); err != nil {
+		return fmt.Errorf("rewrite for %q: %w", e.Domain, err)
+	} else if _, _, err = net.ParseCIDR(e.Subnet); e.Subnet != "" && err != nil {
+		return fmt.Errorf("rewrite for %q: bad client subnet: %w", e.Domain, err)
+	} else if e.MX != nil {
+		if e.MX.Exchange == "" {
+			return fmt.Errorf("rewrite for %q: empty mx exchange", e.Domain)
+		}
+
+		return nil
+	} else if e.Answer == "" && e.IP == nil {
+		return fmt.Errorf("rewrite for %q: empty answer", e.Domain)
+	}
+
+	ip := e.IP
+	if ip == nil {
+		ip = net.ParseIP(e.Answer)
+	}
+
+	if ip == nil {
+		if len(e.ECSAnswers) > 0 {
+			return fmt.Errorf("rewrite for %q: ecs answers without ip answer", e.Domain)
+		} else if len(e.CountryAnswers) > 0 {
+			return fmt.Errorf("rewrite for %q: country answers without ip answer", e.Domain)
+		}
+
+		return nil
+	}
+
+	isIPv4 := ip.To4() != nil
+	for i, a := range e.ECSAnswers {
+		err = a.validate(isIPv4)
+		if err != nil {
+			return fmt.Errorf("rewrite for %q: ecs answer at index %d: %w", e.Domain, i, err)
+		}
+	}
+
+	for i, a := range e.CountryAnswers {
+		err = a.validate(isIPv4)
+		if err != nil {
+			return fmt.Errorf("rewrite for %q: country answer at index %d: %w", e.Domain, i, err)
+		}
+	}
+
+	if e.Type != dns.TypeA && e.Type != dns.TypeAAAA {
+		return nil
+	}
+
+	if e.Type == dns.TypeA && !isIPv4 {
+		return fmt.Errorf("rewrite for %q: A record with ipv6 address %s", e.Domain, ip)
+	} else if e.Type == dns.TypeAAAA && isIPv4 {
+		return fmt.Errorf("rewrite for %q: AAAA record with ipv4 address %s", e.Domain, ip)
+	}
+
+	return nil
+}
+
+// validateUpstream returns an error if the entry has an upstream but isn't a
+// CNAME one or if the upstream address is malformed.
+func (e *RewriteEntry) validateUpstream() (err error) {
+	if e.Upstream == "" {
+		return nil
+	}
+
+	isCNAME := e.MX == nil &&
+		e.IP == nil &&
+		e.Answer != "A" &&
+		e.Answer != "AAAA" &&
+		net.ParseIP(e.Answer) == nil
+	if isCNAME {
+		_, err = netutil.IPFromReversedAddr(e.Domain)
+		isCNAME = err != nil
+	}
+
+	if !isCNAME {
+		return errors.Error("upstream for non-cname entry")
+	}
+
+	_, err = upstream.AddressToUpstream(e.Upstream, &upstream.Options{})
+	if err != nil {
+		return fmt.Errorf("bad upstream %q: %w", e.Upstream, err)
+	}
+
+	return nil
+}
+
+// upstreamHost returns the hostname or the IP address of the DNS server with
+// the upstream address addr, like "tls://dns.example" or "192.0.2.1:53".  The
+// DNS stamps aren't decoded, so host is never a server's address for those.
+func upstreamHost(addr string) (host string) {
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return ""
+		}
+
+		return u.Hostname()
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	return strings.Trim(host, "[]")
+}
+
+// isSelfAddr returns true if host is "localhost", a loopback or unspecified
+// address, or one of names and ips.
+func isSelfAddr(host string, names []string, ips []net.IP) (ok bool) {
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		return true
+	}
+
+	return hasServerName(selfNames, host) || isServerAddr(host, names, ips)
+}
+
+// selfNames are the names which always refer to the server itself.
+var selfNames = []string{"localhost"}
+
+// isSelfUpstream returns true if the upstream address ups points at the server
+// itself, see Config.SelfNames.  d.confLock is expected to be locked.
+func (d *DNSFilter) isSelfUpstream(ups string) (ok bool) {
+	return isSelfAddr(upstreamHost(ups), d.selfNames(), d.selfIPs())
+}
+
+// refusedUpstreamResult returns the result for a request rewritten to an
+// upstream pointing at the server itself, which is answered with SERVFAIL to
+// break the loop.
+func refusedUpstreamResult() (res Result) {
+	return Result{
+		Reason: RewrittenRule,
+		DNSRewriteResult: &DNSRewriteResult{
+			RCode: dns.RcodeServerFailure,
+		},
+	}
+}
+
+// validateNotSelf returns an error if the upstream of the entry points at the
+// server itself with one of serverNames or serverIPs, "localhost", or a
+// loopback address, since the requests would loop back to it then.
+func (e *RewriteEntry) validateNotSelf(serverNames []string, serverIPs []net.IP) (err error) {
+	if e.Upstream == "" {
+		return nil
+	}
+
+	if host := upstreamHost(e.Upstream); isSelfAddr(host, serverNames, serverIPs) {
+		return fmt.Errorf("rewrite for %q: upstream %q points at the server itself", e.Domain, e.Upstream)
+	}
+
+	return nil
+}
+
+// selfNames returns the names of the server itself, see Config.SelfNames.
+func (c *Config) selfNames() (names []string) {
+	return append(c.ServerNames[:len(c.ServerNames):len(c.ServerNames)], c.SelfNames...)
+}
+
+// selfIPs returns the addresses of the server itself, see Config.SelfIPs.
+func (c *Config) selfIPs() (ips []net.IP) {
+	return append(c.ServerIPs[:len(c.ServerIPs):len(c.ServerIPs)], c.SelfIPs...)
+}
+
+// ValidateRewrites returns an error describing the invalid entries, see
+// RewriteEntry.validate.  The entries with the upstreams pointing at the server
+// itself, that is at "localhost", a loopback address, or one of serverNames and
+// serverIPs, are invalid as well, see Config.SelfNames.
+func ValidateRewrites(entries []RewriteEntry, serverNames []string, serverIPs []net.IP) (err error) {
+	var errs []error
+	for i := range entries {
+		err = entries[i].validate()
+		if err == nil {
+			err = entries[i].validateNotSelf(serverNames, serverIPs)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("entry %d: %w", i, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.List("invalid rewrites", errs...)
+	}
+
+	return nil
+}
+
+// prepareRewrites normalizes the rewrites.  The entries with the IP addresses
+// not matching their types are reported and then retyped according to their
+// answers.
 func (d *DNSFilter) prepareRewrites() {
+	err := ValidateRewrites(d.Rewrites, d.selfNames(), d.selfIPs())
+	if err != nil {
+		log.Error("filtering: %s", err)
+	}
+
 	for i := range d.Rewrites {
 		d.Rewrites[i].normalize()
 	}
 }
 
+// ReloadRewrites validates entries and, if they are valid, replaces the
+// rewrites with them.  The current rewrites are kept if entries are invalid.
+// The pinned entries are replaced as well, since entries are expected to come
+// from the configuration file, which is where those are set, see
+// RewriteEntry.Pinned.
+func (d *DNSFilter) ReloadRewrites(entries []RewriteEntry) (err error) {
+	d.confLock.Lock()
+	defer d.confLock.Unlock()
+
+	err = ValidateRewrites(entries, d.selfNames(), d.selfIPs())
+	if err != nil {
+		return fmt.Errorf("reloading rewrites: %w", err)
+	}
+
+	entries = cloneRewrites(entries)
+	for i := range entries {
+		entries[i].normalize()
+	}
+
+	d.Rewrites = entries
+
+	log.Debug("filtering: reloaded %d rewrites", len(d.Rewrites))
+
+	return nil
+}
+
+// addrRewrite returns the domain of the first A or AAAA entry answering with ip
+// for the request with setts, so that the addresses of the rewritten hosts are
+// resolved back to those.  The wildcard entries are ignored, since there is no
+// single name for those.  ok is false if there is no such entry.
+func addrRewrite(
+	entries []RewriteEntry,
+	ip net.IP,
+	setts *Settings,
+	geo GeoIP,
+) (domain string, ok bool) {
+	for _, e := range entries {
+		if e.Type != dns.TypeA && e.Type != dns.TypeAAAA {
+			continue
+		} else if e.IP == nil || isWildcard(e.Domain) || !e.matchesClient(setts.ClientIP) {
+			continue
+		}
+
+		if e.answerIP(setts, geo).Equal(ip) {
+			return e.Domain, true
+		}
+	}
+
+	return "", false
+}
+
+// addrPTRRewrite returns the result answering the PTR request for the reversed
+// address host with the domain of the A or AAAA rewrite with the address, see
+// addrRewrite.  res is empty if host isn't a reversed address or there is no
+// such rewrite.  d.confLock is expected to be locked.
+func (d *DNSFilter) addrPTRRewrite(host string, setts *Settings) (res Result) {
+	ip, err := netutil.IPFromReversedAddr(host)
+	if err != nil {
+		return Result{}
+	}
+
+	domain, ok := addrRewrite(d.Rewrites, ip, setts, d.GeoIP)
+	if !ok {
+		return Result{}
+	}
+
+	log.Debug("rewrite: PTR for %s is %s", ip, domain)
+
+	return Result{
+		Reason:           Rewritten,
+		DNSRewriteResult: appendRewritePTR(nil, domain),
+	}
+}
+
 // findRewrites returns the list of matched rewrite entries.  The priority is:
 // CNAME, then A and AAAA; exact, then wildcard.  If the host is matched
 // exactly, wildcard entries aren't returned.  If the host matched by wildcards,
-// return the most specific for the question type.
-func findRewrites(entries []RewriteEntry, host string, qtype uint16) (matched []RewriteEntry) {
-	rr := rewritesSorted{}
+// return the most specific for the question type.  Entries scoped to the
+// subnet of clientIP take precedence over the unscoped ones.  The pinned
+// entries take precedence over all the others, see RewriteEntry.Pinned.
+// strictWildcards is passed to matchDomainWildcard.
+func findRewrites(
+	entries []RewriteEntry,
+	host string,
+	qtype uint16,
+	clientIP net.IP,
+	strictWildcards bool,
+) (matched []RewriteEntry) {
+	rr, scoped := rewritesSorted{}, rewritesSorted{}
+	pinned := false
 	for _, e := range entries {
-		if e.Domain != host && !matchDomainWildcard(host, e.Domain) {
+		if e.Domain != host && !matchDomainWildcard(host, e.Domain, strictWildcards) {
 			continue
 		}
 
-		if e.matchesQType(qtype) {
+		if !e.matchesQType(qtype) || !e.matchesClient(clientIP) {
+			continue
+		}
+
+		if e.Pinned && !pinned {
+			// Drop the entries found so far, since those aren't pinned.
+			pinned = true
+			rr, scoped = rr[:0], scoped[:0]
+		} else if pinned && !e.Pinned {
+			continue
+		}
+
+		if e.ClientSubnet != nil {
+			scoped = append(scoped, e)
+		} else {
 			rr = append(rr, e)
 		}
 	}
 
+	if len(scoped) != 0 {
+		rr = scoped
+	}
+
 	if len(rr) == 0 {
 		return nil
 	}
@@ -188,6 +835,12 @@ func max(a, b int) int {
 type rewriteEntryJSON struct {
 	Domain string `json:"domain"`
 	Answer string `json:"answer"`
+	// ClientSubnet is the subnet the entry is restricted to, see
+	// RewriteEntry.Subnet.
+	ClientSubnet string `json:"client_subnet,omitempty"`
+	// Pinned is only used in the responses, the pinned entries can't be
+	// added with the HTTP API.
+	Pinned bool `json:"pinned,omitempty"`
 }
 
 func (d *DNSFilter) handleRewriteList(w http.ResponseWriter, r *http.Request) {
@@ -196,8 +849,10 @@ func (d *DNSFilter) handleRewriteList(w http.ResponseWriter, r *http.Request) {
 	d.confLock.Lock()
 	for _, ent := range d.Config.Rewrites {
 		jsent := rewriteEntryJSON{
-			Domain: ent.Domain,
-			Answer: ent.Answer,
+			Domain:       ent.Domain,
+			Answer:       ent.Answer,
+			ClientSubnet: ent.clientSubnet(),
+			Pinned:       ent.Pinned,
 		}
 		arr = append(arr, &jsent)
 	}
@@ -222,9 +877,19 @@ func (d *DNSFilter) handleRewriteAdd(w http.ResponseWriter, r *http.Request) {
 	ent := RewriteEntry{
 		Domain: jsent.Domain,
 		Answer: jsent.Answer,
+		Subnet: jsent.ClientSubnet,
 	}
-	ent.normalize()
+
 	d.confLock.Lock()
+	err = ValidateRewrites([]RewriteEntry{ent}, d.selfNames(), d.selfIPs())
+	if err != nil {
+		d.confLock.Unlock()
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+
+	ent.normalize()
 	d.Config.Rewrites = append(d.Config.Rewrites, ent)
 	d.confLock.Unlock()
 	log.Debug("Rewrites: added element: %s -> %s [%d]",
@@ -244,11 +909,17 @@ func (d *DNSFilter) handleRewriteDelete(w http.ResponseWriter, r *http.Request)
 	entDel := RewriteEntry{
 		Domain: jsent.Domain,
 		Answer: jsent.Answer,
+		Subnet: jsent.ClientSubnet,
 	}
 	arr := []RewriteEntry{}
 	d.confLock.Lock()
 	for _, ent := range d.Config.Rewrites {
-		if ent.equal(entDel) {
+		if ent.equal(entDel) && ent.Pinned {
+			d.confLock.Unlock()
+			httpError(r, w, http.StatusForbidden, "rewrite %s -> %s is pinned", ent.Domain, ent.Answer)
+
+			return
+		} else if ent.equal(entDel) {
 			log.Debug("Rewrites: removed element: %s -> %s", ent.Domain, ent.Answer)
 			continue
 		}
@@ -260,8 +931,29 @@ func (d *DNSFilter) handleRewriteDelete(w http.ResponseWriter, r *http.Request)
 	d.Config.ConfigModified()
 }
 
+// handleRewriteReload replaces the rewrites with the ones from the
+// configuration file.
+func (d *DNSFilter) handleRewriteReload(w http.ResponseWriter, r *http.Request) {
+	entries, err := d.Config.ReadRewrites()
+	if err != nil {
+		httpError(r, w, http.StatusInternalServerError, "reading rewrites: %s", err)
+
+		return
+	}
+
+	err = d.ReloadRewrites(entries)
+	if err != nil {
+		httpError(r, w, http.StatusBadRequest, "%s", err)
+
+		return
+	}
+}
+
 func (d *DNSFilter) registerRewritesHandlers() {
 	d.Config.HTTPRegister(http.MethodGet, "/control/rewrite/list", d.handleRewriteList)
 	d.Config.HTTPRegister(http.MethodPost, "/control/rewrite/add", d.handleRewriteAdd)
 	d.Config.HTTPRegister(http.MethodPost, "/control/rewrite/delete", d.handleRewriteDelete)
+	if d.Config.ReadRewrites != nil {
+		d.Config.HTTPRegister(http.MethodPost, "/control/rewrite/reload", d.handleRewriteReload)
+	}
 }
@@ -1,9 +1,16 @@
 package filtering
 
 import (
+	"bytes"
+	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/urlfilter/rules"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -141,7 +148,7 @@ func TestRewrites(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			valsNum := len(tc.wantVals)
 
-			r := d.processRewrites(tc.host, tc.dtyp)
+			r := d.processRewrites(tc.host, tc.dtyp, &Settings{})
 			if valsNum == 0 {
 				assert.Equal(t, NotFilteredNotFound, r.Reason)
 
@@ -201,7 +208,7 @@ func TestRewritesLevels(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r := d.processRewrites(tc.host, dns.TypeA)
+			r := d.processRewrites(tc.host, dns.TypeA, &Settings{})
 			assert.Equal(t, Rewritten, r.Reason)
 			require.Len(t, r.IPList, 1)
 		})
@@ -242,7 +249,7 @@ func TestRewritesExceptionCNAME(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r := d.processRewrites(tc.host, dns.TypeA)
+			r := d.processRewrites(tc.host, dns.TypeA, &Settings{})
 			if tc.want == nil {
 				assert.Equal(t, NotFilteredNotFound, r.Reason)
 
@@ -322,7 +329,7 @@ func TestRewritesExceptionIP(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name+"_"+tc.host, func(t *testing.T) {
-			r := d.processRewrites(tc.host, tc.dtyp)
+			r := d.processRewrites(tc.host, tc.dtyp, &Settings{})
 			if tc.want == nil {
 				assert.Equal(t, NotFilteredNotFound, r.Reason)
 
@@ -339,3 +346,1551 @@ func TestRewritesExceptionIP(t *testing.T) {
 		})
 	}
 }
+
+func TestRewritesClientSubnet(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	_, lan, err := net.ParseCIDR("192.168.0.0/16")
+	require.NoError(t, err)
+
+	_, vpn, err := net.ParseCIDR("10.8.0.0/24")
+	require.NoError(t, err)
+
+	d.Rewrites = []RewriteEntry{{
+		Domain:       "intranet.example.com",
+		Answer:       "192.168.1.1",
+		ClientSubnet: lan,
+	}, {
+		Domain:       "intranet.example.com",
+		Answer:       "10.8.0.1",
+		ClientSubnet: vpn,
+	}, {
+		Domain: "*.example.com",
+		Answer: "1.2.3.4",
+	}, {
+		Domain:       "vpn-only.example.com",
+		Answer:       "intranet.example.com",
+		ClientSubnet: vpn,
+	}}
+	d.prepareRewrites()
+
+	testCases := []struct {
+		name      string
+		host      string
+		clientIP  net.IP
+		wantCName string
+		want      net.IP
+	}{{
+		name:     "lan",
+		host:     "intranet.example.com",
+		clientIP: net.IP{192, 168, 10, 10},
+		want:     net.IP{192, 168, 1, 1},
+	}, {
+		name:     "vpn",
+		host:     "intranet.example.com",
+		clientIP: net.IP{10, 8, 0, 5},
+		want:     net.IP{10, 8, 0, 1},
+	}, {
+		name:     "other_subnet",
+		host:     "intranet.example.com",
+		clientIP: net.IP{172, 16, 0, 1},
+		want:     net.IP{1, 2, 3, 4},
+	}, {
+		name:     "no_client_ip",
+		host:     "intranet.example.com",
+		clientIP: nil,
+		want:     net.IP{1, 2, 3, 4},
+	}, {
+		name:      "scoped_cname",
+		host:      "vpn-only.example.com",
+		clientIP:  net.IP{10, 8, 0, 5},
+		wantCName: "intranet.example.com",
+		want:      net.IP{10, 8, 0, 1},
+	}, {
+		name:     "scoped_cname_other_subnet",
+		host:     "vpn-only.example.com",
+		clientIP: net.IP{192, 168, 10, 10},
+		want:     net.IP{1, 2, 3, 4},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites(tc.host, dns.TypeA, &Settings{ClientIP: tc.clientIP})
+			require.Equalf(t, Rewritten, r.Reason, "got %s", r.Reason)
+
+			assert.Equal(t, tc.wantCName, r.CanonName)
+
+			require.Len(t, r.IPList, 1)
+
+			assert.Equal(t, tc.want, r.IPList[0])
+		})
+	}
+}
+
+func TestRewritesClientSubnet_http(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.ConfigModified = func() {}
+
+	do := func(t *testing.T, h http.HandlerFunc, body string) (w *httptest.ResponseRecorder) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, "/control/rewrite", strings.NewReader(body))
+		w = httptest.NewRecorder()
+		h(w, r)
+
+		return w
+	}
+
+	const lanBody = `{"domain":"nas.example","answer":"192.168.1.2","client_subnet":"192.168.0.0/16"}`
+	const vpnBody = `{"domain":"nas.example","answer":"192.168.1.2","client_subnet":"10.8.0.0/24"}`
+
+	require.Equal(t, http.StatusOK, do(t, d.handleRewriteAdd, lanBody).Code)
+	require.Equal(t, http.StatusOK, do(t, d.handleRewriteAdd, vpnBody).Code)
+
+	w := do(t, d.handleRewriteAdd, `{"domain":"a.example","answer":"1.2.3.4","client_subnet":"bad"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	r := d.processRewrites("nas.example", dns.TypeA, &Settings{ClientIP: net.IP{10, 8, 0, 5}})
+	assert.Equal(t, []net.IP{{192, 168, 1, 2}}, r.IPList)
+
+	r = d.processRewrites("nas.example", dns.TypeA, &Settings{ClientIP: net.IP{172, 16, 0, 1}})
+	assert.Empty(t, r.IPList)
+
+	require.Equal(t, http.StatusOK, do(t, d.handleRewriteDelete, vpnBody).Code)
+	require.Len(t, d.Rewrites, 1)
+
+	assert.Equal(t, "192.168.0.0/16", d.Rewrites[0].Subnet)
+
+	lw := httptest.NewRecorder()
+	d.handleRewriteList(lw, httptest.NewRequest(http.MethodGet, "/control/rewrite/list", nil))
+	require.Equal(t, http.StatusOK, lw.Code)
+
+	assert.JSONEq(t, "["+lanBody+"]", lw.Body.String())
+}
+
+func TestRewritesECS(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: "cdn.example.com",
+		Answer: "1.2.3.4",
+		ECSAnswers: []RewriteECSAnswer{{
+			Subnet: "198.51.100.0/24",
+			Answer: "198.51.100.1",
+		}, {
+			Subnet: "203.0.113.0/24",
+			Answer: "203.0.113.1",
+		}, {
+			Subnet: "203.0.113.128/25",
+			Answer: "203.0.113.129",
+		}},
+	}, {
+		Domain: "cdn.example.com",
+		Answer: "2001:db8::1",
+		ECSAnswers: []RewriteECSAnswer{{
+			Subnet: "2001:db8:1::/48",
+			Answer: "2001:db8:1::1",
+		}},
+	}}
+	d.prepareRewrites()
+
+	ecs := func(cidr string) (n *net.IPNet) {
+		_, n, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+
+		return n
+	}
+
+	testCases := []struct {
+		ecs   *net.IPNet
+		name  string
+		want  net.IP
+		qtype uint16
+	}{{
+		ecs:   ecs("198.51.100.0/24"),
+		name:  "first_subnet",
+		want:  net.IP{198, 51, 100, 1},
+		qtype: dns.TypeA,
+	}, {
+		ecs:   ecs("203.0.113.0/26"),
+		name:  "second_subnet",
+		want:  net.IP{203, 0, 113, 1},
+		qtype: dns.TypeA,
+	}, {
+		ecs:   ecs("203.0.113.192/26"),
+		name:  "most_specific",
+		want:  net.IP{203, 0, 113, 129},
+		qtype: dns.TypeA,
+	}, {
+		ecs:   ecs("192.0.2.0/24"),
+		name:  "unmatched",
+		want:  net.IP{1, 2, 3, 4},
+		qtype: dns.TypeA,
+	}, {
+		ecs:   nil,
+		name:  "no_ecs",
+		want:  net.IP{1, 2, 3, 4},
+		qtype: dns.TypeA,
+	}, {
+		ecs:   ecs("2001:db8:1:2::/64"),
+		name:  "ipv6",
+		want:  net.ParseIP("2001:db8:1::1"),
+		qtype: dns.TypeAAAA,
+	}, {
+		ecs:   ecs("2001:db8:2::/64"),
+		name:  "ipv6_unmatched",
+		want:  net.ParseIP("2001:db8::1"),
+		qtype: dns.TypeAAAA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites("cdn.example.com", tc.qtype, &Settings{ECS: tc.ecs})
+			require.Equalf(t, Rewritten, r.Reason, "got %s", r.Reason)
+			require.Len(t, r.IPList, 1)
+
+			assert.Equal(t, tc.want, r.IPList[0])
+		})
+	}
+}
+
+// fakeGeoIP is a GeoIP mapping the IP addresses to the countries.
+type fakeGeoIP map[string]string
+
+// Country implements the GeoIP interface for fakeGeoIP.
+func (g fakeGeoIP) Country(ip net.IP) (code string, ok bool) {
+	code, ok = g[ip.String()]
+
+	return code, ok
+}
+
+func TestRewritesCountry(t *testing.T) {
+	geo := fakeGeoIP{
+		"192.168.0.1": "DE",
+		"192.168.0.2": "US",
+		"192.168.0.3": "FR",
+	}
+
+	_, ecs, err := net.ParseCIDR("198.51.100.0/24")
+	require.NoError(t, err)
+
+	rewrites := []RewriteEntry{{
+		Domain: "service.example",
+		Answer: "192.0.2.1",
+		ECSAnswers: []RewriteECSAnswer{{
+			Subnet: "198.51.100.0/24",
+			Answer: "198.51.100.1",
+		}},
+		CountryAnswers: []RewriteCountryAnswer{{
+			Country: "de",
+			Answer:  "192.0.2.49",
+		}, {
+			Country: "US",
+			Answer:  "192.0.2.1",
+		}},
+	}}
+
+	testCases := []struct {
+		geo      GeoIP
+		ecs      *net.IPNet
+		clientIP net.IP
+		name     string
+		want     net.IP
+	}{{
+		geo:      geo,
+		ecs:      nil,
+		clientIP: net.IP{192, 168, 0, 1},
+		name:     "de",
+		want:     net.IP{192, 0, 2, 49},
+	}, {
+		geo:      geo,
+		ecs:      nil,
+		clientIP: net.IP{192, 168, 0, 2},
+		name:     "us",
+		want:     net.IP{192, 0, 2, 1},
+	}, {
+		geo:      geo,
+		ecs:      nil,
+		clientIP: net.IP{192, 168, 0, 3},
+		name:     "other_country",
+		want:     net.IP{192, 0, 2, 1},
+	}, {
+		geo:      geo,
+		ecs:      nil,
+		clientIP: net.IP{192, 168, 0, 4},
+		name:     "unknown_country",
+		want:     net.IP{192, 0, 2, 1},
+	}, {
+		geo:      geo,
+		ecs:      ecs,
+		clientIP: net.IP{192, 168, 0, 1},
+		name:     "ecs_first",
+		want:     net.IP{198, 51, 100, 1},
+	}, {
+		geo:      nil,
+		ecs:      nil,
+		clientIP: net.IP{192, 168, 0, 1},
+		name:     "no_geoip",
+		want:     net.IP{192, 0, 2, 1},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				Rewrites: rewrites,
+				GeoIP:    tc.geo,
+			}, nil)
+			t.Cleanup(d.Close)
+
+			r := d.processRewrites("service.example", dns.TypeA, &Settings{
+				ClientIP: tc.clientIP,
+				ECS:      tc.ecs,
+			})
+			require.Equalf(t, Rewritten, r.Reason, "got %s", r.Reason)
+
+			assert.Equal(t, []net.IP{tc.want}, r.IPList)
+		})
+	}
+}
+
+func TestRewritesMX(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: "example.com",
+		MX:     &RewriteMX{Exchange: "relay.lan", Preference: 10},
+	}, {
+		Domain: "example.com",
+		MX:     &RewriteMX{Exchange: "backup-relay.lan", Preference: 20},
+	}, {
+		Domain: "example.com",
+		Answer: "1.2.3.4",
+	}, {
+		Domain: "*.example.org",
+		MX:     &RewriteMX{Exchange: "wildcard-relay.lan", Preference: 5},
+	}, {
+		Domain: "alias.example.net",
+		Answer: "example.com",
+	}}
+	d.prepareRewrites()
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantCName  string
+		wantMX     []*rules.DNSMX
+		qtype      uint16
+		wantReason Reason
+	}{{
+		name:      "multiple",
+		host:      "example.com",
+		wantCName: "",
+		wantMX: []*rules.DNSMX{{
+			Exchange:   "relay.lan",
+			Preference: 10,
+		}, {
+			Exchange:   "backup-relay.lan",
+			Preference: 20,
+		}},
+		qtype:      dns.TypeMX,
+		wantReason: Rewritten,
+	}, {
+		name:      "wildcard",
+		host:      "mail.example.org",
+		wantCName: "",
+		wantMX: []*rules.DNSMX{{
+			Exchange:   "wildcard-relay.lan",
+			Preference: 5,
+		}},
+		qtype:      dns.TypeMX,
+		wantReason: Rewritten,
+	}, {
+		name:      "cname",
+		host:      "alias.example.net",
+		wantCName: "example.com",
+		wantMX: []*rules.DNSMX{{
+			Exchange:   "relay.lan",
+			Preference: 10,
+		}, {
+			Exchange:   "backup-relay.lan",
+			Preference: 20,
+		}},
+		qtype:      dns.TypeMX,
+		wantReason: Rewritten,
+	}, {
+		name:       "a",
+		host:       "example.com",
+		wantCName:  "",
+		wantMX:     nil,
+		qtype:      dns.TypeA,
+		wantReason: Rewritten,
+	}, {
+		name:       "other",
+		host:       "example.net",
+		wantCName:  "",
+		wantMX:     nil,
+		qtype:      dns.TypeMX,
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites(tc.host, tc.qtype, &Settings{})
+			require.Equal(t, tc.wantReason, r.Reason)
+
+			assert.Equal(t, tc.wantCName, r.CanonName)
+			if tc.wantMX == nil {
+				assert.Nil(t, r.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, r.DNSRewriteResult)
+
+			var mxs []*rules.DNSMX
+			for _, v := range r.DNSRewriteResult.Response[dns.TypeMX] {
+				mx, ok := v.(*rules.DNSMX)
+				require.True(t, ok)
+
+				mxs = append(mxs, mx)
+			}
+
+			assert.Equal(t, tc.wantMX, mxs)
+		})
+	}
+
+	t.Run("records", func(t *testing.T) {
+		r := d.processRewrites("example.com", dns.TypeMX, &Settings{})
+		require.NotNil(t, r.DNSRewriteResult)
+
+		rrs, err := r.DNSRewriteResult.Records("example.com.", dns.TypeMX, 10)
+		require.NoError(t, err)
+		require.Len(t, rrs, 2)
+
+		mx, ok := rrs[0].(*dns.MX)
+		require.True(t, ok)
+
+		assert.Equal(t, "relay.lan.", mx.Mx)
+		assert.Equal(t, uint16(10), mx.Preference)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		err := ValidateRewrites([]RewriteEntry{{
+			Domain: "example.com",
+			MX:     &RewriteMX{Preference: 10},
+		}}, nil, nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestRewritesPTR(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	revAddr := func(ip string) (arpa string) {
+		arpa, err := dns.ReverseAddr(ip)
+		require.NoError(t, err)
+
+		return strings.TrimSuffix(arpa, ".")
+	}
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: revAddr("192.168.0.1"),
+		Answer: "router.lan",
+	}, {
+		Domain: "nas.lan",
+		Answer: "192.168.0.2",
+	}, {
+		Domain: "nas.lan",
+		Answer: "2001:db8::2",
+	}, {
+		Domain: "*.wildcard.lan",
+		Answer: "192.168.0.3",
+	}, {
+		Domain: "router.lan",
+		Answer: "192.168.0.4",
+	}}
+	d.prepareRewrites()
+
+	require.Equal(t, dns.TypePTR, d.Rewrites[0].Type)
+
+	testCases := []struct {
+		name       string
+		host       string
+		want       string
+		qtype      uint16
+		wantReason Reason
+	}{{
+		name:       "explicit",
+		host:       revAddr("192.168.0.1"),
+		want:       "router.lan",
+		qtype:      dns.TypePTR,
+		wantReason: Rewritten,
+	}, {
+		name:       "from_a",
+		host:       revAddr("192.168.0.2"),
+		want:       "nas.lan",
+		qtype:      dns.TypePTR,
+		wantReason: Rewritten,
+	}, {
+		name:       "from_aaaa",
+		host:       revAddr("2001:db8::2"),
+		want:       "nas.lan",
+		qtype:      dns.TypePTR,
+		wantReason: Rewritten,
+	}, {
+		name:       "wildcard",
+		host:       revAddr("192.168.0.3"),
+		want:       "",
+		qtype:      dns.TypePTR,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "unknown",
+		host:       revAddr("192.168.0.5"),
+		want:       "",
+		qtype:      dns.TypePTR,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "not_ptr",
+		host:       revAddr("192.168.0.1"),
+		want:       "",
+		qtype:      dns.TypeA,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "a_not_ptr",
+		host:       "router.lan",
+		want:       "",
+		qtype:      dns.TypePTR,
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites(tc.host, tc.qtype, &Settings{})
+			require.Equal(t, tc.wantReason, r.Reason)
+
+			if tc.want == "" {
+				assert.Nil(t, r.DNSRewriteResult)
+
+				return
+			}
+
+			require.NotNil(t, r.DNSRewriteResult)
+
+			assert.Equal(t, []rules.RRValue{tc.want}, r.DNSRewriteResult.Response[dns.TypePTR])
+		})
+	}
+
+	t.Run("records", func(t *testing.T) {
+		host := revAddr("192.168.0.2")
+		res, err := d.CheckHost(host, dns.TypePTR, &setts)
+		require.NoError(t, err)
+		require.NotNil(t, res.DNSRewriteResult)
+
+		rrs, err := res.DNSRewriteResult.Records(dns.Fqdn(host), dns.TypePTR, 10)
+		require.NoError(t, err)
+		require.Len(t, rrs, 1)
+
+		ptr, ok := rrs[0].(*dns.PTR)
+		require.True(t, ok)
+
+		assert.Equal(t, "nas.lan.", ptr.Ptr)
+	})
+}
+
+func TestRewritesStrictWildcards(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: "*.example.com",
+		Answer: "1.2.3.4",
+	}}
+	d.prepareRewrites()
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantStrict Reason
+		wantLoose  Reason
+	}{{
+		name:       "single_level",
+		host:       "a.example.com",
+		wantStrict: Rewritten,
+		wantLoose:  Rewritten,
+	}, {
+		name:       "multi_level",
+		host:       "a.b.example.com",
+		wantStrict: NotFilteredNotFound,
+		wantLoose:  Rewritten,
+	}, {
+		name:       "parent",
+		host:       "example.com",
+		wantStrict: NotFilteredNotFound,
+		wantLoose:  NotFilteredNotFound,
+	}, {
+		name:       "other",
+		host:       "aexample.com",
+		wantStrict: NotFilteredNotFound,
+		wantLoose:  NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d.StrictWildcards = false
+			r := d.processRewrites(tc.host, dns.TypeA, &Settings{})
+			assert.Equal(t, tc.wantLoose, r.Reason)
+
+			d.StrictWildcards = true
+			r = d.processRewrites(tc.host, dns.TypeA, &Settings{})
+			assert.Equal(t, tc.wantStrict, r.Reason)
+		})
+	}
+}
+
+func TestValidateRewrites(t *testing.T) {
+	testCases := []struct {
+		name       string
+		wantErrMsg string
+		entry      RewriteEntry
+	}{{
+		name:       "a_ipv4",
+		wantErrMsg: "",
+		entry:      RewriteEntry{Domain: "a.example", Answer: "1.2.3.4", Type: dns.TypeA},
+	}, {
+		name:       "aaaa_ipv6",
+		wantErrMsg: "",
+		entry:      RewriteEntry{Domain: "a.example", Answer: "::1", Type: dns.TypeAAAA},
+	}, {
+		name: "a_ipv6",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`A record with ipv6 address ::1`,
+		entry: RewriteEntry{Domain: "a.example", Answer: "::1", Type: dns.TypeA},
+	}, {
+		name: "aaaa_ipv4",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`AAAA record with ipv4 address 1.2.3.4`,
+		entry: RewriteEntry{Domain: "a.example", Answer: "1.2.3.4", Type: dns.TypeAAAA},
+	}, {
+		name: "aaaa_ipv4_field",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`AAAA record with ipv4 address 1.2.3.4`,
+		entry: RewriteEntry{
+			Domain: "a.example",
+			Answer: "1.2.3.4",
+			IP:     net.IP{1, 2, 3, 4},
+			Type:   dns.TypeAAAA,
+		},
+	}, {
+		name:       "cname",
+		wantErrMsg: "",
+		entry:      RewriteEntry{Domain: "a.example", Answer: "b.example", Type: dns.TypeCNAME},
+	}, {
+		name:       "untyped",
+		wantErrMsg: "",
+		entry:      RewriteEntry{Domain: "a.example", Answer: "::1"},
+	}, {
+		name:       "empty_domain",
+		wantErrMsg: "invalid rewrites: entry 0: empty domain",
+		entry:      RewriteEntry{Domain: "", Answer: "1.2.3.4"},
+	}, {
+		name:       "empty_answer",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": empty answer`,
+		entry:      RewriteEntry{Domain: "a.example", Answer: ""},
+	}, {
+		name:       "ecs",
+		wantErrMsg: "",
+		entry: RewriteEntry{
+			Domain:     "a.example",
+			Answer:     "1.2.3.4",
+			ECSAnswers: []RewriteECSAnswer{{Subnet: "192.0.2.0/24", Answer: "192.0.2.1"}},
+		},
+	}, {
+		name: "bad_client_subnet",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`bad client subnet: invalid CIDR address: 10.8.0.0`,
+		entry: RewriteEntry{Domain: "a.example", Answer: "1.2.3.4", Subnet: "10.8.0.0"},
+	}, {
+		name: "ecs_bad_subnet",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`ecs answer at index 0: bad subnet: invalid CIDR address: 192.0.2.0`,
+		entry: RewriteEntry{
+			Domain:     "a.example",
+			Answer:     "1.2.3.4",
+			ECSAnswers: []RewriteECSAnswer{{Subnet: "192.0.2.0", Answer: "192.0.2.1"}},
+		},
+	}, {
+		name: "ecs_other_family",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`ecs answer at index 0: answer 2001:db8::1 of other family`,
+		entry: RewriteEntry{
+			Domain:     "a.example",
+			Answer:     "1.2.3.4",
+			ECSAnswers: []RewriteECSAnswer{{Subnet: "192.0.2.0/24", Answer: "2001:db8::1"}},
+		},
+	}, {
+		name: "ecs_cname",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`ecs answers without ip answer`,
+		entry: RewriteEntry{
+			Domain:     "a.example",
+			Answer:     "b.example",
+			ECSAnswers: []RewriteECSAnswer{{Subnet: "192.0.2.0/24", Answer: "192.0.2.1"}},
+		},
+	}, {
+		name:       "country",
+		wantErrMsg: "",
+		entry: RewriteEntry{
+			Domain:         "a.example",
+			Answer:         "1.2.3.4",
+			CountryAnswers: []RewriteCountryAnswer{{Country: "de", Answer: "192.0.2.1"}},
+		},
+	}, {
+		name: "country_bad_code",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`country answer at index 0: bad country code "DEU"`,
+		entry: RewriteEntry{
+			Domain:         "a.example",
+			Answer:         "1.2.3.4",
+			CountryAnswers: []RewriteCountryAnswer{{Country: "DEU", Answer: "192.0.2.1"}},
+		},
+	}, {
+		name: "country_other_family",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`country answer at index 0: answer 2001:db8::1 of other family`,
+		entry: RewriteEntry{
+			Domain:         "a.example",
+			Answer:         "1.2.3.4",
+			CountryAnswers: []RewriteCountryAnswer{{Country: "DE", Answer: "2001:db8::1"}},
+		},
+	}, {
+		name: "country_cname",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`country answers without ip answer`,
+		entry: RewriteEntry{
+			Domain:         "a.example",
+			Answer:         "b.example",
+			CountryAnswers: []RewriteCountryAnswer{{Country: "DE", Answer: "192.0.2.1"}},
+		},
+	}, {
+		name: "upstream_ip",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream for non-cname entry`,
+		entry: RewriteEntry{
+			Domain:   "a.example",
+			Answer:   "1.2.3.4",
+			Upstream: "tls://dns.example",
+		},
+	}, {
+		name: "upstream_bad",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`bad upstream "bad://dns.example": unsupported URL scheme: bad`,
+		entry: RewriteEntry{
+			Domain:   "a.example",
+			Answer:   "b.example",
+			Upstream: "bad://dns.example",
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRewrites([]RewriteEntry{tc.entry}, nil, nil)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			require.Error(t, err)
+
+			assert.Equal(t, tc.wantErrMsg, err.Error())
+		})
+	}
+
+	t.Run("prepare", func(t *testing.T) {
+		d := newForTest(t, nil, nil)
+		t.Cleanup(d.Close)
+
+		d.Rewrites = []RewriteEntry{{
+			Domain: "a.example",
+			Answer: "::1",
+			Type:   dns.TypeA,
+		}}
+		d.prepareRewrites()
+
+		// The entry is retyped according to its answer.
+		assert.Equal(t, dns.TypeAAAA, d.Rewrites[0].Type)
+
+		r := d.processRewrites("a.example", dns.TypeA, &Settings{})
+		assert.Empty(t, r.IPList)
+	})
+}
+
+func TestValidateRewrites_upstreamLoop(t *testing.T) {
+	serverNames := []string{"dns.lan"}
+	serverIPs := []net.IP{{192, 168, 0, 1}, net.ParseIP("fd00::1")}
+
+	testCases := []struct {
+		name       string
+		upstream   string
+		wantErrMsg string
+	}{{
+		name:     "self_ip",
+		upstream: "192.168.0.1:53",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream "192.168.0.1:53" points at the server itself`,
+	}, {
+		name:     "self_ipv6",
+		upstream: "[fd00::1]:53",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream "[fd00::1]:53" points at the server itself`,
+	}, {
+		name:     "self_name",
+		upstream: "tls://DNS.lan",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream "tls://DNS.lan" points at the server itself`,
+	}, {
+		name:     "localhost",
+		upstream: "tcp://localhost:53",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream "tcp://localhost:53" points at the server itself`,
+	}, {
+		name:     "loopback",
+		upstream: "127.0.0.53:53",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream "127.0.0.53:53" points at the server itself`,
+	}, {
+		name:     "loopback_ipv6",
+		upstream: "[::1]:53",
+		wantErrMsg: `invalid rewrites: entry 0: rewrite for "a.example": ` +
+			`upstream "[::1]:53" points at the server itself`,
+	}, {
+		name:       "external_ip",
+		upstream:   "192.0.2.1",
+		wantErrMsg: "",
+	}, {
+		name:       "external_name",
+		upstream:   "https://dns.example/dns-query",
+		wantErrMsg: "",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRewrites([]RewriteEntry{{
+				Domain:   "a.example",
+				Answer:   "b.example",
+				Upstream: tc.upstream,
+			}}, serverNames, serverIPs)
+			if tc.wantErrMsg == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			require.Error(t, err)
+
+			assert.Equal(t, tc.wantErrMsg, err.Error())
+		})
+	}
+
+	t.Run("runtime", func(t *testing.T) {
+		d := newForTest(t, &Config{
+			SelfNames: serverNames,
+			SelfIPs:   serverIPs,
+			Rewrites: []RewriteEntry{{
+				Domain:   "self.example",
+				Answer:   "b.example",
+				Upstream: "udp://192.168.0.1",
+			}, {
+				Domain:   "external.example",
+				Answer:   "b.example",
+				Upstream: "udp://192.0.2.1",
+			}},
+		}, nil)
+		t.Cleanup(d.Close)
+
+		r := d.processRewrites("self.example", dns.TypeA, &setts)
+		assert.Equal(t, RewrittenRule, r.Reason)
+		assert.Empty(t, r.CanonName)
+		assert.Empty(t, r.Upstream)
+		require.NotNil(t, r.DNSRewriteResult)
+
+		assert.Equal(t, dns.RcodeServerFailure, r.DNSRewriteResult.RCode)
+
+		r, err := d.CheckHost("self.example", dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.Equal(t, RewrittenRule, r.Reason)
+
+		r = d.processRewrites("external.example", dns.TypeA, &setts)
+		assert.Equal(t, Rewritten, r.Reason)
+		assert.Equal(t, "b.example", r.CanonName)
+		assert.Equal(t, "udp://192.0.2.1", r.Upstream)
+
+		err = d.ReloadRewrites([]RewriteEntry{{
+			Domain:   "self.example",
+			Answer:   "b.example",
+			Upstream: "dns.lan:53",
+		}})
+		assert.Error(t, err)
+	})
+}
+
+func TestDNSFilter_ReloadRewrites(t *testing.T) {
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "old.example",
+			Answer: "1.2.3.4",
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	requireRewrite := func(t *testing.T, host string, want net.IP) {
+		t.Helper()
+
+		res := d.processRewrites(host, dns.TypeA, &setts)
+		if want == nil {
+			assert.Equal(t, NotFilteredNotFound, res.Reason)
+
+			return
+		}
+
+		require.Equal(t, Rewritten, res.Reason)
+		require.Len(t, res.IPList, 1)
+
+		assert.Equal(t, want, res.IPList[0])
+	}
+
+	requireRewrite(t, "old.example", net.IP{1, 2, 3, 4})
+
+	t.Run("valid", func(t *testing.T) {
+		entries := []RewriteEntry{{
+			Domain: "new.example",
+			Answer: "5.6.7.8",
+		}, {
+			Domain: "*.new.example",
+			Answer: "new.example",
+		}}
+
+		err := d.ReloadRewrites(entries)
+		require.NoError(t, err)
+
+		requireRewrite(t, "old.example", nil)
+		requireRewrite(t, "new.example", net.IP{5, 6, 7, 8})
+
+		// The passed entries aren't normalized in place.
+		assert.Zero(t, entries[0].Type)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := d.ReloadRewrites([]RewriteEntry{{
+			Domain: "other.example",
+			Answer: "9.9.9.9",
+		}, {
+			Domain: "",
+			Answer: "1.1.1.1",
+		}, {
+			Domain: "v6.example",
+			Answer: "::1",
+			Type:   dns.TypeA,
+		}})
+		require.Error(t, err)
+
+		assert.Contains(t, err.Error(), "entry 1: empty domain")
+		assert.Contains(t, err.Error(), "entry 2: ")
+
+		// The current set is intact.
+		requireRewrite(t, "new.example", net.IP{5, 6, 7, 8})
+		requireRewrite(t, "other.example", nil)
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		err := d.ReloadRewrites([]RewriteEntry{{
+			Domain: "pinned.example",
+			Answer: "1.1.1.1",
+			Pinned: true,
+		}})
+		require.NoError(t, err)
+
+		err = d.ReloadRewrites([]RewriteEntry{{
+			Domain: "other.example",
+			Answer: "9.9.9.9",
+		}})
+		require.NoError(t, err)
+
+		// The pinned entries are only protected from the HTTP API, so the
+		// ones removed from the configuration are removed.
+		requireRewrite(t, "other.example", net.IP{9, 9, 9, 9})
+		requireRewrite(t, "pinned.example", nil)
+
+		d.confLock.RLock()
+		defer d.confLock.RUnlock()
+
+		assert.Len(t, d.Rewrites, 1)
+	})
+}
+
+func TestDNSFilter_processRewrites_maxLookups(t *testing.T) {
+	// Build a chain interleaving wildcards and exact CNAMEs:
+	//
+	//   0.example -> 1.example -> a.2.example (by *.1.example) -> ...
+	//
+	// with an A record at the end.
+	const chainLen = 10
+
+	var entries []RewriteEntry
+	for i := 0; i < chainLen; i++ {
+		domain := fmt.Sprintf("%d.example", i)
+		if i%2 == 1 {
+			domain = "*." + domain
+		}
+
+		answer := fmt.Sprintf("%d.example", i+1)
+		if i%2 == 0 {
+			answer = "a." + answer
+		}
+
+		entries = append(entries, RewriteEntry{Domain: domain, Answer: answer})
+	}
+
+	entries = append(entries, RewriteEntry{
+		Domain: fmt.Sprintf("%d.example", chainLen),
+		Answer: "1.2.3.4",
+	})
+
+	testCases := []struct {
+		name          string
+		wantCanonName string
+		wantLog       string
+		wantIPs       []net.IP
+		limit         uint
+	}{{
+		name:          "default",
+		wantCanonName: fmt.Sprintf("%d.example", chainLen),
+		wantLog:       "",
+		wantIPs:       []net.IP{{1, 2, 3, 4}},
+		limit:         0,
+	}, {
+		name:          "enough",
+		wantCanonName: fmt.Sprintf("%d.example", chainLen),
+		wantLog:       "",
+		wantIPs:       []net.IP{{1, 2, 3, 4}},
+		limit:         chainLen + 1,
+	}, {
+		name:          "exceeded",
+		wantCanonName: "a.3.example",
+		wantLog:       "warning: rewrite: stopping after 3 lookups at a.3.example",
+		wantIPs:       nil,
+		limit:         3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logOutput := &bytes.Buffer{}
+			aghtest.ReplaceLogWriter(t, logOutput)
+
+			d := newForTest(t, &Config{
+				Rewrites:          entries,
+				MaxRewriteLookups: tc.limit,
+			}, nil)
+			t.Cleanup(d.Close)
+
+			res := d.processRewrites("0.example", dns.TypeA, &setts)
+
+			assert.Equal(t, Rewritten, res.Reason)
+			assert.Equal(t, tc.wantCanonName, res.CanonName)
+			assert.Equal(t, tc.wantIPs, res.IPList)
+
+			if tc.wantLog == "" {
+				assert.NotContains(t, logOutput.String(), "warning")
+			} else {
+				assert.Contains(t, logOutput.String(), tc.wantLog)
+			}
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_rewriteChain(t *testing.T) {
+	const text = "||blocked.example^\n" +
+		"||blocked-target.example^$important\n" +
+		"@@||allowed.example^\n"
+
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "clean.example",
+			Answer: "blocked.example",
+		}, {
+			Domain: "two-hops.example",
+			Answer: "middle.example",
+		}, {
+			Domain: "middle.example",
+			Answer: "blocked-target.example",
+		}, {
+			Domain: "to-allowed.example",
+			Answer: "allowed.example",
+		}, {
+			Domain: "to-ip.example",
+			Answer: "1.2.3.4",
+		}, {
+			// The rewrites of the blocked names themselves take precedence
+			// over the rules, as before.
+			Domain: "blocked.example",
+			Answer: "5.6.7.8",
+		}},
+	}, []Filter{{ID: 1, Data: []byte(text)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantRule   string
+		wantReason Reason
+	}{{
+		name:       "blocked_target",
+		host:       "clean.example",
+		wantRule:   "||blocked.example^",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "blocked_last",
+		host:       "two-hops.example",
+		wantRule:   "||blocked-target.example^$important",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "allowed_target",
+		host:       "to-allowed.example",
+		wantRule:   "",
+		wantReason: Rewritten,
+	}, {
+		name:       "ip",
+		host:       "to-ip.example",
+		wantRule:   "",
+		wantReason: Rewritten,
+	}, {
+		name:       "blocked_host_rewrite",
+		host:       "blocked.example",
+		wantRule:   "",
+		wantReason: Rewritten,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equalf(t, tc.wantReason, res.Reason, "got %s", res.Reason)
+			if tc.wantRule == "" {
+				assert.False(t, res.IsFiltered)
+
+				return
+			}
+
+			assert.True(t, res.IsFiltered)
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+		})
+	}
+}
+
+func TestRewritesPinned(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.ConfigModified = func() {}
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: "proxy.corp.example",
+		Answer: "192.0.2.1",
+		Pinned: true,
+	}, {
+		Domain: "*.pinned.example",
+		Answer: "192.0.2.2",
+		Pinned: true,
+	}}
+	d.prepareRewrites()
+
+	addRewrite := func(t *testing.T, domain, answer string) {
+		t.Helper()
+
+		body := fmt.Sprintf(`{"domain":%q,"answer":%q,"pinned":true}`, domain, answer)
+		r := httptest.NewRequest(http.MethodPost, "/control/rewrite/add", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		d.handleRewriteAdd(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// User rewrites conflicting with the pinned ones.
+	addRewrite(t, "proxy.corp.example", "203.0.113.1")
+	addRewrite(t, "proxy.corp.example", "proxy.evil.example")
+	addRewrite(t, "www.pinned.example", "203.0.113.2")
+	addRewrite(t, "user.example", "203.0.113.3")
+
+	testCases := []struct {
+		name string
+		host string
+		want net.IP
+	}{{
+		name: "pinned_over_user",
+		host: "proxy.corp.example",
+		want: net.IP{192, 0, 2, 1},
+	}, {
+		name: "pinned_wildcard_over_exact",
+		host: "www.pinned.example",
+		want: net.IP{192, 0, 2, 2},
+	}, {
+		name: "user",
+		host: "user.example",
+		want: net.IP{203, 0, 113, 3},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := d.processRewrites(tc.host, dns.TypeA, &setts)
+			require.Equal(t, Rewritten, r.Reason)
+
+			assert.Empty(t, r.CanonName)
+			assert.Equal(t, []net.IP{tc.want}, r.IPList)
+		})
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		deleteRewrite := func(domain, answer string) (code int) {
+			body := fmt.Sprintf(`{"domain":%q,"answer":%q}`, domain, answer)
+			r := httptest.NewRequest(http.MethodPost, "/control/rewrite/delete", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			d.handleRewriteDelete(w, r)
+
+			return w.Code
+		}
+
+		assert.Equal(t, http.StatusForbidden, deleteRewrite("proxy.corp.example", "192.0.2.1"))
+		assert.Equal(t, http.StatusOK, deleteRewrite("proxy.corp.example", "203.0.113.1"))
+
+		var pinned []string
+		for _, e := range d.Rewrites {
+			if e.Pinned {
+				pinned = append(pinned, e.Domain)
+			}
+		}
+
+		assert.Equal(t, []string{"proxy.corp.example", "*.pinned.example"}, pinned)
+		assert.Len(t, d.Rewrites, 5)
+	})
+}
+
+func TestDNSFilter_CheckHost_rewritePrecedence(t *testing.T) {
+	const rulesData = "||sinkhole.example^\n" +
+		"||dnsrewrite.example^$dnsrewrite=192.0.2.2\n" +
+		"||dnsrewrite.example^\n"
+
+	rewrites := []RewriteEntry{{
+		Domain: "sinkhole.example",
+		Answer: "192.0.2.1",
+	}, {
+		Domain: "allowed.example",
+		Answer: "192.0.2.3",
+	}}
+
+	testCases := []struct {
+		name       string
+		precedence string
+		host       string
+		wantReason Reason
+	}{{
+		name:       "default_rewrite",
+		precedence: "",
+		host:       "sinkhole.example",
+		wantReason: Rewritten,
+	}, {
+		name:       "rewrite_rewrite",
+		precedence: RewritePrecedenceRewrite,
+		host:       "sinkhole.example",
+		wantReason: Rewritten,
+	}, {
+		name:       "block_rewrite",
+		precedence: RewritePrecedenceBlock,
+		host:       "sinkhole.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "default_dnsrewrite",
+		precedence: "",
+		host:       "dnsrewrite.example",
+		wantReason: RewrittenRule,
+	}, {
+		name:       "rewrite_dnsrewrite",
+		precedence: RewritePrecedenceRewrite,
+		host:       "dnsrewrite.example",
+		wantReason: RewrittenRule,
+	}, {
+		name:       "block_dnsrewrite",
+		precedence: RewritePrecedenceBlock,
+		host:       "dnsrewrite.example",
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "block_not_blocked",
+		precedence: RewritePrecedenceBlock,
+		host:       "allowed.example",
+		wantReason: Rewritten,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				Rewrites:          rewrites,
+				RewritePrecedence: tc.precedence,
+			}, []Filter{{ID: 1, Data: []byte(rulesData)}})
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantReason == FilteredBlockList, res.IsFiltered)
+		})
+	}
+}
+
+func TestRewritesTTL(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+	require.NoError(t, err)
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: "cname.example",
+		Answer: "a.example",
+		TTL:    300,
+	}, {
+		Domain: "a.example",
+		Answer: "1.2.3.4",
+		TTL:    60,
+	}, {
+		Domain: "long.example",
+		Answer: "cname.example",
+		TTL:    30,
+	}, {
+		Domain: "nottl.example",
+		Answer: "a.example",
+	}, {
+		Domain: "a.example",
+		Answer: "1.2.3.5",
+		TTL:    120,
+	}, {
+		Domain: "none.example",
+		Answer: "5.6.7.8",
+	}, {
+		Domain: "mx.example",
+		MX:     &RewriteMX{Exchange: "mail.example", Preference: 10},
+		TTL:    600,
+	}, {
+		Domain: "v4only.example",
+		Answer: "9.9.9.9",
+		TTL:    90,
+	}}
+	d.prepareRewrites()
+	d.NAT64Prefix = prefix
+
+	testCases := []struct {
+		name  string
+		host  string
+		qtype uint16
+		want  uint32
+	}{{
+		name:  "cname_to_a",
+		host:  "cname.example",
+		qtype: dns.TypeA,
+		want:  60,
+	}, {
+		name:  "a",
+		host:  "a.example",
+		qtype: dns.TypeA,
+		want:  60,
+	}, {
+		name:  "longer_chain",
+		host:  "long.example",
+		qtype: dns.TypeA,
+		want:  30,
+	}, {
+		name:  "cname_without_ttl",
+		host:  "nottl.example",
+		qtype: dns.TypeA,
+		want:  60,
+	}, {
+		name:  "no_ttl",
+		host:  "none.example",
+		qtype: dns.TypeA,
+		want:  0,
+	}, {
+		name:  "mx",
+		host:  "mx.example",
+		qtype: dns.TypeMX,
+		want:  600,
+	}, {
+		name:  "nat64",
+		host:  "v4only.example",
+		qtype: dns.TypeAAAA,
+		want:  90,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := d.processRewrites(tc.host, tc.qtype, &setts)
+			require.Equal(t, Rewritten, res.Reason)
+
+			assert.Equal(t, tc.want, res.TTL)
+		})
+	}
+}
+
+func TestRewritesHTTPS(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	d.Rewrites = []RewriteEntry{{
+		Domain: "a.example",
+		Answer: "1.2.3.4",
+		TTL:    60,
+	}, {
+		Domain: "cname.example",
+		Answer: "a.example",
+		TTL:    300,
+	}, {
+		Domain: "aaaa.example",
+		Answer: "2001:db8::1",
+	}, {
+		Domain: "exception.example",
+		Answer: "A",
+	}, {
+		Domain: "external.example",
+		Answer: "upstream.example",
+	}}
+	d.prepareRewrites()
+
+	emptyAnswer := &DNSRewriteResult{
+		Response: DNSRewriteResultResponse{},
+		RCode:    dns.RcodeSuccess,
+	}
+
+	testCases := []struct {
+		wantDNSRR     *DNSRewriteResult
+		name          string
+		host          string
+		wantCanonName string
+		wantReason    Reason
+		wantTTL       uint32
+		qtype         uint16
+		noData        bool
+	}{{
+		wantDNSRR:     emptyAnswer,
+		name:          "https",
+		host:          "a.example",
+		wantCanonName: "",
+		wantReason:    Rewritten,
+		wantTTL:       60,
+		qtype:         dns.TypeHTTPS,
+		noData:        true,
+	}, {
+		wantDNSRR:     emptyAnswer,
+		name:          "svcb",
+		host:          "a.example",
+		wantCanonName: "",
+		wantReason:    Rewritten,
+		wantTTL:       60,
+		qtype:         dns.TypeSVCB,
+		noData:        true,
+	}, {
+		wantDNSRR:     emptyAnswer,
+		name:          "cname",
+		host:          "cname.example",
+		wantCanonName: "a.example",
+		wantReason:    Rewritten,
+		wantTTL:       60,
+		qtype:         dns.TypeHTTPS,
+		noData:        true,
+	}, {
+		wantDNSRR:     emptyAnswer,
+		name:          "aaaa",
+		host:          "aaaa.example",
+		wantCanonName: "",
+		wantReason:    Rewritten,
+		wantTTL:       0,
+		qtype:         dns.TypeHTTPS,
+		noData:        true,
+	}, {
+		wantDNSRR:     nil,
+		name:          "exception",
+		host:          "exception.example",
+		wantCanonName: "",
+		wantReason:    NotFilteredNotFound,
+		wantTTL:       0,
+		qtype:         dns.TypeHTTPS,
+		noData:        true,
+	}, {
+		wantDNSRR:     nil,
+		name:          "external_cname",
+		host:          "external.example",
+		wantCanonName: "upstream.example",
+		wantReason:    Rewritten,
+		wantTTL:       0,
+		qtype:         dns.TypeHTTPS,
+		noData:        true,
+	}, {
+		wantDNSRR:     nil,
+		name:          "not_rewritten",
+		host:          "other.example",
+		wantCanonName: "",
+		wantReason:    NotFilteredNotFound,
+		wantTTL:       0,
+		qtype:         dns.TypeHTTPS,
+		noData:        true,
+	}, {
+		wantDNSRR:     nil,
+		name:          "disabled",
+		host:          "a.example",
+		wantCanonName: "",
+		wantReason:    NotFilteredNotFound,
+		wantTTL:       0,
+		qtype:         dns.TypeHTTPS,
+		noData:        false,
+	}, {
+		wantDNSRR:     nil,
+		name:          "disabled_cname",
+		host:          "cname.example",
+		wantCanonName: "a.example",
+		wantReason:    Rewritten,
+		wantTTL:       300,
+		qtype:         dns.TypeHTTPS,
+		noData:        false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d.RewriteHTTPSNoData = tc.noData
+
+			res := d.processRewrites(tc.host, tc.qtype, &setts)
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantCanonName, res.CanonName)
+			assert.Equal(t, tc.wantDNSRR, res.DNSRewriteResult)
+			assert.Equal(t, tc.wantTTL, res.TTL)
+			assert.Empty(t, res.IPList)
+
+			if tc.wantDNSRR == nil {
+				return
+			}
+
+			// Make sure that the response doesn't leak the service
+			// bindings of the original host.
+			rrs, err := res.DNSRewriteResult.Records(dns.Fqdn(tc.host), tc.qtype, 10)
+			require.NoError(t, err)
+
+			assert.Empty(t, rrs)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package filtering
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// RoutingFilter is a filter list whose rules route the matched hosts to the
+// group of upstreams with Tag.
+type RoutingFilter struct {
+	// Tag is the tag of the upstream group.  It must not be empty.
+	Tag string `yaml:"tag"`
+
+	Filter `yaml:",inline"`
+}
+
+// routing is the compiled set of routing filters.
+type routing struct {
+	storage *filterlist.RuleStorage
+	engine  *urlfilter.DNSEngine
+	// tags maps the IDs of the routing filters to their tags.
+	tags map[int]string
+}
+
+// close closes the storage of r, if any.
+func (r *routing) close() {
+	if r == nil {
+		return
+	}
+
+	err := r.storage.Close()
+	if err != nil {
+		log.Error("filtering: closing routing storage: %s", err)
+	}
+}
+
+// SetRoutingFilters compiles filters and replaces the current routing rules
+// with them.  The lists which fail to load are skipped and reported in err.
+func (d *DNSFilter) SetRoutingFilters(filters []RoutingFilter) (err error) {
+	tags := make(map[int]string, len(filters))
+	lists := make([]Filter, 0, len(filters))
+	for _, f := range filters {
+		tag := strings.TrimSpace(f.Tag)
+		if tag == "" {
+			return fmt.Errorf("routing filter %d: empty tag", f.ID)
+		}
+
+		tags[int(f.ID)] = tag
+		lists = append(lists, f.Filter)
+	}
+
+	rs, err := newRuleStorage(lists, true, false)
+	if rs == nil {
+		return fmt.Errorf("routing filters: %w", err)
+	} else if err != nil {
+		err = fmt.Errorf("routing filters: %w", err)
+	}
+
+	// Check the rules before the engine uses the storage.
+	tagged := false
+	sc := rs.NewRuleStorageScanner()
+	for !tagged && sc.Scan() {
+		rule, _ := sc.Rule()
+		_, isNet := rule.(*rules.NetworkRule)
+		tagged = isNet && hasServerIPTag(rule.Text())
+	}
+
+	r := &routing{
+		storage: rs,
+		engine:  urlfilter.NewDNSEngine(rs),
+		tags:    tags,
+	}
+
+	d.routingLock.Lock()
+	defer d.routingLock.Unlock()
+
+	d.routing.close()
+	d.routing = r
+	setServerIPTagged(&d.routingServerIPTagged, tagged)
+
+	return err
+}
+
+// RouteDecision returns the tag of the upstream group for host according to
+// the routing filters.  ok is false if the host isn't matched or is matched
+// by an exception rule, which means that the default upstreams should be
+// used.
+func (d *DNSFilter) RouteDecision(host string, setts *Settings) (tag string, ok bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	d.routingLock.RLock()
+	defer d.routingLock.RUnlock()
+
+	if d.routing == nil {
+		return "", false
+	}
+
+	dnsres, ok := d.routing.engine.MatchRequest(newDNSRequest(host, dns.TypeA, setts))
+	if !ok {
+		return "", false
+	}
+
+	var r rules.Rule
+	switch {
+	case dnsres.NetworkRule != nil:
+		if dnsres.NetworkRule.Whitelist {
+			return "", false
+		}
+
+		r = dnsres.NetworkRule
+	case len(dnsres.HostRulesV4) > 0:
+		r = dnsres.HostRulesV4[0]
+	case len(dnsres.HostRulesV6) > 0:
+		r = dnsres.HostRulesV6[0]
+	default:
+		return "", false
+	}
+
+	tag, ok = d.routing.tags[r.GetFilterListID()]
+	if ok {
+		log.Debug("filtering: routing %q to %q by rule %q", host, tag, r.Text())
+	}
+
+	return tag, ok
+}
+
+// closeRouting closes the routing filters.
+func (d *DNSFilter) closeRouting() {
+	d.routingLock.Lock()
+	defer d.routingLock.Unlock()
+
+	d.routing.close()
+	d.routing = nil
+}
@@ -0,0 +1,104 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_RouteDecision(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	s := &Settings{}
+	tag, ok := d.RouteDecision("corp.example", s)
+	assert.False(t, ok)
+	assert.Empty(t, tag)
+
+	err := d.SetRoutingFilters([]RoutingFilter{{
+		Tag: "corp",
+		Filter: Filter{
+			ID:   1,
+			Data: []byte("||corp.example^\n@@||public.corp.example^\n"),
+		},
+	}, {
+		Tag: "vpn",
+		Filter: Filter{
+			ID:   2,
+			Data: []byte("||vpn.example^\n0.0.0.0 hosts.vpn\n||lan.example^$client=192.168.0.0/16\n"),
+		},
+	}})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		host     string
+		clientIP net.IP
+		wantTag  string
+		wantOK   bool
+	}{{
+		name:    "corp",
+		host:    "corp.example",
+		wantTag: "corp",
+		wantOK:  true,
+	}, {
+		name:    "corp_subdomain",
+		host:    "Sub.Corp.Example.",
+		wantTag: "corp",
+		wantOK:  true,
+	}, {
+		name:    "exception",
+		host:    "public.corp.example",
+		wantTag: "",
+		wantOK:  false,
+	}, {
+		name:    "vpn",
+		host:    "vpn.example",
+		wantTag: "vpn",
+		wantOK:  true,
+	}, {
+		name:    "hosts_syntax",
+		host:    "hosts.vpn",
+		wantTag: "vpn",
+		wantOK:  true,
+	}, {
+		name:     "client",
+		host:     "lan.example",
+		clientIP: net.IP{192, 168, 1, 1},
+		wantTag:  "vpn",
+		wantOK:   true,
+	}, {
+		name:     "other_client",
+		host:     "lan.example",
+		clientIP: net.IP{10, 0, 0, 1},
+		wantTag:  "",
+		wantOK:   false,
+	}, {
+		name:    "unmatched",
+		host:    "example.org",
+		wantTag: "",
+		wantOK:  false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tag, ok = d.RouteDecision(tc.host, &Settings{ClientIP: tc.clientIP})
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantTag, tag)
+		})
+	}
+
+	t.Run("empty_tag", func(t *testing.T) {
+		err = d.SetRoutingFilters([]RoutingFilter{{
+			Filter: Filter{ID: 3, Data: []byte("||other.example^\n")},
+		}})
+		assert.Error(t, err)
+
+		// The previous filters are kept.
+		tag, ok = d.RouteDecision("corp.example", s)
+		assert.True(t, ok)
+		assert.Equal(t, "corp", tag)
+	})
+}
@@ -0,0 +1,63 @@
+package filtering
+
+import (
+	"strings"
+	"unicode"
+)
+
+// domainAnchor is the beginning of the network rules matching a domain and its
+// subdomains.
+const domainAnchor = "||"
+
+// normalizeRuleDomains returns text with the domains of the domain-anchored
+// rules normalized, see normalizeRuleDomain.  The lines are also trimmed of
+// the whitespace.
+func normalizeRuleDomains(text string) (normalized string) {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = normalizeRuleDomain(strings.TrimSpace(l))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// normalizeRuleDomain returns the rule with the whitespace around the anchored
+// domain and the leading and trailing dots of its labels removed, so that
+//
+//	|| example.com. ^$important
+//
+// becomes
+//
+//	||example.com^$important
+//
+// Only the rules starting with the domain anchor, possibly after the exception
+// marker, are changed, which leaves the comments, the hosts rules, the regular
+// expression rules, and the cosmetic rules intact.  The rule is returned as is
+// if the domain is empty or contains whitespace after the normalization.
+func normalizeRuleDomain(rule string) (normalized string) {
+	prefix := ""
+	pattern := rule
+	if strings.HasPrefix(pattern, "@@") {
+		prefix, pattern = "@@", pattern[len("@@"):]
+	}
+
+	if !strings.HasPrefix(pattern, domainAnchor) {
+		return rule
+	}
+
+	pattern = pattern[len(domainAnchor):]
+
+	end := strings.IndexAny(pattern, "^|/:$")
+	if end < 0 {
+		end = len(pattern)
+	}
+
+	domain := strings.Trim(strings.TrimSpace(pattern[:end]), ".")
+	if domain == "" || strings.IndexFunc(domain, unicode.IsSpace) >= 0 {
+		return rule
+	}
+
+	rest := strings.TrimLeftFunc(pattern[end:], unicode.IsSpace)
+
+	return prefix + domainAnchor + domain + rest
+}
@@ -0,0 +1,163 @@
+package filtering
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeRuleDomain(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule string
+		want string
+	}{{
+		name: "spaces_and_trailing_dot",
+		rule: "|| example.com. ^",
+		want: "||example.com^",
+	}, {
+		name: "leading_dot",
+		rule: "||.example.com^",
+		want: "||example.com^",
+	}, {
+		name: "modifiers",
+		rule: "||example.com.$important",
+		want: "||example.com$important",
+	}, {
+		name: "exception",
+		rule: "@@|| example.com.. ^$dnstype=A",
+		want: "@@||example.com^$dnstype=A",
+	}, {
+		name: "no_terminator",
+		rule: "||example.com.",
+		want: "||example.com",
+	}, {
+		name: "wildcard",
+		rule: "||*.example.com.^",
+		want: "||*.example.com^",
+	}, {
+		name: "path",
+		rule: "||example.com./path",
+		want: "||example.com/path",
+	}, {
+		name: "already_normal",
+		rule: "||example.com^",
+		want: "||example.com^",
+	}, {
+		name: "regexp",
+		rule: "/example\\.com\\./",
+		want: "/example\\.com\\./",
+	}, {
+		name: "cosmetic",
+		rule: "example.com##.banner",
+		want: "example.com##.banner",
+	}, {
+		name: "comment",
+		rule: "! || example.com. ^",
+		want: "! || example.com. ^",
+	}, {
+		name: "hosts",
+		rule: "0.0.0.0 example.com.",
+		want: "0.0.0.0 example.com.",
+	}, {
+		name: "empty_domain",
+		rule: "|| . ^",
+		want: "|| . ^",
+	}, {
+		name: "inner_space",
+		rule: "||exam ple.com^",
+		want: "||exam ple.com^",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, normalizeRuleDomain(tc.rule))
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_normalizeRuleDomains(t *testing.T) {
+	const text = "  || example.com. ^  \r\n" +
+		"@@|| allowed.example.com. ^\r\n" +
+		"||.dotted.example^\r\n" +
+		"example.com##.banner\r\n"
+
+	path := filepath.Join(t.TempDir(), "list.txt")
+	err := os.WriteFile(path, []byte("|| file.example. ^\n"), 0o644)
+	require.NoError(t, err)
+
+	filters := []Filter{
+		{ID: 1, Data: []byte(text)},
+		{ID: 2, FilePath: path},
+	}
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantNormal Reason
+		wantAsIs   Reason
+	}{{
+		name:       "blocked",
+		host:       "example.com",
+		wantNormal: FilteredBlockList,
+		wantAsIs:   NotFilteredNotFound,
+	}, {
+		name:       "subdomain",
+		host:       "sub.example.com",
+		wantNormal: FilteredBlockList,
+		wantAsIs:   NotFilteredNotFound,
+	}, {
+		name:       "allowed",
+		host:       "allowed.example.com",
+		wantNormal: NotFilteredAllowList,
+		wantAsIs:   NotFilteredNotFound,
+	}, {
+		name:       "leading_dot",
+		host:       "dotted.example",
+		wantNormal: FilteredBlockList,
+		wantAsIs:   NotFilteredNotFound,
+	}, {
+		name:       "file",
+		host:       "file.example",
+		wantNormal: FilteredBlockList,
+		wantAsIs:   NotFilteredNotFound,
+	}}
+
+	for _, normalize := range []bool{true, false} {
+		d := newForTest(t, &Config{
+			NormalizeRuleDomains: normalize,
+			KeepCosmeticRules:    true,
+		}, filters)
+		t.Cleanup(d.Close)
+
+		for _, tc := range testCases {
+			want := tc.wantAsIs
+			if normalize {
+				want = tc.wantNormal
+			}
+
+			name := tc.name
+			if normalize {
+				name += "_normalized"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+				require.NoError(t, err)
+
+				assert.Equal(t, want, res.Reason)
+			})
+		}
+
+		t.Run("cosmetic", func(t *testing.T) {
+			cosmetic := d.CosmeticRules()
+			require.Len(t, cosmetic, 1)
+
+			assert.Equal(t, "example.com##.banner", cosmetic[0].Text)
+		})
+	}
+}
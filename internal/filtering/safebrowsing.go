@@ -2,6 +2,7 @@ package filtering
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
@@ -15,10 +16,10 @@ import (
 
 	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/AdguardTeam/golibs/log"
 	"github.com/AdguardTeam/golibs/stringutil"
 	"github.com/miekg/dns"
-	"golang.org/x/net/publicsuffix"
 )
 
 // Safe browsing and parental control methods.
@@ -45,27 +46,62 @@ func (d *DNSFilter) SetSafeBrowsingUpstream(u upstream.Upstream) {
 	d.safeBrowsingUpstream = u
 }
 
-func (d *DNSFilter) initSecurityServices() error {
-	var err error
+// securityUpstreamConf returns the timeout and the number of retries for
+// the security service upstream from the values set in the configuration.
+func securityUpstreamConf(timeoutMs, retries uint) (timeout time.Duration, n uint) {
+	if timeoutMs == 0 {
+		return dnsTimeout, retries
+	}
+
+	return time.Duration(timeoutMs) * time.Millisecond, retries
+}
+
+// newSecurityUpstream creates the upstream for the security service at addr.
+func newSecurityUpstream(
+	addr string,
+	ips []net.IP,
+	timeout time.Duration,
+	retries uint,
+) (u upstream.Upstream, err error) {
+	u, err = upstream.AddressToUpstream(addr, &upstream.Options{
+		Timeout:       timeout,
+		ServerIPAddrs: ips,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryUpstream{
+		Upstream: u,
+		timeout:  timeout,
+		retries:  retries,
+	}, nil
+}
+
+func (d *DNSFilter) initSecurityServices(c *Config) (err error) {
 	d.safeBrowsingServer = defaultSafebrowsingServer
 	d.parentalServer = defaultParentalServer
-	opts := &upstream.Options{
-		Timeout: dnsTimeout,
-		ServerIPAddrs: []net.IP{
-			{94, 140, 14, 15},
-			{94, 140, 15, 16},
-			net.ParseIP("2a10:50c0::bad1:ff"),
-			net.ParseIP("2a10:50c0::bad2:ff"),
-		},
+
+	ips := []net.IP{
+		{94, 140, 14, 15},
+		{94, 140, 15, 16},
+		net.ParseIP("2a10:50c0::bad1:ff"),
+		net.ParseIP("2a10:50c0::bad2:ff"),
+	}
+
+	if c == nil {
+		c = &Config{}
 	}
 
-	parUps, err := upstream.AddressToUpstream(d.parentalServer, opts)
+	timeout, retries := securityUpstreamConf(c.ParentalTimeout, c.ParentalRetries)
+	parUps, err := newSecurityUpstream(d.parentalServer, ips, timeout, retries)
 	if err != nil {
 		return fmt.Errorf("converting parental server: %w", err)
 	}
 	d.SetParentalUpstream(parUps)
 
-	sbUps, err := upstream.AddressToUpstream(d.safeBrowsingServer, opts)
+	timeout, retries = securityUpstreamConf(c.SafeBrowsingTimeout, c.SafeBrowsingRetries)
+	sbUps, err := newSecurityUpstream(d.safeBrowsingServer, ips, timeout, retries)
 	if err != nil {
 		return fmt.Errorf("converting safe browsing server: %w", err)
 	}
@@ -74,6 +110,72 @@ func (d *DNSFilter) initSecurityServices() error {
 	return nil
 }
 
+// errSecurityTimeout is returned by retryUpstream when the underlying
+// upstream doesn't respond in time.
+const errSecurityTimeout errors.Error = "upstream timed out"
+
+// retryUpstream is an upstream.Upstream which limits the time of each
+// exchange with the underlying upstream and retries the failed ones.
+type retryUpstream struct {
+	upstream.Upstream
+
+	// timeout is the maximum duration of a single attempt.  Zero means no
+	// limit besides the one of the underlying upstream.
+	timeout time.Duration
+
+	// retries is the number of additional attempts after the failed one.
+	retries uint
+}
+
+// type check
+var _ upstream.Upstream = (*retryUpstream)(nil)
+
+// Exchange implements the upstream.Upstream interface for *retryUpstream.
+func (u *retryUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	for i := uint(0); i <= u.retries; i++ {
+		resp, err = u.exchange(req)
+		if err == nil {
+			return resp, nil
+		}
+
+		log.Debug("filtering: attempt %d to %s: %s", i+1, u.Address(), err)
+	}
+
+	return nil, fmt.Errorf("after %d attempts: %w", u.retries+1, err)
+}
+
+// exchange performs a single exchange with the underlying upstream.
+func (u *retryUpstream) exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	if u.timeout == 0 {
+		return u.Upstream.Exchange(req)
+	}
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+
+	// Use a buffered channel so that the goroutine doesn't leak if the
+	// upstream responds after the timeout.
+	resCh := make(chan result, 1)
+	go func() {
+		defer log.OnPanic("filtering: security upstream exchange")
+
+		r, rerr := u.Upstream.Exchange(req.Copy())
+		resCh <- result{resp: r, err: rerr}
+	}()
+
+	timer := time.NewTimer(u.timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-resCh:
+		return res.resp, res.err
+	case <-timer.C:
+		return nil, errSecurityTimeout
+	}
+}
+
 /*
 expire byte[4]
 hash byte[32]
@@ -81,7 +183,7 @@ hash byte[32]
 */
 func (c *sbCtx) setCache(prefix, hashes []byte) {
 	d := make([]byte, 4+len(hashes))
-	expire := uint(time.Now().Unix()) + c.cacheTime*60
+	expire := time.Now().Unix() + c.cacheTTL()
 	binary.BigEndian.PutUint32(d[:4], uint32(expire))
 	copy(d[4:], hashes)
 	c.cache.Set(prefix, d)
@@ -130,16 +232,111 @@ func (c *sbCtx) getCached() int {
 }
 
 type sbCtx struct {
+	// ctx is the context of the lookup.  The lookup stops once it's done.
+	ctx context.Context
+
 	host       string
 	svc        string
 	hashToHost map[[32]byte]string
-	cache      cache.Cache
+	cache      VerdictCache
 	cacheTime  uint
+
+	// cacheJitter is the maximum deviation of the cache TTL, in percent.
+	cacheJitter uint
+	// randInt63n returns a random number in [0, n).  It's only used when
+	// cacheJitter is not zero.
+	randInt63n func(n int64) (r int64)
+
+	// psl is the public suffix list used to compute the hashes.  If nil, the
+	// built-in one is used.
+	psl *suffixList
+
+	// stats are the lookup counters of the service.  If nil, the lookups
+	// aren't counted.
+	stats *LookupStats
+}
+
+// maxCacheJitter is the maximum value of Config.CacheTimeJitter.
+const maxCacheJitter = 100
+
+// cacheTTL returns the TTL of a cache entry, in seconds, with the jitter
+// applied.
+func (c *sbCtx) cacheTTL() (ttl int64) {
+	ttl = int64(c.cacheTime) * 60
+	if c.cacheJitter == 0 || ttl == 0 {
+		return ttl
+	}
+
+	jitter := c.cacheJitter
+	if jitter > maxCacheJitter {
+		jitter = maxCacheJitter
+	}
+
+	band := ttl * int64(jitter) / 100
+
+	return ttl - band + c.randInt63n(2*band+1)
+}
+
+// VerdictCache is the storage for the responses of the safe browsing and
+// parental control services.  The keys are hash prefixes and the values are
+// opaque byte slices containing the expiration time and the hashes.
+//
+// Implementations must be safe for concurrent use.
+type VerdictCache interface {
+	// Get returns the value stored for key or nil if there is none.
+	Get(key []byte) (val []byte)
+
+	// Set stores val for key replacing the previous value, if any.
+	Set(key, val []byte)
+
+	// Delete removes the value stored for key, if any.
+	Delete(key []byte)
+}
+
+// lruVerdictCache is the default in-memory LRU implementation of
+// VerdictCache.
+type lruVerdictCache struct {
+	cache.Cache
+}
+
+// Names of the caches for Config.OnCacheEvict.
+const (
+	CacheNameSafeBrowsing = "safebrowsing"
+	CacheNameParental     = "parental"
+	CacheNameSafeSearch   = "safesearch"
+)
+
+// evictionHook returns the eviction callback for the in-memory cache with name
+// which calls onEvict.  hook is nil if onEvict is nil.
+func evictionHook(name string, onEvict func(cacheName string)) (hook func(key, val []byte)) {
+	if onEvict == nil {
+		return nil
+	}
+
+	return func(_, _ []byte) {
+		onEvict(name)
+	}
+}
+
+// type check
+var _ VerdictCache = (*lruVerdictCache)(nil)
+
+// Set implements the VerdictCache interface for *lruVerdictCache.
+func (c *lruVerdictCache) Set(key, val []byte) {
+	_ = c.Cache.Set(key, val)
+}
+
+// Delete implements the VerdictCache interface for *lruVerdictCache.
+func (c *lruVerdictCache) Delete(key []byte) {
+	c.Cache.Del(key)
 }
 
-func hostnameToHashes(host string) map[[32]byte]string {
+// hostnameToHashes returns the hashes of host and its parent domains down to
+// the public suffix from psl.  psl may be nil, in which case the built-in
+// public suffix list is used.
+func hostnameToHashes(host string, psl *suffixList) map[[32]byte]string {
 	hashes := map[[32]byte]string{}
-	tld, icann := publicsuffix.PublicSuffix(host)
+	tld, icann := psl.publicSuffix(host)
 	if !icann {
 		// private suffixes like cloudfront.net
 		tld = ""
@@ -272,11 +469,15 @@ func (c *sbCtx) storeCache(hashes [][]byte) {
 }
 
 func check(c *sbCtx, r Result, u upstream.Upstream) (Result, error) {
-	c.hashToHost = hostnameToHashes(c.host)
+	c.hashToHost = hostnameToHashes(c.host, c.psl)
 	switch c.getCached() {
 	case -1:
+		c.stats.cacheHit()
+
 		return Result{}, nil
 	case 1:
+		c.stats.cacheHit()
+
 		return r, nil
 	}
 
@@ -285,7 +486,9 @@ func check(c *sbCtx, r Result, u upstream.Upstream) (Result, error) {
 	log.Tracef("%s: checking %s: %s", c.svc, c.host, question)
 	req := (&dns.Msg{}).SetQuestion(question, dns.TypeTXT)
 
-	resp, err := u.Exchange(req)
+	done := c.stats.startRequest()
+	resp, err := exchangeCtx(c.ctx, u, req)
+	done()
 	if err != nil {
 		return Result{}, err
 	}
@@ -300,13 +503,88 @@ func check(c *sbCtx, r Result, u upstream.Upstream) (Result, error) {
 	return Result{}, nil
 }
 
-// TODO(a.garipov): Unify with checkParental.
+// exchangeCtx sends req to u and returns the response.  Since the upstreams
+// don't accept a context, the exchange is performed in a separate goroutine,
+// and ctx.Err() is returned once ctx is done without waiting for it.  ctx may
+// be nil.
+func exchangeCtx(ctx context.Context, u upstream.Upstream, req *dns.Msg) (resp *dns.Msg, err error) {
+	if ctx == nil {
+		return u.Exchange(req)
+	}
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+
+	// Use a buffered channel so that the goroutine doesn't leak if the
+	// upstream responds after ctx is done.
+	resCh := make(chan result, 1)
+	go func() {
+		defer log.OnPanic("filtering: security upstream exchange")
+
+		r, rerr := u.Exchange(req.Copy())
+		resCh <- result{resp: r, err: rerr}
+	}()
+
+	select {
+	case res := <-resCh:
+		return res.resp, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// defaultSecurityCheckTypes are the types of the requests checked by the safe
+// browsing and the parental control by default, see Config.SecurityCheckTypes.
+// Those are the ones the clients use to connect to the hosts.
+var defaultSecurityCheckTypes = []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS}
+
+// securityCheckTypes returns the types of the requests checked by the safe
+// browsing and the parental control.
+func (d *DNSFilter) securityCheckTypes() (types []uint16) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	if len(d.SecurityCheckTypes) == 0 {
+		return defaultSecurityCheckTypes
+	}
+
+	return d.SecurityCheckTypes
+}
+
+// isSecurityCheckType returns true if the requests of qtype should be checked
+// by the safe browsing and the parental control.
+func (d *DNSFilter) isSecurityCheckType(qtype uint16) (ok bool) {
+	for _, t := range d.securityCheckTypes() {
+		if t == qtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSafeBrowsing is the hostChecker version of checkSafeBrowsingCtx.
 func (d *DNSFilter) checkSafeBrowsing(
 	host string,
-	_ uint16,
+	qtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	return d.checkSafeBrowsingCtx(context.Background(), host, qtype, setts)
+}
+
+// checkSafeBrowsingCtx is like checkSafeBrowsing, but it stops once ctx is done, in which
+// case err is ctx.Err().
+//
+// TODO(a.garipov): Unify with checkParentalCtx.
+func (d *DNSFilter) checkSafeBrowsingCtx(
+	ctx context.Context,
+	host string,
+	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
-	if !setts.ProtectionEnabled || !setts.SafeBrowsingEnabled {
+	if !setts.EffectiveProtection() || !setts.SafeBrowsingEnabled || !d.isSecurityCheckType(qtype) {
 		return Result{}, nil
 	}
 
@@ -316,10 +594,15 @@ func (d *DNSFilter) checkSafeBrowsing(
 	}
 
 	sctx := &sbCtx{
-		host:      host,
-		svc:       "SafeBrowsing",
-		cache:     d.safebrowsingCache,
-		cacheTime: d.Config.CacheTime,
+		ctx:         ctx,
+		host:        host,
+		svc:         "SafeBrowsing",
+		cache:       d.safebrowsingCache,
+		cacheTime:   d.Config.CacheTime,
+		cacheJitter: d.Config.CacheTimeJitter,
+		randInt63n:  d.randInt63n,
+		psl:         d.psl,
+		stats:       &d.stats.Safebrowsing,
 	}
 
 	res = Result{
@@ -331,16 +614,87 @@ func (d *DNSFilter) checkSafeBrowsing(
 		}},
 	}
 
-	return check(sctx, res, d.safeBrowsingUpstream)
+	checked, err := check(sctx, res, d.safeBrowsingUpstream)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return Result{}, ctxErr
+	} else if err != nil {
+		return d.securityFailure(sctx.svc, host, res, err)
+	}
+
+	return checked, nil
+}
+
+// Security fail modes, see Config.SecurityFailMode.
+const (
+	// SecurityFailOpen makes the hosts not filtered when the lookup fails.
+	SecurityFailOpen = "open"
+	// SecurityFailClosed makes the requests for the hosts answered with
+	// SERVFAIL when the lookup fails.
+	SecurityFailClosed = "closed"
+)
+
+// securityFailureRuleText is the text of the rule of the results for the
+// hosts blocked because their lookups have failed, see SecurityFailClosed.
+const securityFailureRuleText = "security-lookup-failure"
+
+// securityFailure handles the error of the lookup of host by the security
+// service svc according to Config.SecurityFailMode.  blocked is the result the
+// service returns for the matched hosts, its list ID is kept in the result of
+// the failed lookup.
+func (d *DNSFilter) securityFailure(
+	svc string,
+	host string,
+	blocked Result,
+	lookupErr error,
+) (res Result, err error) {
+	d.confLock.RLock()
+	mode := d.SecurityFailMode
+	d.confLock.RUnlock()
+
+	switch mode {
+	case SecurityFailOpen:
+		log.Debug("%s: lookup for %s failed, allowing: %s", svc, host, lookupErr)
+
+		return Result{}, nil
+	case SecurityFailClosed:
+		log.Debug("%s: lookup for %s failed, refusing: %s", svc, host, lookupErr)
+
+		return Result{
+			IsFiltered: true,
+			Reason:     FilteredSecurityFailure,
+			Rules: []*ResultRule{{
+				Text:         securityFailureRuleText,
+				FilterListID: blocked.Rules[0].FilterListID,
+			}},
+			DNSRewriteResult: &DNSRewriteResult{
+				RCode: dns.RcodeServerFailure,
+			},
+		}, nil
+	default:
+		return Result{}, lookupErr
+	}
 }
 
-// TODO(a.garipov): Unify with checkSafeBrowsing.
+// checkParental is the hostChecker version of checkParentalCtx.
 func (d *DNSFilter) checkParental(
 	host string,
-	_ uint16,
+	qtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	return d.checkParentalCtx(context.Background(), host, qtype, setts)
+}
+
+// checkParentalCtx is like checkParental, but it stops once ctx is done, in which
+// case err is ctx.Err().
+//
+// TODO(a.garipov): Unify with checkSafeBrowsingCtx.
+func (d *DNSFilter) checkParentalCtx(
+	ctx context.Context,
+	host string,
+	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
-	if !setts.ProtectionEnabled || !setts.ParentalEnabled {
+	if !setts.EffectiveProtection() || !setts.ParentalEnabled || !d.isSecurityCheckType(qtype) {
 		return Result{}, nil
 	}
 
@@ -350,10 +704,15 @@ func (d *DNSFilter) checkParental(
 	}
 
 	sctx := &sbCtx{
-		host:      host,
-		svc:       "Parental",
-		cache:     d.parentalCache,
-		cacheTime: d.Config.CacheTime,
+		ctx:         ctx,
+		host:        host,
+		svc:         "Parental",
+		cache:       d.parentalCache,
+		cacheTime:   d.Config.CacheTime,
+		cacheJitter: d.Config.CacheTimeJitter,
+		randInt63n:  d.randInt63n,
+		psl:         d.psl,
+		stats:       &d.stats.Parental,
 	}
 
 	res = Result{
@@ -365,7 +724,43 @@ func (d *DNSFilter) checkParental(
 		}},
 	}
 
-	return check(sctx, res, d.parentalUpstream)
+	checked, err := check(sctx, res, d.parentalUpstream)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return Result{}, ctxErr
+	} else if err != nil {
+		return d.securityFailure(sctx.svc, host, res, err)
+	}
+
+	return checked, nil
+}
+
+// InvalidateCache removes the cached verdicts for host from the safe browsing,
+// parental control, and safe search caches, so that those are requested again
+// on the next check.  Since the safe browsing and parental control caches are
+// keyed by the hash prefixes of the host and its parent domains, the verdicts
+// for the hosts sharing those prefixes are removed as well.
+func (d *DNSFilter) InvalidateCache(host string) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return
+	}
+
+	for hash := range hostnameToHashes(host, d.psl) {
+		prefix := hash[0:2]
+		if d.safebrowsingCache != nil {
+			d.safebrowsingCache.Delete(prefix)
+		}
+
+		if d.parentalCache != nil {
+			d.parentalCache.Delete(prefix)
+		}
+	}
+
+	if d.safeSearchCache != nil {
+		d.safeSearchCache.Del([]byte(host))
+	}
+
+	log.Debug("filtering: invalidated cache for %q", host)
 }
 
 func httpError(r *http.Request, w http.ResponseWriter, code int, format string, args ...interface{}) {
@@ -2,11 +2,17 @@ package filtering
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/dnsproxy/upstream"
 	"github.com/AdguardTeam/golibs/cache"
+	"github.com/AdguardTeam/golibs/errors"
 	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +20,7 @@ import (
 
 func TestSafeBrowsingHash(t *testing.T) {
 	// test hostnameToHashes()
-	hashes := hostnameToHashes("1.2.3.sub.host.com")
+	hashes := hostnameToHashes("1.2.3.sub.host.com", nil)
 	assert.Len(t, hashes, 3)
 	_, ok := hashes[sha256.Sum256([]byte("3.sub.host.com"))]
 	assert.True(t, ok)
@@ -43,8 +49,7 @@ func TestSafeBrowsingCache(t *testing.T) {
 		svc:       "SafeBrowsing",
 		cacheTime: 100,
 	}
-	conf := cache.Config{}
-	c.cache = cache.New(conf)
+	c.cache = &lruVerdictCache{Cache: cache.New(cache.Config{})}
 
 	// store in cache hashes for "3.sub.host.com" and "host.com"
 	//  and empty data for hash-prefix for "sub.host.com"
@@ -96,8 +101,7 @@ func TestSafeBrowsingCache(t *testing.T) {
 		svc:       "SafeBrowsing",
 		cacheTime: 100,
 	}
-	conf = cache.Config{}
-	c.cache = cache.New(conf)
+	c.cache = &lruVerdictCache{Cache: cache.New(cache.Config{})}
 
 	hash = sha256.Sum256([]byte("sub.host.com"))
 	c.hashToHost = make(map[[32]byte]string)
@@ -107,6 +111,100 @@ func TestSafeBrowsingCache(t *testing.T) {
 	assert.Empty(t, c.getCached())
 }
 
+func TestSBCtx_cacheTTL(t *testing.T) {
+	const cacheTime = 10
+
+	// ttl is the cache TTL without jitter in seconds.
+	const ttl = cacheTime * 60
+
+	testCases := []struct {
+		name   string
+		rand   int64
+		jitter uint
+		want   int64
+	}{{
+		name:   "no_jitter",
+		rand:   0,
+		jitter: 0,
+		want:   ttl,
+	}, {
+		name:   "min",
+		rand:   0,
+		jitter: 10,
+		want:   ttl - 60,
+	}, {
+		name:   "middle",
+		rand:   60,
+		jitter: 10,
+		want:   ttl,
+	}, {
+		name:   "max",
+		rand:   120,
+		jitter: 10,
+		want:   ttl + 60,
+	}, {
+		name:   "too_big",
+		rand:   0,
+		jitter: 1000,
+		want:   0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &sbCtx{
+				cacheTime:   cacheTime,
+				cacheJitter: tc.jitter,
+				randInt63n: func(n int64) (r int64) {
+					require.Less(t, tc.rand, n)
+
+					return tc.rand
+				},
+			}
+
+			assert.Equal(t, tc.want, c.cacheTTL())
+		})
+	}
+}
+
+func TestSafeBrowsingCache_jitter(t *testing.T) {
+	const (
+		cacheTime = 100
+		jitter    = 20
+	)
+
+	c := &sbCtx{
+		svc:         "SafeBrowsing",
+		cache:       &lruVerdictCache{Cache: cache.New(cache.Config{})},
+		cacheTime:   cacheTime,
+		cacheJitter: jitter,
+		randInt63n:  rand.New(rand.NewSource(1)).Int63n,
+	}
+
+	ttl := int64(cacheTime * 60)
+	band := ttl * jitter / 100
+
+	expires := map[int64]struct{}{}
+	for i := 0; i < 100; i++ {
+		prefix := []byte{byte(i), 0}
+
+		start := time.Now().Unix()
+		c.setCache(prefix, nil)
+		end := time.Now().Unix()
+
+		val := c.cache.Get(prefix)
+		require.Len(t, val, 4)
+
+		exp := int64(binary.BigEndian.Uint32(val))
+		assert.GreaterOrEqual(t, exp, start+ttl-band)
+		assert.LessOrEqual(t, exp, end+ttl+band)
+
+		expires[exp-start] = struct{}{}
+	}
+
+	// The TTLs must actually vary.
+	assert.Greater(t, len(expires), 1)
+}
+
 func TestSBPC_checkErrorUpstream(t *testing.T) {
 	d := newForTest(t, &Config{SafeBrowsingEnabled: true}, nil)
 	t.Cleanup(d.Close)
@@ -129,6 +227,100 @@ func TestSBPC_checkErrorUpstream(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestSBPC_checkErrorUpstream_failMode(t *testing.T) {
+	setts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+	}
+
+	testCases := []struct {
+		name       string
+		mode       string
+		wantRCode  int
+		wantErr    bool
+		wantFilter bool
+	}{{
+		name:       "default",
+		mode:       "",
+		wantRCode:  0,
+		wantErr:    true,
+		wantFilter: false,
+	}, {
+		name:       "open",
+		mode:       SecurityFailOpen,
+		wantRCode:  0,
+		wantErr:    false,
+		wantFilter: false,
+	}, {
+		name:       "closed",
+		mode:       SecurityFailClosed,
+		wantRCode:  dns.RcodeServerFailure,
+		wantErr:    false,
+		wantFilter: true,
+	}}
+
+	checkers := []struct {
+		check      func(d *DNSFilter) (res Result, err error)
+		name       string
+		wantListID int64
+	}{{
+		check: func(d *DNSFilter) (res Result, err error) {
+			return d.checkSafeBrowsing("smthng.com", dns.TypeA, setts)
+		},
+		name:       "safebrowsing",
+		wantListID: SafeBrowsingListID,
+	}, {
+		check: func(d *DNSFilter) (res Result, err error) {
+			return d.checkParental("smthng.com", dns.TypeA, setts)
+		},
+		name:       "parental",
+		wantListID: ParentalListID,
+	}}
+
+	for _, tc := range testCases {
+		d := newForTest(t, &Config{
+			SafeBrowsingEnabled: true,
+			ParentalEnabled:     true,
+			SecurityFailMode:    tc.mode,
+		}, nil)
+		t.Cleanup(d.Close)
+
+		ups := &aghtest.TestErrUpstream{}
+		d.SetSafeBrowsingUpstream(ups)
+		d.SetParentalUpstream(ups)
+
+		for _, c := range checkers {
+			t.Run(tc.name+"_"+c.name, func(t *testing.T) {
+				res, err := c.check(d)
+				if tc.wantErr {
+					assert.Error(t, err)
+
+					return
+				}
+
+				require.NoError(t, err)
+
+				assert.Equal(t, tc.wantFilter, res.IsFiltered)
+				if !tc.wantFilter {
+					return
+				}
+
+				// The failed lookups must not be reported as the matched
+				// hosts.
+				assert.Equal(t, FilteredSecurityFailure, res.Reason)
+				require.Len(t, res.Rules, 1)
+
+				assert.Equal(t, securityFailureRuleText, res.Rules[0].Text)
+				assert.Equal(t, c.wantListID, res.Rules[0].FilterListID)
+				require.NotNil(t, res.DNSRewriteResult)
+
+				assert.Equal(t, tc.wantRCode, res.DNSRewriteResult.RCode)
+			})
+		}
+	}
+}
+
 func TestSBPC(t *testing.T) {
 	d := newForTest(t, &Config{SafeBrowsingEnabled: true}, nil)
 	t.Cleanup(d.Close)
@@ -142,27 +334,27 @@ func TestSBPC(t *testing.T) {
 	}
 
 	testCases := []struct {
-		testCache cache.Cache
+		testCache *lruVerdictCache
 		testFunc  func(host string, _ uint16, _ *Settings) (res Result, err error)
 		name      string
 		block     bool
 	}{{
-		testCache: d.safebrowsingCache,
+		testCache: d.safebrowsingCache.(*lruVerdictCache),
 		testFunc:  d.checkSafeBrowsing,
 		name:      "sb_no_block",
 		block:     false,
 	}, {
-		testCache: d.safebrowsingCache,
+		testCache: d.safebrowsingCache.(*lruVerdictCache),
 		testFunc:  d.checkSafeBrowsing,
 		name:      "sb_block",
 		block:     true,
 	}, {
-		testCache: d.parentalCache,
+		testCache: d.parentalCache.(*lruVerdictCache),
 		testFunc:  d.checkParental,
 		name:      "pc_no_block",
 		block:     false,
 	}, {
-		testCache: d.parentalCache,
+		testCache: d.parentalCache.(*lruVerdictCache),
 		testFunc:  d.checkParental,
 		name:      "pc_block",
 		block:     true,
@@ -220,3 +412,474 @@ func TestSBPC(t *testing.T) {
 		purgeCaches(d)
 	}
 }
+
+func TestDNSFilter_checkSafeBrowsing_qtype(t *testing.T) {
+	const hostname = "example.org"
+
+	setts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+	}
+
+	testCases := []struct {
+		name      string
+		types     []uint16
+		qtype     uint16
+		wantCheck bool
+	}{{
+		name:      "default_a",
+		types:     nil,
+		qtype:     dns.TypeA,
+		wantCheck: true,
+	}, {
+		name:      "default_https",
+		types:     nil,
+		qtype:     dns.TypeHTTPS,
+		wantCheck: true,
+	}, {
+		name:      "default_txt",
+		types:     nil,
+		qtype:     dns.TypeTXT,
+		wantCheck: false,
+	}, {
+		name:      "default_mx",
+		types:     nil,
+		qtype:     dns.TypeMX,
+		wantCheck: false,
+	}, {
+		name:      "custom_txt",
+		types:     []uint16{dns.TypeTXT},
+		qtype:     dns.TypeTXT,
+		wantCheck: true,
+	}, {
+		name:      "custom_a",
+		types:     []uint16{dns.TypeTXT},
+		qtype:     dns.TypeA,
+		wantCheck: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{
+				SafeBrowsingEnabled: true,
+				ParentalEnabled:     true,
+				SecurityCheckTypes:  tc.types,
+			}, nil)
+			t.Cleanup(d.Close)
+
+			sbUps := &aghtest.TestBlockUpstream{Hostname: hostname, Block: true}
+			d.SetSafeBrowsingUpstream(sbUps)
+
+			pcUps := &aghtest.TestBlockUpstream{Hostname: hostname, Block: true}
+			d.SetParentalUpstream(pcUps)
+
+			wantCount := 0
+			if tc.wantCheck {
+				wantCount = 1
+			}
+
+			res, err := d.checkSafeBrowsing(hostname, tc.qtype, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantCheck, res.IsFiltered)
+			assert.Equal(t, wantCount, sbUps.RequestsCount())
+
+			res, err = d.checkParental(hostname, tc.qtype, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantCheck, res.IsFiltered)
+			assert.Equal(t, wantCount, pcUps.RequestsCount())
+		})
+	}
+}
+
+func TestDNSFilter_InvalidateCache(t *testing.T) {
+	const (
+		hostname = "example.org"
+		other    = "example.net"
+	)
+
+	d := newForTest(t, &Config{SafeBrowsingEnabled: true}, nil)
+	t.Cleanup(d.Close)
+
+	setts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+		ParentalEnabled:     true,
+	}
+
+	testCases := []struct {
+		testFunc func(host string, _ uint16, _ *Settings) (res Result, err error)
+		setUps   func(u upstream.Upstream)
+		name     string
+	}{{
+		testFunc: d.checkSafeBrowsing,
+		setUps:   d.SetSafeBrowsingUpstream,
+		name:     "safe_browsing",
+	}, {
+		testFunc: d.checkParental,
+		setUps:   d.SetParentalUpstream,
+		name:     "parental",
+	}}
+
+	for _, tc := range testCases {
+		ups := &aghtest.TestBlockUpstream{
+			Hostname: hostname,
+			Block:    true,
+		}
+		tc.setUps(ups)
+
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := tc.testFunc(hostname, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.True(t, res.IsFiltered)
+
+			_, err = tc.testFunc(other, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			require.Equal(t, 2, ups.RequestsCount())
+
+			d.InvalidateCache(hostname)
+
+			// The other host is still cached.
+			_, err = tc.testFunc(other, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, 2, ups.RequestsCount())
+
+			// The invalidated one is requested again.
+			res, err = tc.testFunc(hostname, dns.TypeA, setts)
+			require.NoError(t, err)
+
+			assert.True(t, res.IsFiltered)
+			assert.Equal(t, 3, ups.RequestsCount())
+		})
+
+		purgeCaches(d)
+	}
+
+	t.Run("safe_search", func(t *testing.T) {
+		res := Result{IsFiltered: true, Reason: FilteredSafeSearch}
+		d.setCacheResult(d.safeSearchCache, hostname, res)
+		d.setCacheResult(d.safeSearchCache, other, res)
+
+		d.InvalidateCache(hostname + ".")
+
+		_, ok := getCachedResult(d.safeSearchCache, hostname)
+		assert.False(t, ok)
+
+		_, ok = getCachedResult(d.safeSearchCache, other)
+		assert.True(t, ok)
+	})
+}
+
+func TestDNSFilter_onCacheEvict(t *testing.T) {
+	const (
+		cacheSize = 100
+		entrySize = 20
+		entries   = 10
+	)
+
+	evicted := map[string]int{}
+	var mu sync.Mutex
+	d := New(&Config{
+		SafeBrowsingCacheSize: cacheSize,
+		ParentalCacheSize:     cacheSize,
+		SafeSearchCacheSize:   cacheSize,
+		OnCacheEvict: func(name string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			evicted[name]++
+		},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	val := make([]byte, entrySize-2)
+	for i := 0; i < entries; i++ {
+		key := []byte{0, byte(i)}
+		d.safebrowsingCache.Set(key, val)
+		d.parentalCache.Set(key, val)
+		d.safeSearchCache.Set(key, val)
+	}
+
+	// Each cache fits cacheSize/entrySize entries, the rest are evicted.
+	const wantEvicted = entries - cacheSize/entrySize
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, map[string]int{
+		CacheNameSafeBrowsing: wantEvicted,
+		CacheNameParental:     wantEvicted,
+		CacheNameSafeSearch:   wantEvicted,
+	}, evicted)
+}
+
+// testVerdictCache is a VerdictCache for tests which records the operations.
+type testVerdictCache struct {
+	data map[string][]byte
+	gets []string
+	sets []string
+	dels []string
+	mu   sync.Mutex
+}
+
+// type check
+var _ VerdictCache = (*testVerdictCache)(nil)
+
+// Get implements the VerdictCache interface for *testVerdictCache.
+func (c *testVerdictCache) Get(key []byte) (val []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gets = append(c.gets, string(key))
+
+	return c.data[string(key)]
+}
+
+// Set implements the VerdictCache interface for *testVerdictCache.
+func (c *testVerdictCache) Set(key, val []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sets = append(c.sets, string(key))
+	c.data[string(key)] = val
+}
+
+// Delete implements the VerdictCache interface for *testVerdictCache.
+func (c *testVerdictCache) Delete(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dels = append(c.dels, string(key))
+	delete(c.data, string(key))
+}
+
+func TestSBPC_customCache(t *testing.T) {
+	const hostname = "example.org"
+
+	sbCache := &testVerdictCache{data: map[string][]byte{}}
+	pcCache := &testVerdictCache{data: map[string][]byte{}}
+
+	d := newForTest(t, &Config{
+		SafeBrowsingEnabled: true,
+		SafeBrowsingCache:   sbCache,
+		ParentalCache:       pcCache,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	ups := &aghtest.TestBlockUpstream{
+		Hostname: hostname,
+		Block:    true,
+	}
+	d.SetSafeBrowsingUpstream(ups)
+
+	setts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+	}
+
+	hash := sha256.Sum256([]byte(hostname))
+	prefix := string(hash[:2])
+
+	res, err := d.checkSafeBrowsing(hostname, dns.TypeA, setts)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, 1, ups.RequestsCount())
+
+	// The prefix is looked up before the request and once more after it to
+	// check if it should be cached as empty.
+	assert.Equal(t, []string{prefix, prefix}, sbCache.gets)
+	assert.Equal(t, []string{prefix}, sbCache.sets)
+	require.Contains(t, sbCache.data, prefix)
+
+	res, err = d.checkSafeBrowsing(hostname, dns.TypeA, setts)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, 1, ups.RequestsCount())
+
+	assert.Equal(t, []string{prefix, prefix, prefix}, sbCache.gets)
+	assert.Len(t, sbCache.sets, 1)
+
+	// The parental control cache must not be touched.
+	assert.Empty(t, pcCache.gets)
+	assert.Empty(t, pcCache.sets)
+	assert.Empty(t, sbCache.dels)
+
+	t.Run("delete", func(t *testing.T) {
+		sbCache.Delete(hash[:2])
+
+		res, err = d.checkSafeBrowsing(hostname, dns.TypeA, setts)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+		assert.Equal(t, 2, ups.RequestsCount())
+		assert.Len(t, sbCache.sets, 2)
+	})
+}
+
+// flakyUpstream is an upstream.Upstream for tests which fails the first
+// fails exchanges and delays each response by delay.
+type flakyUpstream struct {
+	ups   upstream.Upstream
+	delay time.Duration
+
+	// mu protects fails and reqNum.
+	mu     sync.Mutex
+	fails  int
+	reqNum int
+}
+
+// Exchange implements the upstream.Upstream interface for *flakyUpstream.
+func (u *flakyUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	u.mu.Lock()
+	u.reqNum++
+	fail := u.fails > 0
+	if fail {
+		u.fails--
+	}
+	u.mu.Unlock()
+
+	time.Sleep(u.delay)
+
+	if fail {
+		return nil, errors.Error("test error")
+	}
+
+	return u.ups.Exchange(req)
+}
+
+// Address implements the upstream.Upstream interface for *flakyUpstream.
+func (u *flakyUpstream) Address() (addr string) {
+	return "flaky"
+}
+
+// Close implements the upstream.Upstream interface for *flakyUpstream.
+func (u *flakyUpstream) Close() (err error) {
+	return nil
+}
+
+// requestsCount returns the number of exchanges performed.
+func (u *flakyUpstream) requestsCount() (n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.reqNum
+}
+
+func TestRetryUpstream(t *testing.T) {
+	const hostname = "example.org"
+
+	setts := &Settings{
+		ProtectionEnabled:   true,
+		SafeBrowsingEnabled: true,
+	}
+
+	testCases := []struct {
+		name     string
+		fails    int
+		retries  uint
+		wantReqs int
+		wantErr  bool
+	}{{
+		name:     "no_retries",
+		fails:    0,
+		retries:  0,
+		wantReqs: 1,
+		wantErr:  false,
+	}, {
+		name:     "no_retries_fail",
+		fails:    1,
+		retries:  0,
+		wantReqs: 1,
+		wantErr:  true,
+	}, {
+		name:     "retried",
+		fails:    2,
+		retries:  2,
+		wantReqs: 3,
+		wantErr:  false,
+	}, {
+		name:     "retries_exhausted",
+		fails:    5,
+		retries:  2,
+		wantReqs: 3,
+		wantErr:  true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(t, &Config{SafeBrowsingEnabled: true}, nil)
+			t.Cleanup(d.Close)
+
+			ups := &flakyUpstream{
+				ups: &aghtest.TestBlockUpstream{
+					Hostname: hostname,
+					Block:    true,
+				},
+				fails: tc.fails,
+			}
+			d.SetSafeBrowsingUpstream(&retryUpstream{
+				Upstream: ups,
+				timeout:  time.Second,
+				retries:  tc.retries,
+			})
+
+			res, err := d.checkSafeBrowsing(hostname, dns.TypeA, setts)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+
+				assert.True(t, res.IsFiltered)
+			}
+
+			assert.Equal(t, tc.wantReqs, ups.requestsCount())
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		ups := &flakyUpstream{
+			ups:   &aghtest.TestBlockUpstream{Hostname: hostname},
+			delay: 500 * time.Millisecond,
+		}
+		u := &retryUpstream{
+			Upstream: ups,
+			timeout:  10 * time.Millisecond,
+			retries:  1,
+		}
+
+		start := time.Now()
+		_, err := u.Exchange((&dns.Msg{}).SetQuestion("example.org.", dns.TypeTXT))
+		assert.ErrorIs(t, err, errSecurityTimeout)
+		assert.Less(t, time.Since(start), 250*time.Millisecond)
+
+		assert.Equal(t, 2, ups.requestsCount())
+	})
+}
+
+func TestDNSFilter_initSecurityServices(t *testing.T) {
+	d := New(&Config{
+		SafeBrowsingTimeout: 100,
+		SafeBrowsingRetries: 2,
+		ParentalRetries:     1,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	sbUps, ok := d.safeBrowsingUpstream.(*retryUpstream)
+	require.True(t, ok)
+
+	assert.Equal(t, 100*time.Millisecond, sbUps.timeout)
+	assert.Equal(t, uint(2), sbUps.retries)
+
+	pcUps, ok := d.parentalUpstream.(*retryUpstream)
+	require.True(t, ok)
+
+	assert.Equal(t, dnsTimeout, pcUps.timeout)
+	assert.Equal(t, uint(1), pcUps.retries)
+}
@@ -69,12 +69,24 @@ func (d *DNSFilter) SafeSearchDomain(host string) (string, bool) {
 	return val, ok
 }
 
+// checkSafeSearch is the hostChecker version of checkSafeSearchCtx.
 func (d *DNSFilter) checkSafeSearch(
 	host string,
-	_ uint16,
+	qtype uint16,
 	setts *Settings,
 ) (res Result, err error) {
-	if !setts.ProtectionEnabled || !setts.SafeSearchEnabled {
+	return d.checkSafeSearchCtx(context.Background(), host, qtype, setts)
+}
+
+// checkSafeSearchCtx is like checkSafeSearch, but it stops resolving the safe
+// search host once ctx is done.
+func (d *DNSFilter) checkSafeSearchCtx(
+	ctx context.Context,
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (res Result, err error) {
+	if !setts.EffectiveProtection() || !setts.SafeSearchEnabled {
 		return Result{}, nil
 	}
 
@@ -83,19 +95,38 @@ func (d *DNSFilter) checkSafeSearch(
 		defer timer.LogElapsed("SafeSearch: lookup for %s", host)
 	}
 
-	// Check cache. Return cached result if it was found
-	cachedValue, isFound := getCachedResult(d.safeSearchCache, host)
-	if isFound {
-		// atomic.AddUint64(&gctx.stats.Safesearch.CacheHits, 1)
-		log.Tracef("SafeSearch: found in cache: %s", host)
-		return cachedValue, nil
-	}
-
 	safeHost, ok := d.SafeSearchDomain(host)
 	if !ok {
 		return Result{}, nil
 	}
 
+	// Don't enforce safe search for the allowlisted hosts, since the
+	// enforcement is a kind of blocking.  The allowlist is a part of the
+	// filtering, so only consult it when the filtering is enabled.  Do it
+	// before checking the cache, since the allowlist rules may be
+	// client-specific.
+	if setts.EffectiveFiltering() {
+		var allowed bool
+		res, allowed, err = d.matchAllowlist(host, qtype, setts)
+		if err != nil {
+			return Result{}, fmt.Errorf("matching allowlist: %w", err)
+		} else if allowed {
+			log.Debug("SafeSearch: %s is allowlisted", host)
+
+			return res, nil
+		}
+	}
+
+	// Check cache. Return cached result if it was found.  The per-request
+	// resolver may resolve the hosts differently, so don't use the cache
+	// then.
+	cachedValue, isFound := getCachedResult(d.safeSearchCache, host)
+	if isFound && setts.Resolver == nil {
+		d.stats.Safesearch.cacheHit()
+		log.Tracef("SafeSearch: found in cache: %s", host)
+		return cachedValue, nil
+	}
+
 	res = Result{
 		IsFiltered: true,
 		Reason:     FilteredSafeSearch,
@@ -112,7 +143,14 @@ func (d *DNSFilter) checkSafeSearch(
 		return res, nil
 	}
 
-	ips, err := d.resolver.LookupIP(context.Background(), "ip", safeHost)
+	resolver := d.resolver
+	if setts.Resolver != nil {
+		resolver = setts.Resolver
+	}
+
+	done := d.stats.Safesearch.startRequest()
+	ips, err := resolver.LookupIP(ctx, "ip", safeHost)
+	done()
 	if err != nil {
 		log.Tracef("SafeSearchDomain for %s was found but failed to lookup for %s cause %s", host, safeHost, err)
 		return Result{}, err
@@ -125,8 +163,10 @@ func (d *DNSFilter) checkSafeSearch(
 
 		res.Rules[0].IP = ip
 
-		l := d.setCacheResult(d.safeSearchCache, host, res)
-		log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, l)
+		if setts.Resolver == nil {
+			l := d.setCacheResult(d.safeSearchCache, host, res)
+			log.Debug("SafeSearch: stored in cache: %s (%d bytes)", host, l)
+		}
 
 		return res, nil
 	}
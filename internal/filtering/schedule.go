@@ -0,0 +1,70 @@
+package filtering
+
+import "time"
+
+// DayRange is the time range within a day.  Start and End are the offsets from
+// the midnight.  If End is before Start, the range continues past the midnight
+// into the next day, so that Start 22:00 and End 07:00 cover the night.  If End
+// is equal to Start, the range covers the whole day.  The daylight saving time
+// transitions are ignored.
+type DayRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ClientSchedule defines when the filtering and the blocked services apply to
+// the client.  A nil *ClientSchedule is always active.
+type ClientSchedule struct {
+	// TimeZone is the location in which the ranges are defined.  If nil,
+	// UTC is used.
+	TimeZone *time.Location
+
+	// Week are the active ranges indexed by time.Weekday.  A nil range means
+	// that the schedule is inactive on the day, except for the range of the
+	// previous day continuing past the midnight.
+	Week [7]*DayRange
+}
+
+// Active returns true if t falls within one of the ranges of s.  s may be nil,
+// in which case it's always active.
+func (s *ClientSchedule) Active(t time.Time) (ok bool) {
+	if s == nil {
+		return true
+	}
+
+	loc := s.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	t = t.In(loc)
+	day := t.Weekday()
+	year, month, mday := t.Date()
+	offset := t.Sub(time.Date(year, month, mday, 0, 0, 0, 0, loc))
+
+	if r := s.Week[day]; r != nil {
+		switch {
+		case r.Start == r.End:
+			return true
+		case r.Start < r.End:
+			if offset >= r.Start && offset < r.End {
+				return true
+			}
+		default:
+			if offset >= r.Start {
+				return true
+			}
+		}
+	}
+
+	// Check if the range of the previous day continues past the midnight.
+	prev := s.Week[(day+6)%7]
+
+	return prev != nil && prev.End < prev.Start && offset < prev.End
+}
+
+// scheduleActive returns true if the filtering and the blocked services apply
+// to the request with setts at the moment, see Settings.Schedule.
+func (d *DNSFilter) scheduleActive(setts *Settings) (ok bool) {
+	return setts == nil || setts.Schedule.Active(d.now())
+}
@@ -0,0 +1,149 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSchedule_Active(t *testing.T) {
+	// loc is 3 hours east of UTC.
+	loc := time.FixedZone("UTC+3", 3*60*60)
+
+	night := &DayRange{Start: 22 * time.Hour, End: 7 * time.Hour}
+	s := &ClientSchedule{
+		TimeZone: loc,
+		Week: [7]*DayRange{
+			time.Monday:   night,
+			time.Tuesday:  {Start: 9 * time.Hour, End: 17 * time.Hour},
+			time.Saturday: {},
+		},
+	}
+
+	// 2021-11-01 is a Monday.
+	at := func(day, hour, min int) (t time.Time) {
+		return time.Date(2021, 11, day, hour, min, 0, 0, loc)
+	}
+
+	testCases := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{{
+		name: "monday_before_night",
+		t:    at(1, 21, 59),
+		want: false,
+	}, {
+		name: "monday_night",
+		t:    at(1, 22, 0),
+		want: true,
+	}, {
+		name: "tuesday_early_morning",
+		t:    at(2, 6, 59),
+		want: true,
+	}, {
+		name: "tuesday_morning",
+		t:    at(2, 7, 0),
+		want: false,
+	}, {
+		name: "tuesday_work",
+		t:    at(2, 12, 0),
+		want: true,
+	}, {
+		name: "tuesday_evening",
+		t:    at(2, 17, 0),
+		want: false,
+	}, {
+		name: "wednesday",
+		t:    at(3, 3, 0),
+		want: false,
+	}, {
+		name: "saturday_whole_day",
+		t:    at(6, 0, 0),
+		want: true,
+	}, {
+		name: "time_zone",
+		// 20:00 UTC is 23:00 in loc.
+		t:    time.Date(2021, 11, 1, 20, 0, 0, 0, time.UTC),
+		want: true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, s.Active(tc.t))
+		})
+	}
+
+	t.Run("nil", func(t *testing.T) {
+		var ns *ClientSchedule
+		assert.True(t, ns.Active(at(3, 3, 0)))
+	})
+
+	t.Run("utc", func(t *testing.T) {
+		us := &ClientSchedule{
+			Week: [7]*DayRange{time.Monday: night},
+		}
+
+		assert.True(t, us.Active(time.Date(2021, 11, 1, 23, 0, 0, 0, time.UTC)))
+		assert.False(t, us.Active(at(1, 23, 0)))
+	})
+}
+
+func TestDNSFilter_CheckHost_schedule(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, nil, []Filter{{
+		ID: 1, Data: []byte("||blocked.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	// 2021-11-01 is a Monday.
+	now := time.Date(2021, 11, 1, 23, 0, 0, 0, time.UTC)
+	d.now = func() (t time.Time) { return now }
+
+	s := setts
+	d.ApplyClientBlockedServices(&s, ClientServices{
+		Block:   []string{"youtube"},
+		Replace: true,
+	})
+	s.Schedule = &ClientSchedule{
+		Week: [7]*DayRange{
+			time.Monday: {Start: 22 * time.Hour, End: 7 * time.Hour},
+		},
+	}
+
+	check := func(t *testing.T, host string, want Reason) {
+		t.Helper()
+
+		res, err := d.CheckHost(host, dns.TypeA, &s)
+		require.NoError(t, err)
+
+		assert.Equal(t, want, res.Reason)
+		assert.Equal(t, want != NotFilteredNotFound, res.IsFiltered)
+	}
+
+	t.Run("active", func(t *testing.T) {
+		check(t, "blocked.example", FilteredBlockList)
+		check(t, "www.youtube.com", FilteredBlockedService)
+	})
+
+	t.Run("inactive", func(t *testing.T) {
+		now = now.Add(12 * time.Hour)
+
+		check(t, "blocked.example", NotFilteredNotFound)
+		check(t, "www.youtube.com", NotFilteredNotFound)
+	})
+
+	t.Run("no_schedule", func(t *testing.T) {
+		ns := s
+		ns.Schedule = nil
+
+		res, err := d.CheckHost("blocked.example", dns.TypeA, &ns)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+	})
+}
@@ -0,0 +1,107 @@
+package filtering
+
+import (
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/netutil"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// matchServerHost returns the result answering the A and AAAA requests for
+// the server's own names and the PTR requests for its addresses, see
+// Config.ServerNames and Config.ServerIPs.  ok is false if the request isn't
+// about the server itself.
+func (d *DNSFilter) matchServerHost(host string, qtype uint16) (res Result, ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	if len(d.ServerNames) == 0 || len(d.ServerIPs) == 0 {
+		return Result{}, false
+	}
+
+	var vals []rules.RRValue
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		if !d.isServerName(host) {
+			return Result{}, false
+		}
+
+		for _, ip := range d.ServerIPs {
+			if ip4 := ip.To4(); ip4 != nil && qtype == dns.TypeA {
+				vals = append(vals, ip4)
+			} else if ip4 == nil && qtype == dns.TypeAAAA {
+				vals = append(vals, ip)
+			}
+		}
+	case dns.TypePTR:
+		ip, err := netutil.IPFromReversedAddr(host)
+		if err != nil || !d.isServerIP(ip) {
+			return Result{}, false
+		}
+
+		vals = []rules.RRValue{strings.TrimSuffix(d.ServerNames[0], ".")}
+	default:
+		return Result{}, false
+	}
+
+	dnsrr := &DNSRewriteResult{
+		Response: DNSRewriteResultResponse{},
+		RCode:    dns.RcodeSuccess,
+	}
+	if len(vals) > 0 {
+		dnsrr.Response[qtype] = vals
+	}
+
+	return Result{
+		Reason:           RewrittenRule,
+		DNSRewriteResult: dnsrr,
+	}, true
+}
+
+// isServerName returns true if host is one of the server's own names.
+// d.confLock is expected to be locked.
+func (d *DNSFilter) isServerName(host string) (ok bool) {
+	return hasServerName(d.ServerNames, host)
+}
+
+// isServerIP returns true if ip is one of the server's own addresses.
+// d.confLock is expected to be locked.
+func (d *DNSFilter) isServerIP(ip net.IP) (ok bool) {
+	return hasServerIP(d.ServerIPs, ip)
+}
+
+// isServerAddr returns true if host is one of names or, if it's an IP address,
+// one of ips.
+func isServerAddr(host string, names []string, ips []net.IP) (ok bool) {
+	if ip := net.ParseIP(host); ip != nil {
+		return hasServerIP(ips, ip)
+	}
+
+	return hasServerName(names, host)
+}
+
+// hasServerName returns true if host is one of names, ignoring the case and the
+// trailing dots.
+func hasServerName(names []string, host string) (ok bool) {
+	host = strings.TrimSuffix(host, ".")
+	for _, n := range names {
+		if strings.EqualFold(host, strings.TrimSuffix(n, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasServerIP returns true if ip is one of ips.
+func hasServerIP(ips []net.IP, ip net.IP) (ok bool) {
+	for _, sip := range ips {
+		if sip.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
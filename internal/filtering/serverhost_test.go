@@ -0,0 +1,119 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_serverHost(t *testing.T) {
+	const data = "||adguard.lan^\n||other.lan^\n"
+
+	ip4 := net.IP{192, 168, 0, 1}
+	ip6 := net.ParseIP("fd00::1")
+
+	d := newForTest(t, &Config{
+		ServerNames: []string{"adguard.lan.", "dns.home"},
+		ServerIPs:   []net.IP{ip4, ip6},
+	}, []Filter{{ID: 0, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantVals   []rules.RRValue
+		qtype      uint16
+		wantReason Reason
+	}{{
+		name:       "a",
+		host:       "adguard.lan",
+		wantVals:   []rules.RRValue{ip4.To4()},
+		qtype:      dns.TypeA,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "aaaa",
+		host:       "dns.home",
+		wantVals:   []rules.RRValue{ip6},
+		qtype:      dns.TypeAAAA,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "a_case",
+		host:       "DNS.Home",
+		wantVals:   []rules.RRValue{ip4.To4()},
+		qtype:      dns.TypeA,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "ptr_ipv4",
+		host:       "1.0.168.192.in-addr.arpa",
+		wantVals:   []rules.RRValue{"adguard.lan"},
+		qtype:      dns.TypePTR,
+		wantReason: RewrittenRule,
+	}, {
+		name: "ptr_ipv6",
+		host: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0." +
+			"0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa",
+		wantVals:   []rules.RRValue{"adguard.lan"},
+		qtype:      dns.TypePTR,
+		wantReason: RewrittenRule,
+	}, {
+		name:       "unrelated_blocked",
+		host:       "other.lan",
+		wantVals:   nil,
+		qtype:      dns.TypeA,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "unrelated",
+		host:       "example.org",
+		wantVals:   nil,
+		qtype:      dns.TypeA,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "unrelated_ptr",
+		host:       "2.0.168.192.in-addr.arpa",
+		wantVals:   nil,
+		qtype:      dns.TypePTR,
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "other_qtype",
+		host:       "dns.home",
+		wantVals:   nil,
+		qtype:      dns.TypeTXT,
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantVals == nil {
+				return
+			}
+
+			require.NotNil(t, res.DNSRewriteResult)
+
+			assert.Equal(t, dns.RcodeSuccess, res.DNSRewriteResult.RCode)
+			assert.Equal(t, tc.wantVals, res.DNSRewriteResult.Response[tc.qtype])
+		})
+	}
+
+	t.Run("no_data", func(t *testing.T) {
+		nd := newForTest(t, &Config{
+			ServerNames: []string{"adguard.lan"},
+			ServerIPs:   []net.IP{ip4},
+		}, nil)
+		t.Cleanup(nd.Close)
+
+		res, err := nd.CheckHost("adguard.lan", dns.TypeAAAA, &setts)
+		require.NoError(t, err)
+		require.NotNil(t, res.DNSRewriteResult)
+
+		assert.Equal(t, dns.RcodeSuccess, res.DNSRewriteResult.RCode)
+		assert.Empty(t, res.DNSRewriteResult.Response)
+	})
+}
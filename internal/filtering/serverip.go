@@ -0,0 +1,76 @@
+package filtering
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// serverIPTagPrefix is the prefix of the client tags describing the address of
+// the server's interface which has received the request.
+const serverIPTagPrefix = "server_ip_"
+
+// serverIPTagReplacer replaces the separators of the addresses in the server
+// address tags, see serverIPTag.
+var serverIPTagReplacer = strings.NewReplacer(".", "_", ":", "_")
+
+// serverIPTag returns the client tag for the server's address ip, so that the
+// rules could be scoped with it, for example:
+//
+//	||example.org^$ctag=server_ip_192_168_2_1
+//	||example.org^$ctag=server_ip_fd00__1
+//
+// It returns an empty string if ip is nil.
+func serverIPTag(ip net.IP) (tag string) {
+	if ip == nil {
+		return ""
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return serverIPTagPrefix + serverIPTagReplacer.Replace(ip.String())
+}
+
+// hasServerIPTag returns true if the rule with text may be scoped by the
+// server's address, see serverIPTag.
+func hasServerIPTag(text string) (ok bool) {
+	return strings.Contains(text, "ctag=") && strings.Contains(text, serverIPTagPrefix)
+}
+
+// UsesServerIP returns true if any of the loaded filtering or routing rules is
+// scoped by the server's address, see Settings.ServerIP.  The callers may skip
+// determining the address otherwise.
+func (d *DNSFilter) UsesServerIP() (ok bool) {
+	return atomic.LoadUint32(&d.serverIPTagged) != 0 ||
+		atomic.LoadUint32(&d.routingServerIPTagged) != 0
+}
+
+// setServerIPTagged stores into flag whether the rules use the server address
+// tags.
+func setServerIPTagged(flag *uint32, tagged bool) {
+	var v uint32
+	if tagged {
+		v = 1
+	}
+
+	atomic.StoreUint32(flag, v)
+}
+
+// requestTags returns the sorted client tags for the request with setts,
+// including the tag of the server's address, if any.
+func requestTags(setts *Settings) (tags []string) {
+	tag := serverIPTag(setts.ServerIP)
+	if tag == "" {
+		return setts.ClientTags
+	}
+
+	tags = make([]string, 0, len(setts.ClientTags)+1)
+	tags = append(tags, setts.ClientTags...)
+	tags = append(tags, tag)
+	sort.Strings(tags)
+
+	return tags
+}
@@ -0,0 +1,149 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerIPTag(t *testing.T) {
+	testCases := []struct {
+		name string
+		ip   net.IP
+		want string
+	}{{
+		name: "nil",
+		ip:   nil,
+		want: "",
+	}, {
+		name: "ipv4",
+		ip:   net.IP{192, 168, 2, 1},
+		want: "server_ip_192_168_2_1",
+	}, {
+		name: "ipv4_in_ipv6",
+		ip:   net.ParseIP("192.168.2.1"),
+		want: "server_ip_192_168_2_1",
+	}, {
+		name: "ipv6",
+		ip:   net.ParseIP("fd00::1"),
+		want: "server_ip_fd00__1",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, serverIPTag(tc.ip))
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_serverIP(t *testing.T) {
+	const data = "||example.org^$ctag=server_ip_192_168_2_1\n" +
+		"||example.net^$ctag=~server_ip_192_168_2_1\n"
+
+	d := newForTest(t, &Config{}, []Filter{{ID: 0, Data: []byte(data)}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		serverIP    net.IP
+		name        string
+		host        string
+		wantBlocked bool
+	}{{
+		serverIP:    net.IP{192, 168, 1, 1},
+		name:        "home",
+		host:        "example.org",
+		wantBlocked: false,
+	}, {
+		serverIP:    net.IP{192, 168, 2, 1},
+		name:        "guest",
+		host:        "example.org",
+		wantBlocked: true,
+	}, {
+		serverIP:    nil,
+		name:        "unknown",
+		host:        "example.org",
+		wantBlocked: false,
+	}, {
+		serverIP:    net.IP{192, 168, 1, 1},
+		name:        "home_restricted",
+		host:        "example.net",
+		wantBlocked: true,
+	}, {
+		serverIP:    net.IP{192, 168, 2, 1},
+		name:        "guest_restricted",
+		host:        "example.net",
+		wantBlocked: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := setts
+			s.ClientTags = []string{"device_phone"}
+			s.ServerIP = tc.serverIP
+
+			res, err := d.CheckHost(tc.host, dns.TypeA, &s)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantBlocked, res.IsFiltered)
+		})
+	}
+}
+
+func TestDNSFilter_UsesServerIP(t *testing.T) {
+	d := newForTest(t, &Config{}, []Filter{{ID: 1, Data: []byte("||example.org^\n")}})
+	t.Cleanup(d.Close)
+
+	assert.False(t, d.UsesServerIP())
+
+	t.Run("blocklist", func(t *testing.T) {
+		err := d.SetFilters([]Filter{{
+			ID:   1,
+			Data: []byte("||example.org^$ctag=~server_ip_192_168_2_1\n"),
+		}}, nil, false)
+		require.NoError(t, err)
+
+		assert.True(t, d.UsesServerIP())
+
+		err = d.SetFilters([]Filter{{ID: 1, Data: []byte("||example.org^\n")}}, nil, false)
+		require.NoError(t, err)
+
+		assert.False(t, d.UsesServerIP())
+	})
+
+	t.Run("exception", func(t *testing.T) {
+		err := d.AddException("||example.org^$ctag=server_ip_192_168_2_1")
+		require.NoError(t, err)
+
+		assert.True(t, d.UsesServerIP())
+
+		err = d.SetFilters(nil, nil, false)
+		require.NoError(t, err)
+
+		// The exceptions are kept after the reload.
+		assert.True(t, d.UsesServerIP())
+	})
+
+	t.Run("routing", func(t *testing.T) {
+		rd := newForTest(t, &Config{}, nil)
+		t.Cleanup(rd.Close)
+
+		err := rd.SetRoutingFilters([]RoutingFilter{{
+			Tag: "guest",
+			Filter: Filter{
+				ID:   100,
+				Data: []byte("||example.org^$ctag=server_ip_192_168_2_1\n"),
+			},
+		}})
+		require.NoError(t, err)
+
+		assert.True(t, rd.UsesServerIP())
+
+		err = rd.SetRoutingFilters(nil)
+		require.NoError(t, err)
+
+		assert.False(t, rd.UsesServerIP())
+	})
+}
@@ -0,0 +1,32 @@
+package filtering
+
+import "strings"
+
+// serviceLabelPrefix is the prefix of the labels of the service names, like
+// "_dmarc" in "_dmarc.example.com" or "_sip" and "_tcp" in
+// "_sip._tcp.example.com".
+const serviceLabelPrefix = "_"
+
+// serviceNameParent returns the domain left after removing the leading service
+// labels from host.  ok is false if host isn't a service name or if matching
+// the service names as their parents isn't configured.
+func (d *DNSFilter) serviceNameParent(host string) (parent string, ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	if !d.ServiceNamesAsParents {
+		return "", false
+	}
+
+	parent = host
+	for strings.HasPrefix(parent, serviceLabelPrefix) {
+		i := strings.IndexByte(parent, '.')
+		if i < 0 {
+			return "", false
+		}
+
+		parent = parent[i+1:]
+	}
+
+	return parent, parent != host && parent != ""
+}
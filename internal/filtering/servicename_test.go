@@ -0,0 +1,202 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_serviceNameParent(t *testing.T) {
+	d := newForTest(t, &Config{ServiceNamesAsParents: true}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		host       string
+		wantParent string
+		wantOK     bool
+	}{{
+		host:       "_dmarc.example.com",
+		wantParent: "example.com",
+		wantOK:     true,
+	}, {
+		host:       "_sip._tcp.example.com",
+		wantParent: "example.com",
+		wantOK:     true,
+	}, {
+		host:       "www._tcp.example.com",
+		wantParent: "",
+		wantOK:     false,
+	}, {
+		host:       "example.com",
+		wantParent: "",
+		wantOK:     false,
+	}, {
+		host:       "_tcp",
+		wantParent: "",
+		wantOK:     false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			parent, ok := d.serviceNameParent(tc.host)
+			assert.Equal(t, tc.wantOK, ok)
+			if ok {
+				assert.Equal(t, tc.wantParent, parent)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		nd := newForTest(t, &Config{}, nil)
+		t.Cleanup(nd.Close)
+
+		_, ok := nd.serviceNameParent("_dmarc.example.com")
+		assert.False(t, ok)
+	})
+}
+
+func TestDNSFilter_CheckHost_serviceNames(t *testing.T) {
+	const data = "||example.com^\n" +
+		"||_sip._tcp.example.info^\n" +
+		"0.0.0.0 example.org\n" +
+		"@@||_dmarc.example.org^\n"
+
+	testCases := []struct {
+		name        string
+		host        string
+		qtype       uint16
+		wantBlocked bool
+		asParents   bool
+	}{{
+		name:        "network_rule_parent",
+		host:        "_dmarc.example.com",
+		qtype:       dns.TypeTXT,
+		wantBlocked: true,
+		asParents:   false,
+	}, {
+		name:        "network_rule_exact_srv",
+		host:        "_sip._tcp.example.info",
+		qtype:       dns.TypeSRV,
+		wantBlocked: true,
+		asParents:   false,
+	}, {
+		name:        "network_rule_other_service",
+		host:        "_xmpp._tcp.example.info",
+		qtype:       dns.TypeSRV,
+		wantBlocked: false,
+		asParents:   false,
+	}, {
+		name:        "host_rule_exact",
+		host:        "_sip._tcp.example.org",
+		qtype:       dns.TypeSRV,
+		wantBlocked: false,
+		asParents:   false,
+	}, {
+		name:        "host_rule_as_parent",
+		host:        "_sip._tcp.example.org",
+		qtype:       dns.TypeSRV,
+		wantBlocked: true,
+		asParents:   true,
+	}, {
+		name:        "host_rule_as_parent_allowed",
+		host:        "_dmarc.example.org",
+		qtype:       dns.TypeTXT,
+		wantBlocked: false,
+		asParents:   true,
+	}, {
+		name:        "host_rule_as_parent_not_service",
+		host:        "www.example.org",
+		qtype:       dns.TypeA,
+		wantBlocked: false,
+		asParents:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newForTest(
+				t,
+				&Config{ServiceNamesAsParents: tc.asParents},
+				[]Filter{{ID: 0, Data: []byte(data)}},
+			)
+			t.Cleanup(d.Close)
+
+			res, err := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantBlocked, res.IsFiltered)
+		})
+	}
+}
+
+func TestDNSFilter_processRewrites_serviceNames(t *testing.T) {
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "_dmarc.example.com",
+			Answer: "dmarc.example.net",
+		}, {
+			Domain: "*._tcp.example.com",
+			Answer: "srv.example.net",
+		}, {
+			Domain: "_sip._udp.example.com",
+			Answer: "1.2.3.4",
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name          string
+		host          string
+		wantCanonName string
+		wantIPs       []net.IP
+		qtype         uint16
+		wantReason    Reason
+	}{{
+		name:          "dmarc_txt",
+		host:          "_dmarc.example.com",
+		wantCanonName: "dmarc.example.net",
+		wantIPs:       nil,
+		qtype:         dns.TypeTXT,
+		wantReason:    Rewritten,
+	}, {
+		name:          "srv_wildcard",
+		host:          "_sip._tcp.example.com",
+		wantCanonName: "srv.example.net",
+		wantIPs:       nil,
+		qtype:         dns.TypeSRV,
+		wantReason:    Rewritten,
+	}, {
+		name:          "srv_a",
+		host:          "_sip._udp.example.com",
+		wantCanonName: "",
+		wantIPs:       []net.IP{{1, 2, 3, 4}},
+		qtype:         dns.TypeA,
+		wantReason:    Rewritten,
+	}, {
+		name:          "srv_other_type",
+		host:          "_sip._udp.example.com",
+		wantCanonName: "",
+		wantIPs:       nil,
+		qtype:         dns.TypeSRV,
+		wantReason:    NotFilteredNotFound,
+	}, {
+		name:          "parent_not_rewritten",
+		host:          "example.com",
+		wantCanonName: "",
+		wantIPs:       nil,
+		qtype:         dns.TypeA,
+		wantReason:    NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := d.processRewrites(tc.host, tc.qtype, &setts)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			assert.Equal(t, tc.wantCanonName, res.CanonName)
+			assert.Equal(t, tc.wantIPs, res.IPList)
+		})
+	}
+}
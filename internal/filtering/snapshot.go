@@ -0,0 +1,170 @@
+package filtering
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// FilterSnapshot is the saved state of a DNSFilter.  It contains the
+// configuration, including the rewrites and the blocked services, and the
+// filter lists which the engines are built from, but not the engines
+// themselves.
+type FilterSnapshot struct {
+	// Config is the copy of the configuration.  The caches and the security
+	// upstreams aren't reconfigured on restoring.
+	Config Config
+
+	// Enabled is true if the filtering is enabled, see DNSFilter.SetEnabled.
+	Enabled bool
+
+	// BlockFilters and AllowFilters are the loaded filter lists.  The lists
+	// loaded from files are read again on restoring.
+	BlockFilters []Filter
+	AllowFilters []Filter
+
+	// Exceptions are the rules added with AddException.
+	Exceptions []string
+}
+
+// cloneFilters returns a deep copy of filters.
+func cloneFilters(filters []Filter) (clone []Filter) {
+	if filters == nil {
+		return nil
+	}
+
+	clone = make([]Filter, len(filters))
+	for i, f := range filters {
+		clone[i] = f
+		if f.Data != nil {
+			clone[i].Data = append([]byte(nil), f.Data...)
+		}
+	}
+
+	return clone
+}
+
+// cloneIP returns a copy of ip.
+func cloneIP(ip net.IP) (clone net.IP) {
+	if ip == nil {
+		return nil
+	}
+
+	return append(net.IP(nil), ip...)
+}
+
+// cloneIPNet returns a copy of n.
+func cloneIPNet(n *net.IPNet) (clone *net.IPNet) {
+	if n == nil {
+		return nil
+	}
+
+	return &net.IPNet{
+		IP:   cloneIP(n.IP),
+		Mask: append(net.IPMask(nil), n.Mask...),
+	}
+}
+
+// deepCloneRewrites returns a copy of entries which doesn't share any mutable
+// data with it.
+func deepCloneRewrites(entries []RewriteEntry) (clone []RewriteEntry) {
+	clone = cloneRewrites(entries)
+	for i := range clone {
+		e := &clone[i]
+		e.IP = cloneIP(e.IP)
+		e.ClientSubnet = cloneIPNet(e.ClientSubnet)
+		e.ECSAnswers = append([]RewriteECSAnswer(nil), e.ECSAnswers...)
+		e.CountryAnswers = append([]RewriteCountryAnswer(nil), e.CountryAnswers...)
+		if e.MX != nil {
+			mx := *e.MX
+			e.MX = &mx
+		}
+	}
+
+	return clone
+}
+
+// cloneConfig returns a copy of c which doesn't share the slices and the maps
+// with it.  The functions and the interfaces, like the caches, are shared.
+func cloneConfig(c *Config) (clone Config) {
+	clone = *c
+	clone.Rewrites = deepCloneRewrites(c.Rewrites)
+	clone.LocalDomains = append([]string(nil), c.LocalDomains...)
+	clone.BlockedServices = append([]string(nil), c.BlockedServices...)
+	clone.ServerNames = append([]string(nil), c.ServerNames...)
+	clone.SecurityCheckTypes = append([]uint16(nil), c.SecurityCheckTypes...)
+	clone.NAT64Prefix = cloneIPNet(c.NAT64Prefix)
+
+	if c.ServerIPs != nil {
+		clone.ServerIPs = make([]net.IP, len(c.ServerIPs))
+		for i, ip := range c.ServerIPs {
+			clone.ServerIPs[i] = cloneIP(ip)
+		}
+	}
+
+	if c.ResponseTTLByReason != nil {
+		clone.ResponseTTLByReason = make(map[Reason]uint32, len(c.ResponseTTLByReason))
+		for r, ttl := range c.ResponseTTLByReason {
+			clone.ResponseTTLByReason[r] = ttl
+		}
+	}
+
+	return clone
+}
+
+// Snapshot returns the current state of d.  The result doesn't share any
+// mutable data with d.
+func (d *DNSFilter) Snapshot() (s *FilterSnapshot) {
+	s = &FilterSnapshot{}
+
+	func() {
+		d.confLock.RLock()
+		defer d.confLock.RUnlock()
+
+		s.Config = cloneConfig(&d.Config)
+	}()
+	s.Enabled = atomic.LoadUint32(&d.enabled) != 0
+
+	func() {
+		d.engineLock.RLock()
+		defer d.engineLock.RUnlock()
+
+		s.BlockFilters = cloneFilters(d.blockFilters)
+		s.AllowFilters = cloneFilters(d.allowFilters)
+	}()
+
+	d.exceptionsLock.Lock()
+	defer d.exceptionsLock.Unlock()
+
+	s.Exceptions = append([]string(nil), d.exceptions...)
+
+	return s
+}
+
+// RestoreSnapshot sets the state of d to the one saved in s and rebuilds the
+// engines.  The configuration is normalized the same way New does it.  s isn't
+// modified and may be restored multiple times.
+func (d *DNSFilter) RestoreSnapshot(s *FilterSnapshot) (err error) {
+	func() {
+		d.confLock.Lock()
+		defer d.confLock.Unlock()
+
+		d.Config = cloneConfig(&s.Config)
+		d.normalizeConfig()
+	}()
+	d.SetEnabled(s.Enabled)
+
+	func() {
+		d.exceptionsLock.Lock()
+		defer d.exceptionsLock.Unlock()
+
+		d.exceptions = append([]string(nil), s.Exceptions...)
+	}()
+
+	err = d.initFiltering(cloneFilters(s.AllowFilters), cloneFilters(s.BlockFilters))
+	if err != nil {
+		return fmt.Errorf("restoring filters: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package filtering
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_Snapshot(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "1.2.3.4",
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	d.confLock.Lock()
+	d.BlockedServices = []string{"facebook"}
+	d.confLock.Unlock()
+	d.SetEnabled(true)
+
+	err := d.SetFilters(
+		[]Filter{{ID: 1, Data: []byte("||blocked.example^\n")}},
+		[]Filter{{ID: 2, Data: []byte("@@||allowed.blocked.example^\n")}},
+		false,
+	)
+	require.NoError(t, err)
+
+	err = d.AddException("||exception.blocked.example^")
+	require.NoError(t, err)
+
+	s := d.Snapshot()
+	require.NotNil(t, s)
+
+	assert.Equal(t, []int64{1}, filterIDs(s.BlockFilters))
+	assert.Equal(t, []int64{2}, filterIDs(s.AllowFilters))
+	assert.Equal(t, []string{"@@||exception.blocked.example^"}, s.Exceptions)
+	assert.Equal(t, []string{"facebook"}, s.Config.BlockedServices)
+	require.Len(t, s.Config.Rewrites, 1)
+
+	checkState := func(t *testing.T) {
+		t.Helper()
+
+		assert.True(t, d.Enabled())
+
+		for host, want := range map[string]Reason{
+			"blocked.example":           FilteredBlockList,
+			"allowed.blocked.example":   NotFilteredAllowList,
+			"exception.blocked.example": NotFilteredAllowList,
+			"rewritten.example":         Rewritten,
+			"other.example":             NotFilteredNotFound,
+		} {
+			res, cerr := d.CheckHost(host, dns.TypeA, &setts)
+			require.NoError(t, cerr)
+
+			assert.Equalf(t, want, res.Reason, "host %q", host)
+		}
+
+		c := &Config{}
+		d.WriteDiskConfig(c)
+		assert.Equal(t, []string{"facebook"}, c.BlockedServices)
+	}
+
+	t.Run("snapshot", checkState)
+
+	// Change everything.
+	err = d.SetFilters([]Filter{{ID: 3, Data: []byte("||other.example^\n")}}, nil, false)
+	require.NoError(t, err)
+
+	d.confLock.Lock()
+	d.Rewrites = nil
+	d.BlockedServices = append(d.BlockedServices[:0], "youtube")
+	d.confLock.Unlock()
+
+	d.SetEnabled(false)
+
+	// Make sure the snapshot doesn't share the data with the filter.
+	assert.Equal(t, []string{"facebook"}, s.Config.BlockedServices)
+
+	res, err := d.CheckHost("other.example", dns.TypeA, &setts)
+	require.NoError(t, err)
+
+	assert.True(t, res.IsFiltered)
+
+	err = d.RestoreSnapshot(s)
+	require.NoError(t, err)
+
+	t.Run("restored", checkState)
+
+	t.Run("restored_again", func(t *testing.T) {
+		err = d.RestoreSnapshot(s)
+		require.NoError(t, err)
+
+		checkState(t)
+	})
+}
+
+// filterIDs returns the IDs of filters.
+func filterIDs(filters []Filter) (ids []int64) {
+	for _, f := range filters {
+		ids = append(ids, f.ID)
+	}
+
+	return ids
+}
+
+func TestDNSFilter_Snapshot_deepCopy(t *testing.T) {
+	d := newForTest(t, &Config{
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "1.2.3.4",
+			Subnet: "10.0.0.0/8",
+			ECSAnswers: []RewriteECSAnswer{{
+				Subnet: "192.0.2.0/24",
+				Answer: "192.0.2.1",
+			}},
+		}},
+		ServerIPs:           []net.IP{{192, 0, 2, 53}},
+		ResponseTTLByReason: map[Reason]uint32{FilteredBlockList: 10},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	s := d.Snapshot()
+	require.Len(t, s.Config.Rewrites, 1)
+
+	func() {
+		d.confLock.Lock()
+		defer d.confLock.Unlock()
+
+		e := &d.Rewrites[0]
+		e.IP[0] = 5
+		e.ClientSubnet.IP[0] = 11
+		e.ECSAnswers[0].Answer = "192.0.2.2"
+		d.ServerIPs[0][0] = 198
+		d.ResponseTTLByReason[FilteredBlockList] = 20
+	}()
+
+	e := s.Config.Rewrites[0]
+	assert.Equal(t, net.IP{1, 2, 3, 4}, e.IP.To4())
+	assert.Equal(t, "10.0.0.0/8", e.ClientSubnet.String())
+	assert.Equal(t, "192.0.2.1", e.ECSAnswers[0].Answer)
+	assert.Equal(t, net.IP{192, 0, 2, 53}, s.Config.ServerIPs[0])
+	assert.Equal(t, uint32(10), s.Config.ResponseTTLByReason[FilteredBlockList])
+}
+
+func TestDNSFilter_RestoreSnapshot_normalize(t *testing.T) {
+	InitModule()
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.RestoreSnapshot(&FilterSnapshot{
+		Config: Config{
+			Rewrites: []RewriteEntry{{
+				Domain: "Rewritten.Example",
+				Answer: "1.2.3.4",
+			}},
+			BlockedServices: []string{"FaceBook", "unknown"},
+			BlockTXT:        strings.Repeat("a", maxBlockTXTLen+1),
+		},
+		Enabled: true,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, d.Enabled())
+
+	c := &Config{}
+	d.WriteDiskConfig(c)
+
+	assert.Equal(t, []string{"facebook"}, c.BlockedServices)
+	assert.Empty(t, c.BlockTXT)
+	require.Len(t, c.Rewrites, 1)
+
+	assert.Equal(t, "rewritten.example", c.Rewrites[0].Domain)
+	assert.Equal(t, dns.TypeA, c.Rewrites[0].Type)
+}
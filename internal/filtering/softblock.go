@@ -0,0 +1,43 @@
+package filtering
+
+import "github.com/AdguardTeam/golibs/log"
+
+// nonEnforcingLists returns the set of the IDs of the filters which are not
+// enforced, see Filter.NonEnforcing.  ids is nil if all of them are.
+func nonEnforcingLists(filters []Filter) (ids map[int64]struct{}) {
+	for _, f := range filters {
+		if !f.NonEnforcing {
+			continue
+		}
+
+		if ids == nil {
+			ids = map[int64]struct{}{}
+		}
+
+		ids[f.ID] = struct{}{}
+	}
+
+	return ids
+}
+
+// softBlock returns res unfiltered, but with the reason and the rules kept, if
+// all the rules blocking the request are from the non-enforcing blocklists,
+// see Filter.NonEnforcing.  d.engineLock is expected to be locked.
+func (d *DNSFilter) softBlock(res Result) (soft Result) {
+	if !res.IsFiltered || res.Reason != FilteredBlockList || len(d.nonEnforcing) == 0 {
+		return res
+	}
+
+	for _, r := range res.Rules {
+		if _, ok := d.nonEnforcing[r.FilterListID]; !ok {
+			return res
+		}
+	}
+
+	log.Debug("filtering: not enforcing the block by list %d", res.Rules[0].FilterListID)
+
+	res.IsFiltered = false
+	res.CanonName = ""
+
+	return res
+}
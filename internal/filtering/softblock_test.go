@@ -0,0 +1,76 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_nonEnforcing(t *testing.T) {
+	const (
+		enforcingID = 1
+		softID      = 2
+	)
+
+	d := newForTest(t, &Config{BlockCNAME: "block.page.example"}, []Filter{{
+		ID:   enforcingID,
+		Data: []byte("||enforced.example^\n||both.example^\n"),
+	}, {
+		ID:           softID,
+		Data:         []byte("||soft.example^\n||both.example^\n0.0.0.0 soft-hosts.example\n"),
+		NonEnforcing: true,
+	}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name         string
+		host         string
+		wantListID   int64
+		wantFiltered bool
+	}{{
+		name:         "enforcing",
+		host:         "enforced.example",
+		wantListID:   enforcingID,
+		wantFiltered: true,
+	}, {
+		name:         "non_enforcing",
+		host:         "soft.example",
+		wantListID:   softID,
+		wantFiltered: false,
+	}, {
+		name:         "non_enforcing_hosts",
+		host:         "soft-hosts.example",
+		wantListID:   softID,
+		wantFiltered: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantFiltered, res.IsFiltered)
+			assert.Equal(t, FilteredBlockList, res.Reason)
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+			if !tc.wantFiltered {
+				assert.Empty(t, res.CanonName)
+			}
+		})
+	}
+
+	t.Run("both", func(t *testing.T) {
+		res, err := d.CheckHost("both.example", dns.TypeA, &setts)
+		require.NoError(t, err)
+		require.Len(t, res.Rules, 1)
+
+		// The request is blocked if the matched rule is from an enforcing
+		// list, and only reported otherwise.
+		assert.Equal(t, res.Rules[0].FilterListID == enforcingID, res.IsFiltered)
+		assert.Equal(t, FilteredBlockList, res.Reason)
+	})
+}
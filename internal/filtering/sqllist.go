@@ -0,0 +1,137 @@
+package filtering
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// sqlListQuery is the query for an exact domain in the database of an
+// sqlRuleList.  The database must have the following table:
+//
+//	CREATE TABLE domains (domain TEXT PRIMARY KEY);
+//
+// The domains are expected to be lowercased and without the trailing dot.
+const sqlListQuery = "SELECT 1 FROM domains WHERE domain = ? LIMIT 1"
+
+// sqlListTimeout is the maximum duration of matching a host against all the
+// database-backed lists.
+const sqlListTimeout = 1 * time.Second
+
+// sqlRuleList is a rule list backed by a database, for example an SQLite one,
+// of the domains blocked by their exact names.  Unlike the other lists, its
+// rules aren't loaded into the engine but are queried from the database on
+// each request, which allows keeping very large and frequently changing lists
+// outside of memory.
+type sqlRuleList struct {
+	db *sql.DB
+	id int
+}
+
+// newSQLRuleList returns a new rule list with id backed by db.
+func newSQLRuleList(id int, db *sql.DB) (l *sqlRuleList) {
+	return &sqlRuleList{
+		db: db,
+		id: id,
+	}
+}
+
+// type check
+var _ filterlist.RuleList = (*sqlRuleList)(nil)
+
+// GetID implements the filterlist.RuleList interface for *sqlRuleList.
+func (l *sqlRuleList) GetID() (id int) {
+	return l.id
+}
+
+// NewScanner implements the filterlist.RuleList interface for *sqlRuleList.
+// The scanner doesn't return any rules, so that the engines don't load the
+// database.
+func (l *sqlRuleList) NewScanner() (sc *filterlist.RuleScanner) {
+	return filterlist.NewRuleScanner(strings.NewReader(""), l.id, true)
+}
+
+// errSQLRetrieve is returned from (*sqlRuleList).RetrieveRule.
+const errSQLRetrieve errors.Error = "retrieving rules from sql lists is not supported"
+
+// RetrieveRule implements the filterlist.RuleList interface for *sqlRuleList.
+// It always returns an error, since the list doesn't provide the rules to the
+// engines.
+func (l *sqlRuleList) RetrieveRule(_ int) (r rules.Rule, err error) {
+	return nil, errSQLRetrieve
+}
+
+// Close implements the filterlist.RuleList interface for *sqlRuleList.  It
+// doesn't close the database, which is owned by the caller.
+func (l *sqlRuleList) Close() (err error) {
+	return nil
+}
+
+// match returns true if host is in the database.
+func (l *sqlRuleList) match(ctx context.Context, host string) (ok bool, err error) {
+	var one int
+	err = l.db.QueryRowContext(ctx, sqlListQuery, host).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("sql list %d: querying %q: %w", l.id, host, err)
+	}
+
+	return true, nil
+}
+
+// sqlRuleLists returns the sqlRuleLists from rs.
+func sqlRuleLists(rs *filterlist.RuleStorage) (lists []*sqlRuleList) {
+	for _, l := range rs.Lists {
+		if sl, ok := l.(*sqlRuleList); ok {
+			lists = append(lists, sl)
+		}
+	}
+
+	return lists
+}
+
+// matchSQLLists matches host against the database-backed blocklists.  ok is
+// false if none of them contains host.  The queries are bounded by ctx and
+// sqlListTimeout, and d.engineLock should not be locked, since those may be
+// slow.  The lists fail open, that is the errors of the queries are logged and
+// the failed lists are considered not containing host.
+func matchSQLLists(
+	ctx context.Context,
+	lists []*sqlRuleList,
+	host string,
+) (res Result, ok bool) {
+	ctx, cancel := context.WithTimeout(ctx, sqlListTimeout)
+	defer cancel()
+
+	for _, l := range lists {
+		matched, err := l.match(ctx, host)
+		if err != nil {
+			log.Error("filtering: %s", err)
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			continue
+		} else if matched {
+			return Result{
+				IsFiltered: true,
+				Reason:     FilteredBlockList,
+				Rules: []*ResultRule{{
+					Text:         "|" + host + "^",
+					FilterListID: int64(l.id),
+				}},
+			}, true
+		}
+	}
+
+	return Result{}, false
+}
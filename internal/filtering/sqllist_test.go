@@ -0,0 +1,282 @@
+//go:build cgo
+// +build cgo
+
+package filtering
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSQLDB returns an SQLite database containing domains.
+func newTestSQLDB(t *testing.T, domains ...string) (db *sql.DB) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "domains.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+	_, err = db.Exec("CREATE TABLE domains (domain TEXT PRIMARY KEY)")
+	require.NoError(t, err)
+
+	for _, d := range domains {
+		_, err = db.Exec("INSERT INTO domains (domain) VALUES (?)", d)
+		require.NoError(t, err)
+	}
+
+	return db
+}
+
+func TestDNSFilter_CheckHost_sqlList(t *testing.T) {
+	db := newTestSQLDB(t, "blocked.example", "other.example")
+
+	d := newForTest(t, nil, []Filter{{
+		ID: 1,
+		DB: db,
+	}, {
+		ID:   2,
+		Data: []byte("||rule.example^\n@@||other.example^\n"),
+	}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantRule   string
+		wantListID int64
+		wantReason Reason
+	}{{
+		name:       "blocked",
+		host:       "blocked.example",
+		wantRule:   "|blocked.example^",
+		wantListID: 1,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "subdomain",
+		host:       "sub.blocked.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "not_in_db",
+		host:       "none.example",
+		wantReason: NotFilteredNotFound,
+	}, {
+		name:       "rule",
+		host:       "rule.example",
+		wantRule:   "||rule.example^",
+		wantListID: 2,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "rule_exception",
+		host:       "other.example",
+		wantRule:   "@@||other.example^",
+		wantListID: 2,
+		wantReason: NotFilteredAllowList,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantRule == "" {
+				assert.Empty(t, res.Rules)
+
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantRule, res.Rules[0].Text)
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+		})
+	}
+}
+
+func TestDNSFilter_CheckHost_sqlListError(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "broken.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+	// The database has no domains table, so each query fails.
+	d := newForTest(t, nil, []Filter{{
+		ID: 1,
+		DB: db,
+	}, {
+		ID: 2,
+		DB: newTestSQLDB(t, "blocked.example"),
+	}})
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantListID int64
+		wantReason Reason
+	}{{
+		name:       "blocked",
+		host:       "blocked.example",
+		wantListID: 2,
+		wantReason: FilteredBlockList,
+	}, {
+		name:       "not_blocked",
+		host:       "none.example",
+		wantReason: NotFilteredNotFound,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cErr := d.CheckHost(tc.host, dns.TypeA, &setts)
+			require.NoError(t, cErr)
+
+			assert.Equal(t, tc.wantReason, res.Reason)
+			if tc.wantListID == 0 {
+				return
+			}
+
+			require.Len(t, res.Rules, 1)
+
+			assert.Equal(t, tc.wantListID, res.Rules[0].FilterListID)
+		})
+	}
+}
+
+// testSQLWaitDriver is the name of the SQLite driver with the function
+// test_wait, which blocks until testSQLRelease is closed.
+const testSQLWaitDriver = "sqlite3_filtering_test"
+
+var (
+	testSQLRelease  chan struct{}
+	testSQLWaitOnce = &sync.Once{}
+)
+
+// newTestSQLWaitDB returns an SQLite database containing blocked.example, the
+// queries of which wait until release is called.
+func newTestSQLWaitDB(t *testing.T) (db *sql.DB, release func()) {
+	t.Helper()
+
+	testSQLWaitOnce.Do(func() {
+		sql.Register(testSQLWaitDriver, &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) (err error) {
+				return conn.RegisterFunc("test_wait", func() (n int) {
+					<-testSQLRelease
+
+					return 1
+				}, false)
+			},
+		})
+	})
+
+	testSQLRelease = make(chan struct{})
+	released := false
+	release = func() {
+		if !released {
+			close(testSQLRelease)
+			released = true
+		}
+	}
+	t.Cleanup(release)
+
+	db, err := sql.Open(testSQLWaitDriver, filepath.Join(t.TempDir(), "domains.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, db.Close()) })
+
+	// Make the queries of domains wait until released.
+	for _, q := range []string{
+		"CREATE TABLE stored (domain TEXT PRIMARY KEY)",
+		"INSERT INTO stored (domain) VALUES ('blocked.example')",
+		"CREATE VIEW domains AS SELECT domain FROM stored WHERE test_wait() = 1",
+	} {
+		_, err = db.Exec(q)
+		require.NoError(t, err)
+	}
+
+	return db, release
+}
+
+func TestDNSFilter_CheckHost_sqlListSlow(t *testing.T) {
+	db, release := newTestSQLWaitDB(t)
+
+	d := newForTest(t, nil, []Filter{{ID: 1, DB: db}})
+	t.Cleanup(d.Close)
+
+	type result struct {
+		err error
+		res Result
+	}
+
+	resCh := make(chan result, 1)
+	go func() {
+		res, cErr := d.CheckHost("blocked.example", dns.TypeA, &setts)
+		resCh <- result{err: cErr, res: res}
+	}()
+
+	// The engines can be rebuilt while the query waits.
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	err := d.SetFilters([]Filter{{ID: 1, DB: db}}, nil, false)
+	require.NoError(t, err)
+
+	assert.Less(t, time.Since(start), sqlListTimeout/2)
+
+	// The query is interrupted once it takes too long, and the list fails
+	// open.
+	time.Sleep(sqlListTimeout)
+	release()
+
+	select {
+	case r := <-resCh:
+		require.NoError(t, r.err)
+
+		assert.False(t, r.res.IsFiltered)
+	case <-time.After(sqlListTimeout):
+		t.Fatal("query isn't finished")
+	}
+}
+
+func TestDNSFilter_CheckHostCtx_sqlList(t *testing.T) {
+	db, release := newTestSQLWaitDB(t)
+
+	d := newForTest(t, nil, []Filter{{ID: 1, DB: db}})
+	t.Cleanup(d.Close)
+
+	const timeout = sqlListTimeout / 10
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+
+	type result struct {
+		err     error
+		res     Result
+		partial bool
+	}
+
+	resCh := make(chan result, 1)
+	go func() {
+		res, partial, cErr := d.CheckHostCtx(ctx, "blocked.example", dns.TypeA, &setts)
+		resCh <- result{err: cErr, res: res, partial: partial}
+	}()
+
+	// Release the query after the caller's ctx is done but long before
+	// sqlListTimeout, so that it's only interrupted if ctx is used.
+	time.Sleep(2 * timeout)
+	release()
+
+	select {
+	case r := <-resCh:
+		assert.ErrorIs(t, r.err, context.DeadlineExceeded)
+		assert.True(t, r.partial)
+		assert.False(t, r.res.IsFiltered)
+	case <-time.After(sqlListTimeout):
+		t.Fatal("query isn't finished")
+	}
+}
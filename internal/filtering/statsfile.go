@@ -0,0 +1,165 @@
+package filtering
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// savedStats are the counters of a DNSFilter saved with SaveStats.
+type savedStats struct {
+	// Clients are the per-client counters, see ClientBlockRate, with the
+	// most recently active clients first.
+	Clients []savedClientStats `json:"clients"`
+
+	// Stats are the lookup counters, see CollectStats.  It's nil in the files
+	// saved before those were persisted.
+	Stats *savedLookupStats `json:"stats,omitempty"`
+}
+
+// savedLookupStats are the saved lookup counters of each service.  The pending
+// requests aren't saved since those don't survive a restart.
+type savedLookupStats struct {
+	Safebrowsing savedLookupCounters `json:"safebrowsing"`
+	Parental     savedLookupCounters `json:"parental"`
+	Safesearch   savedLookupCounters `json:"safesearch"`
+}
+
+// savedLookupCounters are the saved counters of a single service.
+type savedLookupCounters struct {
+	Requests  uint64 `json:"requests"`
+	CacheHits uint64 `json:"cache_hits"`
+}
+
+// savedClientStats are the saved counters of a single client.
+type savedClientStats struct {
+	WindowStart time.Time `json:"window_start"`
+	ID          string    `json:"id"`
+	Checked     uint64    `json:"checked"`
+	Blocked     uint64    `json:"blocked"`
+}
+
+// SaveStats writes the current counters of d, the per-client ones reported by
+// ClientBlockRate and the lookup ones reported by CollectStats, to w as JSON,
+// so that those could be restored with LoadStats after a restart.
+func (d *DNSFilter) SaveStats(w io.Writer) (err error) {
+	saved := savedStats{
+		Clients: d.clientStats.save(),
+		Stats: &savedLookupStats{
+			Safebrowsing: d.stats.Safebrowsing.save(),
+			Parental:     d.stats.Parental.save(),
+			Safesearch:   d.stats.Safesearch.save(),
+		},
+	}
+
+	err = json.NewEncoder(w).Encode(saved)
+	if err != nil {
+		return fmt.Errorf("encoding stats: %w", err)
+	}
+
+	return nil
+}
+
+// LoadStats restores the counters saved with SaveStats from r.  The restored
+// counters replace the current ones of the same clients and continue from the
+// saved values within their windows.  The least recently active clients are
+// dropped if there are more of them than Config.ClientStatsSize.  No client
+// counters are restored if the counting is disabled.  The lookup counters
+// replace the current ones and keep counting from the saved values.
+func (d *DNSFilter) LoadStats(r io.Reader) (err error) {
+	saved := savedStats{}
+	err = json.NewDecoder(r).Decode(&saved)
+	if err != nil {
+		return fmt.Errorf("decoding stats: %w", err)
+	}
+
+	d.clientStats.load(saved.Clients)
+
+	if ls := saved.Stats; ls != nil {
+		d.stats.Safebrowsing.load(ls.Safebrowsing)
+		d.stats.Parental.load(ls.Parental)
+		d.stats.Safesearch.load(ls.Safesearch)
+	}
+
+	return nil
+}
+
+// save returns the persistent counters of s.
+func (s *LookupStats) save() (saved savedLookupCounters) {
+	return savedLookupCounters{
+		Requests:  atomic.LoadUint64(&s.Requests),
+		CacheHits: atomic.LoadUint64(&s.CacheHits),
+	}
+}
+
+// load restores the counters saved with save.  Each counter is stored
+// atomically, so the concurrent lookups keep counting from the saved values.
+func (s *LookupStats) load(saved savedLookupCounters) {
+	atomic.StoreUint64(&s.Requests, saved.Requests)
+	atomic.StoreUint64(&s.CacheHits, saved.CacheHits)
+}
+
+// save returns the counters of all clients with the most recently active ones
+// first.  s may be nil.
+func (s *clientStats) save() (saved []savedClientStats) {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved = make([]savedClientStats, 0, s.recent.Len())
+	for elem := s.recent.Front(); elem != nil; elem = elem.Next() {
+		e := elem.Value.(*clientStatsEntry)
+		saved = append(saved, savedClientStats{
+			WindowStart: e.start,
+			ID:          e.id,
+			Checked:     e.checked,
+			Blocked:     e.blocked,
+		})
+	}
+
+	return saved
+}
+
+// load restores the counters saved with save.  s may be nil.
+func (s *clientStats) load(saved []savedClientStats) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Add the least recently active clients first so that the most recently
+	// active ones end up in front.
+	for i := len(saved) - 1; i >= 0; i-- {
+		sc := saved[i]
+		if sc.ID == "" {
+			continue
+		}
+
+		e := &clientStatsEntry{
+			start:   sc.WindowStart,
+			id:      sc.ID,
+			checked: sc.Checked,
+			blocked: sc.Blocked,
+		}
+
+		if elem, ok := s.entries[sc.ID]; ok {
+			elem.Value = e
+			s.recent.MoveToFront(elem)
+
+			continue
+		}
+
+		s.entries[sc.ID] = s.recent.PushFront(e)
+		for s.recent.Len() > s.size {
+			old := s.recent.Remove(s.recent.Back()).(*clientStatsEntry)
+			delete(s.entries, old.id)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package filtering
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_SaveStats(t *testing.T) {
+	filters := []Filter{{ID: 1, Data: []byte("||blocked.example^\n")}}
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newFilter := func(t *testing.T, size uint) (d *DNSFilter) {
+		t.Helper()
+
+		d = newForTest(t, &Config{ClientStatsSize: size}, filters)
+		t.Cleanup(d.Close)
+
+		d.clientStats.now = func() (t time.Time) { return now }
+
+		return d
+	}
+
+	check := func(t *testing.T, d *DNSFilter, host, client string) {
+		t.Helper()
+
+		s := setts
+		s.ClientName = client
+		_, err := d.CheckHost(host, dns.TypeA, &s)
+		require.NoError(t, err)
+	}
+
+	d := newFilter(t, 10)
+	check(t, d, "blocked.example", "phone")
+	check(t, d, "allowed.example", "phone")
+	check(t, d, "allowed.example", "laptop")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, d.SaveStats(buf))
+
+	t.Run("restart", func(t *testing.T) {
+		restarted := newFilter(t, 10)
+		require.NoError(t, restarted.LoadStats(bytes.NewReader(buf.Bytes())))
+
+		checked, blocked := restarted.ClientBlockRate("phone")
+		assert.Equal(t, uint64(2), checked)
+		assert.Equal(t, uint64(1), blocked)
+
+		check(t, restarted, "blocked.example", "phone")
+		check(t, restarted, "allowed.example", "laptop")
+
+		checked, blocked = restarted.ClientBlockRate("phone")
+		assert.Equal(t, uint64(3), checked)
+		assert.Equal(t, uint64(2), blocked)
+
+		checked, blocked = restarted.ClientBlockRate("laptop")
+		assert.Equal(t, uint64(2), checked)
+		assert.Equal(t, uint64(0), blocked)
+	})
+
+	t.Run("smaller", func(t *testing.T) {
+		small := newFilter(t, 1)
+		require.NoError(t, small.LoadStats(bytes.NewReader(buf.Bytes())))
+
+		// "laptop" was the most recently active client.
+		checked, _ := small.ClientBlockRate("laptop")
+		assert.Equal(t, uint64(1), checked)
+
+		checked, _ = small.ClientBlockRate("phone")
+		assert.Zero(t, checked)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled := newForTest(t, nil, nil)
+		t.Cleanup(disabled.Close)
+
+		require.NoError(t, disabled.LoadStats(bytes.NewReader(buf.Bytes())))
+
+		out := &bytes.Buffer{}
+		require.NoError(t, disabled.SaveStats(out))
+
+		assert.JSONEq(t, `{
+			"clients": null,
+			"stats": {
+				"safebrowsing": {"requests": 0, "cache_hits": 0},
+				"parental": {"requests": 0, "cache_hits": 0},
+				"safesearch": {"requests": 0, "cache_hits": 0}
+			}
+		}`, out.String())
+	})
+
+	t.Run("lookups", func(t *testing.T) {
+		src := newFilter(t, 10)
+		src.stats.Safebrowsing.startRequest()()
+		src.stats.Safebrowsing.cacheHit()
+		src.stats.Parental.cacheHit()
+		src.stats.Safesearch.startRequest()()
+
+		lbuf := &bytes.Buffer{}
+		require.NoError(t, src.SaveStats(lbuf))
+
+		restarted := newFilter(t, 10)
+		require.NoError(t, restarted.LoadStats(bytes.NewReader(lbuf.Bytes())))
+
+		restarted.stats.Safebrowsing.startRequest()()
+		restarted.stats.Parental.cacheHit()
+
+		got := restarted.CollectStats()
+		assert.Equal(t, uint64(2), got.Safebrowsing.Requests)
+		assert.Equal(t, uint64(1), got.Safebrowsing.CacheHits)
+		assert.Equal(t, uint64(0), got.Parental.Requests)
+		assert.Equal(t, uint64(2), got.Parental.CacheHits)
+		assert.Equal(t, uint64(1), got.Safesearch.Requests)
+		assert.Equal(t, uint64(0), got.Safesearch.CacheHits)
+	})
+
+	t.Run("no_lookups", func(t *testing.T) {
+		restarted := newFilter(t, 10)
+		restarted.stats.Parental.cacheHit()
+
+		require.NoError(t, restarted.LoadStats(strings.NewReader(`{"clients":[]}`)))
+
+		assert.Equal(t, uint64(1), restarted.CollectStats().Parental.CacheHits)
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		err := d.LoadStats(strings.NewReader("{"))
+
+		assert.Error(t, err)
+	})
+}
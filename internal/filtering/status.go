@@ -0,0 +1,95 @@
+package filtering
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/urlfilter"
+)
+
+// reloadInfo is the information about an initialization of the engines.
+type reloadInfo struct {
+	// time is the moment the initialization started.
+	time time.Time
+	// err is the error returned by the initialization, if any.
+	err error
+	// dur is the duration of the initialization.
+	dur time.Duration
+}
+
+// EngineStatus is the state of the filtering engines for diagnostics.
+type EngineStatus struct {
+	// LastReload is the moment the last initialization of the engines
+	// started.  It's zero if the engines were never initialized.
+	LastReload time.Time
+
+	// LastError is the error of the last initialization.  It's not nil if
+	// some of the lists were skipped, even though the engines are
+	// initialized.
+	LastError error
+
+	// LastReloadDuration is the duration of the last initialization.
+	LastReloadDuration time.Duration
+
+	// BlockRulesCount and AllowRulesCount are the numbers of the rules in
+	// the blocklists and the allowlists engines.
+	BlockRulesCount int
+	AllowRulesCount int
+
+	// BlockInitialized and AllowInitialized are true if the corresponding
+	// engines are initialized.
+	BlockInitialized bool
+	AllowInitialized bool
+}
+
+// EngineStatus returns the current state of the filtering engines.
+func (d *DNSFilter) EngineStatus() (s EngineStatus) {
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	s = EngineStatus{
+		LastReload:         d.lastReload.time,
+		LastError:          d.lastReload.err,
+		LastReloadDuration: d.lastReload.dur,
+	}
+
+	s.BlockInitialized, s.BlockRulesCount = engineRulesCount(d.filteringEngine)
+	s.AllowInitialized, s.AllowRulesCount = engineRulesCount(d.filteringEngineAllow)
+
+	return s
+}
+
+// engineRulesCount returns true and the number of rules in e if it's not nil.
+func engineRulesCount(e *urlfilter.DNSEngine) (ok bool, n int) {
+	if e == nil {
+		return false, 0
+	}
+
+	return true, e.RulesCount
+}
+
+// recordReload saves the information about the initialization of the engines
+// which started at start and returned err.  ev describes the initialization
+// for the listeners, see OnReload.
+func (d *DNSFilter) recordReload(start time.Time, err error) (ev ReloadEvent) {
+	dur := d.now().Sub(start)
+
+	d.engineLock.Lock()
+	defer d.engineLock.Unlock()
+
+	d.lastReload = reloadInfo{
+		time: start,
+		err:  err,
+		dur:  dur,
+	}
+
+	_, blockCount := engineRulesCount(d.filteringEngine)
+	_, allowCount := engineRulesCount(d.filteringEngineAllow)
+
+	return ReloadEvent{
+		Time:            start,
+		Err:             err,
+		Duration:        dur,
+		BlockRulesCount: blockCount,
+		AllowRulesCount: allowCount,
+	}
+}
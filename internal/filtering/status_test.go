@@ -0,0 +1,71 @@
+package filtering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_EngineStatus(t *testing.T) {
+	t.Run("never_loaded", func(t *testing.T) {
+		d := newForTest(t, nil, nil)
+		t.Cleanup(d.Close)
+
+		assert.Equal(t, EngineStatus{}, d.EngineStatus())
+	})
+
+	t.Run("loaded", func(t *testing.T) {
+		d := newForTest(t, nil, nil)
+		t.Cleanup(d.Close)
+
+		start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		now := start
+		d.now = func() (t time.Time) {
+			t = now
+			now = now.Add(time.Second)
+
+			return t
+		}
+
+		err := d.SetFilters([]Filter{{
+			ID:   1,
+			Data: []byte("||blocked.example^\n0.0.0.0 a.example b.example\n"),
+		}}, []Filter{{
+			ID:   2,
+			Data: []byte("@@||allowed.example^\n"),
+		}}, false)
+		require.NoError(t, err)
+
+		assert.Equal(t, EngineStatus{
+			LastReload:         start,
+			LastError:          nil,
+			LastReloadDuration: time.Second,
+			BlockRulesCount:    2,
+			AllowRulesCount:    1,
+			BlockInitialized:   true,
+			AllowInitialized:   true,
+		}, d.EngineStatus())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		d := newForTest(t, nil, nil)
+		t.Cleanup(d.Close)
+
+		err := d.SetFilters([]Filter{{
+			ID:   1,
+			Data: []byte("||blocked.example^\n"),
+		}, {
+			ID:   1,
+			Data: []byte("||duplicate.example^\n"),
+		}}, nil, false)
+		require.Error(t, err)
+
+		s := d.EngineStatus()
+		assert.True(t, s.BlockInitialized)
+		assert.Equal(t, 1, s.BlockRulesCount)
+		assert.Equal(t, err, s.LastError)
+		assert.False(t, s.LastReload.IsZero())
+	})
+}
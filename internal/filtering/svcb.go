@@ -1,4 +1,4 @@
-package dnsforward
+package filtering
 
 import (
 	"encoding/base64"
@@ -10,13 +10,13 @@ import (
 	"github.com/miekg/dns"
 )
 
-// genAnswerHTTPS returns a properly initialized HTTPS resource record.
+// newAnswerHTTPS returns a properly initialized HTTPS resource record.
 //
-// See the comment on genAnswerSVCB for a list of current restrictions on
+// See the comment on newAnswerSVCB for a list of current restrictions on
 // parameter values.
-func (s *Server) genAnswerHTTPS(req *dns.Msg, svcb *rules.DNSSVCB) (ans *dns.HTTPS) {
+func newAnswerHTTPS(hdr dns.RR_Header, svcb *rules.DNSSVCB) (ans *dns.HTTPS) {
 	ans = &dns.HTTPS{
-		SVCB: *s.genAnswerSVCB(req, svcb),
+		SVCB: *newAnswerSVCB(hdr, svcb),
 	}
 
 	ans.Hdr.Rrtype = dns.TypeHTTPS
@@ -121,7 +121,7 @@ var svcbKeyHandlers = map[string]svcbKeyHandler{
 	},
 }
 
-// genAnswerSVCB returns a properly initialized SVCB resource record.
+// newAnswerSVCB returns a properly initialized SVCB resource record.
 //
 // Currently, there are several restrictions on how the parameters are parsed.
 // Firstly, the parsing of non-contiguous values isn't supported.  Secondly, the
@@ -133,9 +133,10 @@ var svcbKeyHandlers = map[string]svcbKeyHandler{
 //   ipv4hint="127.0.0.1,127.0.0.2" // Unsupported.
 //
 // TODO(a.garipov): Support all of these.
-func (s *Server) genAnswerSVCB(req *dns.Msg, svcb *rules.DNSSVCB) (ans *dns.SVCB) {
+func newAnswerSVCB(hdr dns.RR_Header, svcb *rules.DNSSVCB) (ans *dns.SVCB) {
+	hdr.Rrtype = dns.TypeSVCB
 	ans = &dns.SVCB{
-		Hdr:      s.hdr(req, dns.TypeSVCB),
+		Hdr:      hdr,
 		Priority: svcb.Priority,
 		Target:   dns.Fqdn(svcb.Target),
 	}
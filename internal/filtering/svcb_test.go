@@ -1,4 +1,4 @@
-package dnsforward
+package filtering
 
 import (
 	"net"
@@ -9,21 +9,14 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGenAnswerHTTPS_andSVCB(t *testing.T) {
+func TestNewAnswerHTTPS_andSVCB(t *testing.T) {
 	// Preconditions.
 
-	s := &Server{
-		conf: ServerConfig{
-			FilteringConfig: FilteringConfig{
-				BlockedResponseTTL: 3600,
-			},
-		},
-	}
-
-	req := &dns.Msg{
-		Question: []dns.Question{{
-			Name: "abcd",
-		}},
+	hdr := dns.RR_Header{
+		Name:   "abcd",
+		Rrtype: dns.TypeSVCB,
+		Ttl:    3600,
+		Class:  dns.ClassINET,
 	}
 
 	// Constants and helper values.
@@ -55,7 +48,7 @@ func TestGenAnswerHTTPS_andSVCB(t *testing.T) {
 
 	wantsvcb := func(kv dns.SVCBKeyValue) (want *dns.SVCB) {
 		want = &dns.SVCB{
-			Hdr:      s.hdr(req, dns.TypeSVCB),
+			Hdr:      hdr,
 			Priority: prio,
 			Target:   dns.Fqdn(host),
 		}
@@ -139,14 +132,14 @@ func TestGenAnswerHTTPS_andSVCB(t *testing.T) {
 				want := &dns.HTTPS{SVCB: *tc.want}
 				want.Hdr.Rrtype = dns.TypeHTTPS
 
-				got := s.genAnswerHTTPS(req, tc.svcb)
+				got := newAnswerHTTPS(hdr, tc.svcb)
 				assert.Equal(t, want, got)
 			})
 		})
 
 		t.Run("svcb", func(t *testing.T) {
 			t.Run(tc.name, func(t *testing.T) {
-				got := s.genAnswerSVCB(req, tc.svcb)
+				got := newAnswerSVCB(hdr, tc.svcb)
 				assert.Equal(t, tc.want, got)
 			})
 		})
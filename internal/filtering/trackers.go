@@ -0,0 +1,28 @@
+package filtering
+
+import (
+	// Embed the built-in trackers list.
+	_ "embed"
+)
+
+// trackersList is the built-in list of the known tracking domains and
+// disposable email providers.
+//
+//go:embed trackers.txt
+var trackersList []byte
+
+// trackersFilter returns the built-in trackers filter.  ok is false if it's
+// disabled in the configuration.
+func (d *DNSFilter) trackersFilter() (f Filter, ok bool) {
+	d.confLock.RLock()
+	defer d.confLock.RUnlock()
+
+	if !d.BlockTrackers {
+		return Filter{}, false
+	}
+
+	return Filter{
+		ID:   TrackersListID,
+		Data: trackersList,
+	}, true
+}
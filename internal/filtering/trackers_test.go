@@ -0,0 +1,55 @@
+package filtering
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHost_trackers(t *testing.T) {
+	const host = "www.mailinator.com"
+
+	t.Run("enabled", func(t *testing.T) {
+		d := newForTest(t, &Config{BlockTrackers: true}, nil)
+		t.Cleanup(d.Close)
+
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.True(t, res.IsFiltered)
+		assert.Equal(t, FilteredBlockList, res.Reason)
+
+		require.Len(t, res.Rules, 1)
+
+		assert.Equal(t, int64(TrackersListID), res.Rules[0].FilterListID)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		d := newForTest(t, &Config{BlockTrackers: false}, nil)
+		t.Cleanup(d.Close)
+
+		res, err := d.CheckHost(host, dns.TypeA, &setts)
+		require.NoError(t, err)
+
+		assert.False(t, res.IsFiltered)
+	})
+
+	t.Run("with_filters", func(t *testing.T) {
+		filters := []Filter{{ID: 1, Data: []byte("||example.org^\n")}}
+		d := newForTest(t, &Config{BlockTrackers: true}, filters)
+		t.Cleanup(d.Close)
+
+		for _, h := range []string{host, "example.org"} {
+			res, err := d.CheckHost(h, dns.TypeA, &setts)
+			require.NoError(t, err)
+
+			assert.Truef(t, res.IsFiltered, "host %q", h)
+		}
+
+		// The built-in list must not be saved along with the loaded ones.
+		s := d.Snapshot()
+		assert.Len(t, s.BlockFilters, 1)
+	})
+}
@@ -0,0 +1,125 @@
+package filtering
+
+import (
+	"fmt"
+
+	"github.com/AdguardTeam/golibs/errors"
+)
+
+// maxCacheSize is the maximum reasonable size of the in-memory caches, in
+// bytes.
+const maxCacheSize = 1 << 30
+
+// ConfigProblem is a single problem with the configuration found by
+// Config.Validate.
+type ConfigProblem struct {
+	// Err describes the problem.
+	Err error
+	// Field is the YAML name of the problematic field, possibly with the
+	// index of the element.
+	Field string
+}
+
+// type check
+var _ error = ConfigProblem{}
+
+// Error implements the error interface for ConfigProblem.
+func (p ConfigProblem) Error() (msg string) {
+	return fmt.Sprintf("%s: %s", p.Field, p.Err)
+}
+
+// Unwrap returns the underlying error of the problem.
+func (p ConfigProblem) Unwrap() (err error) {
+	return p.Err
+}
+
+// Validate returns all the problems found in c:
+//
+//   - the invalid rewrite entries, including the ones with the malformed
+//     upstream URLs and the ones with the upstreams pointing at the server
+//     itself, see ValidateRewrites;
+//
+//   - the unknown blocked services, so InitModule must be called before;
+//
+//   - the zero sizes of the caches of the enabled services and the sizes of
+//     the caches over maxCacheSize;
+//
+//   - the unknown fail mode of the security services.
+//
+// problems is nil if c is valid.
+func (c *Config) Validate() (problems []ConfigProblem) {
+	add := func(field string, err error) {
+		problems = append(problems, ConfigProblem{Err: err, Field: field})
+	}
+
+	for i := range c.Rewrites {
+		e := &c.Rewrites[i]
+		err := e.validate()
+		if err == nil {
+			err = e.validateNotSelf(c.selfNames(), c.selfIPs())
+		}
+
+		if err != nil {
+			add(fmt.Sprintf("rewrites[%d]", i), err)
+		}
+	}
+
+	for i, s := range c.BlockedServices {
+		if !BlockedSvcKnown(s) {
+			add(fmt.Sprintf("blocked_services[%d]", i), fmt.Errorf("unknown service %q", s))
+		}
+	}
+
+	caches := []struct {
+		field   string
+		size    uint
+		enabled bool
+	}{{
+		field:   "safebrowsing_cache_size",
+		size:    c.SafeBrowsingCacheSize,
+		enabled: c.SafeBrowsingEnabled && c.SafeBrowsingCache == nil,
+	}, {
+		field:   "parental_cache_size",
+		size:    c.ParentalCacheSize,
+		enabled: c.ParentalEnabled && c.ParentalCache == nil,
+	}, {
+		field:   "safesearch_cache_size",
+		size:    c.SafeSearchCacheSize,
+		enabled: c.SafeSearchEnabled,
+	}}
+
+	for _, cache := range caches {
+		if cache.size == 0 && cache.enabled {
+			add(cache.field, errors.Error("zero cache size for enabled service"))
+		} else if cache.size > maxCacheSize {
+			add(cache.field, fmt.Errorf("cache size %d is over %d", cache.size, maxCacheSize))
+		}
+	}
+
+	switch c.SecurityFailMode {
+	case "", SecurityFailOpen, SecurityFailClosed:
+		// Go on.
+	default:
+		add("security_fail_mode", fmt.Errorf("unknown mode %q", c.SecurityFailMode))
+	}
+
+	switch c.RewritePrecedence {
+	case "", RewritePrecedenceRewrite, RewritePrecedenceBlock:
+		// Go on.
+	default:
+		add("rewrite_precedence", fmt.Errorf("unknown precedence %q", c.RewritePrecedence))
+	}
+
+	var err error
+	if c.NAT64Prefix == nil && c.NAT64PrefixCIDR != "" {
+		_, err = parseNAT64Prefix(c.NAT64PrefixCIDR)
+	} else if c.NAT64Prefix != nil {
+		err = validateNAT64Prefix(c.NAT64Prefix)
+	}
+
+	if err != nil {
+		add("nat64_prefix", err)
+	}
+
+	return problems
+}
@@ -0,0 +1,148 @@
+package filtering
+
+import (
+	"net"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	InitModule()
+
+	// validConf returns a new valid configuration.
+	validConf := func() (c *Config) {
+		return &Config{
+			SafeBrowsingEnabled:   true,
+			ParentalEnabled:       true,
+			SafeSearchEnabled:     true,
+			SafeBrowsingCacheSize: 1024 * 1024,
+			ParentalCacheSize:     1024 * 1024,
+			SafeSearchCacheSize:   1024 * 1024,
+			Rewrites: []RewriteEntry{{
+				Domain: "example.com",
+				Answer: "1.2.3.4",
+			}},
+			BlockedServices: []string{"youtube"},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		modify     func(c *Config)
+		wantFields []string
+	}{{
+		name:       "valid",
+		modify:     func(_ *Config) {},
+		wantFields: nil,
+	}, {
+		name: "rewrites",
+		modify: func(c *Config) {
+			c.Rewrites = append(c.Rewrites, RewriteEntry{
+				Domain: "",
+				Answer: "1.2.3.4",
+			}, RewriteEntry{
+				Domain: "example.org",
+				Answer: "",
+			})
+		},
+		wantFields: []string{"rewrites[1]", "rewrites[2]"},
+	}, {
+		name: "upstreams",
+		modify: func(c *Config) {
+			c.ServerIPs = []net.IP{{192, 168, 0, 1}}
+			c.Rewrites = append(c.Rewrites, RewriteEntry{
+				Domain:   "a.example",
+				Answer:   "b.example",
+				Upstream: "bad://dns.example",
+			}, RewriteEntry{
+				Domain:   "c.example",
+				Answer:   "b.example",
+				Upstream: "https://[::1",
+			}, RewriteEntry{
+				Domain:   "d.example",
+				Answer:   "b.example",
+				Upstream: "tls://192.168.0.1",
+			}, RewriteEntry{
+				Domain:   "e.example",
+				Answer:   "b.example",
+				Upstream: "tls://dns.example",
+			})
+		},
+		wantFields: []string{"rewrites[1]", "rewrites[2]", "rewrites[3]"},
+	}, {
+		name: "blocked_services",
+		modify: func(c *Config) {
+			c.BlockedServices = append(c.BlockedServices, "unknown_service")
+		},
+		wantFields: []string{"blocked_services[1]"},
+	}, {
+		name: "zero_cache_size",
+		modify: func(c *Config) {
+			c.SafeBrowsingCacheSize = 0
+		},
+		wantFields: []string{"safebrowsing_cache_size"},
+	}, {
+		name: "zero_cache_size_disabled",
+		modify: func(c *Config) {
+			c.ParentalEnabled = false
+			c.ParentalCacheSize = 0
+		},
+		wantFields: nil,
+	}, {
+		name: "zero_cache_size_custom_cache",
+		modify: func(c *Config) {
+			c.ParentalCache = &lruVerdictCache{Cache: cache.New(cache.Config{MaxSize: 1024})}
+			c.ParentalCacheSize = 0
+		},
+		wantFields: nil,
+	}, {
+		name: "absurd_cache_size",
+		modify: func(c *Config) {
+			c.SafeSearchCacheSize = maxCacheSize + 1
+		},
+		wantFields: []string{"safesearch_cache_size"},
+	}, {
+		name: "rewrite_precedence",
+		modify: func(c *Config) {
+			c.RewritePrecedence = "blocks"
+		},
+		wantFields: []string{"rewrite_precedence"},
+	}, {
+		name: "nat64_prefix",
+		modify: func(c *Config) {
+			c.NAT64PrefixCIDR = "64:ff9b::/80"
+		},
+		wantFields: []string{"nat64_prefix"},
+	}, {
+		name: "nat64_prefix_bad",
+		modify: func(c *Config) {
+			c.NAT64PrefixCIDR = "64:ff9b::"
+		},
+		wantFields: []string{"nat64_prefix"},
+	}, {
+		name: "nat64_prefix_valid",
+		modify: func(c *Config) {
+			c.NAT64PrefixCIDR = "64:ff9b::/96"
+		},
+		wantFields: nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := validConf()
+			tc.modify(c)
+
+			problems := c.Validate()
+
+			var fields []string
+			for _, p := range problems {
+				assert.Error(t, p.Err)
+				fields = append(fields, p.Field)
+			}
+
+			assert.Equal(t, tc.wantFields, fields)
+		})
+	}
+}
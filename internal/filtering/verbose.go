@@ -0,0 +1,155 @@
+package filtering
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/AdguardTeam/urlfilter/rules"
+)
+
+// StageResult is the result of a single stage of checking a request, see
+// CheckHostVerbose.
+type StageResult struct {
+	// Name is the name of the stage, like "filtering" or "safe browsing".
+	Name string
+
+	// Matching are all the allowlist and blocklist rules matching the
+	// request, including the ones which didn't decide Result.  It's only set
+	// for the filtering stage.
+	Matching []*ResultRule
+
+	// Result is the result of the stage.  Its reason is NotFilteredNotFound
+	// if the stage didn't match.
+	Result Result
+}
+
+// filteringStageName is the name of the stage matching the filtering rules.
+const filteringStageName = "filtering"
+
+// CheckHostVerbose returns the results of the stages CheckHost runs the
+// request through, including the ones which didn't match, in the same order.
+// Like CheckHost, it stops at the stage which decided the result, so the last
+// of stages is the one CheckHost returns.  The filtering stage also reports all
+// the rules matching the request, so that the conflicts between the lists,
+// like the host being both allowlisted and blocked, can be seen.  It's
+// intended for debugging, so it's much slower than CheckHost.  Unlike
+// CheckHost, it doesn't report the decisions to the sink.
+func (d *DNSFilter) CheckHostVerbose(
+	host string,
+	qtype uint16,
+	setts *Settings,
+) (stages []StageResult, err error) {
+	if host == "" {
+		return nil, nil
+	}
+
+	host = strings.ToLower(host)
+
+	stages = make([]StageResult, 0, len(d.hostCheckers))
+	collect := func(hc *hostChecker, res Result) (cont bool) {
+		d.redactRules(&res)
+		stage := StageResult{
+			Name:   hc.name,
+			Result: res,
+		}
+
+		if hc.name == filteringStageName {
+			stage.Matching = d.allMatchingRules(host, qtype, setts)
+		}
+
+		stages = append(stages, stage)
+
+		return !res.Reason.Matched()
+	}
+
+	_, err = d.walkCheckers(context.Background(), host, qtype, setts, false, collect)
+	if err != nil {
+		return nil, err
+	}
+
+	return stages, nil
+}
+
+// listScanner returns a scanner of the rules of l which is safe to use
+// concurrently with the engines.  The file-based lists are read with ReadAt,
+// so that the file offset used by the engines isn't moved.  l must not be
+// closed while the scanner is used.
+func listScanner(l filterlist.RuleList) (sc *filterlist.RuleScanner, err error) {
+	fl, ok := l.(*filterlist.FileRuleList)
+	if !ok {
+		// The other lists create independent readers.
+		return l.NewScanner(), nil
+	}
+
+	fi, err := fl.File.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("list %d: %w", fl.ID, err)
+	}
+
+	r := io.NewSectionReader(fl.File, 0, fi.Size())
+
+	return filterlist.NewRuleScanner(r, fl.ID, fl.IgnoreCosmetic), nil
+}
+
+// allMatchingRules returns all the blocklist and then allowlist rules matching
+// the request for host.  It scans all the rules, so it's slow.  The lists
+// which can't be read are skipped.
+func (d *DNSFilter) allMatchingRules(host string, qtype uint16, setts *Settings) (matching []*ResultRule) {
+	ureq := newDNSRequest(host, qtype, setts)
+	req := rules.NewRequestForHostname(host)
+	req.SortedClientTags = ureq.SortedClientTags
+	req.ClientIP = ureq.ClientIP
+	req.ClientName = ureq.ClientName
+	req.DNSType = ureq.DNSType
+
+	res := Result{}
+
+	// Hold the lock while scanning, so that the lists aren't closed.
+	d.engineLock.RLock()
+	for _, rs := range []*filterlist.RuleStorage{d.rulesStorage, d.rulesStorageAllow} {
+		if rs == nil {
+			continue
+		}
+
+		for _, l := range rs.Lists {
+			sc, err := listScanner(l)
+			if err != nil {
+				log.Debug("filtering: matching all rules: %s", err)
+
+				continue
+			}
+
+			res.Rules = append(res.Rules, scanMatching(sc, req)...)
+		}
+	}
+	d.engineLock.RUnlock()
+
+	d.redactRules(&res)
+
+	return res.Rules
+}
+
+// scanMatching returns the network and host rules from sc matching req.
+func scanMatching(sc *filterlist.RuleScanner, req *rules.Request) (matching []*ResultRule) {
+	for sc.Scan() {
+		r, _ := sc.Rule()
+		switch r := r.(type) {
+		case *rules.NetworkRule:
+			if r.Match(req) {
+				matching = append(matching, newResultRule(r))
+			}
+		case *rules.HostRule:
+			if r.Match(req.Hostname) {
+				matching = append(matching, newResultRule(r))
+			}
+		default:
+			// Go on.
+		}
+	}
+
+	return matching
+}
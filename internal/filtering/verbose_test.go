@@ -0,0 +1,243 @@
+package filtering
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/internal/aghtest"
+	"github.com/AdguardTeam/urlfilter/filterlist"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_CheckHostVerbose(t *testing.T) {
+	InitModule()
+
+	const (
+		blockID = 1
+		allowID = 2
+
+		rewritesIdx  = 5
+		filteringIdx = 7
+	)
+
+	d := newForTest(t, &Config{
+		SafeSearchEnabled: true,
+		CustomResolver:    &aghtest.TestResolver{},
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "192.0.2.1",
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters([]Filter{{
+		ID: blockID,
+		Data: []byte("||conflict.example^\n" +
+			"0.0.0.0 conflict.example\n" +
+			"||other.example^\n" +
+			"||www.google.com^\n",
+		),
+	}}, []Filter{{
+		ID:   allowID,
+		Data: []byte("||conflict.example^\n"),
+	}}, false)
+	require.NoError(t, err)
+
+	s := setts
+	s.SafeSearchEnabled = true
+	d.ApplyClientBlockedServices(&s, ClientServices{
+		Block:   []string{"youtube"},
+		Replace: true,
+	})
+
+	names := func(stages []StageResult) (res []string) {
+		for _, st := range stages {
+			res = append(res, st.Name)
+		}
+
+		return res
+	}
+
+	matching := func(st StageResult) (res map[string][]int64) {
+		res = map[string][]int64{}
+		for _, r := range st.Matching {
+			res[r.Text] = append(res[r.Text], r.FilterListID)
+		}
+
+		return res
+	}
+
+	wantNames := []string{
+		"opcode",
+		"dnssec",
+		"server host",
+		"zone",
+		"nat64 ptr",
+		"rewrites",
+		"hosts container",
+		"filtering",
+		"blocked services",
+		"safe browsing",
+		"parental",
+		"safe search",
+		"default deny",
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		stages, vErr := d.CheckHostVerbose("Conflict.example", dns.TypeA, &s)
+		require.NoError(t, vErr)
+		require.Equal(t, wantNames[:filteringIdx+1], names(stages))
+
+		filterStage := stages[filteringIdx]
+		assert.Equal(t, NotFilteredAllowList, filterStage.Result.Reason)
+		assert.Equal(t, map[string][]int64{
+			"||conflict.example^":      {blockID, allowID},
+			"0.0.0.0 conflict.example": {blockID},
+		}, matching(filterStage))
+
+		for i, st := range stages[:filteringIdx] {
+			assert.Equal(t, NotFilteredNotFound, st.Result.Reason, st.Name)
+			assert.Nil(t, stages[i].Matching, st.Name)
+		}
+	})
+
+	t.Run("stops_at_decision", func(t *testing.T) {
+		stages, vErr := d.CheckHostVerbose("www.google.com", dns.TypeA, &s)
+		require.NoError(t, vErr)
+		require.Equal(t, wantNames[:filteringIdx+1], names(stages))
+
+		// The safe search stage would match as well, but the filtering one
+		// decides the result.
+		assert.Equal(t, FilteredBlockList, stages[filteringIdx].Result.Reason)
+
+		res, cErr := d.CheckHost("www.google.com", dns.TypeA, &s)
+		require.NoError(t, cErr)
+
+		assert.Equal(t, FilteredBlockList, res.Reason)
+	})
+
+	t.Run("not_matched", func(t *testing.T) {
+		stages, vErr := d.CheckHostVerbose("unknown.example", dns.TypeA, &s)
+		require.NoError(t, vErr)
+		require.Equal(t, wantNames, names(stages))
+
+		for _, st := range stages {
+			assert.Equal(t, NotFilteredNotFound, st.Result.Reason, st.Name)
+		}
+	})
+
+	t.Run("blocked_service", func(t *testing.T) {
+		stages, vErr := d.CheckHostVerbose("www.youtube.com", dns.TypeA, &s)
+		require.NoError(t, vErr)
+		require.Equal(t, wantNames[:filteringIdx+2], names(stages))
+
+		assert.Equal(t, NotFilteredNotFound, stages[filteringIdx].Result.Reason)
+		assert.Empty(t, stages[filteringIdx].Matching)
+		assert.Equal(t, FilteredBlockedService, stages[filteringIdx+1].Result.Reason)
+	})
+
+	t.Run("rewritten", func(t *testing.T) {
+		stages, vErr := d.CheckHostVerbose("rewritten.example", dns.TypeA, &s)
+		require.NoError(t, vErr)
+		require.Equal(t, wantNames[:rewritesIdx+1], names(stages))
+
+		assert.Equal(t, Rewritten, stages[rewritesIdx].Result.Reason)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		stages, vErr := d.CheckHostVerbose("", dns.TypeA, &s)
+		require.NoError(t, vErr)
+
+		assert.Empty(t, stages)
+	})
+}
+
+func TestDNSFilter_CheckHostVerbose_defaultDeny(t *testing.T) {
+	d := newForTest(t, &Config{
+		DefaultDeny: true,
+		Rewrites: []RewriteEntry{{
+			Domain: "rewritten.example",
+			Answer: "192.0.2.1",
+		}},
+	}, nil)
+	t.Cleanup(d.Close)
+
+	err := d.SetFilters(nil, []Filter{{
+		ID:   1,
+		Data: []byte("@@||allowed.example^\n"),
+	}}, false)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		host       string
+		wantLast   string
+		wantReason Reason
+	}{{
+		name:       "allowed",
+		host:       "allowed.example",
+		wantLast:   filteringStageName,
+		wantReason: NotFilteredAllowList,
+	}, {
+		name:       "rewritten",
+		host:       "rewritten.example",
+		wantLast:   "rewrites",
+		wantReason: Rewritten,
+	}, {
+		name:       "denied",
+		host:       "other.example",
+		wantLast:   defaultDenyStageName,
+		wantReason: FilteredDefaultDeny,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stages, vErr := d.CheckHostVerbose(tc.host, dns.TypeA, &setts)
+			require.NoError(t, vErr)
+			require.NotEmpty(t, stages)
+
+			last := stages[len(stages)-1]
+			assert.Equal(t, tc.wantLast, last.Name)
+			assert.Equal(t, tc.wantReason, last.Result.Reason)
+		})
+	}
+}
+
+func TestListScanner(t *testing.T) {
+	// Make the list large enough for the scanner to read it in several
+	// chunks.
+	const n = 10_000
+
+	sb := &strings.Builder{}
+	for i := 0; i < n; i++ {
+		_, _ = fmt.Fprintf(sb, "||host%d.example^\n", i)
+	}
+
+	path := filepath.Join(t.TempDir(), "block.txt")
+	err := os.WriteFile(path, []byte(sb.String()), 0o644)
+	require.NoError(t, err)
+
+	fl, err := filterlist.NewFileRuleList(1, path, true)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, fl.Close()) })
+
+	sc, err := listScanner(fl)
+	require.NoError(t, err)
+
+	var scanned int
+	for sc.Scan() {
+		scanned++
+
+		// Retrieving the rules moves the file offset, which mustn't affect
+		// the scanner.
+		_, err = fl.RetrieveRule(0)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, n, scanned)
+}
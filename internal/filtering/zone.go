@@ -0,0 +1,159 @@
+package filtering
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// zone is an authoritative zone loaded from a zone file.
+type zone struct {
+	// records maps the lowercased names without the trailing dot to their
+	// resource records.
+	records map[string][]dns.RR
+	// origin is the lowercased origin of the zone without the trailing
+	// dot.
+	origin string
+}
+
+// normalizeZoneName returns the lowercased name without the trailing dot.
+func normalizeZoneName(name string) (norm string) {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// contains returns true if host is the origin of z or its subdomain.
+func (z *zone) contains(host string) (ok bool) {
+	return z.origin == "" || host == z.origin || strings.HasSuffix(host, "."+z.origin)
+}
+
+// LoadZone parses the RFC 1035 zone file from r with the origin and adds it to
+// the authoritative zones, replacing the previously loaded zone with the same
+// origin, if any.  The wildcard records are not supported.
+func (d *DNSFilter) LoadZone(origin string, r io.Reader) (err error) {
+	z := &zone{
+		records: map[string][]dns.RR{},
+		origin:  normalizeZoneName(origin),
+	}
+
+	zp := dns.NewZoneParser(r, dns.Fqdn(origin), "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := normalizeZoneName(rr.Header().Name)
+		if !z.contains(name) {
+			return fmt.Errorf("zone %q: record %q is out of zone", origin, rr)
+		}
+
+		z.records[name] = append(z.records[name], rr)
+	}
+
+	err = zp.Err()
+	if err != nil {
+		return fmt.Errorf("parsing zone %q: %w", origin, err)
+	}
+
+	d.zonesLock.Lock()
+	defer d.zonesLock.Unlock()
+
+	if d.zones == nil {
+		d.zones = map[string]*zone{}
+	}
+
+	d.zones[z.origin] = z
+
+	log.Debug("filtering: loaded zone %q with %d names", z.origin, len(z.records))
+
+	return nil
+}
+
+// findZone returns the most specific loaded zone containing host.  z is nil if
+// there is no such zone.
+func (d *DNSFilter) findZone(host string) (z *zone) {
+	d.zonesLock.RLock()
+	defer d.zonesLock.RUnlock()
+
+	for _, cur := range d.zones {
+		if cur.contains(host) && (z == nil || len(cur.origin) > len(z.origin)) {
+			z = cur
+		}
+	}
+
+	return z
+}
+
+// matchZone answers the request for host with qtype from the authoritative
+// zones.  The names within a zone without any records of qtype get an empty
+// successful response, and the names absent from it get NXDOMAIN.  A CNAME
+// record is returned as the canonical name to resolve further.
+func (d *DNSFilter) matchZone(host string, qtype uint16) (res Result, ok bool) {
+	z := d.findZone(host)
+	if z == nil {
+		return Result{}, false
+	}
+
+	rrs, found := z.records[host]
+	if !found {
+		return Result{
+			Reason:           RewrittenRule,
+			DNSRewriteResult: &DNSRewriteResult{RCode: dns.RcodeNameError},
+		}, true
+	}
+
+	dnsrr := &DNSRewriteResult{
+		Response: DNSRewriteResultResponse{},
+		RCode:    dns.RcodeSuccess,
+	}
+
+	var resRules []*ResultRule
+	for _, rr := range rrs {
+		if cname, isCNAME := rr.(*dns.CNAME); isCNAME && qtype != dns.TypeCNAME {
+			return Result{
+				Reason:    RewrittenRule,
+				Rules:     []*ResultRule{{Text: rr.String()}},
+				CanonName: normalizeZoneName(cname.Target),
+			}, true
+		}
+
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+
+		v := zoneRRValue(rr)
+		if v == nil {
+			continue
+		}
+
+		dnsrr.Response[qtype] = append(dnsrr.Response[qtype], v)
+		resRules = append(resRules, &ResultRule{Text: rr.String()})
+	}
+
+	return Result{
+		Reason:           RewrittenRule,
+		Rules:            resRules,
+		DNSRewriteResult: dnsrr,
+	}, true
+}
+
+// zoneRRValue returns the rewrite value for rr.  v is nil if the type of rr
+// isn't supported.
+func zoneRRValue(rr dns.RR) (v rules.RRValue) {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.To4()
+	case *dns.AAAA:
+		return rr.AAAA
+	case *dns.CNAME:
+		return normalizeZoneName(rr.Target)
+	case *dns.MX:
+		return &rules.DNSMX{
+			Exchange:   normalizeZoneName(rr.Mx),
+			Preference: rr.Preference,
+		}
+	case *dns.TXT:
+		return strings.Join(rr.Txt, "")
+	default:
+		return nil
+	}
+}
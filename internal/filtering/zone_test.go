@@ -0,0 +1,136 @@
+package filtering
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSFilter_LoadZone(t *testing.T) {
+	const zoneData = `$TTL 3600
+@	IN	SOA	ns.example.lan. admin.example.lan. 1 7200 3600 1209600 3600
+@	IN	A	192.168.1.1
+@	IN	MX	10 mail.example.lan.
+@	IN	TXT	"v=spf1 -all"
+host	IN	A	192.168.1.2
+host	IN	AAAA	fd00::2
+www	IN	CNAME	host
+mail	IN	A	192.168.1.3
+`
+
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.LoadZone("example.lan", strings.NewReader(zoneData))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		wantRes   *DNSRewriteResult
+		name      string
+		host      string
+		wantCanon string
+		qtype     uint16
+	}{{
+		wantRes: &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{
+				dns.TypeA: []rules.RRValue{net.IP{192, 168, 1, 2}},
+			},
+		},
+		name:  "a",
+		host:  "host.example.lan",
+		qtype: dns.TypeA,
+	}, {
+		wantRes: &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{
+				dns.TypeAAAA: []rules.RRValue{net.ParseIP("fd00::2")},
+			},
+		},
+		name:  "aaaa",
+		host:  "host.example.lan",
+		qtype: dns.TypeAAAA,
+	}, {
+		wantRes:   nil,
+		name:      "cname",
+		host:      "www.example.lan",
+		wantCanon: "host.example.lan",
+		qtype:     dns.TypeA,
+	}, {
+		wantRes: &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{
+				dns.TypeCNAME: []rules.RRValue{"host.example.lan"},
+			},
+		},
+		name:  "cname_qtype",
+		host:  "www.example.lan",
+		qtype: dns.TypeCNAME,
+	}, {
+		wantRes: &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{
+				dns.TypeMX: []rules.RRValue{&rules.DNSMX{
+					Exchange:   "mail.example.lan",
+					Preference: 10,
+				}},
+			},
+		},
+		name:  "mx",
+		host:  "example.lan",
+		qtype: dns.TypeMX,
+	}, {
+		wantRes: &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{
+				dns.TypeTXT: []rules.RRValue{"v=spf1 -all"},
+			},
+		},
+		name:  "txt",
+		host:  "example.lan",
+		qtype: dns.TypeTXT,
+	}, {
+		wantRes: &DNSRewriteResult{
+			Response: DNSRewriteResultResponse{},
+		},
+		name:  "nodata",
+		host:  "mail.example.lan",
+		qtype: dns.TypeAAAA,
+	}, {
+		wantRes: &DNSRewriteResult{
+			RCode: dns.RcodeNameError,
+		},
+		name:  "nxdomain",
+		host:  "none.example.lan",
+		qtype: dns.TypeA,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, cerr := d.CheckHost(tc.host, tc.qtype, &setts)
+			require.NoError(t, cerr)
+
+			assert.Equal(t, RewrittenRule, res.Reason)
+			assert.Equal(t, tc.wantCanon, res.CanonName)
+			assert.Equal(t, tc.wantRes, res.DNSRewriteResult)
+		})
+	}
+
+	t.Run("out_of_zone", func(t *testing.T) {
+		res, cerr := d.CheckHost("example.org", dns.TypeA, &setts)
+		require.NoError(t, cerr)
+
+		assert.Equal(t, NotFilteredNotFound, res.Reason)
+	})
+}
+
+func TestDNSFilter_LoadZone_errors(t *testing.T) {
+	d := newForTest(t, nil, nil)
+	t.Cleanup(d.Close)
+
+	err := d.LoadZone("example.lan", strings.NewReader("host IN A bad\n"))
+	assert.Error(t, err)
+
+	err = d.LoadZone("example.lan", strings.NewReader("example.org. IN A 1.2.3.4\n"))
+	assert.Error(t, err)
+}
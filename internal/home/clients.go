@@ -43,12 +43,33 @@ type Client struct {
 	BlockedServices []string
 	Upstreams       []string
 
+	// AllowedServices are the services not blocked for the client, even if
+	// those are blocked globally or in BlockedServices.  It's only used if
+	// UseOwnBlockedServices is true.
+	AllowedServices []string
+
 	UseOwnSettings        bool
 	FilteringEnabled      bool
 	SafeSearchEnabled     bool
 	SafeBrowsingEnabled   bool
 	ParentalEnabled       bool
 	UseOwnBlockedServices bool
+
+	// MergeBlockedServices makes BlockedServices add to the globally blocked
+	// services instead of replacing them.  It's only used if
+	// UseOwnBlockedServices is true.
+	MergeBlockedServices bool
+}
+
+// services returns the blocked services directives of c.  Unless
+// c.MergeBlockedServices is true, c.BlockedServices replace the globally
+// blocked services.
+func (c *Client) services() (svcs filtering.ClientServices) {
+	return filtering.ClientServices{
+		Block:   c.BlockedServices,
+		Allow:   c.AllowedServices,
+		Replace: !c.MergeBlockedServices,
+	}
 }
 
 type clientSource uint
@@ -165,6 +186,7 @@ type clientObject struct {
 	Tags            []string `yaml:"tags"`
 	IDs             []string `yaml:"ids"`
 	BlockedServices []string `yaml:"blocked_services"`
+	AllowedServices []string `yaml:"allowed_services,omitempty"`
 	Upstreams       []string `yaml:"upstreams"`
 
 	UseGlobalSettings        bool `yaml:"use_global_settings"`
@@ -173,6 +195,7 @@ type clientObject struct {
 	SafeSearchEnabled        bool `yaml:"safesearch_enabled"`
 	SafeBrowsingEnabled      bool `yaml:"safebrowsing_enabled"`
 	UseGlobalBlockedServices bool `yaml:"use_global_blocked_services"`
+	MergeBlockedServices     bool `yaml:"merge_blocked_services,omitempty"`
 }
 
 // addFromConfig initializes the clients containter with objects from the
@@ -191,16 +214,25 @@ func (clients *clientsContainer) addFromConfig(objects []*clientObject) {
 			SafeSearchEnabled:     o.SafeSearchEnabled,
 			SafeBrowsingEnabled:   o.SafeBrowsingEnabled,
 			UseOwnBlockedServices: !o.UseGlobalBlockedServices,
+			MergeBlockedServices:  o.MergeBlockedServices,
 		}
 
 		for _, s := range o.BlockedServices {
-			if filtering.BlockedSvcKnown(s) {
-				cli.BlockedServices = append(cli.BlockedServices, s)
+			if e, ok := filtering.BlockedServiceEntry(s); ok {
+				cli.BlockedServices = append(cli.BlockedServices, e.Name)
 			} else {
 				log.Info("clients: skipping unknown blocked service %q", s)
 			}
 		}
 
+		for _, s := range o.AllowedServices {
+			if e, ok := filtering.BlockedServiceEntry(s); ok {
+				cli.AllowedServices = append(cli.AllowedServices, e.Name)
+			} else {
+				log.Info("clients: skipping unknown allowed service %q", s)
+			}
+		}
+
 		for _, t := range o.Tags {
 			if clients.allTags.Has(t) {
 				cli.Tags = append(cli.Tags, t)
@@ -232,6 +264,7 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			Tags:            stringutil.CloneSlice(cli.Tags),
 			IDs:             stringutil.CloneSlice(cli.IDs),
 			BlockedServices: stringutil.CloneSlice(cli.BlockedServices),
+			AllowedServices: stringutil.CloneSlice(cli.AllowedServices),
 			Upstreams:       stringutil.CloneSlice(cli.Upstreams),
 
 			UseGlobalSettings:        !cli.UseOwnSettings,
@@ -240,6 +273,7 @@ func (clients *clientsContainer) forConfig() (objs []*clientObject) {
 			SafeSearchEnabled:        cli.SafeSearchEnabled,
 			SafeBrowsingEnabled:      cli.SafeBrowsingEnabled,
 			UseGlobalBlockedServices: !cli.UseOwnBlockedServices,
+			MergeBlockedServices:     cli.MergeBlockedServices,
 		}
 
 		objs = append(objs, o)
@@ -379,10 +413,59 @@ func (clients *clientsContainer) Find(id string) (c *Client, ok bool) {
 	c.IDs = stringutil.CloneSlice(c.IDs)
 	c.Tags = stringutil.CloneSlice(c.Tags)
 	c.BlockedServices = stringutil.CloneSlice(c.BlockedServices)
+	c.AllowedServices = stringutil.CloneSlice(c.AllowedServices)
 	c.Upstreams = stringutil.CloneSlice(c.Upstreams)
 	return c, true
 }
 
+// findByName returns the persistent client with name.
+func (clients *clientsContainer) findByName(name string) (c *Client, ok bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok = clients.list[name]
+	if !ok {
+		return nil, false
+	}
+
+	c.IDs = stringutil.CloneSlice(c.IDs)
+	c.Tags = stringutil.CloneSlice(c.Tags)
+	c.BlockedServices = stringutil.CloneSlice(c.BlockedServices)
+	c.AllowedServices = stringutil.CloneSlice(c.AllowedServices)
+	c.Upstreams = stringutil.CloneSlice(c.Upstreams)
+
+	return c, true
+}
+
+// filteringSettings returns the filtering settings of the persistent client
+// with name or, if there is no such client, with clientIP, if any.  It mirrors
+// applyAdditionalFiltering.
+func (clients *clientsContainer) filteringSettings(
+	clientIP net.IP,
+	name string,
+) (cs *filtering.ClientSettings) {
+	c, ok := clients.findByName(name)
+	if !ok && clientIP != nil {
+		c, ok = clients.Find(clientIP.String())
+	}
+
+	if !ok {
+		return nil
+	}
+
+	return &filtering.ClientSettings{
+		Name:                  c.Name,
+		Tags:                  c.Tags,
+		Services:              c.services(),
+		UseOwnBlockedServices: c.UseOwnBlockedServices,
+		UseOwnSettings:        c.UseOwnSettings,
+		FilteringEnabled:      c.FilteringEnabled,
+		SafeSearchEnabled:     c.SafeSearchEnabled,
+		SafeBrowsingEnabled:   c.SafeBrowsingEnabled,
+		ParentalEnabled:       c.ParentalEnabled,
+	}
+}
+
 // findUpstreams returns upstreams configured for the client, identified either
 // by its IP address or its ClientID.  upsConf is nil if the client isn't found
 // or if the client has no custom upstreams.
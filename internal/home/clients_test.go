@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/AdguardTeam/AdGuardHome/internal/dhcpd"
+	"github.com/AdguardTeam/AdGuardHome/internal/filtering"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -349,3 +350,131 @@ func TestClientsCustomUpstream(t *testing.T) {
 	assert.Len(t, config.Upstreams, 1)
 	assert.Len(t, config.DomainReservedUpstreams, 1)
 }
+
+func TestClientsContainer_services(t *testing.T) {
+	filtering.InitModule()
+
+	clients := clientsContainer{
+		testing: true,
+	}
+	clients.Init(nil, nil, nil)
+
+	clients.addFromConfig([]*clientObject{{
+		Name:            "replace",
+		IDs:             []string{"1.1.1.1"},
+		BlockedServices: []string{"youtube"},
+	}, {
+		Name:                 "merge",
+		IDs:                  []string{"2.2.2.2"},
+		BlockedServices:      []string{" YouTube "},
+		AllowedServices:      []string{"FACEBOOK", "unknown"},
+		MergeBlockedServices: true,
+	}})
+
+	testCases := []struct {
+		name string
+		id   string
+		want filtering.ClientServices
+	}{{
+		name: "replace",
+		id:   "1.1.1.1",
+		want: filtering.ClientServices{
+			Block:   []string{"youtube"},
+			Replace: true,
+		},
+	}, {
+		name: "merge",
+		id:   "2.2.2.2",
+		want: filtering.ClientServices{
+			Block:   []string{"youtube"},
+			Allow:   []string{"facebook"},
+			Replace: false,
+		},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, ok := clients.Find(tc.id)
+			require.True(t, ok)
+
+			assert.Equal(t, tc.want, c.services())
+		})
+	}
+
+	t.Run("config", func(t *testing.T) {
+		objs := clients.forConfig()
+		require.Len(t, objs, 2)
+
+		// The objects are sorted by name.
+		assert.Equal(t, []string{"facebook"}, objs[0].AllowedServices)
+		assert.True(t, objs[0].MergeBlockedServices)
+		assert.Empty(t, objs[1].AllowedServices)
+		assert.False(t, objs[1].MergeBlockedServices)
+	})
+}
+
+func TestClientsContainer_filteringSettings(t *testing.T) {
+	clients := clientsContainer{
+		testing: true,
+	}
+	clients.Init(nil, nil, nil)
+
+	ok, err := clients.Add(&Client{
+		IDs:              []string{"1.1.1.1", "kids-tablet"},
+		Name:             "Kids tablet",
+		Tags:             []string{"device_tablet"},
+		UseOwnSettings:   true,
+		FilteringEnabled: true,
+		ParentalEnabled:  true,
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	d := filtering.New(&filtering.Config{
+		FindClient: clients.filteringSettings,
+	}, nil)
+	t.Cleanup(d.Close)
+
+	testCases := []struct {
+		ip       net.IP
+		name     string
+		client   string
+		wantName string
+	}{{
+		ip:       nil,
+		name:     "by_name",
+		client:   "Kids tablet",
+		wantName: "Kids tablet",
+	}, {
+		ip:       net.IP{1, 1, 1, 1},
+		name:     "by_ip",
+		client:   "",
+		wantName: "Kids tablet",
+	}, {
+		ip:       nil,
+		name:     "by_client_id",
+		client:   "kids-tablet",
+		wantName: "kids-tablet",
+	}, {
+		ip:       net.IP{2, 2, 2, 2},
+		name:     "unknown",
+		client:   "Other",
+		wantName: "Other",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := d.ResolveSettings(tc.ip, nil, tc.client)
+
+			assert.Equal(t, tc.wantName, s.ClientName)
+
+			found := tc.wantName == "Kids tablet"
+			assert.Equal(t, found, s.ParentalEnabled)
+			if found {
+				assert.Equal(t, []string{"device_tablet"}, s.ClientTags)
+			} else {
+				assert.Empty(t, s.ClientTags)
+			}
+		})
+	}
+}
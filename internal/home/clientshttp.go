@@ -31,6 +31,7 @@ type clientJSON struct {
 	Name string `json:"name"`
 
 	BlockedServices []string `json:"blocked_services"`
+	AllowedServices []string `json:"allowed_services"`
 	IDs             []string `json:"ids"`
 	Tags            []string `json:"tags"`
 	Upstreams       []string `json:"upstreams"`
@@ -41,6 +42,7 @@ type clientJSON struct {
 	SafeSearchEnabled        bool `json:"safesearch_enabled"`
 	UseGlobalBlockedServices bool `json:"use_global_blocked_services"`
 	UseGlobalSettings        bool `json:"use_global_settings"`
+	MergeBlockedServices     bool `json:"merge_blocked_services"`
 }
 
 type runtimeClientJSON struct {
@@ -124,7 +126,9 @@ func jsonToClient(cj clientJSON) (c *Client) {
 		SafeBrowsingEnabled: cj.SafeBrowsingEnabled,
 
 		UseOwnBlockedServices: !cj.UseGlobalBlockedServices,
+		MergeBlockedServices:  cj.MergeBlockedServices,
 		BlockedServices:       cj.BlockedServices,
+		AllowedServices:       cj.AllowedServices,
 
 		Upstreams: cj.Upstreams,
 	}
@@ -143,7 +147,9 @@ func clientToJSON(c *Client) (cj *clientJSON) {
 		SafeBrowsingEnabled: c.SafeBrowsingEnabled,
 
 		UseGlobalBlockedServices: !c.UseOwnBlockedServices,
+		MergeBlockedServices:     c.MergeBlockedServices,
 		BlockedServices:          c.BlockedServices,
+		AllowedServices:          c.AllowedServices,
 
 		Upstreams: c.Upstreams,
 	}
@@ -299,6 +299,22 @@ func parseConfig() error {
 	return nil
 }
 
+// validateFilteringConfig returns an error describing the problems found in
+// the filtering configuration, see filtering.Config.Validate.
+func validateFilteringConfig() (err error) {
+	problems := config.DNS.DnsfilterConf.Validate()
+	if len(problems) == 0 {
+		return nil
+	}
+
+	errs := make([]error, 0, len(problems))
+	for _, p := range problems {
+		errs = append(errs, p)
+	}
+
+	return errors.List("invalid filtering configuration", errs...)
+}
+
 // readConfigFile reads config file contents if it exists
 func readConfigFile() ([]byte, error) {
 	if len(config.fileData) != 0 {
@@ -313,6 +329,27 @@ func readConfigFile() ([]byte, error) {
 	return d, nil
 }
 
+// readRewrites reads the DNS rewrites from the configuration file on disk.
+func readRewrites() (entries []filtering.RewriteEntry, err error) {
+	configFile := config.getConfigFilename()
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %s: %w", configFile, err)
+	}
+
+	conf := struct {
+		DNS struct {
+			Rewrites []filtering.RewriteEntry `yaml:"rewrites"`
+		} `yaml:"dns"`
+	}{}
+	err = yaml.Unmarshal(data, &conf)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", configFile, err)
+	}
+
+	return conf.DNS.Rewrites, nil
+}
+
 // Saves configuration to the YAML file and also saves the user filter contents to a file
 func (c *configuration) write() error {
 	c.Lock()
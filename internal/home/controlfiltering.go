@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
@@ -298,6 +299,11 @@ type filteringConfig struct {
 	Filters          []filterJSON `json:"filters"`
 	WhitelistFilters []filterJSON `json:"whitelist_filters"`
 	UserRules        []string     `json:"user_rules"`
+
+	// PausedUntil is the time, in RFC 3339 format, at which the current pause
+	// of the filtering ends.  It's only set in the responses and only if the
+	// filtering is paused.
+	PausedUntil string `json:"paused_until,omitempty"`
 }
 
 func filterToJSON(f filter) filterJSON {
@@ -333,6 +339,10 @@ func (f *Filtering) handleFilteringStatus(w http.ResponseWriter, r *http.Request
 	resp.UserRules = config.UserRules
 	config.RUnlock()
 
+	if until, ok := Context.dnsFilter.PausedUntil(); ok {
+		resp.PausedUntil = until.Format(time.RFC3339)
+	}
+
 	jsonVal, err := json.Marshal(resp)
 	if err != nil {
 		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
@@ -371,6 +381,39 @@ func (f *Filtering) handleFilteringConfig(w http.ResponseWriter, r *http.Request
 	enableFilters(true)
 }
 
+// filteringPauseReq is the request to pause the filtering.
+type filteringPauseReq struct {
+	// Duration is the duration of the pause, in milliseconds.
+	Duration uint64 `json:"duration"`
+}
+
+// handleFilteringPause pauses the filtering for the requested duration, see
+// filtering.DNSFilter.PauseFor.  The configured status of the filtering isn't
+// changed.
+func (f *Filtering) handleFilteringPause(w http.ResponseWriter, r *http.Request) {
+	req := filteringPauseReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+
+		return
+	}
+
+	if req.Duration == 0 || req.Duration > math.MaxInt64/uint64(time.Millisecond) {
+		httpError(w, http.StatusBadRequest, "bad duration %d", req.Duration)
+
+		return
+	}
+
+	Context.dnsFilter.PauseFor(time.Duration(req.Duration) * time.Millisecond)
+}
+
+// handleFilteringResume ends the pause of the filtering, if any, see
+// filtering.DNSFilter.ResumeNow.
+func (f *Filtering) handleFilteringResume(w http.ResponseWriter, r *http.Request) {
+	Context.dnsFilter.ResumeNow()
+}
+
 type checkHostRespRule struct {
 	FilterListID int64  `json:"filter_list_id"`
 	Text         string `json:"text"`
@@ -444,6 +487,8 @@ func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 func (f *Filtering) RegisterFilteringHandlers() {
 	httpRegister(http.MethodGet, "/control/filtering/status", f.handleFilteringStatus)
 	httpRegister(http.MethodPost, "/control/filtering/config", f.handleFilteringConfig)
+	httpRegister(http.MethodPost, "/control/filtering/pause", f.handleFilteringPause)
+	httpRegister(http.MethodPost, "/control/filtering/resume", f.handleFilteringResume)
 	httpRegister(http.MethodPost, "/control/filtering/add_url", f.handleFilteringAddURL)
 	httpRegister(http.MethodPost, "/control/filtering/remove_url", f.handleFilteringRemoveURL)
 	httpRegister(http.MethodPost, "/control/filtering/set_url", f.handleFilteringSetURL)
@@ -75,6 +75,9 @@ func initDNSServer() (err error) {
 	filterConf.EtcHosts = Context.etcHosts
 	filterConf.ConfigModified = onConfigModified
 	filterConf.HTTPRegister = httpRegister
+	filterConf.ReadRewrites = readRewrites
+	filterConf.FindClient = Context.clients.filteringSettings
+	filterConf.SelfNames, filterConf.SelfIPs = dnsSelfAddrs()
 	Context.dnsFilter = filtering.New(&filterConf, nil)
 
 	p := dnsforward.DNSCreateParams{
@@ -119,6 +122,51 @@ func initDNSServer() (err error) {
 	return nil
 }
 
+// dnsSelfAddrs returns the names and the addresses at which the DNS server is
+// reachable according to the configuration, see filtering.Config.SelfNames.
+// The unspecified bind addresses are replaced with the addresses of all the
+// network interfaces.
+func dnsSelfAddrs() (names []string, ips []net.IP) {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		names = append(names, hostname)
+	}
+
+	if Context.tls != nil {
+		tlsConf := tlsConfigSettings{}
+		Context.tls.WriteDiskConfig(&tlsConf)
+		if tlsConf.ServerName != "" {
+			names = append(names, tlsConf.ServerName)
+		}
+	}
+
+	ifacesAdded := false
+	for _, ip := range config.DNS.BindHosts {
+		if !ip.IsUnspecified() {
+			ips = append(ips, ip)
+
+			continue
+		} else if ifacesAdded {
+			continue
+		}
+
+		ifacesAdded = true
+		addrs, err := aghnet.CollectAllIfacesAddrs()
+		if err != nil {
+			log.Error("dns: getting self addresses: %s", err)
+
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ifaceIP := net.ParseIP(addr); ifaceIP != nil {
+				ips = append(ips, ifaceIP)
+			}
+		}
+	}
+
+	return names, ips
+}
+
 func isRunning() bool {
 	return Context.dnsServer != nil && Context.dnsServer.IsRunning()
 }
@@ -322,7 +370,7 @@ func applyAdditionalFiltering(clientAddr net.IP, clientID string, setts *filteri
 	log.Debug("using settings for client %s with ip %s and id %q", c.Name, clientAddr, clientID)
 
 	if c.UseOwnBlockedServices {
-		Context.dnsFilter.ApplyBlockedServices(setts, c.BlockedServices, false)
+		Context.dnsFilter.ApplyClientBlockedServices(setts, c.services())
 	}
 
 	setts.ClientName = c.Name
@@ -721,8 +721,9 @@ func enableFiltersLocked(async bool) {
 		}
 
 		filters = append(filters, filtering.Filter{
-			ID:       filter.ID,
-			FilePath: filter.Path(),
+			ID:           filter.ID,
+			FilePath:     filter.Path(),
+			NonEnforcing: filter.NonEnforcing,
 		})
 	}
 
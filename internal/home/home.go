@@ -172,6 +172,17 @@ func setupContext(args options) {
 			os.Exit(1)
 		}
 
+		// The invalid filtering settings have been accepted before, so only
+		// refuse those when checking the configuration.
+		err = validateFilteringConfig()
+		if err != nil {
+			log.Error("%s", err)
+
+			if args.checkConfig {
+				os.Exit(1)
+			}
+		}
+
 		if args.checkConfig {
 			log.Info("configuration file is ok")
 
@@ -381,16 +392,17 @@ func run(args options, clientBuildFS fs.FS) {
 		log.Info("AdGuard Home is running as a service")
 	}
 
+	// clients package uses filtering package's static data (filtering.BlockedSvcKnown()),
+	//  so we have to initialize filtering's static data first,
+	//  but also avoid relying on automatic Go init() function.  The
+	//  configuration is validated against it as well.
+	filtering.InitModule()
+
 	setupContext(args)
 
 	err = configureOS(config)
 	fatalOnError(err)
 
-	// clients package uses filtering package's static data (filtering.BlockedSvcKnown()),
-	//  so we have to initialize filtering's static data first,
-	//  but also avoid relying on automatic Go init() function
-	filtering.InitModule()
-
 	err = setupConfig(args)
 	fatalOnError(err)
 
@@ -171,7 +171,12 @@ func (c *searchCriterion) ctFilteringStatusCase(res filtering.Result) bool {
 
 	case filteringStatusBlocked:
 		return res.IsFiltered &&
-			res.Reason.In(filtering.FilteredBlockList, filtering.FilteredBlockedService)
+			res.Reason.In(
+				filtering.FilteredBlockList,
+				filtering.FilteredBlockedService,
+				filtering.FilteredDefaultDeny,
+				filtering.FilteredSecurityFailure,
+			)
 
 	case filteringStatusBlockedService:
 		return res.IsFiltered && res.Reason == filtering.FilteredBlockedService
@@ -199,6 +204,8 @@ func (c *searchCriterion) ctFilteringStatusCase(res filtering.Result) bool {
 		return !res.Reason.In(
 			filtering.FilteredBlockList,
 			filtering.FilteredBlockedService,
+			filtering.FilteredDefaultDeny,
+			filtering.FilteredSecurityFailure,
 			filtering.NotFilteredAllowList,
 		)
 